@@ -0,0 +1,193 @@
+//
+// This file contains our zone-transfer handler.
+//
+// Transfers are expensive, and would make us a useful proxy for anybody
+// trying to enumerate zones they shouldn't, so they are only available
+// to authenticated callers, for zones which have been explicitly listed
+// in our configuration-file.
+//
+
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// transferAuthenticated tests whether the request contains one of the
+// tokens listed in our configuration-file.
+//
+func (s *Server) transferAuthenticated(req *http.Request) bool {
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+
+	for _, t := range s.config.Transfers.Tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// transferZone returns the configuration of the given zone, if it may be
+// transferred.
+//
+func (s *Server) transferZone(zone string) *resolver.TransferZone {
+	zone = dns.Fqdn(strings.ToLower(zone))
+
+	for i, z := range s.config.Transfers.Zones {
+		if z.Zone == zone {
+			return &s.config.Transfers.Zones[i]
+		}
+	}
+	return nil
+}
+
+//
+// transferServer decides which server the transfer should be made from.
+//
+// Zones with configured servers may only be transferred from those,
+// otherwise the caller must name a server which our usual policy allows.
+//
+func (s *Server) transferServer(zone *resolver.TransferZone, server string) (string, error) {
+
+	if len(zone.Servers) > 0 {
+		if server == "" {
+			return zone.Servers[0], nil
+		}
+		addr, err := resolver.ServerAddress(server)
+		if err == nil {
+			for _, allowed := range zone.Servers {
+				if allowed == addr {
+					return allowed, nil
+				}
+			}
+		}
+		return "", resolver.TargetDenied{Reason: fmt.Sprintf("Transfers of %s are not permitted from %s", zone.Zone, server)}
+	}
+
+	if server == "" {
+		return "", errors.New("Missing 'server' parameter")
+	}
+	return s.resolver.TargetServer(server, &s.config.Servers)
+}
+
+//
+// AXFRHandler is the handler for zone-transfers.
+//
+// It is called via requests like this:
+//
+//     GET /axfr/$ZONE?server=$SERVER
+//
+// Adding `serial=N` performs an IXFR.  The records may be returned in any
+// of our formats, such as `format=zone` for zone-file format.
+//
+func (s *Server) AXFRHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+
+	//
+	// Only authenticated callers may make transfers.
+	//
+	if !s.transferAuthenticated(req) {
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		status = http.StatusUnauthorized
+		err = errors.New("Zone transfers require authentication")
+		return
+	}
+
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	//
+	// Of zones we've been told about.
+	//
+	name := mux.Vars(req)["zone"]
+	zone := s.transferZone(name)
+	if zone == nil {
+		status = http.StatusForbidden
+		err = fmt.Errorf("Transfers of %s are not permitted", name)
+		return
+	}
+
+	server, err := s.transferServer(zone, req.URL.Query().Get("server"))
+	if err != nil {
+		status = http.StatusBadRequest
+		if _, ok := err.(resolver.TargetDenied); ok {
+			status = http.StatusForbidden
+		}
+		return
+	}
+
+	//
+	// An IXFR is made if we've been given a serial.
+	//
+	serial := uint64(0)
+	if str := req.URL.Query().Get("serial"); str != "" {
+		serial, err = strconv.ParseUint(str, 10, 32)
+		if err != nil {
+			status = http.StatusBadRequest
+			err = fmt.Errorf("Invalid serial '%s'", str)
+			return
+		}
+	}
+
+	format, err := negotiateFormat(req)
+	if err != nil {
+		status = http.StatusBadRequest
+		return
+	}
+
+	records, err := resolver.Transfer(zone, server, uint32(serial))
+	if err != nil {
+		status = http.StatusBadGateway
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["transfers"]++
+	s.mutex.Unlock()
+
+	//
+	// Return the records in the format the caller asked for.
+	//
+	var out []map[string]string
+	for _, rr := range records {
+		out = append(out, map[string]string{
+			"name":  rr.Header().Name,
+			"ttl":   fmt.Sprintf("%d", rr.Header().Ttl),
+			"type":  dns.TypeToString[rr.Header().Rrtype],
+			"value": strings.TrimPrefix(rr.String(), rr.Header().String()),
+		})
+	}
+	res.Header().Add("Vary", "Accept")
+	sendRecords(res, format, out, records)
+}
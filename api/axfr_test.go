@@ -0,0 +1,85 @@
+//
+// Test our zone-transfer handler.
+//
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test the access-controls of our handler.
+//
+func TestAXFRHandler(t *testing.T) {
+
+	addr := dnstest.StartPrimary(t)
+
+	srv := newTestServer(t, Options{Config: &Config{
+		Transfers: TransferConfig{
+			Tokens: []string{"let-me-in"},
+			Zones: []resolver.TransferZone{
+				{Zone: "example.test.", Servers: []string{addr}, KeyName: dnstest.KeyName, Algorithm: dns.HmacSHA256, Secret: dnstest.Secret},
+			},
+		},
+	}})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	get := func(path string, token string) (int, string) {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		Path   string
+		Token  string
+		Status int
+		Body   string
+	}{
+		// Authentication is required.
+		{"/axfr/example.test", "", http.StatusUnauthorized, "require authentication"},
+		{"/axfr/example.test", "wrong", http.StatusUnauthorized, "require authentication"},
+
+		// Only listed zones, from listed servers.
+		{"/axfr/other.test", "let-me-in", http.StatusForbidden, "not permitted"},
+		{"/axfr/example.test?server=192.0.2.1", "let-me-in", http.StatusForbidden, "not permitted from 192.0.2.1"},
+
+		// Valid requests.
+		{"/axfr/example.test", "let-me-in", http.StatusOK, `"value": "10 mail.example.test."`},
+		{"/axfr/Example.Test.?server=" + addr, "let-me-in", http.StatusOK, `"type": "SOA"`},
+		{"/axfr/example.test?format=zone", "let-me-in", http.StatusOK, "www.example.test.\t300\tIN\tA\t192.0.2.3"},
+		{"/axfr/example.test?serial=1&format=zone", "let-me-in", http.StatusOK, "www.example.test.\t300\tIN\tA\t192.0.2.1"},
+		{"/axfr/example.test?serial=bogus", "let-me-in", http.StatusBadRequest, "Invalid serial"},
+	}
+
+	for _, test := range tests {
+		status, body := get(test.Path, test.Token)
+		if status != test.Status {
+			t.Errorf("Unexpected status-code for %s: %d - %s", test.Path, status, body)
+		}
+		if !strings.Contains(body, test.Body) {
+			t.Errorf("Unexpected body for %s: %s", test.Path, body)
+		}
+	}
+}
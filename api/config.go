@@ -0,0 +1,151 @@
+//
+// This file contains the configuration an operator may supply, via the
+// `-config` flag, to control the more advanced features of the service.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/skx/dns-api-go/ratelimit"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+// Config holds the settings which may be loaded from our JSON
+// configuration-file.
+type Config struct {
+
+	// Servers controls which nameservers callers may direct their
+	// queries towards.
+	Servers resolver.NetworkPolicy `json:"servers"`
+
+	// Resolvers lists the public resolvers our propagation-check
+	// queries.  If empty resolver.DefaultResolvers are used.
+	Resolvers []resolver.PublicResolver `json:"resolvers"`
+
+	// Transfers controls the zone-transfers we'll perform on behalf
+	// of our callers.
+	Transfers TransferConfig `json:"transfers"`
+
+	// DKIMSelectors are the selectors our email-report looks for,
+	// if the caller doesn't specify any.
+	DKIMSelectors []string `json:"dkim_selectors"`
+
+	// Blocklists are the DNSBLs & RHSBLs our blocklist-check
+	// queries.  If empty resolver.DefaultBlocklists are used.
+	Blocklists []resolver.Blocklist `json:"blocklists"`
+
+	// Webhooks controls which addresses the webhooks of our watches
+	// may be delivered to.
+	Webhooks resolver.NetworkPolicy `json:"webhooks"`
+
+	// CORS controls which web-pages may use us from within a browser.
+	CORS CORSPolicy `json:"cors"`
+
+	// Proxies controls which proxies we trust to report the address
+	// of the caller.
+	Proxies ProxyPolicy `json:"proxies"`
+
+	// RateLimits controls how many requests each client may make.  If
+	// empty clients may make 200 requests per hour.
+	RateLimits ratelimit.Policy `json:"rate_limits"`
+
+	// Keys controls the API keys callers may present, to be limited
+	// by their tier rather than by RateLimits.
+	Keys KeyConfig `json:"keys"`
+}
+
+// TransferConfig lists the zones which may be transferred, and the
+// tokens callers must present to do so.
+type TransferConfig struct {
+	// Tokens are the bearer-tokens which authenticate a caller.
+	Tokens []string `json:"tokens"`
+
+	// Zones are the only zones which may be transferred.
+	Zones []resolver.TransferZone `json:"zones"`
+}
+
+// LoadConfig reads and validates the given configuration-file.
+func LoadConfig(path string) (*Config, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+
+	err = c.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %s", path, err.Error())
+	}
+	return c, nil
+}
+
+// Parse validates the configuration, and applies our defaults, which must
+// be done before it is used.  New does so for the configuration it is
+// given.
+func (c *Config) Parse() error {
+
+	err := c.Servers.Parse()
+	if err != nil {
+		return fmt.Errorf("invalid 'servers' policy: %s", err.Error())
+	}
+
+	for i, r := range c.Resolvers {
+		c.Resolvers[i].Address, err = resolver.ServerAddress(r.Address)
+		if r.Name == "" || err != nil {
+			return fmt.Errorf("invalid resolver '%s' (%s)", r.Name, r.Address)
+		}
+	}
+
+	for i := range c.Transfers.Zones {
+		err = c.Transfers.Zones[i].Parse()
+		if err != nil {
+			return fmt.Errorf("invalid transfer-zone '%s': %s", c.Transfers.Zones[i].Zone, err.Error())
+		}
+	}
+
+	err = c.Webhooks.Parse()
+	if err != nil {
+		return fmt.Errorf("invalid 'webhooks' policy: %s", err.Error())
+	}
+
+	err = c.CORS.Parse()
+	if err != nil {
+		return fmt.Errorf("invalid 'cors' policy: %s", err.Error())
+	}
+
+	err = c.Proxies.Parse()
+	if err != nil {
+		return fmt.Errorf("invalid 'proxies' policy: %s", err.Error())
+	}
+
+	err = c.RateLimits.Parse()
+	if err != nil {
+		return fmt.Errorf("invalid 'rate_limits' policy: %s", err.Error())
+	}
+
+	for name, tier := range c.Keys.Tiers {
+		err = tier.Parse()
+		if err != nil {
+			return fmt.Errorf("invalid tier '%s': %s", name, err.Error())
+		}
+		c.Keys.Tiers[name] = tier
+	}
+
+	for i := range c.Blocklists {
+		err = c.Blocklists[i].Parse()
+		if err != nil {
+			return fmt.Errorf("invalid blocklist '%s': %s", c.Blocklists[i].Name, err.Error())
+		}
+	}
+	return nil
+}
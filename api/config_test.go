@@ -0,0 +1,153 @@
+//
+// Test the loading of our configuration-file.
+//
+
+package api
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// writeConfig writes the given configuration to a temporary file, and
+// returns the path to it.
+//
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+//
+// Test that a valid configuration-file is loaded.
+//
+func TestLoadConfig(t *testing.T) {
+
+	path := writeConfig(t, `{"servers": {"allow": ["192.0.2.0/24", "2001:db8::1"], "deny": ["192.0.2.1"]}}`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	//
+	// The policy was parsed, with single addresses converted to
+	// networks of their own.
+	//
+	for addr, allowed := range map[string]bool{"192.0.2.5": true, "192.0.2.1": false, "2001:db8::1": true, "2001:db8::2": false} {
+		err = c.Servers.AllowedTarget(net.ParseIP(addr), 53)
+		if (err == nil) != allowed {
+			t.Errorf("Unexpected policy for %s: %v", addr, err)
+		}
+	}
+}
+
+//
+// Test that resolvers are loaded, and given a default port.
+//
+func TestLoadConfigResolvers(t *testing.T) {
+
+	path := writeConfig(t, `{"resolvers": [{"name": "one", "address": "192.0.2.1"}, {"name": "two", "address": "[2001:db8::1]:5353"}]}`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(c.Resolvers) != 2 {
+		t.Fatalf("Unexpected resolvers: %v", c.Resolvers)
+	}
+	if c.Resolvers[0].Address != "192.0.2.1:53" || c.Resolvers[1].Address != "[2001:db8::1]:5353" {
+		t.Errorf("Unexpected resolvers: %v", c.Resolvers)
+	}
+}
+
+//
+// Test that bogus configuration-files are rejected.
+//
+func TestLoadConfigInvalid(t *testing.T) {
+
+	tests := map[string]string{
+		`{"servers": `:                                                                                              "failed to parse",
+		`{"servers": {"allow": ["bogus"]}}`:                                                                         "'bogus' is not a valid network",
+		`{"servers": {"deny": ["10.0.0.0/33"]}}`:                                                                    "'10.0.0.0/33' is not a valid network",
+		`{"resolvers": [{"name": "x", "address": "dns.google"}]}`:                                                   "invalid resolver 'x'",
+		`{"resolvers": [{"address": "192.0.2.1"}]}`:                                                                 "invalid resolver ''",
+		`{"transfers": {"zones": [{"zone": "bad..zone"}]}}`:                                                         "'bad..zone' is not a valid zone",
+		`{"transfers": {"zones": [{"zone": "a.test", "servers": ["ns1.a.test"]}]}}`:                                 "is not an IP address",
+		`{"transfers": {"zones": [{"zone": "a.test", "key_name": "k", "secret": "!"}]}}`:                            "not valid base64",
+		`{"transfers": {"zones": [{"zone": "a.test", "key_name": "k", "algorithm": "md5", "secret": "c2VjcmV0"}]}}`: "unsupported TSIG algorithm",
+		`{"webhooks": {"deny": ["bogus"]}}`:                                                                         "invalid 'webhooks' policy",
+		`{"blocklists": [{"zone": "bl.test"}]}`:                                                                     "has no name",
+		`{"blocklists": [{"name": "x", "zone": "bad..zone"}]}`:                                                      "'bad..zone' is not a valid zone",
+		`{"blocklists": [{"name": "x", "zone": "bl.test", "type": "url"}]}`:                                         "unknown type 'url'",
+		`{"blocklists": [{"name": "x", "zone": "bl.test", "codes": {"two": "Spam"}}]}`:                              "'two' is not an address",
+		`{"rate_limits": {"limits": [{"period": "week", "requests": 5}]}}`:                                          "invalid 'rate_limits' policy",
+		`{"rate_limits": {"exempt": ["bogus"]}}`:                                                                    "invalid address 'bogus'",
+		`{"proxies": {"trusted": ["10.0.0.0/33"]}}`:                                                                 "invalid 'proxies' policy",
+		`{"proxies": {"header": "Client-IP"}}`:                                                                      "invalid header 'Client-Ip'",
+	}
+
+	for content, expected := range tests {
+		_, err := LoadConfig(writeConfig(t, content))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error '%s', got %v", expected, err)
+		}
+	}
+
+	_, err := LoadConfig("/this/does/not/exist")
+	if err == nil {
+		t.Errorf("Expected an error loading a missing file")
+	}
+}
+
+//
+// Test that the configuration given to New is validated, and applied, just
+// as that loaded from a file is.
+//
+func TestNewParsesConfig(t *testing.T) {
+
+	config := &Config{
+		Servers:    resolver.NetworkPolicy{Deny: []string{"8.8.8.0/24"}},
+		Transfers:  TransferConfig{Zones: []resolver.TransferZone{{Zone: "Example.TEST", Servers: []string{"192.0.2.1"}}}},
+		Blocklists: []resolver.Blocklist{{Name: "x", Zone: "bl.test"}},
+	}
+	srv := newTestServer(t, Options{Config: config})
+
+	req := httptest.NewRequest("GET", "/@8.8.8.8/a/example.com", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status-code %d for a denied server", rr.Code)
+	}
+
+	zone := config.Transfers.Zones[0]
+	if zone.Zone != "example.test." || zone.Servers[0] != "192.0.2.1:53" {
+		t.Errorf("Unexpected transfer-zone: %v", zone)
+	}
+	if config.Blocklists[0].Type != "ip" {
+		t.Errorf("Unexpected blocklist: %v", config.Blocklists[0])
+	}
+
+	_, err := New(Options{Config: &Config{Webhooks: resolver.NetworkPolicy{Allow: []string{"bogus"}}}})
+	if err == nil || !strings.Contains(err.Error(), "invalid 'webhooks' policy") {
+		t.Errorf("Expected an invalid policy to be rejected, got %v", err)
+	}
+}
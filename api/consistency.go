@@ -0,0 +1,67 @@
+//
+// This file contains the handler for our consistency-check, which
+// compares the answers of every authoritative nameserver of a zone.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// ConsistencyHandler is the handler for our consistency-check.
+//
+// It is called via requests like this:
+//
+//     GET /consistency/$ZONE?type=$TYPE
+//
+func (s *Server) ConsistencyHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	zone := mux.Vars(req)["zone"]
+
+	//
+	// The record-type to compare is optional.
+	//
+	t := strings.ToUpper(req.URL.Query().Get("type"))
+	if _, ok := resolver.StringToType[t]; t != "" && !ok {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT")
+		return
+	}
+
+	report, err := s.resolver.CheckConsistency(zone, t, &s.config.Servers)
+	if err != nil {
+		status = http.StatusNotFound
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["consistency.checks"]++
+	s.mutex.Unlock()
+
+	sendJSON(res, report)
+}
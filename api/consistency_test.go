@@ -0,0 +1,80 @@
+//
+// Test the handler of our consistency-check.
+//
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test a healthy zone, via our HTTP handler.
+//
+func TestConsistencyHandler(t *testing.T) {
+
+	addr := dnstest.Start(t, dnstest.Zone(t,
+		"test. 60 IN NS a.nic.test.",
+		"a.nic.test. 60 IN A 127.0.0.2",
+		"good.test. 60 IN NS ns1.good.test.",
+		"good.test. 60 IN NS ns2.good.test.",
+		"ns1.good.test. 60 IN A 127.0.0.3",
+		"ns2.good.test. 60 IN A 127.0.0.4",
+	))
+	//
+	// Our stand-in nameservers are upon reserved addresses.
+	//
+	config := &Config{Servers: resolver.NetworkPolicy{Allow: []string{"127.0.0.0/8"}}}
+	config.Servers.Parse()
+	srv := newTestServer(t, Options{Config: config}, addr)
+
+	zone := dnstest.Zone(t,
+		"good.test. 60 IN SOA ns1.good.test. hostmaster.good.test. 7 3600 600 86400 60",
+		"good.test. 60 IN NS ns1.good.test.",
+		"good.test. 60 IN NS ns2.good.test.",
+		"good.test. 60 IN MX 10 mail.good.test.")
+	srv.resolver.Port = dnstest.StartAuthoritative(t, map[string]dns.HandlerFunc{
+		"127.0.0.2": zone,
+		"127.0.0.3": zone,
+		"127.0.0.4": zone,
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/consistency/good.test?type=mx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status-code: %d - %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), `"consistent": true`) {
+		t.Errorf("Unexpected body: %s", body)
+	}
+	if !strings.Contains(string(body), `"10 mail.good.test."`) {
+		t.Errorf("Unexpected body: %s", body)
+	}
+
+	//
+	// Bogus types are rejected.
+	//
+	resp, err = http.Get(ts.URL + "/consistency/good.test?type=bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unexpected status-code: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,237 @@
+//
+// This file contains our CORS policy, which controls the web-pages
+// allowed to make requests to us from within a browser.
+//
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// The defaults of our CORS policy, which allow any page to use us.
+//
+var (
+	defaultCORSMethods       = []string{"GET", "POST", "DELETE"}
+	defaultCORSHeaders       = []string{"Accept", "Authorization", "Content-Type", apiKeyHeader}
+	defaultCORSExposeHeaders = []string{
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Delay", "X-RateLimit-IP",
+		"X-EDNS-Client-Subnet", "X-EDNS-Client-Subnet-Scope",
+	}
+)
+
+const defaultCORSMaxAge = 10 * time.Minute
+
+// CORSPolicy controls which web-pages may make requests to us, from
+// within a browser, and what those requests may contain.
+//
+// Empty fields take our defaults, which allow any origin to make GET,
+// POST and DELETE requests.
+type CORSPolicy struct {
+	// Origins are the origins allowed, such as "https://example.com".
+	// A "*" allows every origin, and "https://*.example.com" allows
+	// any subdomain of example.com.
+	Origins []string `json:"origins"`
+
+	// Methods are the methods which may be used.
+	Methods []string `json:"methods"`
+
+	// Headers are the request-headers which may be sent.
+	Headers []string `json:"headers"`
+
+	// ExposeHeaders are the response-headers scripts may read.
+	ExposeHeaders []string `json:"expose_headers"`
+
+	// Credentials allows cookies, and other credentials, to be sent.
+	// It may not be combined with an origin of "*".
+	Credentials bool `json:"credentials"`
+
+	// MaxAge is how long browsers may cache the result of a preflight,
+	// such as "1h".
+	MaxAge string `json:"max_age"`
+
+	// The parsed version of MaxAge.
+	maxAge time.Duration
+}
+
+// Parse validates the policy, and applies our defaults, which must be
+// done before it is used.
+func (p *CORSPolicy) Parse() error {
+
+	if len(p.Origins) == 0 {
+		p.Origins = []string{"*"}
+	}
+	for _, origin := range p.Origins {
+		if origin == "*" {
+			if p.Credentials {
+				return fmt.Errorf("credentials may not be allowed for every origin")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("'%s' is not an origin, such as https://example.com", origin)
+		}
+		if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			return fmt.Errorf("'%s' may only contain a wildcard as its first label", origin)
+		}
+	}
+
+	if len(p.Methods) == 0 {
+		p.Methods = append([]string{}, defaultCORSMethods...)
+	}
+	for i, m := range p.Methods {
+		p.Methods[i] = strings.ToUpper(m)
+	}
+	if len(p.Headers) == 0 {
+		p.Headers = defaultCORSHeaders
+	}
+	if len(p.ExposeHeaders) == 0 {
+		p.ExposeHeaders = defaultCORSExposeHeaders
+	}
+
+	p.maxAge = defaultCORSMaxAge
+	if p.MaxAge != "" {
+		d, err := time.ParseDuration(p.MaxAge)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid max_age '%s' - use a duration such as 1h", p.MaxAge)
+		}
+		p.maxAge = d
+	}
+	return nil
+}
+
+//
+// allowedOrigin returns the value of the Access-Control-Allow-Origin
+// header for the given origin, or "" if it isn't allowed.
+//
+func (p *CORSPolicy) allowedOrigin(origin string) string {
+	for _, o := range p.Origins {
+		if o == "*" {
+			if p.Credentials {
+				return origin
+			}
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return origin
+		}
+
+		//
+		// "https://*.example.com" allows "https://www.example.com",
+		// but not "https://example.com" itself.
+		//
+		if i := strings.Index(o, "://*."); i >= 0 {
+			scheme := strings.ToLower(o[:i+3])
+			suffix := strings.ToLower(strings.TrimSuffix(o[i+4:], "/"))
+			lower := strings.ToLower(origin)
+			if strings.HasPrefix(lower, scheme) && strings.HasSuffix(lower, suffix) && len(lower) > len(scheme)+len(suffix) {
+				return origin
+			}
+		}
+	}
+	return ""
+}
+
+//
+// allows returns true if the given value is in the list, ignoring case.
+//
+func allows(list []string, value string) bool {
+	for _, entry := range list {
+		if strings.EqualFold(entry, value) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// cors applies our CORS policy to every request, and answers preflight
+// requests itself.
+//
+// Requests from origins we don't allow are still served, as CORS is
+// enforced by the browser, but without the headers which would permit
+// the page to read the response.  Their preflights are refused.
+//
+func (s *Server) cors(next http.Handler) http.Handler {
+	p := &s.config.CORS
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		//
+		// Our response depends upon the origin even when there isn't
+		// one, so that caches don't give a response without our
+		// headers to a page, or one with them to anybody else.
+		//
+		h := res.Header()
+		h.Add("Vary", "Origin")
+
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		allowed := p.allowedOrigin(origin)
+
+		//
+		// A preflight is an OPTIONS request which names the method
+		// the real request will use.
+		//
+		method := req.Header.Get("Access-Control-Request-Method")
+		if req.Method == "OPTIONS" && method != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+
+			if allowed == "" {
+				http.Error(res, "Origin '"+origin+"' is not permitted", http.StatusForbidden)
+				return
+			}
+			if !allows(p.Methods, method) {
+				http.Error(res, "Method '"+method+"' is not permitted", http.StatusForbidden)
+				return
+			}
+			for _, name := range strings.Split(req.Header.Get("Access-Control-Request-Headers"), ",") {
+				name = strings.TrimSpace(name)
+				if name != "" && !allows(p.Headers, name) {
+					http.Error(res, "Header '"+name+"' is not permitted", http.StatusForbidden)
+					return
+				}
+			}
+
+			//
+			// We only answer for routes which exist.
+			//
+			probe := req.WithContext(req.Context())
+			probe.Method = method
+			if !s.routeExists(probe) {
+				http.NotFound(res, req)
+				return
+			}
+
+			h.Set("Access-Control-Allow-Origin", allowed)
+			h.Set("Access-Control-Allow-Methods", strings.Join(p.Methods, ", "))
+			h.Set("Access-Control-Allow-Headers", strings.Join(p.Headers, ", "))
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge/time.Second)))
+			if p.Credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed != "" {
+			h.Set("Access-Control-Allow-Origin", allowed)
+			h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposeHeaders, ", "))
+			if p.Credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		next.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,212 @@
+//
+// Test our CORS policy.
+//
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// corsRequest makes a request with the given origin, which is a preflight
+// if method is non-empty.
+//
+func corsRequest(srv *Server, path string, origin string, method string, headers string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if method != "" {
+		req = httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Access-Control-Request-Method", method)
+	}
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+//
+// Test that, by default, every origin may use us.
+//
+func TestCORSDefault(t *testing.T) {
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.test. 60 IN A 192.0.2.1"))
+	srv := newTestServer(t, Options{}, addr)
+
+	rr := corsRequest(srv, "/A/example.test", "https://anywhere.example", "GET", "authorization, content-type")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Unexpected status-code for preflight: %d", rr.Code)
+	}
+	h := rr.Header()
+	if h.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Unexpected origin '%s'", h.Get("Access-Control-Allow-Origin"))
+	}
+	if h.Get("Access-Control-Allow-Methods") != "GET, POST, DELETE" {
+		t.Errorf("Unexpected methods '%s'", h.Get("Access-Control-Allow-Methods"))
+	}
+	if h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Unexpected max-age '%s'", h.Get("Access-Control-Max-Age"))
+	}
+	if h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Credentials shouldn't be allowed by default")
+	}
+
+	rr = corsRequest(srv, "/A/example.test", "https://anywhere.example", "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Unexpected status-code: %d", rr.Code)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Unexpected origin '%s'", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if !strings.Contains(rr.Header().Get("Access-Control-Expose-Headers"), "X-RateLimit-Remaining") {
+		t.Errorf("Our rate-limit headers should be exposed")
+	}
+
+	//
+	// Requests without an origin aren't from browsers.
+	//
+	rr = corsRequest(srv, "/A/example.test", "", "", "")
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Unexpected CORS headers without an origin")
+	}
+	if !allows(rr.Header()["Vary"], "Origin") {
+		t.Errorf("Unexpected Vary %v without an origin", rr.Header()["Vary"])
+	}
+}
+
+//
+// Test a configured policy allows, and denies, the right origins.
+//
+func TestCORSPolicy(t *testing.T) {
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.test. 60 IN A 192.0.2.1"))
+
+	config := &Config{CORS: CORSPolicy{
+		Origins:     []string{"https://app.example.com", "https://*.example.net"},
+		Headers:     []string{"Content-Type", "X-API-Key"},
+		Credentials: true,
+		MaxAge:      "1h",
+	}}
+	srv := newTestServer(t, Options{Config: config}, addr)
+
+	tests := []struct {
+		Name    string
+		Path    string
+		Origin  string
+		Method  string
+		Headers string
+		Status  int
+		Allowed bool
+	}{
+		{"exact origin", "/A/example.test", "https://app.example.com", "GET", "x-api-key", http.StatusNoContent, true},
+		{"origin case", "/A/example.test", "https://APP.example.com", "GET", "", http.StatusNoContent, true},
+		{"subdomain", "/A/example.test", "https://www.example.net", "GET", "", http.StatusNoContent, true},
+		{"deep subdomain", "/watches", "https://a.b.example.net", "POST", "content-type", http.StatusNoContent, true},
+		{"apex of wildcard", "/A/example.test", "https://example.net", "GET", "", http.StatusForbidden, false},
+		{"wrong scheme", "/A/example.test", "http://app.example.com", "GET", "", http.StatusForbidden, false},
+		{"suffix attack", "/A/example.test", "https://evilexample.net", "GET", "", http.StatusForbidden, false},
+		{"other origin", "/A/example.test", "https://evil.example", "GET", "", http.StatusForbidden, false},
+		{"bad method", "/A/example.test", "https://app.example.com", "PUT", "", http.StatusForbidden, false},
+		{"bad header", "/A/example.test", "https://app.example.com", "GET", "x-api-key, x-other", http.StatusForbidden, false},
+		{"unknown route", "/no/such/route/here", "https://app.example.com", "GET", "", http.StatusNotFound, false},
+		{"unrouted method", "/A/example.test", "https://app.example.com", "DELETE", "", http.StatusNotFound, false},
+	}
+
+	for _, test := range tests {
+		rr := corsRequest(srv, test.Path, test.Origin, test.Method, test.Headers)
+		if rr.Code != test.Status {
+			t.Errorf("%s: unexpected status-code %d", test.Name, rr.Code)
+		}
+
+		h := rr.Header()
+		if !test.Allowed {
+			if h.Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("%s: unexpected origin '%s'", test.Name, h.Get("Access-Control-Allow-Origin"))
+			}
+			continue
+		}
+		if h.Get("Access-Control-Allow-Origin") != test.Origin {
+			t.Errorf("%s: the origin should be echoed, got '%s'", test.Name, h.Get("Access-Control-Allow-Origin"))
+		}
+		if h.Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s: credentials should be allowed", test.Name)
+		}
+		if h.Get("Access-Control-Allow-Headers") != "Content-Type, X-API-Key" {
+			t.Errorf("%s: unexpected headers '%s'", test.Name, h.Get("Access-Control-Allow-Headers"))
+		}
+		if h.Get("Access-Control-Max-Age") != "3600" {
+			t.Errorf("%s: unexpected max-age '%s'", test.Name, h.Get("Access-Control-Max-Age"))
+		}
+		if !strings.Contains(strings.Join(h["Vary"], ","), "Origin") {
+			t.Errorf("%s: responses should vary by origin", test.Name)
+		}
+	}
+
+	//
+	// Requests from denied origins are still served, but without the
+	// headers which let the browser show the response to the page.
+	//
+	rr := corsRequest(srv, "/A/example.test", "https://evil.example", "", "")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Unexpected status-code %d", rr.Code)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Unexpected origin for a denied request")
+	}
+
+	rr = corsRequest(srv, "/A/example.test", "https://app.example.com", "", "")
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Unexpected origin for an allowed request '%s'", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+//
+// Test that invalid policies are rejected.
+//
+func TestCORSPolicyParse(t *testing.T) {
+
+	tests := []struct {
+		Policy CORSPolicy
+		Error  string
+	}{
+		{CORSPolicy{Origins: []string{"*"}, Credentials: true}, "credentials may not be allowed for every origin"},
+		{CORSPolicy{Origins: []string{"example.com"}}, "not an origin"},
+		{CORSPolicy{Origins: []string{"ftp://example.com"}}, "not an origin"},
+		{CORSPolicy{Origins: []string{"https://example.com/path"}}, "not an origin"},
+		{CORSPolicy{Origins: []string{"https://www.*.example.com"}}, "may only contain a wildcard as its first label"},
+		{CORSPolicy{MaxAge: "forever"}, "invalid max_age"},
+		{CORSPolicy{MaxAge: "-1h"}, "invalid max_age"},
+	}
+
+	for _, test := range tests {
+		err := test.Policy.Parse()
+		if err == nil || !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("Expected error '%s' for %v, got %v", test.Error, test.Policy, err)
+		}
+	}
+
+	_, err := New(Options{Config: &Config{CORS: CORSPolicy{MaxAge: "forever"}}})
+	if err == nil {
+		t.Errorf("An invalid policy should prevent a server being created")
+	}
+
+	path := writeConfig(t, `{"cors": {"origins": ["https://example.com/"], "methods": ["get"], "max_age": "30s"}}`)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if c.CORS.Methods[0] != "GET" || c.CORS.maxAge.Seconds() != 30 {
+		t.Errorf("Unexpected policy: %v", c.CORS)
+	}
+	if c.CORS.allowedOrigin("https://example.com") != "https://example.com" {
+		t.Errorf("A trailing slash upon an origin should be ignored")
+	}
+}
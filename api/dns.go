@@ -0,0 +1,217 @@
+//
+// This file contains our DNS lookups, the heart of our service.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// DNSHandler is the meat of our service, it is the handler for performing
+// DNS lookups.
+//
+// It is called via requests like this:
+//
+//     GET /$TYPE/$NAME
+//
+// Or, to query a specific nameserver:
+//
+//     GET /@$SERVER/$TYPE/$NAME
+//
+// The answers are returned as JSON, unless another format is chosen via
+// the `format` parameter or the Accept header.
+//
+func (s *Server) DNSHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	h := res.Header()
+
+	//
+	// Show nothing.
+	//
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+
+	//
+	// Apply our rate-limit.
+	//
+	if !s.rateLimit(res, req) {
+		return
+	}
+	ip := s.RemoteIP(req)
+
+	//
+	// Get the query-type and value.
+	//
+	vars := mux.Vars(req)
+	t := vars["type"]
+	v := vars["value"]
+
+	//
+	// Ensure we received parameters.
+	//
+	if len(t) < 1 {
+		status = http.StatusNotFound
+		err = errors.New("Missing 'type' parameter")
+		return
+	}
+	if len(v) < 1 {
+		status = http.StatusNotFound
+		err = errors.New("Missing 'value' parameter")
+		return
+	}
+
+	//
+	// Lookup type should be upper-case
+	//
+	t = strings.ToUpper(t)
+
+	//
+	// Test that the type is valid
+	//
+	if t != "A" &&
+		t != "AAAA" &&
+		t != "CNAME" &&
+		t != "MX" &&
+		t != "NS" &&
+		t != "PTR" &&
+		t != "SOA" &&
+		t != "TXT" {
+		status = http.StatusNotFound
+		err = errors.New("Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT")
+		return
+	}
+
+	//
+	// Parse any query-options the caller supplied.
+	//
+	opts, err := resolver.ParseQueryOptions(req.URL.Query(), ip)
+	if err != nil {
+		status = http.StatusBadRequest
+		return
+	}
+
+	//
+	// The non-internet classes are only really used for TXT records,
+	// such as `version.bind`.
+	//
+	if opts.Class != dns.ClassINET && t != "TXT" {
+		status = http.StatusBadRequest
+		err = errors.New("The CH and HS classes may only be used for TXT lookups")
+		return
+	}
+
+	//
+	// Choose the format of our output.
+	//
+	format, err := negotiateFormat(req)
+	if err != nil {
+		status = http.StatusBadRequest
+		return
+	}
+	h.Add("Vary", "Accept")
+
+	//
+	// Has the caller asked for a specific nameserver to be queried?
+	//
+	server := vars["server"]
+	if server == "" {
+		server = req.URL.Query().Get("server")
+	}
+	if server != "" {
+		opts.Server, err = s.resolver.TargetServer(server, &s.config.Servers)
+		if err != nil {
+			status = http.StatusBadRequest
+			if _, ok := err.(resolver.TargetDenied); ok {
+				status = http.StatusForbidden
+			}
+			return
+		}
+	}
+
+	//
+	// The result of what we'll return
+	//
+	var results []map[string]string
+	var answers []dns.RR
+	r, e := s.resolver.Query(v, t, opts)
+	if _, ok := e.(resolver.NoSuchDomain); ok {
+		s.recordHistory(v, t, nil, opts)
+	}
+	if r != nil {
+		results = resolver.Answers(r)
+		answers = r.Answer
+		s.recordHistory(v, t, results, opts)
+
+		//
+		// If we sent a client-subnet then tell the caller which
+		// subnet was used, and how widely the answer applies.
+		//
+		if opts.ClientSubnet != "" {
+			h.Set("X-EDNS-Client-Subnet", opts.ClientSubnet)
+			if scope, ok := resolver.ClientSubnetScope(r); ok {
+				h.Set("X-EDNS-Client-Subnet-Scope", strconv.Itoa(scope))
+			}
+		}
+
+		//
+		// If the caller asked we'll flag the answers which were
+		// probably synthesized from a wildcard.
+		//
+		if req.URL.Query().Get("wildcard") == "1" {
+			s.resolver.MarkWildcards(results, r, v, t, opts)
+		}
+	}
+
+	//
+	// Now output the results, in the format the caller asked for, if
+	// we got some results.
+	//
+	if len(results) < 1 {
+		//
+		// Error.
+		//
+		s.mutex.Lock()
+		s.stats["dns.type."+t]++
+		s.stats["dns.errors"]++
+		s.mutex.Unlock()
+
+		//
+		// No results.
+		//
+		status = http.StatusNotFound
+		err = errors.New("[]")
+		return
+	}
+
+	//
+	// Show the results.
+	//
+	sendRecords(res, format, results, answers)
+
+	s.mutex.Lock()
+	s.stats["dns.type."+t]++
+	s.stats["dns.queries"]++
+	s.mutex.Unlock()
+
+}
@@ -0,0 +1,393 @@
+//
+// Test our DNS lookups.
+//
+
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/ratelimit"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// newTestServer creates a server with the given options.  Unless they
+// include a resolver its queries are sent to the given stand-in servers.
+//
+// The server is closed when the test completes.
+//
+func newTestServer(t *testing.T, opts Options, servers ...string) *Server {
+	if opts.Resolver == nil {
+		opts.Resolver = resolver.New()
+		opts.Resolver.Nameservers = servers
+	}
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("Failed to create server: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+//
+// Simple test of our HTTP-handler
+//
+func TestInvalidDNSTypes(t *testing.T) {
+	// Wire up the route
+	srv := newTestServer(t, Options{})
+
+	// These are all bogus
+	states := []string{"cnime", "text", "nameserver", "pointer"}
+
+	// Get the test-server
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	for _, ty := range states {
+		url := ts.URL + "/" + ty + "/localhost"
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//
+		// Get the body
+		//
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+
+		if err != nil {
+			t.Errorf("Failed to read response-body %v\n", err)
+		}
+
+		content := fmt.Sprintf("%s", body)
+		if status := resp.StatusCode; status != http.StatusNotFound {
+			t.Errorf("Unexpected status-code: %v", status)
+		}
+		if content != "Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT\n" {
+			t.Fatalf("Unexpected body: '%s'", body)
+		}
+	}
+}
+
+//
+// Test a single lookup of steve.fi's TXT-record.
+//
+func TestSteve(t *testing.T) {
+	// Wire up the route
+	srv := newTestServer(t, Options{})
+
+	// Get the test-server
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	url := ts.URL + "/txt/steve.fi"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//
+	// Get the body
+	//
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Errorf("Failed to read response-body %v\n", err)
+	}
+
+	content := fmt.Sprintf("%s", body)
+	if status := resp.StatusCode; status != http.StatusOK {
+		t.Errorf("Unexpected status-code: %v", status)
+	}
+
+	if !strings.Contains(content, "v=spf1") {
+		t.Fatalf("Unexpected body: '%s'", content)
+	}
+}
+
+//
+// Test a single lookup of a bogus-domain.
+//
+func TestBogusDNS(t *testing.T) {
+
+	// Wire up the route
+	srv := newTestServer(t, Options{})
+
+	// Get the test-server
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	url := ts.URL + "/a/invalid@example.com"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//
+	// Get the body
+	//
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Errorf("Failed to read response-body %v\n", err)
+	}
+
+	content := fmt.Sprintf("%s", body)
+	if status := resp.StatusCode; status != 404 {
+		t.Errorf("Unexpected status-code: %v", status)
+	}
+
+	if !strings.Contains(content, "[]") {
+		t.Fatalf("Unexpected body: '%s'", content)
+	}
+}
+
+//
+// Test that bogus query-options are rejected.
+//
+func TestInvalidQueryOptions(t *testing.T) {
+
+	// Wire up the route
+	srv := newTestServer(t, Options{})
+
+	// Get the test-server
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// The requests to make, and the error we expect.
+	tests := map[string]string{
+		"/a/steve.fi?do=1&edns=0":   "requires EDNS",
+		"/a/steve.fi?timeout=1h":    "Invalid timeout",
+		"/a/steve.fi?class=CH":      "only be used for TXT lookups",
+		"/txt/steve.fi?class=bogus": "Invalid class",
+	}
+
+	for path, expected := range tests {
+
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//
+		// Get the body
+		//
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("Failed to read response-body %v\n", err)
+		}
+
+		if status := resp.StatusCode; status != http.StatusBadRequest {
+			t.Errorf("Unexpected status-code for %s: %v", path, status)
+		}
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Unexpected body for %s: '%s'", path, body)
+		}
+	}
+}
+
+//
+// Test that the /@server/ form of our API queries the right server.
+//
+func TestDNSHandlerServer(t *testing.T) {
+
+	//
+	// The server we'll be asking returns a fixed TXT record.
+	//
+	addr := dnstest.Start(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Authoritative = true
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{"from the stand-in"},
+		})
+		w.WriteMsg(m)
+	})
+
+	srv := newTestServer(t, Options{})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	//
+	// By default loopback addresses are forbidden.
+	//
+	status, body := get("/@" + addr + "/txt/example.com")
+	if status != http.StatusForbidden {
+		t.Errorf("Unexpected status-code: %d - %s", status, body)
+	}
+
+	//
+	// But once allowed, in either form, we get our answer.
+	//
+	srv.config.Servers = resolver.NetworkPolicy{Allow: []string{"127.0.0.1"}}
+	if err := srv.config.Servers.Parse(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, path := range []string{"/@" + addr + "/txt/example.com", "/txt/example.com?server=" + addr} {
+		status, body = get(path)
+		if status != http.StatusOK {
+			t.Errorf("Unexpected status-code for %s: %d - %s", path, status, body)
+		}
+		if !strings.Contains(body, "from the stand-in") {
+			t.Errorf("Unexpected body for %s: %s", path, body)
+		}
+	}
+}
+
+//
+// Test that clients are rate-limited, even without redis.
+//
+func TestDNSHandlerRateLimit(t *testing.T) {
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.test. 60 IN A 192.0.2.1"))
+	srv := newTestServer(t, Options{}, addr)
+
+	for i := 0; i < 201; i++ {
+		req := httptest.NewRequest("GET", "/A/example.test", nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if i < 200 && rr.Code != http.StatusOK {
+			t.Fatalf("%d: unexpected status-code %d", i, rr.Code)
+		}
+		if i == 200 && rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected the request to be limited, got %d", rr.Code)
+		}
+		if rr.Header().Get("X-RateLimit-Remaining") != fmt.Sprintf("%d", 199-i) && i < 200 {
+			t.Errorf("%d: unexpected remaining '%s'", i, rr.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+
+	//
+	// Other clients are unaffected.
+	//
+	req := httptest.NewRequest("GET", "/A/example.test", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Another client shouldn't be limited, got %d", rr.Code)
+	}
+}
+
+//
+// Test that our rate-limits may be configured, and replaced.
+//
+func TestRateLimitPolicy(t *testing.T) {
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.test. 60 IN A 192.0.2.1"))
+
+	config := &Config{RateLimits: ratelimit.Policy{
+		Limits: []ratelimit.Limit{{Period: "minute", Requests: 5}},
+		Costs:  map[string]int64{"dns": 2},
+		Exempt: []string{"198.51.100.0/24"},
+	}}
+	srv := newTestServer(t, Options{Config: config}, addr)
+
+	lookup := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/A/example.test", nil)
+		req.RemoteAddr = remote + ":1234"
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i, remaining := range []string{"3", "1"} {
+		rr := lookup("192.0.2.1")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%d: unexpected status-code %d", i, rr.Code)
+		}
+		if rr.Header().Get("X-RateLimit-Limit") != "5" || rr.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("%d: unexpected headers %v", i, rr.Header())
+		}
+	}
+	rr := lookup("192.0.2.1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the request to be limited, got %d", rr.Code)
+	}
+
+	for i := 0; i < 10; i++ {
+		rr = lookup("198.51.100.1")
+		if rr.Code != http.StatusOK || rr.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("%d: an exempt client was limited", i)
+		}
+	}
+
+	//
+	// An invalid policy is refused, and the current one retained.
+	//
+	err := srv.SetRateLimits(ratelimit.Policy{Costs: map[string]int64{"trace": 5}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "there is no 'trace' route") {
+		t.Errorf("Unexpected error %v", err)
+	}
+	rr = lookup("192.0.2.1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the request to be limited, got %d", rr.Code)
+	}
+
+	//
+	// Raising the limit allows the client to continue.
+	//
+	err = srv.SetRateLimits(ratelimit.Policy{
+		Limits: []ratelimit.Limit{{Period: "minute", Requests: 10}},
+		Costs:  map[string]int64{"propagation": 3},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	rr = lookup("192.0.2.1")
+	if rr.Code != http.StatusOK || rr.Header().Get("X-RateLimit-Remaining") != "5" {
+		t.Errorf("Unexpected response %d %v", rr.Code, rr.Header())
+	}
+
+	_, err = New(Options{Config: &Config{RateLimits: ratelimit.Policy{Costs: map[string]int64{"bulk": 2}}}})
+	if err == nil {
+		t.Errorf("A policy naming a missing route should prevent a server being created")
+	}
+}
+
+//
+// Test the names of our routes, as used by our rate-limits.
+//
+func TestRouteName(t *testing.T) {
+	tests := map[string]string{
+		"/{type}/{value}":            "dns",
+		"/{type}/{value}/":           "dns",
+		"/@{server}/{type}/{value}":  "dns",
+		"/propagation/{type}/{name}": "propagation",
+		"/watches":                   "watches",
+		"/watches/{id}":              "watches",
+		"/":                          "",
+	}
+	for path, expected := range tests {
+		if routeName(path) != expected {
+			t.Errorf("Unexpected name for %s: %s", path, routeName(path))
+		}
+	}
+}
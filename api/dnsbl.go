@@ -0,0 +1,59 @@
+//
+// This file contains the handler for our blocklist-check.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// DNSBLHandler is the handler for our blocklist-check.
+//
+// It is called via requests like this:
+//
+//     GET /dnsbl/$IP
+//     GET /dnsbl/$DOMAIN
+//
+func (s *Server) DNSBLHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	lists := s.config.Blocklists
+	if len(lists) == 0 {
+		lists = resolver.DefaultBlocklists
+	}
+
+	report, err := s.resolver.CheckBlocklists(mux.Vars(req)["target"], lists)
+	if err != nil {
+		status = http.StatusBadRequest
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["dnsbl.checks"]++
+	s.mutex.Unlock()
+
+	sendJSON(res, report)
+}
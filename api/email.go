@@ -0,0 +1,84 @@
+//
+// This file contains the handler for our email-authentication report.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// EmailHandler is the handler for our email-authentication report.
+//
+// It is called via requests like this:
+//
+//     GET /email/$DOMAIN?selectors=$SELECTOR1,$SELECTOR2
+//
+func (s *Server) EmailHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	domain := mux.Vars(req)["domain"]
+	if _, ok := dns.IsDomainName(domain); !ok {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid domain '" + domain + "'")
+		return
+	}
+
+	//
+	// The selectors come from the caller, the configuration-file, or
+	// our defaults - in that order.
+	//
+	selectors := s.config.DKIMSelectors
+	if len(selectors) == 0 {
+		selectors = resolver.DefaultDKIMSelectors
+	}
+	if str := req.URL.Query().Get("selectors"); str != "" {
+		selectors = nil
+		for _, sel := range strings.Split(str, ",") {
+			sel = strings.TrimSpace(sel)
+			if _, ok := dns.IsDomainName(sel); !ok || sel == "" {
+				status = http.StatusBadRequest
+				err = errors.New("Invalid selector '" + sel + "'")
+				return
+			}
+			selectors = append(selectors, sel)
+		}
+		if len(selectors) > 20 {
+			status = http.StatusBadRequest
+			err = errors.New("Too many selectors - the limit is 20")
+			return
+		}
+	}
+
+	report := s.resolver.CheckEmail(domain, selectors)
+
+	s.mutex.Lock()
+	s.stats["email.checks"]++
+	s.mutex.Unlock()
+
+	sendJSON(res, report)
+}
@@ -0,0 +1,300 @@
+//
+// This file contains the formats our answers may be returned in, which
+// the caller chooses via the `format` parameter or their Accept header.
+//
+
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// recordFormat is one of the formats our answers may be written in.
+type recordFormat struct {
+	// Name is the value of the `format` parameter which selects us.
+	Name string
+
+	// ContentType is the type we're served with, and the first of
+	// MediaTypes.
+	ContentType string
+
+	// MediaTypes are the types which select us via an Accept header.
+	MediaTypes []string
+
+	// Write outputs the answers, which are both the records we return
+	// as maps and the resource-records they were created from.
+	Write func(w io.Writer, records []map[string]string, rrs []dns.RR) error
+}
+
+//
+// recordFormats are our supported formats, the first is our default.
+//
+var recordFormats = []recordFormat{
+	{"json", "application/json", []string{"application/json"}, writePrettyJSON},
+	{"compact", "application/json", nil, writeCompactJSON},
+	{"ndjson", "application/x-ndjson", []string{"application/x-ndjson", "application/ndjson", "application/jsonl"}, writeNDJSON},
+	{"csv", "text/csv; charset=utf-8", []string{"text/csv"}, writeCSV},
+	{"yaml", "application/yaml", []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}, writeYAML},
+	{"plain", "text/plain; charset=utf-8", []string{"text/plain"}, writePlain},
+	{"zone", "text/dns", []string{"text/dns"}, writeZone},
+}
+
+// FormatNames returns the names of the formats our answers may be written
+// in, separated by `|`, for error-messages and usage.
+func FormatNames() string {
+	var names []string
+	for _, f := range recordFormats {
+		names = append(names, f.Name)
+	}
+	return strings.Join(names, "|")
+}
+
+//
+// negotiateFormat chooses the format to return our answers in.
+//
+// An explicit `format` parameter wins, and must be valid.  Otherwise we
+// use the most-preferred type in the Accept header that we support,
+// falling back to our default so that browsers, which accept anything,
+// continue to receive JSON.
+//
+func negotiateFormat(req *http.Request) (*recordFormat, error) {
+
+	if name := req.URL.Query().Get("format"); name != "" {
+		return findFormat(name)
+	}
+
+	for _, mediaType := range acceptedTypes(req.Header.Get("Accept")) {
+		for i := range recordFormats {
+			for _, t := range recordFormats[i].MediaTypes {
+				if t == mediaType {
+					return &recordFormats[i], nil
+				}
+			}
+		}
+	}
+	return &recordFormats[0], nil
+}
+
+//
+// findFormat returns the format with the given name.
+//
+func findFormat(name string) (*recordFormat, error) {
+	for i := range recordFormats {
+		if strings.EqualFold(recordFormats[i].Name, name) {
+			return &recordFormats[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid format '%s' - use %s", name, FormatNames())
+}
+
+// WriteRecords writes the given answers to w in the named format, exactly
+// as our DNS handler would return them.
+func WriteRecords(w io.Writer, name string, records []map[string]string, rrs []dns.RR) error {
+	format, err := findFormat(name)
+	if err != nil {
+		return err
+	}
+	return format.Write(w, records, rrs)
+}
+
+//
+// acceptedTypes returns the media-types of an Accept header, in order of
+// preference, omitting those with a quality of zero.
+//
+func acceptedTypes(header string) []string {
+
+	type accepted struct {
+		mediaType string
+		quality   float64
+	}
+
+	var all []accepted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		a := accepted{
+			mediaType: strings.ToLower(strings.TrimSpace(fields[0])),
+			quality:   1,
+		}
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+				if err == nil {
+					a.quality = q
+				}
+			}
+		}
+		if a.mediaType != "" && a.quality > 0 {
+			all = append(all, a)
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].quality > all[j].quality
+	})
+
+	var out []string
+	for _, a := range all {
+		out = append(out, a.mediaType)
+	}
+	return out
+}
+
+//
+// sendRecords writes our answers to the caller, in the given format.
+//
+func sendRecords(res http.ResponseWriter, format *recordFormat, records []map[string]string, rrs []dns.RR) {
+	res.Header().Set("Content-Type", format.ContentType)
+	err := format.Write(res, records, rrs)
+	if err != nil {
+		sendError(res, http.StatusInternalServerError, err)
+	}
+}
+
+//
+// writePrettyJSON writes our answers as indented JSON, as we always
+// have done.
+//
+func writePrettyJSON(w io.Writer, records []map[string]string, rrs []dns.RR) error {
+	out, err := json.MarshalIndent(records, "", "     ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s", out)
+	return err
+}
+
+//
+// writeCompactJSON writes our answers as JSON, without whitespace.
+//
+func writeCompactJSON(w io.Writer, records []map[string]string, rrs []dns.RR) error {
+	return json.NewEncoder(w).Encode(records)
+}
+
+//
+// writeNDJSON writes each answer as a JSON object, upon its own line.
+//
+func writeNDJSON(w io.Writer, records []map[string]string, rrs []dns.RR) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		err := enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// recordColumns returns the fields of our answers, in a stable order:
+// name, ttl, type and value, followed by any others alphabetically.
+//
+func recordColumns(records []map[string]string) []string {
+	columns := []string{"name", "ttl", "type", "value"}
+
+	var extra []string
+	for _, r := range records {
+		for key := range r {
+			known := false
+			for _, c := range append(columns, extra...) {
+				known = known || c == key
+			}
+			if !known {
+				extra = append(extra, key)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(columns, extra...)
+}
+
+//
+// writeCSV writes our answers as CSV, with a header-row.
+//
+func writeCSV(w io.Writer, records []map[string]string, rrs []dns.RR) error {
+	columns := recordColumns(records)
+
+	c := csv.NewWriter(w)
+	c.Write(columns)
+	for _, r := range records {
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			row[i] = r[col]
+		}
+		c.Write(row)
+	}
+	c.Flush()
+	return c.Error()
+}
+
+//
+// writeYAML writes our answers as a YAML sequence of mappings.
+//
+// Every value is a double-quoted scalar, using JSON's escaping which is
+// valid YAML, so that values such as "no" and "1e3" are not mistaken for
+// other types.
+//
+func writeYAML(w io.Writer, records []map[string]string, rrs []dns.RR) error {
+	if len(records) == 0 {
+		_, err := fmt.Fprintf(w, "[]\n")
+		return err
+	}
+
+	columns := recordColumns(records)
+	for _, r := range records {
+		prefix := "- "
+		for _, col := range columns {
+			val, ok := r[col]
+			if !ok {
+				continue
+			}
+			quoted, err := json.Marshal(val)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s%s: %s\n", prefix, col, quoted)
+			if err != nil {
+				return err
+			}
+			prefix = "  "
+		}
+	}
+	return nil
+}
+
+//
+// writePlain writes only the value of each answer, one per line, which
+// is handy in shell scripts.
+//
+func writePlain(w io.Writer, records []map[string]string, rrs []dns.RR) error {
+	for _, r := range records {
+		_, err := fmt.Fprintf(w, "%s\n", r["value"])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// writeZone writes our answers in the presentation format of a BIND
+// zone-file, one record per line.
+//
+func writeZone(w io.Writer, records []map[string]string, rrs []dns.RR) error {
+	for _, rr := range rrs {
+		_, err := fmt.Fprintf(w, "%s\n", rr.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
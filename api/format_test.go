@@ -0,0 +1,257 @@
+//
+// Test the formats our answers may be returned in.
+//
+
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// testAnswers returns the answers our formatters are tested with.
+//
+func testAnswers(t *testing.T) ([]map[string]string, []dns.RR) {
+	var rrs []dns.RR
+	for _, str := range []string{
+		"example.test. 300 IN MX 10 mail.example.test.",
+		"example.test. 300 IN TXT \"v=spf1 a, -all\"",
+	} {
+		rr, err := dns.NewRR(str)
+		if err != nil {
+			t.Fatalf("Invalid test-record: %s", err)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	records := []map[string]string{}
+	for _, rr := range rrs {
+		records = append(records, resolver.FormatRR(rr))
+	}
+	return records, rrs
+}
+
+//
+// formatByName returns one of our formats, by name.
+//
+func formatByName(t *testing.T, name string) *recordFormat {
+	for i := range recordFormats {
+		if recordFormats[i].Name == name {
+			return &recordFormats[i]
+		}
+	}
+	t.Fatalf("No such format: %s", name)
+	return nil
+}
+
+//
+// Test the output of each of our formatters.
+//
+func TestRecordFormats(t *testing.T) {
+	records, rrs := testAnswers(t)
+
+	tests := []struct {
+		Format string
+		Expect string
+	}{
+		{"json", `[
+     {
+          "name": "example.test.",
+          "ttl": "300",
+          "type": "MX",
+          "value": "10\tmail.example.test."
+     },
+     {
+          "name": "example.test.",
+          "ttl": "300",
+          "type": "TXT",
+          "value": "v=spf1 a, -all"
+     }
+]`},
+		{"compact", `[{"name":"example.test.","ttl":"300","type":"MX","value":"10\tmail.example.test."},{"name":"example.test.","ttl":"300","type":"TXT","value":"v=spf1 a, -all"}]
+`},
+		{"ndjson", `{"name":"example.test.","ttl":"300","type":"MX","value":"10\tmail.example.test."}
+{"name":"example.test.","ttl":"300","type":"TXT","value":"v=spf1 a, -all"}
+`},
+		{"csv", `name,ttl,type,value
+example.test.,300,MX,10` + "\t" + `mail.example.test.
+example.test.,300,TXT,"v=spf1 a, -all"
+`},
+		{"yaml", `- name: "example.test."
+  ttl: "300"
+  type: "MX"
+  value: "10\tmail.example.test."
+- name: "example.test."
+  ttl: "300"
+  type: "TXT"
+  value: "v=spf1 a, -all"
+`},
+		{"plain", "10\tmail.example.test.\nv=spf1 a, -all\n"},
+		{"zone", "example.test.\t300\tIN\tMX\t10 mail.example.test.\nexample.test.\t300\tIN\tTXT\t\"v=spf1 a, -all\"\n"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := formatByName(t, test.Format).Write(&out, records, rrs)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.Format, err)
+			continue
+		}
+		if out.String() != test.Expect {
+			t.Errorf("%s: unexpected output:\n%s\nexpected:\n%s", test.Format, out.String(), test.Expect)
+		}
+	}
+}
+
+//
+// Test that fields beyond the usual, such as the wildcard flag, are
+// included in our tabular formats.
+//
+func TestRecordFormatsExtraFields(t *testing.T) {
+	records := []map[string]string{
+		{"name": "a.example.test.", "ttl": "60", "type": "A", "value": "192.0.2.1", "wildcard": "true"},
+		{"name": "b.example.test.", "ttl": "60", "type": "A", "value": "192.0.2.2"},
+	}
+
+	var out bytes.Buffer
+	writeCSV(&out, records, nil)
+	expect := "name,ttl,type,value,wildcard\na.example.test.,60,A,192.0.2.1,true\nb.example.test.,60,A,192.0.2.2,\n"
+	if out.String() != expect {
+		t.Errorf("Unexpected CSV:\n%s", out.String())
+	}
+
+	out.Reset()
+	writeYAML(&out, records, nil)
+	expect = `- name: "a.example.test."
+  ttl: "60"
+  type: "A"
+  value: "192.0.2.1"
+  wildcard: "true"
+- name: "b.example.test."
+  ttl: "60"
+  type: "A"
+  value: "192.0.2.2"
+`
+	if out.String() != expect {
+		t.Errorf("Unexpected YAML:\n%s", out.String())
+	}
+
+	out.Reset()
+	writeYAML(&out, nil, nil)
+	if out.String() != "[]\n" {
+		t.Errorf("Unexpected empty YAML: '%s'", out.String())
+	}
+}
+
+//
+// Test that the format is chosen correctly.
+//
+func TestNegotiateFormat(t *testing.T) {
+
+	tests := []struct {
+		Query  string
+		Accept string
+		Format string
+		Error  bool
+	}{
+		{"", "", "json", false},
+		{"", "*/*", "json", false},
+		{"", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "json", false},
+		{"", "application/json", "json", false},
+		{"", "application/x-ndjson", "ndjson", false},
+		{"", "text/csv", "csv", false},
+		{"", "application/yaml", "yaml", false},
+		{"", "text/plain", "plain", false},
+		{"", "text/dns", "zone", false},
+		{"", "TEXT/CSV; charset=utf-8", "csv", false},
+		{"", "text/plain;q=0.5, text/csv", "csv", false},
+		{"", "text/csv;q=0, text/plain", "plain", false},
+		{"", "application/unknown", "json", false},
+		{"format=compact", "", "compact", false},
+		{"format=YAML", "", "yaml", false},
+		{"format=zone", "text/csv", "zone", false},
+		{"format=xml", "", "", true},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/A/example.test?"+test.Query, nil)
+		if test.Accept != "" {
+			req.Header.Set("Accept", test.Accept)
+		}
+
+		f, err := negotiateFormat(req)
+		if test.Error {
+			if err == nil {
+				t.Errorf("Expected an error for '%s'", test.Query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", test.Query, err)
+			continue
+		}
+		if f.Name != test.Format {
+			t.Errorf("Expected %s for '%s' & '%s', got %s", test.Format, test.Query, test.Accept, f.Name)
+		}
+	}
+}
+
+//
+// Test that our DNS handler returns each format, with the right
+// content-type.
+//
+func TestDNSHandlerFormats(t *testing.T) {
+	addr := dnstest.Start(t, dnstest.Zone(t,
+		"example.test. 60 IN A 192.0.2.1",
+		"example.test. 60 IN A 192.0.2.2",
+	))
+	srv := newTestServer(t, Options{}, addr)
+
+	tests := []struct {
+		Path   string
+		Accept string
+		Status int
+		Type   string
+		Body   string
+	}{
+		{"/A/example.test", "", http.StatusOK, "application/json", `"value": "192.0.2.1"`},
+		{"/A/example.test?format=compact", "", http.StatusOK, "application/json", `"value":"192.0.2.1"`},
+		{"/A/example.test", "application/x-ndjson", http.StatusOK, "application/x-ndjson", `{"name":"example.test.","ttl":"60","type":"A","value":"192.0.2.2"}`},
+		{"/A/example.test?format=csv", "", http.StatusOK, "text/csv; charset=utf-8", "example.test.,60,A,192.0.2.1\n"},
+		{"/A/example.test", "application/yaml", http.StatusOK, "application/yaml", `  value: "192.0.2.2"`},
+		{"/A/example.test?format=plain", "", http.StatusOK, "text/plain; charset=utf-8", "192.0.2.1\n192.0.2.2\n"},
+		{"/A/example.test?format=zone", "", http.StatusOK, "text/dns", "example.test.\t60\tIN\tA\t192.0.2.2\n"},
+		{"/A/example.test?format=xml", "", http.StatusBadRequest, "", "Invalid format 'xml'"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.Path, nil)
+		if test.Accept != "" {
+			req.Header.Set("Accept", test.Accept)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if rr.Code != test.Status {
+			t.Errorf("%s: unexpected status-code %d", test.Path, rr.Code)
+			continue
+		}
+		if test.Type != "" && rr.Header().Get("Content-Type") != test.Type {
+			t.Errorf("%s: unexpected content-type '%s'", test.Path, rr.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(rr.Body.String(), test.Body) {
+			t.Errorf("%s: unexpected body:\n%s", test.Path, rr.Body.String())
+		}
+		if test.Status == http.StatusOK && !allows(rr.Header()["Vary"], "Accept") {
+			t.Errorf("%s: missing Vary header", test.Path)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+//
+// This file contains our (optional) history of answers.
+//
+// Each time we resolve a name we record the answer, ignoring TTLs, and
+// if it differs from the answer we saw previously a new entry is added.
+// That allows us to answer questions like "what did this name point to
+// last Tuesday?".
+//
+// The history is held in memory, and periodically written to a file.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// maxHistoryEntries is the number of distinct answers we keep for each
+// name & type, the oldest are discarded first.
+//
+const maxHistoryEntries = 100
+
+//
+// maxHistoryKeys is the number of names & types we'll keep history for,
+// once reached new names are not recorded.
+//
+const maxHistoryKeys = 100000
+
+// HistoryRecord is a single record of an answer.
+type HistoryRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// HistoryEntry is a distinct answer, and the period we saw it for.
+type HistoryEntry struct {
+	FirstSeen time.Time       `json:"first_seen"`
+	LastSeen  time.Time       `json:"last_seen"`
+	Records   []HistoryRecord `json:"records"`
+}
+
+// HistoryReport is the history of a single name & type.
+type HistoryReport struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+//
+// historyStore holds the answers we've seen, keyed by name & type.
+//
+type historyStore struct {
+	sync.Mutex
+
+	// path is the file we're persisted to.
+	path string
+
+	// entries holds the history of each name & type, oldest first.
+	entries map[string][]HistoryEntry
+
+	// dirty is true if there are changes which haven't been written.
+	dirty bool
+}
+
+//
+// historyKey returns the key we store a name & type under.
+//
+func historyKey(name string, qtype string) string {
+	return strings.ToLower(dns.Fqdn(name)) + "/" + qtype
+}
+
+//
+// loadHistory opens the history stored in the given file, which need not
+// exist yet.
+//
+func loadHistory(path string) (*historyStore, error) {
+
+	h := &historyStore{
+		path:    path,
+		entries: make(map[string][]HistoryEntry),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &h.entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	return h, nil
+}
+
+//
+// Observe records the answer to a lookup, seen at the given time.
+//
+func (h *historyStore) Observe(name string, qtype string, results []map[string]string, now time.Time) {
+
+	records := []HistoryRecord{}
+	for _, result := range results {
+		records = append(records, HistoryRecord{Name: result["name"], Type: result["type"], Value: result["value"]})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		return a.Name+"\t"+a.Type+"\t"+a.Value < b.Name+"\t"+b.Type+"\t"+b.Value
+	})
+
+	key := historyKey(name, qtype)
+	now = now.UTC()
+
+	h.Lock()
+	defer h.Unlock()
+
+	entries, ok := h.entries[key]
+	if !ok && len(h.entries) >= maxHistoryKeys {
+		return
+	}
+	h.dirty = true
+
+	//
+	// If the answer is unchanged we've just seen it for longer.
+	//
+	if len(entries) > 0 {
+		last := &entries[len(entries)-1]
+		if sameRecords(last.Records, records) {
+			if now.After(last.LastSeen) {
+				last.LastSeen = now
+			}
+			return
+		}
+	}
+
+	entries = append(entries, HistoryEntry{FirstSeen: now, LastSeen: now, Records: records})
+	if len(entries) > maxHistoryEntries {
+		entries = entries[len(entries)-maxHistoryEntries:]
+	}
+	h.entries[key] = entries
+}
+
+//
+// sameRecords tests whether two sorted lists of records are identical.
+//
+func sameRecords(a []HistoryRecord, b []HistoryRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// History returns the entries we have for the given name & type, oldest
+// first.
+//
+func (h *historyStore) History(name string, qtype string) []HistoryEntry {
+	h.Lock()
+	defer h.Unlock()
+
+	return append([]HistoryEntry{}, h.entries[historyKey(name, qtype)]...)
+}
+
+//
+// At returns the answer which was current at the given time: the last
+// one we had first seen by then.  The boolean is false if we have no
+// answer from that long ago.
+//
+func (h *historyStore) At(name string, qtype string, when time.Time) (HistoryEntry, bool) {
+	entries := h.History(name, qtype)
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].FirstSeen.After(when) {
+			return entries[i], true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+//
+// Flush writes the history to its file, if it has changed.  A temporary
+// file is renamed into place so that a crash never leaves it half-written.
+//
+func (h *historyStore) Flush() error {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(h.entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(h.path), ".history")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), h.path)
+	if err == nil {
+		h.dirty = false
+	}
+	return err
+}
+
+//
+// recordHistory records the results of a lookup made by the DNSHandler,
+// if history is enabled.
+//
+// Only lookups made with our default options, other than the timeout,
+// are recorded - the answers of other servers, for a particular client
+// subnet, or without recursion, aren't what the world sees.  Otherwise
+// callers could fill the history of a name with referrals and signatures,
+// pushing out its real answers.
+//
+func (s *Server) recordHistory(name string, qtype string, results []map[string]string, opts resolver.QueryOptions) {
+	defaults := resolver.DefaultQueryOptions()
+	opts.Timeout = defaults.Timeout
+	if s.history == nil || opts != defaults {
+		return
+	}
+	s.history.Observe(name, qtype, results, time.Now())
+}
+
+//
+// parseTime parses the time given to the `at` parameter, which may be
+// RFC 3339, a date, or seconds since the epoch.
+//
+func parseTime(str string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid time '" + str + "' - use RFC 3339, YYYY-MM-DD, or seconds since the epoch")
+}
+
+//
+// HistoryHandler is the handler for browsing our history.
+//
+// It is called via requests like this:
+//
+//     GET /history/$TYPE/$NAME
+//     GET /history/$TYPE/$NAME?at=2024-01-02T15:00:00Z
+//
+func (s *Server) HistoryHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+	if s.history == nil {
+		status = http.StatusNotFound
+		err = errors.New("History is not enabled upon this server")
+		return
+	}
+
+	vars := mux.Vars(req)
+	t := strings.ToUpper(vars["type"])
+	v := vars["name"]
+
+	if _, ok := resolver.StringToType[t]; !ok {
+		status = http.StatusNotFound
+		err = errors.New("Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT")
+		return
+	}
+	if _, ok := dns.IsDomainName(v); !ok {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid name '" + v + "'")
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["history.queries"]++
+	s.mutex.Unlock()
+
+	//
+	// Either the answer at a particular time, or all of them.
+	//
+	if at := req.URL.Query().Get("at"); at != "" {
+		var when time.Time
+		when, err = parseTime(at)
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+		entry, ok := s.history.At(v, t, when)
+		if !ok {
+			status = http.StatusNotFound
+			err = errors.New("No answer was recorded at that time")
+			return
+		}
+		sendJSON(res, entry)
+		return
+	}
+
+	entries := s.history.History(v, t)
+	if len(entries) == 0 {
+		status = http.StatusNotFound
+		err = errors.New("No answers have been recorded")
+		return
+	}
+	sendJSON(res, HistoryReport{Name: dns.Fqdn(v), Type: t, Entries: entries})
+}
@@ -0,0 +1,246 @@
+//
+// Test our history of answers.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// newTestHistory creates an empty history, in a temporary file.
+//
+func newTestHistory(t *testing.T) *historyStore {
+	h, err := loadHistory(filepath.Join(t.TempDir(), "history.json"))
+	if err != nil {
+		t.Fatalf("Failed to create history: %s", err)
+	}
+	return h
+}
+
+//
+// answer returns a single A-record result, as the DNSHandler sees it.
+//
+func answer(value string) []map[string]string {
+	return []map[string]string{{"name": "www.example.test.", "type": "A", "value": value, "ttl": "60"}}
+}
+
+//
+// Test that changes are recorded, and the answer at a given time found.
+//
+func TestHistoryObserve(t *testing.T) {
+
+	h := newTestHistory(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	h.Observe("www.example.test", "A", answer("192.0.2.1"), start)
+	h.Observe("WWW.example.test.", "A", answer("192.0.2.1"), start.Add(time.Hour))
+	h.Observe("www.example.test", "A", answer("192.0.2.2"), start.Add(2*time.Hour))
+	h.Observe("www.example.test", "A", nil, start.Add(3*time.Hour))
+
+	entries := h.History("www.example.test", "A")
+	if len(entries) != 3 {
+		t.Fatalf("Expected three entries, got %v", entries)
+	}
+	if !entries[0].LastSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("An unchanged answer should extend the last entry: %v", entries[0])
+	}
+	if len(entries[2].Records) != 0 {
+		t.Errorf("Expected an empty answer to be recorded: %v", entries[2])
+	}
+
+	tests := []struct {
+		when  time.Time
+		found bool
+		value string
+	}{
+		{start.Add(-time.Minute), false, ""},
+		{start, true, "192.0.2.1"},
+		{start.Add(90 * time.Minute), true, "192.0.2.1"},
+		{start.Add(2 * time.Hour), true, "192.0.2.2"},
+		{start.Add(150 * time.Minute), true, "192.0.2.2"},
+		{start.Add(24 * time.Hour), true, ""},
+	}
+
+	for _, test := range tests {
+		entry, ok := h.At("www.example.test", "A", test.when)
+		if ok != test.found {
+			t.Errorf("At(%s) found=%t, expected %t", test.when, ok, test.found)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		value := ""
+		if len(entry.Records) > 0 {
+			value = entry.Records[0].Value
+		}
+		if value != test.value {
+			t.Errorf("At(%s) gave '%s', expected '%s'", test.when, value, test.value)
+		}
+	}
+
+	if _, ok := h.At("www.example.test", "AAAA", start.Add(time.Hour)); ok {
+		t.Errorf("Found an answer for a type which was never seen")
+	}
+}
+
+//
+// Test that the history survives a restart.
+//
+func TestHistoryFlush(t *testing.T) {
+
+	h := newTestHistory(t)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.Observe("www.example.test", "A", answer("192.0.2.1"), now)
+
+	err := h.Flush()
+	if err != nil {
+		t.Fatalf("Failed to flush: %s", err)
+	}
+	if h.dirty {
+		t.Errorf("The history should be clean after flushing")
+	}
+
+	loaded, err := loadHistory(h.path)
+	if err != nil {
+		t.Fatalf("Failed to reload: %s", err)
+	}
+	entry, ok := loaded.At("www.example.test", "A", now)
+	if !ok || len(entry.Records) != 1 || entry.Records[0].Value != "192.0.2.1" {
+		t.Errorf("Unexpected reloaded entry: %v", entry)
+	}
+}
+
+//
+// Test the parsing of the times we accept.
+//
+func TestParseTime(t *testing.T) {
+
+	expected := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, str := range []string{"2024-01-02", "2024-01-02T00:00:00", "2024-01-02T00:00:00Z", "2024-01-02T01:00:00+01:00", "1704153600"} {
+		when, err := parseTime(str)
+		if err != nil {
+			t.Errorf("Failed to parse '%s': %s", str, err)
+			continue
+		}
+		if !when.Equal(expected) {
+			t.Errorf("'%s' gave %s, expected %s", str, when, expected)
+		}
+	}
+
+	if _, err := parseTime("last tuesday"); err == nil {
+		t.Errorf("Expected an error parsing a bogus time")
+	}
+}
+
+//
+// Test that lookups are recorded, and may be browsed via the handler.
+//
+func TestHistoryHandler(t *testing.T) {
+
+	addr := dnstest.Start(t, dnstest.Zone(t,
+		"www.example.test. 60 IN A 192.0.2.1",
+	))
+	srv := newTestServer(t, Options{}, addr)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//
+	// Disabled by default.
+	//
+	if rr := get("/history/A/www.example.test"); rr.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status with history disabled: %d", rr.Code)
+	}
+
+	srv = newTestServer(t, Options{HistoryFile: filepath.Join(t.TempDir(), "history.json")}, addr)
+	before := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+
+	if rr := get("/history/A/www.example.test"); rr.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status with no history: %d", rr.Code)
+	}
+
+	if rr := get("/A/www.example.test"); rr.Code != http.StatusOK {
+		t.Fatalf("Lookup failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := get("/A/missing.example.test"); rr.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status looking up a missing name: %d", rr.Code)
+	}
+
+	//
+	// Lookups of other servers aren't recorded.
+	//
+	opts := resolver.DefaultQueryOptions()
+	opts.Server = "192.0.2.53:53"
+	srv.recordHistory("other.example.test", "A", answer("192.0.2.9"), opts)
+	if len(srv.history.History("other.example.test", "A")) != 0 {
+		t.Errorf("Recorded the answer of another server")
+	}
+
+	//
+	// Nor are lookups made with other options, though the timeout may
+	// differ.
+	//
+	for _, query := range []string{"rd=0", "cd=1", "do=1", "edns=1", "ecs=192.0.2.0/24", "class=CH"} {
+		if rr := get("/TXT/www.example.test?" + query); rr.Code == http.StatusInternalServerError {
+			t.Errorf("Lookup with %s failed: %d %s", query, rr.Code, rr.Body.String())
+		}
+		if len(srv.history.History("www.example.test", "TXT")) != 0 {
+			t.Errorf("Recorded a lookup made with %s", query)
+		}
+	}
+	get("/A/www.example.test?timeout=2s")
+	if len(srv.history.History("www.example.test", "A")) != 1 {
+		t.Errorf("The timeout shouldn't prevent a lookup being recorded")
+	}
+
+	rr := get("/history/a/www.example.test")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: %d %s", rr.Code, rr.Body.String())
+	}
+	var report HistoryReport
+	err := json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("Failed to decode history: %s", err)
+	}
+	if report.Type != "A" || len(report.Entries) != 1 || report.Entries[0].Records[0].Value != "192.0.2.1" {
+		t.Errorf("Unexpected history: %v", report)
+	}
+
+	rr = get("/history/A/missing.example.test")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("NXDOMAIN answers should be recorded: %d", rr.Code)
+	}
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/history/A/www.example.test?at=" + before, http.StatusNotFound},
+		{"/history/A/www.example.test?at=" + time.Now().Add(time.Minute).UTC().Format(time.RFC3339), http.StatusOK},
+		{"/history/A/www.example.test?at=bogus", http.StatusBadRequest},
+		{"/history/BOGUS/www.example.test", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if rr := get(test.url); rr.Code != test.status {
+			t.Errorf("%s gave status %d, expected %d", test.url, rr.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,671 @@
+//
+// This file contains our API keys, which callers may present so that
+// their requests are limited by the tier of their key, rather than by
+// their address.
+//
+// Keys are persisted to a store, just as our watches are, and only the
+// SHA-256 of each key is stored.  They're managed via our admin routes,
+// which require one of the admin tokens from our configuration-file.
+//
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/skx/dns-api-go/ratelimit"
+)
+
+//
+// apiKeyHeader is the header callers present their key in, they may
+// also use the `api_key` parameter.
+//
+const apiKeyHeader = "X-API-Key"
+
+//
+// apiKeyPrefix begins each of our keys, so they're easily recognised.
+//
+const apiKeyPrefix = "dak_"
+
+//
+// keyRefreshInterval is how often we reload our keys from their store,
+// so that changes made by other servers sharing it are seen.
+//
+const keyRefreshInterval = 30 * time.Second
+
+//
+// errInvalidKey is returned when a caller presents a key we don't know.
+//
+var errInvalidKey = errors.New("Invalid API key")
+
+// APIKey identifies a caller, whose requests are limited by its tier.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Tier string `json:"tier"`
+
+	// Key is presented by the caller.  It is only shown when the key
+	// is created, or rotated.
+	Key string `json:"key,omitempty"`
+
+	// Hash is the SHA-256 of the key, which is all that's stored.
+	Hash string `json:"hash,omitempty"`
+
+	Created time.Time `json:"created"`
+	Rotated time.Time `json:"rotated"`
+}
+
+// Tier controls the limits, and the routes, of the keys which belong
+// to it.
+type Tier struct {
+	// RateLimits are applied to each key of the tier, rather than the
+	// limits of anonymous callers.
+	RateLimits ratelimit.Policy `json:"rate_limits"`
+
+	// Features are the routes the keys may use, such as "axfr" or
+	// "watches", with lookups being "dns".  If empty every route may
+	// be used.
+	Features []string `json:"features"`
+}
+
+// Parse validates the tier, which must be done before it is used.
+func (t *Tier) Parse() error {
+	for i, f := range t.Features {
+		t.Features[i] = strings.ToLower(f)
+	}
+	return t.RateLimits.Parse()
+}
+
+//
+// allows returns true if the tier may use the given route.
+//
+func (t *Tier) allows(route string) bool {
+	if len(t.Features) == 0 {
+		return true
+	}
+	for _, f := range t.Features {
+		if f == route {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyConfig controls our API keys.
+type KeyConfig struct {
+	// AdminTokens are the bearer-tokens which authenticate callers
+	// of our admin routes, if empty those routes are disabled.
+	AdminTokens []string `json:"admin_tokens"`
+
+	// Tiers are the tiers keys may belong to, by name.
+	Tiers map[string]Tier `json:"tiers"`
+}
+
+// KeyStore is somewhere API keys may be persisted.
+type KeyStore interface {
+
+	// Load returns all the stored keys.
+	Load() ([]APIKey, error)
+
+	// Save stores, or updates, a key.
+	Save(k APIKey) error
+
+	// Delete removes a key.
+	Delete(id string) error
+}
+
+//
+// fileKeyStore stores keys in a JSON file.  If the path is empty the keys
+// are only held in memory.
+//
+type fileKeyStore struct {
+	sync.Mutex
+	path string
+	keys map[string]APIKey
+}
+
+//
+// NewFileKeyStore creates a store which uses the given file.  If the path
+// is empty the keys are only held in memory.
+//
+func NewFileKeyStore(path string) KeyStore {
+	return &fileKeyStore{path: path, keys: make(map[string]APIKey)}
+}
+
+func (s *fileKeyStore) Load() ([]APIKey, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.path != "" {
+		data, err := ioutil.ReadFile(s.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			keys := make(map[string]APIKey)
+			err = json.Unmarshal(data, &keys)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %s", s.path, err.Error())
+			}
+			s.keys = keys
+		}
+	}
+
+	var out []APIKey
+	for _, k := range s.keys {
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+func (s *fileKeyStore) Save(k APIKey) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.keys[k.ID] = k
+	return s.write()
+}
+
+func (s *fileKeyStore) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.keys, id)
+	return s.write()
+}
+
+//
+// write replaces the file with the current keys.  A temporary file is
+// renamed into place so that a crash never leaves it half-written, and
+// it is only readable by us.
+//
+func (s *fileKeyStore) write() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".keys")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+//
+// redisKeyStore stores keys in a redis hash.
+//
+type redisKeyStore struct {
+	client *redis.Ring
+	key    string
+}
+
+//
+// NewRedisKeyStore creates a store which uses the given redis.
+//
+func NewRedisKeyStore(client *redis.Ring) KeyStore {
+	return &redisKeyStore{client: client, key: "dns-api:keys"}
+}
+
+func (s *redisKeyStore) Load() ([]APIKey, error) {
+	entries, err := s.client.HGetAll(s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []APIKey
+	for id, data := range entries {
+		var k APIKey
+		err = json.Unmarshal([]byte(data), &k)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %s: %s", id, err.Error())
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+func (s *redisKeyStore) Save(k APIKey) error {
+	data, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(s.key, k.ID, data).Err()
+}
+
+func (s *redisKeyStore) Delete(id string) error {
+	return s.client.HDel(s.key, id).Err()
+}
+
+//
+// keyManager holds our keys, indexed by their ID and their hash.
+//
+type keyManager struct {
+	sync.Mutex
+	store  KeyStore
+	keys   map[string]APIKey
+	hashes map[string]string
+	loaded time.Time
+}
+
+//
+// newKeyManager creates a manager for the keys in the given store.
+//
+func newKeyManager(store KeyStore) *keyManager {
+	return &keyManager{
+		store:  store,
+		keys:   make(map[string]APIKey),
+		hashes: make(map[string]string),
+	}
+}
+
+//
+// Load (re)loads the keys from our store.
+//
+func (m *keyManager) Load() error {
+	keys, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.keys = make(map[string]APIKey)
+	m.hashes = make(map[string]string)
+	for _, k := range keys {
+		m.keys[k.ID] = k
+		m.hashes[k.Hash] = k.ID
+	}
+	m.loaded = time.Now()
+	return nil
+}
+
+//
+// Lookup returns the key with the given secret.
+//
+func (m *keyManager) Lookup(secret string) (APIKey, bool) {
+	m.Lock()
+	stale := time.Since(m.loaded) > keyRefreshInterval
+	if stale {
+		m.loaded = time.Now()
+	}
+	m.Unlock()
+
+	if stale {
+		err := m.Load()
+		if err != nil {
+			fmt.Printf("Error reloading API keys: %s\n", err.Error())
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	id, ok := m.hashes[hashKey(secret)]
+	if !ok {
+		return APIKey{}, false
+	}
+	return m.keys[id], true
+}
+
+//
+// Get returns the key with the given ID, without its hash.
+//
+func (m *keyManager) Get(id string) (APIKey, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	k, ok := m.keys[id]
+	k.Hash = ""
+	return k, ok
+}
+
+//
+// List returns all of our keys, without their hashes.
+//
+func (m *keyManager) List() []APIKey {
+	m.Lock()
+	defer m.Unlock()
+
+	out := []APIKey{}
+	for _, k := range m.keys {
+		k.Hash = ""
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+//
+// Create makes a new key, which is returned along with its secret.
+//
+func (m *keyManager) Create(name string, tier string) (APIKey, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	k := APIKey{
+		ID:      hex.EncodeToString(id),
+		Name:    name,
+		Tier:    tier,
+		Created: time.Now().UTC(),
+	}
+	return m.save(k)
+}
+
+//
+// Rotate replaces the secret of the given key, returning the key along
+// with its new secret.
+//
+func (m *keyManager) Rotate(id string) (APIKey, bool, error) {
+	m.Lock()
+	k, ok := m.keys[id]
+	m.Unlock()
+
+	if !ok {
+		return k, false, nil
+	}
+	k.Rotated = time.Now().UTC()
+	k, err := m.save(k)
+	return k, true, err
+}
+
+//
+// Revoke deletes the given key.
+//
+func (m *keyManager) Revoke(id string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	k, ok := m.keys[id]
+	if !ok {
+		return false, nil
+	}
+	delete(m.keys, id)
+	delete(m.hashes, k.Hash)
+	return true, m.store.Delete(id)
+}
+
+//
+// save gives the key a new secret, and stores it.
+//
+func (m *keyManager) save(k APIKey) (APIKey, error) {
+	secret := make([]byte, 20)
+	rand.Read(secret)
+	k.Key = apiKeyPrefix + hex.EncodeToString(secret)
+
+	old := k.Hash
+	k.Hash = hashKey(k.Key)
+
+	stored := k
+	stored.Key = ""
+	err := m.store.Save(stored)
+	if err != nil {
+		return k, err
+	}
+
+	m.Lock()
+	delete(m.hashes, old)
+	m.keys[k.ID] = stored
+	m.hashes[k.Hash] = k.ID
+	m.Unlock()
+
+	k.Hash = ""
+	return k, nil
+}
+
+//
+// hashKey returns the SHA-256 of a key, which is what we store.
+//
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+//
+// apiKey returns the key presented with a request, if any.
+//
+func (s *Server) apiKey(req *http.Request) (*APIKey, error) {
+	secret := req.Header.Get(apiKeyHeader)
+	if secret == "" {
+		secret = req.URL.Query().Get("api_key")
+	}
+	if secret == "" {
+		return nil, nil
+	}
+
+	k, ok := s.keys.Lookup(strings.TrimSpace(secret))
+	if !ok {
+		return nil, errInvalidKey
+	}
+	return &k, nil
+}
+
+//
+// clientID returns the identity a request is rate-limited by, which is
+// either "key:" followed by the ID of their key, or their address.
+//
+// Requests with an invalid key are refused by rateLimit, so that case
+// needn't be handled.
+//
+func (s *Server) clientID(req *http.Request) string {
+	k, _ := s.apiKey(req)
+	if k != nil {
+		return "key:" + k.ID
+	}
+	return s.RemoteIP(req)
+}
+
+//
+// clientPolicy returns the rate-limits, and the tier if any, of the
+// given client.
+//
+// Keys whose tier no longer exists receive the limits of anonymous
+// callers.
+//
+func (s *Server) clientPolicy(client string) (*ratelimit.Policy, *Tier) {
+	limits := s.rateLimits()
+
+	if strings.HasPrefix(client, "key:") {
+		k, ok := s.keys.Get(strings.TrimPrefix(client, "key:"))
+		if ok {
+			if tier, found := limits.tiers[k.Tier]; found {
+				return &tier.RateLimits, tier
+			}
+		}
+	}
+	return &limits.anonymous, nil
+}
+
+//
+// adminAuthenticated returns true if the request carries one of our
+// admin tokens.
+//
+func (s *Server) adminAuthenticated(req *http.Request) bool {
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+
+	for _, t := range s.config.Keys.AdminTokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// apiKeyRequest is the body of a request to create a key.
+//
+type apiKeyRequest struct {
+	Name string `json:"name"`
+	Tier string `json:"tier"`
+}
+
+//
+// KeysHandler is the handler for listing, and creating, API keys.
+//
+// It is called via requests like this:
+//
+//     GET  /admin/keys
+//     POST /admin/keys  {"name": "billing", "tier": "internal"}
+//
+func (s *Server) KeysHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if !s.adminAuthenticated(req) {
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		status = http.StatusUnauthorized
+		err = errors.New("Admin routes require authentication")
+		return
+	}
+
+	if req.Method == "GET" {
+		sendJSON(res, s.keys.List())
+		return
+	}
+
+	var r apiKeyRequest
+	err = json.NewDecoder(http.MaxBytesReader(res, req.Body, 4096)).Decode(&r)
+	if err != nil {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid JSON: " + err.Error())
+		return
+	}
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > 100 {
+		status = http.StatusBadRequest
+		err = errors.New("Keys must have a name, of no more than 100 characters")
+		return
+	}
+	if _, ok := s.rateLimits().tiers[r.Tier]; !ok {
+		status = http.StatusBadRequest
+		err = fmt.Errorf("Unknown tier '%s'", r.Tier)
+		return
+	}
+
+	k, err := s.keys.Create(r.Name, r.Tier)
+	if err != nil {
+		status = http.StatusInternalServerError
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["keys.created"]++
+	s.mutex.Unlock()
+
+	sendJSONStatus(res, http.StatusCreated, k)
+}
+
+//
+// KeyHandler is the handler for viewing, rotating and revoking a single
+// API key.
+//
+// It is called via requests like this:
+//
+//     GET    /admin/keys/$ID
+//     POST   /admin/keys/$ID/rotate
+//     DELETE /admin/keys/$ID
+//
+func (s *Server) KeyHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if !s.adminAuthenticated(req) {
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		status = http.StatusUnauthorized
+		err = errors.New("Admin routes require authentication")
+		return
+	}
+
+	id := mux.Vars(req)["id"]
+
+	switch {
+	case req.Method == "DELETE":
+		var found bool
+		found, err = s.keys.Revoke(id)
+		if err != nil {
+			status = http.StatusInternalServerError
+			return
+		}
+		if !found {
+			status = http.StatusNotFound
+			err = errors.New("No such key")
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+
+	case req.Method == "POST":
+		var (
+			k     APIKey
+			found bool
+		)
+		k, found, err = s.keys.Rotate(id)
+		if err != nil {
+			status = http.StatusInternalServerError
+			return
+		}
+		if !found {
+			status = http.StatusNotFound
+			err = errors.New("No such key")
+			return
+		}
+		sendJSON(res, k)
+
+	default:
+		k, found := s.keys.Get(id)
+		if !found {
+			status = http.StatusNotFound
+			err = errors.New("No such key")
+			return
+		}
+		sendJSON(res, k)
+	}
+}
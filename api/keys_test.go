@@ -0,0 +1,291 @@
+//
+// Test our API keys.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/ratelimit"
+)
+
+//
+// newKeyServer creates a server with an admin token, and two tiers.
+//
+func newKeyServer(t *testing.T, store KeyStore) *Server {
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.test. 60 IN A 192.0.2.1"))
+
+	config := &Config{
+		RateLimits: ratelimit.Policy{Limits: []ratelimit.Limit{{Period: "hour", Requests: 2}}},
+		Keys: KeyConfig{
+			AdminTokens: []string{"admin-secret"},
+			Tiers: map[string]Tier{
+				"internal": {RateLimits: ratelimit.Policy{Limits: []ratelimit.Limit{{Period: "hour", Requests: 1000}}}},
+				"lookups": {
+					RateLimits: ratelimit.Policy{Limits: []ratelimit.Limit{{Period: "hour", Requests: 3}}},
+					Features:   []string{"DNS"},
+				},
+			},
+		},
+	}
+	return newTestServer(t, Options{Config: config, Keys: store}, addr)
+}
+
+//
+// admin makes a request to one of our admin routes, decoding the JSON
+// response into out.
+//
+func admin(t *testing.T, srv *Server, method string, path string, body string, out interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if out != nil && rr.Code < 300 {
+		if rr.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("Unexpected content-type %s", rr.Header().Get("Content-Type"))
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), out)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", rr.Body.String(), err)
+		}
+	}
+	return rr.Code
+}
+
+//
+// Test that keys may be created, listed, rotated and revoked.
+//
+func TestKeysAdmin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	srv := newKeyServer(t, NewFileKeyStore(path))
+
+	var k APIKey
+	code := admin(t, srv, "POST", "/admin/keys", `{"name": "billing", "tier": "internal"}`, &k)
+	if code != http.StatusCreated {
+		t.Fatalf("Unexpected status-code %d", code)
+	}
+	if k.ID == "" || !strings.HasPrefix(k.Key, "dak_") || k.Hash != "" || k.Tier != "internal" {
+		t.Fatalf("Unexpected key %v", k)
+	}
+
+	//
+	// Only the hash is stored.
+	//
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the store: %s", err)
+	}
+	if strings.Contains(string(data), k.Key) || !strings.Contains(string(data), hashKey(k.Key)) {
+		t.Errorf("The secret should not be stored:\n%s", data)
+	}
+
+	var keys []APIKey
+	code = admin(t, srv, "GET", "/admin/keys", "", &keys)
+	if code != http.StatusOK || len(keys) != 1 || keys[0].ID != k.ID || keys[0].Key != "" || keys[0].Hash != "" {
+		t.Errorf("Unexpected keys %d %v", code, keys)
+	}
+
+	var shown APIKey
+	code = admin(t, srv, "GET", "/admin/keys/"+k.ID, "", &shown)
+	if code != http.StatusOK || shown.Name != "billing" || shown.Key != "" {
+		t.Errorf("Unexpected key %d %v", code, shown)
+	}
+
+	//
+	// Rotating a key replaces its secret.
+	//
+	var rotated APIKey
+	code = admin(t, srv, "POST", "/admin/keys/"+k.ID+"/rotate", "", &rotated)
+	if code != http.StatusOK || rotated.ID != k.ID || rotated.Key == k.Key || rotated.Rotated.IsZero() {
+		t.Errorf("Unexpected rotation %d %v", code, rotated)
+	}
+	if keyLookup(srv, k.Key) != http.StatusUnauthorized {
+		t.Errorf("The old secret should no longer work")
+	}
+	if keyLookup(srv, rotated.Key) != http.StatusOK {
+		t.Errorf("The new secret should work")
+	}
+
+	//
+	// The keys survive a restart.
+	//
+	srv2 := newKeyServer(t, NewFileKeyStore(path))
+	if keyLookup(srv2, rotated.Key) != http.StatusOK {
+		t.Errorf("The key should have been loaded")
+	}
+
+	code = admin(t, srv, "DELETE", "/admin/keys/"+k.ID, "", nil)
+	if code != http.StatusNoContent {
+		t.Errorf("Unexpected status-code %d", code)
+	}
+	if keyLookup(srv, rotated.Key) != http.StatusUnauthorized {
+		t.Errorf("A revoked key should no longer work")
+	}
+
+	for _, p := range []string{"/admin/keys/" + k.ID, "/admin/keys/" + k.ID + "/rotate"} {
+		method := "GET"
+		if strings.HasSuffix(p, "rotate") {
+			method = "POST"
+		}
+		if admin(t, srv, method, p, "", nil) != http.StatusNotFound {
+			t.Errorf("Expected %s %s to be missing", method, p)
+		}
+	}
+	if admin(t, srv, "DELETE", "/admin/keys/"+k.ID, "", nil) != http.StatusNotFound {
+		t.Errorf("Expected a second revocation to fail")
+	}
+}
+
+//
+// Test that our admin routes require authentication, and validate the
+// keys they create.
+//
+func TestKeysAdminErrors(t *testing.T) {
+	srv := newKeyServer(t, nil)
+
+	for _, token := range []string{"", "Bearer wrong", "admin-secret"} {
+		req := httptest.NewRequest("GET", "/admin/keys", nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected '%s' to be refused, got %d", token, rr.Code)
+		}
+	}
+
+	tests := map[string]string{
+		`{"name": "x", "tier": "gold"}`:    "Unknown tier 'gold'",
+		`{"tier": "internal"}`:             "must have a name",
+		`{"name": "x", "tier": "internal"`: "Invalid JSON",
+	}
+	for body, expected := range tests {
+		req := httptest.NewRequest("POST", "/admin/keys", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected '%s' for %s, got %d %s", expected, body, rr.Code, rr.Body.String())
+		}
+	}
+
+	//
+	// Without admin tokens the routes can't be used at all.
+	//
+	plain := newTestServer(t, Options{})
+	req := httptest.NewRequest("GET", "/admin/keys", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rr := httptest.NewRecorder()
+	plain.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the admin routes to be disabled, got %d", rr.Code)
+	}
+}
+
+//
+// keyLookup makes a lookup with the given key, returning the status-code.
+//
+func keyLookup(srv *Server, key string) int {
+	req := httptest.NewRequest("GET", "/A/example.test", nil)
+	req.Header.Set("X-API-Key", key)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+//
+// Test that callers with keys are limited by their tier.
+//
+func TestKeysRateLimit(t *testing.T) {
+	srv := newKeyServer(t, nil)
+
+	var internal, lookups APIKey
+	admin(t, srv, "POST", "/admin/keys", `{"name": "internal", "tier": "internal"}`, &internal)
+	admin(t, srv, "POST", "/admin/keys", `{"name": "lookups", "tier": "lookups"}`, &lookups)
+
+	//
+	// Anonymous callers get two lookups.
+	//
+	for i, expected := range []int{200, 200, 429} {
+		if code := keyLookup(srv, ""); code != expected {
+			t.Errorf("%d: expected %d for an anonymous lookup, got %d", i, expected, code)
+		}
+	}
+
+	//
+	// Whilst the same address, with a key, gets far more.
+	//
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "/A/example.test", nil)
+		req.Header.Set("X-API-Key", internal.Key)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%d: unexpected status-code %d", i, rr.Code)
+		}
+		if rr.Header().Get("X-RateLimit-Limit") != "1000" || rr.Header().Get("X-RateLimit-IP") != "key:"+internal.ID {
+			t.Errorf("%d: unexpected headers %v", i, rr.Header())
+		}
+	}
+
+	//
+	// Keys may be given as a parameter too.
+	//
+	for i, expected := range []int{200, 200, 200, 429} {
+		req := httptest.NewRequest("GET", "/A/example.test?api_key="+lookups.Key, nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("%d: expected %d, got %d", i, expected, rr.Code)
+		}
+	}
+
+	//
+	// The second tier may only make lookups.
+	//
+	req := httptest.NewRequest("GET", "/wildcard/example.test", nil)
+	req.Header.Set("X-API-Key", lookups.Key)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "doesn't include 'wildcard'") {
+		t.Errorf("Unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+
+	if code := keyLookup(srv, "dak_bogus"); code != http.StatusUnauthorized {
+		t.Errorf("Expected an invalid key to be refused, got %d", code)
+	}
+}
+
+//
+// Test that tiers are validated.
+//
+func TestKeysTiers(t *testing.T) {
+	tests := map[string]map[string]Tier{
+		"there is no 'trace' route": {"x": {Features: []string{"dns", "trace"}}},
+		"invalid period":            {"x": {RateLimits: ratelimit.Policy{Limits: []ratelimit.Limit{{Period: "week", Requests: 1}}}}},
+		"there is no 'bulk' route":  {"x": {RateLimits: ratelimit.Policy{Costs: map[string]int64{"bulk": 2}}}},
+	}
+
+	srv := newTestServer(t, Options{})
+	for expected, tiers := range tests {
+		err := srv.SetRateLimits(ratelimit.Policy{}, tiers)
+		if err == nil || !strings.Contains(err.Error(), expected) || !strings.Contains(err.Error(), "invalid tier 'x'") {
+			t.Errorf("Expected error '%s', got %v", expected, err)
+		}
+	}
+
+	_, err := LoadConfig(writeConfig(t, `{"keys": {"tiers": {"gold": {"rate_limits": {"limits": [{"period": "hour", "requests": 0}]}}}}}`))
+	if err == nil || !strings.Contains(err.Error(), "invalid tier 'gold'") {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
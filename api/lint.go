@@ -0,0 +1,95 @@
+//
+// This file contains the handler for our domain-linter.
+//
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// LintHandler is the handler for our domain-linter.
+//
+// It is called via requests like this:
+//
+//     GET /lint/$DOMAIN?names=mail,shop&rules=mx-cname,soa-timers
+//
+func (s *Server) LintHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	domain := mux.Vars(req)["domain"]
+	if _, ok := dns.IsDomainName(domain); !ok {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid domain '" + domain + "'")
+		return
+	}
+
+	//
+	// Both the additional names, and the rules, are optional.
+	//
+	var names, rules []string
+	for _, name := range strings.Split(req.URL.Query().Get("names"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := dns.IsDomainName(name); !ok {
+			status = http.StatusBadRequest
+			err = errors.New("Invalid name '" + name + "'")
+			return
+		}
+		names = append(names, name)
+	}
+	if len(names) > resolver.MaxLintNames {
+		status = http.StatusBadRequest
+		err = fmt.Errorf("No more than %d names may be checked", resolver.MaxLintNames)
+		return
+	}
+	for _, rule := range strings.Split(req.URL.Query().Get("rules"), ",") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	err = resolver.ValidateLintRules(rules)
+	if err != nil {
+		status = http.StatusBadRequest
+		return
+	}
+
+	report, err := s.resolver.LintDomain(domain, names, rules, &s.config.Servers)
+	if err != nil {
+		status = http.StatusNotFound
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["lint.checks"]++
+	s.mutex.Unlock()
+
+	sendJSON(res, report)
+}
@@ -0,0 +1,70 @@
+//
+// Test the handler of our domain-linter.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test running all the rules via the HTTP handler.
+//
+func TestLintHandler(t *testing.T) {
+
+	srv := newTestServer(t, Options{}, dnstest.Start(t, dnstest.Zone(t,
+		"example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 1 60 900 86400 3600",
+		"example.test. 60 IN MX 10 mail.example.test.",
+		"mail.example.test. 60 IN CNAME mx.example.test.",
+		"mx.example.test. 60 IN A 192.0.2.1",
+	)))
+
+	tests := []struct {
+		url      string
+		status   int
+		rules    int
+		errors   int
+		warnings int
+	}{
+		{"/lint/example.test?rules=mx-cname,soa-timers", http.StatusOK, 2, 1, 3},
+		{"/lint/example.test?rules=mx-cname&names=shop,blog", http.StatusOK, 1, 1, 0},
+		{"/lint/example.test?rules=bogus", http.StatusBadRequest, 0, 0, 0},
+		{"/lint/missing.test", http.StatusNotFound, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("%s gave status %d, expected %d", test.url, rr.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var report resolver.LintReport
+		err = json.Unmarshal(rr.Body.Bytes(), &report)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", test.url, err)
+		}
+		if len(report.Rules) != test.rules || report.Errors != test.errors || report.Warnings != test.warnings {
+			t.Errorf("%s gave an unexpected report: %v", test.url, report)
+		}
+		if len(report.Findings) > 0 && report.Findings[0].Severity != "error" {
+			t.Errorf("%s: errors should be reported first: %v", test.url, report.Findings)
+		}
+	}
+}
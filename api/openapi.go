@@ -0,0 +1,715 @@
+//
+// This file describes our API as an OpenAPI 3 document, which is served
+// at /openapi.json and rendered by our docs-page.
+//
+// The schemas of our responses are built from the types we return, so
+// they cannot drift from the code.
+//
+
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/dns-api-go/resolver"
+)
+
+// apiParameter describes a parameter of one of our operations.
+type apiParameter struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+// apiOperation describes one of our routes, when called with a single
+// method.
+type apiOperation struct {
+	Path        string
+	Method      string
+	Tag         string
+	Summary     string
+	Description string
+	Parameters  []apiParameter
+
+	// Body is a value of the type of our request-body, if any.
+	Body interface{}
+
+	// Response is a value of the type of our (JSON) response.  If it is
+	// nil the response is described by ContentType instead.
+	Response    interface{}
+	ContentType string
+
+	// Formats is true if the response may be in any of our record
+	// formats, rather than only JSON.
+	Formats bool
+
+	// OtherTypes are other content-types the response may be returned
+	// as, such as CSV.
+	OtherTypes []string
+
+	// Status is the code of a successful response, if it isn't 200.
+	Status int
+
+	// Errors are the codes our failures are reported with.
+	Errors []int
+
+	// Public is true for the resources which aren't rate-limited.
+	Public bool
+
+	// Admin is true for our admin routes, which require an admin
+	// token and aren't rate-limited.
+	Admin bool
+}
+
+// apiRecord is a single record of an answer, as returned by our DNS
+// handler.  We use maps for those, so this exists purely to describe
+// them.
+type apiRecord struct {
+	Name     string `json:"name"`
+	TTL      string `json:"ttl"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Wildcard string `json:"wildcard,omitempty"`
+}
+
+// apiWatchRequest is the body of a request to create a watch.
+type apiWatchRequest struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Interval string `json:"interval"`
+	Webhook  string `json:"webhook"`
+}
+
+//
+// The parameters shared between our operations.
+//
+var (
+	typeParam = apiParameter{
+		Name:        "type",
+		In:          "path",
+		Description: "The record-type to lookup, case-insensitive.",
+		Required:    true,
+		Enum:        []string{"A", "AAAA", "CNAME", "MX", "NS", "PTR", "SOA", "TXT"},
+	}
+	nameParam = apiParameter{
+		Name:        "name",
+		In:          "path",
+		Description: "The name to lookup.",
+		Required:    true,
+	}
+	queryParams = []apiParameter{
+		{Name: "rd", In: "query", Description: "Set the recursion-desired bit, 0 or 1.", Enum: []string{"0", "1"}},
+		{Name: "cd", In: "query", Description: "Set the checking-disabled bit, 0 or 1.", Enum: []string{"0", "1"}},
+		{Name: "do", In: "query", Description: "Set the DNSSEC OK bit, 0 or 1, returning signatures.", Enum: []string{"0", "1"}},
+		{Name: "edns", In: "query", Description: "Send an EDNS OPT record, 0 or 1.  Implied by `do` and `ecs`.", Enum: []string{"0", "1"}},
+		{Name: "timeout", In: "query", Description: "The timeout for each nameserver, between 100ms and 10s."},
+		{Name: "class", In: "query", Description: "The query class, CH and HS may only be used for TXT lookups.", Enum: []string{"IN", "CH", "HS"}},
+		{Name: "ecs", In: "query", Description: "An EDNS Client Subnet, in CIDR notation, or `client` to use your own address."},
+		{Name: "wildcard", In: "query", Description: "Set to 1 to flag answers which were probably synthesized from a wildcard.", Enum: []string{"1"}},
+	}
+	keyIDParam = apiParameter{
+		Name:        "id",
+		In:          "path",
+		Description: "The ID of the key.",
+		Required:    true,
+	}
+	formatParam = apiParameter{
+		Name:        "format",
+		In:          "query",
+		Description: "The format of the answers, which may also be chosen via the Accept header.",
+		Enum:        strings.Split(FormatNames(), "|"),
+	}
+)
+
+//
+// dnsOperation describes one of the routes of our DNS handler.
+//
+func dnsOperation(path string, summary string, extra ...apiParameter) apiOperation {
+	params := append([]apiParameter{typeParam, {
+		Name:        "value",
+		In:          "path",
+		Description: "The name to lookup, PTR lookups use the reversed form such as 1.2.0.192.in-addr.arpa.",
+		Required:    true,
+	}}, extra...)
+
+	return apiOperation{
+		Path:       path,
+		Method:     "GET",
+		Tag:        "dns",
+		Summary:    summary,
+		Parameters: append(append(params, queryParams...), formatParam),
+		Response:   []apiRecord{},
+		Formats:    true,
+		Errors:     []int{400, 403, 404},
+	}
+}
+
+//
+// apiOperations are the descriptions of all our routes.
+//
+// Every route registered in newRouter must be described here, which
+// is tested.
+//
+var apiOperations = []apiOperation{
+	dnsOperation("/{type}/{value}", "Lookup a record",
+		apiParameter{Name: "server", In: "query", Description: "A nameserver to query, rather than our resolvers."}),
+	dnsOperation("/@{server}/{type}/{value}", "Lookup a record from a specific nameserver",
+		apiParameter{Name: "server", In: "path", Description: "The nameserver to query, as `host`, `host:port` or `[ipv6]:port`.", Required: true}),
+	{
+		Path:    "/axfr/{zone}",
+		Method:  "GET",
+		Tag:     "zones",
+		Summary: "Transfer a zone",
+		Description: "Transfers one of the zones in our configuration-file, which requires a bearer " +
+			"token.  Adding `serial` performs an IXFR.",
+		Parameters: []apiParameter{
+			{Name: "zone", In: "path", Description: "The zone to transfer.", Required: true},
+			{Name: "server", In: "query", Description: "The primary to transfer from, if not the configured one."},
+			{Name: "serial", In: "query", Description: "The serial we have, to receive only the changes since."},
+			formatParam,
+		},
+		Response: []apiRecord{},
+		Formats:  true,
+		Errors:   []int{400, 401, 403, 502},
+	},
+	{
+		Path:        "/consistency/{zone}",
+		Method:      "GET",
+		Tag:         "zones",
+		Summary:     "Check a zone's nameservers agree",
+		Description: "Compares the delegation from the parent with the zone's own NS records, and the serial and answers of each nameserver.",
+		Parameters: []apiParameter{
+			{Name: "zone", In: "path", Description: "The zone to check.", Required: true},
+			{Name: "type", In: "query", Description: "A record-type to compare the answers of.", Enum: typeParam.Enum},
+		},
+		Response: resolver.ConsistencyReport{},
+		Errors:   []int{400, 403, 404},
+	},
+	{
+		Path:        "/dnsbl/{target}",
+		Method:      "GET",
+		Tag:         "email",
+		Summary:     "Check DNS blocklists",
+		Description: "Looks up an IP address in DNSBLs, or a domain in RHSBLs.",
+		Parameters: []apiParameter{
+			{Name: "target", In: "path", Description: "The IP address, or domain, to check.", Required: true},
+		},
+		Response: resolver.BlocklistReport{},
+		Errors:   []int{400, 403},
+	},
+	{
+		Path:        "/email/{domain}",
+		Method:      "GET",
+		Tag:         "email",
+		Summary:     "Report upon email authentication",
+		Description: "Reports the MX, SPF, DMARC, MTA-STS, TLS-RPT and DKIM records of a domain.",
+		Parameters: []apiParameter{
+			{Name: "domain", In: "path", Description: "The domain to check.", Required: true},
+			{Name: "selectors", In: "query", Description: "A comma-separated list of DKIM selectors, no more than 20."},
+		},
+		Response: resolver.EmailReport{},
+		Errors:   []int{400, 403},
+	},
+	{
+		Path:        "/history/{type}/{name}",
+		Method:      "GET",
+		Tag:         "history",
+		Summary:     "Show the history of an answer",
+		Description: "Returns the answers we've given over time, or the answer at a particular time.  This is only available if history is enabled.",
+		Parameters: []apiParameter{
+			typeParam,
+			nameParam,
+			{Name: "at", In: "query", Description: "A time, in RFC 3339 format, to return the answer at."},
+		},
+		Response: HistoryReport{},
+		Errors:   []int{400, 403, 404},
+	},
+	{
+		Path:        "/lint/{domain}",
+		Method:      "GET",
+		Tag:         "zones",
+		Summary:     "Lint a domain's configuration",
+		Description: "Applies our rules to a domain, and the other names given, reporting errors and warnings.",
+		Parameters: []apiParameter{
+			{Name: "domain", In: "path", Description: "The domain to check.", Required: true},
+			{Name: "names", In: "query", Description: "A comma-separated list of additional names to check, such as `mail,shop`."},
+			{Name: "rules", In: "query", Description: "A comma-separated list of the rules to apply, rather than all of them."},
+		},
+		Response: resolver.LintReport{},
+		Errors:   []int{400, 403, 404},
+	},
+	{
+		Path:        "/propagation/{type}/{name}",
+		Method:      "GET",
+		Tag:         "dns",
+		Summary:     "Check propagation across public resolvers",
+		Description: "Asks a number of public resolvers for the same record, and groups their answers.",
+		Parameters:  []apiParameter{typeParam, nameParam},
+		Response:    resolver.PropagationReport{},
+		Errors:      []int{400, 403, 404},
+	},
+	{
+		Path:        "/spf/{domain}",
+		Method:      "GET",
+		Tag:         "email",
+		Summary:     "Evaluate an SPF policy",
+		Description: "Evaluates the SPF policy of a domain for the given client, as check_host() would.",
+		Parameters: []apiParameter{
+			{Name: "domain", In: "path", Description: "The domain to check.", Required: true},
+			{Name: "ip", In: "query", Description: "The address of the sending client.", Required: true},
+			{Name: "sender", In: "query", Description: "The envelope sender."},
+			{Name: "helo", In: "query", Description: "The HELO, or EHLO, name of the client."},
+		},
+		Response: resolver.SPFEvaluation{},
+		Errors:   []int{400, 403},
+	},
+	{
+		Path:        "/stream/{type}/{name}",
+		Method:      "GET",
+		Tag:         "watches",
+		Summary:     "Stream changes to an answer",
+		Description: "Sends the answer, and then any changes to it, as Server-Sent Events of StreamEvent objects.  WebSocket upgrades are also accepted.",
+		Parameters:  []apiParameter{typeParam, nameParam},
+		Response:    StreamEvent{},
+		ContentType: "text/event-stream",
+		Errors:      []int{400, 403, 404, 429, 503},
+	},
+	{
+		Path:        "/watches",
+		Method:      "GET",
+		Tag:         "watches",
+		Summary:     "List your watches",
+		Description: "Lists the watches created from your address.",
+		Response:    []Watch{},
+		Errors:      []int{403},
+	},
+	{
+		Path:        "/watches",
+		Method:      "POST",
+		Tag:         "watches",
+		Summary:     "Create a watch",
+		Description: "Creates a watch, which resolves a record periodically and sends any changes to a webhook.  The secret which signs the notifications is only returned now.",
+		Body:        apiWatchRequest{},
+		Response:    Watch{},
+		Status:      http.StatusCreated,
+		Errors:      []int{400, 403, 429, 503},
+	},
+	{
+		Path:    "/watches/{id}",
+		Method:  "GET",
+		Tag:     "watches",
+		Summary: "Show a watch",
+		Parameters: []apiParameter{
+			{Name: "id", In: "path", Description: "The ID of the watch.", Required: true},
+		},
+		Response: Watch{},
+		Errors:   []int{403, 404},
+	},
+	{
+		Path:    "/watches/{id}",
+		Method:  "DELETE",
+		Tag:     "watches",
+		Summary: "Delete a watch",
+		Parameters: []apiParameter{
+			{Name: "id", In: "path", Description: "The ID of the watch.", Required: true},
+		},
+		Status: http.StatusNoContent,
+		Errors: []int{403, 404},
+	},
+	{
+		Path:        "/wildcard/{zone}",
+		Method:      "GET",
+		Tag:         "zones",
+		Summary:     "Detect wildcard records",
+		Description: "Probes a zone with random names, to discover which types have wildcard records.",
+		Parameters: []apiParameter{
+			{Name: "zone", In: "path", Description: "The zone to check.", Required: true},
+		},
+		Response: resolver.WildcardReport{},
+		Errors:   []int{400, 403, 404},
+	},
+	{
+		Path:        "/admin/keys",
+		Method:      "GET",
+		Tag:         "admin",
+		Summary:     "List API keys",
+		Description: "Lists every API key, without their secrets.",
+		Response:    []APIKey{},
+		Admin:       true,
+	},
+	{
+		Path:        "/admin/keys",
+		Method:      "POST",
+		Tag:         "admin",
+		Summary:     "Create an API key",
+		Description: "Creates a key in one of the tiers of our configuration-file.  The key itself is only returned now.",
+		Body:        apiKeyRequest{},
+		Response:    APIKey{},
+		Status:      http.StatusCreated,
+		Errors:      []int{400},
+		Admin:       true,
+	},
+	{
+		Path:       "/admin/keys/{id}",
+		Method:     "GET",
+		Tag:        "admin",
+		Summary:    "Show an API key",
+		Parameters: []apiParameter{keyIDParam},
+		Response:   APIKey{},
+		Errors:     []int{404},
+		Admin:      true,
+	},
+	{
+		Path:        "/admin/keys/{id}",
+		Method:      "DELETE",
+		Tag:         "admin",
+		Summary:     "Revoke an API key",
+		Description: "Deletes a key, so that it may no longer be used.",
+		Parameters:  []apiParameter{keyIDParam},
+		Status:      http.StatusNoContent,
+		Errors:      []int{404},
+		Admin:       true,
+	},
+	{
+		Path:        "/admin/keys/{id}/rotate",
+		Method:      "POST",
+		Tag:         "admin",
+		Summary:     "Rotate an API key",
+		Description: "Replaces the secret of a key, which is only returned now.  The old secret may no longer be used.",
+		Parameters:  []apiParameter{keyIDParam},
+		Response:    APIKey{},
+		Errors:      []int{404},
+		Admin:       true,
+	},
+	{
+		Path:        "/admin/usage",
+		Method:      "GET",
+		Tag:         "admin",
+		Summary:     "Report usage",
+		Description: "Counts the requests of each API key, and of the callers without a key by network, by day.  The period defaults to the current month.",
+		Parameters: []apiParameter{
+			{Name: "from", In: "query", Description: "The first day of the period, as YYYY-MM-DD."},
+			{Name: "to", In: "query", Description: "The last day of the period, as YYYY-MM-DD, which defaults to today."},
+			{Name: "client", In: "query", Description: "Only report a single client, `key:` followed by the ID of a key, `net:` followed by a network such as `198.51.100.0/24`, or `anonymous`."},
+			{Name: "format", In: "query", Description: "The format of the report, which may also be chosen via the Accept header.  CSV has a row for each client on each day.", Enum: []string{"json", "csv"}},
+		},
+		Response:   UsageReport{},
+		OtherTypes: []string{"text/csv"},
+		Errors:     []int{400},
+		Admin:      true,
+	},
+	{Path: "/openapi.json", Method: "GET", Tag: "meta", Summary: "This document", ContentType: "application/json", Public: true},
+	{Path: "/docs", Method: "GET", Tag: "meta", Summary: "Our interactive documentation", ContentType: "text/html", Public: true},
+	{Path: "/", Method: "GET", Tag: "meta", Summary: "Our index-page", ContentType: "text/html", Public: true},
+	{Path: "/humans.txt", Method: "GET", Tag: "meta", Summary: "Our humans.txt", ContentType: "text/plain", Public: true},
+	{Path: "/robots.txt", Method: "GET", Tag: "meta", Summary: "Our robots.txt", ContentType: "text/plain", Public: true},
+	{Path: "/favicon.ico", Method: "GET", Tag: "meta", Summary: "Our icon", ContentType: "image/x-icon", Public: true},
+}
+
+//
+// OpenAPI returns our OpenAPI 3 document, describing all of our routes.
+//
+func (s *Server) OpenAPI() map[string]interface{} {
+
+	schemas := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+
+	for _, op := range apiOperations {
+		if paths[op.Path] == nil {
+			paths[op.Path] = make(map[string]interface{})
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = op.describe(schemas)
+	}
+
+	schemas["Error"] = map[string]interface{}{
+		"type":        "string",
+		"description": "Failures are reported as plain text, or `[]` when there are no results.",
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "dns-api-go",
+			"description": "An API for making DNS lookups, and checking the configuration of domains.",
+			"version":     s.version,
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": "."},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"headers": map[string]interface{}{
+				"X-RateLimit-Limit":     rateLimitHeader("The number of requests permitted each period, by the most restrictive limit."),
+				"X-RateLimit-Remaining": rateLimitHeader("The number of requests remaining under that limit."),
+				"X-RateLimit-Delay":     rateLimitHeader("The number of seconds until the limit is reset, or until the request may be retried if it was exceeded."),
+				"X-RateLimit-IP": map[string]interface{}{
+					"description": "The address the limit is applied to, or `key:` and the ID of your API key.",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        apiKeyHeader,
+					"description": "An optional API key, whose tier controls your rate-limits.  It may also be given via the `api_key` parameter.",
+				},
+				"adminToken": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "One of the admin tokens of our configuration-file.",
+				},
+			},
+		},
+	}
+}
+
+//
+// rateLimitHeader describes one of our numeric rate-limit headers.
+//
+func rateLimitHeader(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"schema":      map[string]interface{}{"type": "integer"},
+	}
+}
+
+//
+// describe returns the OpenAPI operation-object of an operation, adding
+// the schemas it refers to.
+//
+func (op apiOperation) describe(schemas map[string]interface{}) map[string]interface{} {
+
+	out := map[string]interface{}{
+		"tags":    []string{op.Tag},
+		"summary": op.Summary,
+	}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+
+	var params []interface{}
+	for _, p := range op.Parameters {
+		schema := map[string]interface{}{"type": "string"}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		params = append(params, map[string]interface{}{
+			"name":        p.Name,
+			"in":          p.In,
+			"description": p.Description,
+			"required":    p.Required,
+			"schema":      schema,
+		})
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	if op.Body != nil {
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaOf(reflect.TypeOf(op.Body), schemas),
+				},
+			},
+		}
+	}
+
+	//
+	// The successful response.
+	//
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{
+		"description": http.StatusText(status),
+	}
+	if status != http.StatusNoContent {
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		schema := map[string]interface{}{"type": "string"}
+		if op.Response != nil {
+			schema = schemaOf(reflect.TypeOf(op.Response), schemas)
+		}
+		content := map[string]interface{}{
+			contentType: map[string]interface{}{"schema": schema},
+		}
+		if op.Formats {
+			for _, f := range recordFormats {
+				if _, ok := content[f.ContentType]; !ok {
+					content[f.ContentType] = map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string"},
+					}
+				}
+			}
+		}
+		for _, t := range op.OtherTypes {
+			content[t] = map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string"},
+			}
+		}
+		success["content"] = content
+	}
+	limited := !op.Public && !op.Admin
+	if limited {
+		success["headers"] = rateLimitHeaders()
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+	}
+
+	//
+	// The failures.
+	//
+	codes := append([]int{}, op.Errors...)
+	if limited {
+		codes = append(codes, http.StatusUnauthorized, http.StatusTooManyRequests)
+	}
+	if op.Admin {
+		codes = append(codes, http.StatusUnauthorized)
+	}
+	for _, code := range codes {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content": map[string]interface{}{
+				"text/plain": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		}
+	}
+	out["responses"] = responses
+
+	//
+	// Keys are optional, but our admin routes require a token.
+	//
+	if limited {
+		out["security"] = []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"apiKey": []string{}},
+		}
+	}
+	if op.Admin {
+		out["security"] = []interface{}{
+			map[string]interface{}{"adminToken": []string{}},
+		}
+	}
+	return out
+}
+
+//
+// rateLimitHeaders refers to the headers we return with each
+// rate-limited response.
+//
+func rateLimitHeaders() map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Delay", "X-RateLimit-IP"} {
+		out[name] = map[string]interface{}{"$ref": "#/components/headers/" + name}
+	}
+	return out
+}
+
+//
+// schemaOf returns the schema of the given type, as it is encoded by
+// encoding/json.  Structures are added to schemas, and referred to.
+//
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		break
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+
+	//
+	// Our own descriptive types are named without their prefix.
+	//
+	name := strings.TrimPrefix(t.Name(), "api")
+	name = strings.ToUpper(name[:1]) + name[1:]
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	if _, ok := schemas[name]; ok {
+		return ref
+	}
+
+	//
+	// Add a placeholder first, so that recursive types terminate.
+	//
+	schemas[name] = nil
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		field := f.Name
+		if tag[0] != "" {
+			field = tag[0]
+		}
+		properties[field] = schemaOf(f.Type, schemas)
+
+		omit := false
+		for _, opt := range tag[1:] {
+			omit = omit || opt == "omitempty"
+		}
+		if !omit {
+			required = append(required, field)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	schemas[name] = schema
+	return ref
+}
+
+//
+// OpenAPIHandler returns our OpenAPI document.
+//
+// It is called via requests like this:
+//
+//     GET /openapi.json
+//
+func (s *Server) OpenAPIHandler(res http.ResponseWriter, req *http.Request) {
+	sendJSON(res, s.OpenAPI())
+}
@@ -0,0 +1,183 @@
+//
+// Test our OpenAPI document.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+//
+// Test that every route in our router is described by our OpenAPI
+// document, and that the document describes nothing else.
+//
+func TestOpenAPIRoutes(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	paths := srv.OpenAPI()["paths"].(map[string]map[string]interface{})
+
+	routed := make(map[string]bool)
+	err := srv.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("The route %s doesn't restrict its methods", path)
+			return nil
+		}
+
+		//
+		// Routes with a trailing slash are aliases of those
+		// without, so they're described once.
+		//
+		if path != "/" {
+			path = strings.TrimSuffix(path, "/")
+		}
+
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			routed[method+" "+path] = true
+
+			if _, ok := paths[path][method]; !ok {
+				t.Errorf("The route %s %s isn't described in our OpenAPI document", strings.ToUpper(method), path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk our routes: %s", err)
+	}
+
+	for path, ops := range paths {
+		for method := range ops {
+			if !routed[method+" "+path] {
+				t.Errorf("Our OpenAPI document describes %s %s, which isn't routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+//
+// Test that our document is served, and that the references within it
+// may all be resolved.
+//
+func TestOpenAPIHandler(t *testing.T) {
+	srv := newTestServer(t, Options{Version: "1.2.3"})
+
+	req, err := http.NewRequest("GET", "/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Unexpected status-code: %v", rr.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components map[string]map[string]json.RawMessage `json:"components"`
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("Invalid JSON: %s", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Unexpected OpenAPI version '%s'", doc.OpenAPI)
+	}
+	if doc.Info.Version != "1.2.3" {
+		t.Errorf("Unexpected version '%s'", doc.Info.Version)
+	}
+
+	//
+	// The schemas we expect to have been built from our types.
+	//
+	for _, name := range []string{"Record", "ConsistencyReport", "EmailReport", "Watch", "WatchRequest", "StreamEvent", "Error"} {
+		if _, ok := doc.Components["schemas"][name]; !ok {
+			t.Errorf("Missing the schema %s", name)
+		}
+	}
+
+	//
+	// Every reference must resolve.
+	//
+	body := rr.Body.String()
+	for _, part := range strings.Split(body, `"$ref": "#/components/`)[1:] {
+		ref := strings.SplitN(strings.SplitN(part, `"`, 2)[0], "/", 2)
+		if _, ok := doc.Components[ref[0]][ref[1]]; !ok {
+			t.Errorf("Unresolved reference to %s", strings.Join(ref, "/"))
+		}
+	}
+
+	//
+	// Our lookups are rate-limited, our static resources are not.
+	//
+	var lookup struct {
+		Responses map[string]struct {
+			Headers map[string]interface{} `json:"headers"`
+		} `json:"responses"`
+	}
+	json.Unmarshal(doc.Paths["/{type}/{value}"]["get"], &lookup)
+	if _, ok := lookup.Responses["200"].Headers["X-RateLimit-Remaining"]; !ok {
+		t.Errorf("Lookups should describe our rate-limit headers")
+	}
+	if _, ok := lookup.Responses["429"]; !ok {
+		t.Errorf("Lookups should describe the rate-limit being exceeded")
+	}
+
+	var index struct {
+		Responses map[string]interface{} `json:"responses"`
+	}
+	json.Unmarshal(doc.Paths["/"]["get"], &index)
+	if _, ok := index.Responses["429"]; ok {
+		t.Errorf("Our index isn't rate-limited")
+	}
+}
+
+//
+// Test that our schemas follow the JSON encoding of our types.
+//
+func TestOpenAPISchemas(t *testing.T) {
+	schemas := make(map[string]interface{})
+
+	ref := schemaOf(reflect.TypeOf(Watch{}), schemas)
+	if ref["$ref"] != "#/components/schemas/Watch" {
+		t.Fatalf("Unexpected reference %v", ref)
+	}
+
+	watch := schemas["Watch"].(map[string]interface{})
+	props := watch["properties"].(map[string]interface{})
+
+	if props["last_checked"].(map[string]interface{})["format"] != "date-time" {
+		t.Errorf("Times should be described as date-times")
+	}
+	records := props["records"].(map[string]interface{})
+	if records["type"] != "array" || records["items"].(map[string]interface{})["$ref"] != "#/components/schemas/WatchRecord" {
+		t.Errorf("Unexpected schema for records: %v", records)
+	}
+	if _, ok := schemas["WatchRecord"]; !ok {
+		t.Errorf("Nested types should be added")
+	}
+
+	//
+	// Fields which are omitted when empty aren't required.
+	//
+	required := strings.Join(watch["required"].([]string), ",")
+	if !strings.Contains(required, "name") || strings.Contains(required, "secret") {
+		t.Errorf("Unexpected required fields: %s", required)
+	}
+}
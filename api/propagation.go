@@ -0,0 +1,72 @@
+//
+// This file contains the handler for our propagation-checker, which
+// asks a number of public resolvers the same question.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// PropagationHandler is the handler for our propagation-check.
+//
+// It is called via requests like this:
+//
+//     GET /propagation/$TYPE/$NAME
+//
+func (s *Server) PropagationHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	vars := mux.Vars(req)
+	t := strings.ToUpper(vars["type"])
+	v := vars["name"]
+
+	if _, ok := resolver.StringToType[t]; !ok {
+		status = http.StatusNotFound
+		err = errors.New("Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT")
+		return
+	}
+	if _, ok := dns.IsDomainName(v); !ok {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid name '" + v + "'")
+		return
+	}
+
+	resolvers := s.config.Resolvers
+	if len(resolvers) == 0 {
+		resolvers = resolver.DefaultResolvers
+	}
+
+	report := resolver.CheckPropagation(v, t, resolvers)
+
+	s.mutex.Lock()
+	s.stats["propagation.checks"]++
+	s.mutex.Unlock()
+
+	sendJSON(res, report)
+}
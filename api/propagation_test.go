@@ -0,0 +1,62 @@
+//
+// Test the handler of our propagation-checker.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test our handler uses the configured resolvers.
+//
+func TestPropagationHandler(t *testing.T) {
+
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.com. 300 IN TXT \"hello\""))
+
+	srv := newTestServer(t, Options{Config: &Config{
+		Resolvers: []resolver.PublicResolver{{Name: "local", Address: addr}, {Name: "again", Address: addr}},
+	}})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/propagation/txt/example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status-code: %d - %s", resp.StatusCode, body)
+	}
+
+	var report resolver.PropagationReport
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %s", err)
+	}
+	if !report.Consistent || len(report.Results) != 2 || report.Results[0].Resolver != "local" {
+		t.Errorf("Unexpected report: %s", body)
+	}
+
+	//
+	// Invalid types are rejected.
+	//
+	resp, err = http.Get(ts.URL + "/propagation/bogus/example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected status-code: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,198 @@
+//
+// This file contains our proxy policy, which controls whether we believe
+// the address of the caller reported by the proxy a request came via.
+//
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//
+// The headers our proxies may report the address of the caller in.
+//
+var proxyHeaders = []string{"X-Forwarded-For", "Forwarded", "X-Real-Ip"}
+
+// ProxyPolicy controls which proxies we trust to report the address of
+// the caller, and the header they report it in.
+//
+// By default no proxies are trusted, and the address each request came
+// from is used, so that callers can't choose their own address to escape
+// our rate-limits.
+type ProxyPolicy struct {
+	// Trusted are the addresses, or networks, of our proxies.
+	Trusted []string `json:"trusted"`
+
+	// Header is the header our proxies add the address of the caller
+	// to: "X-Forwarded-For", the default, "Forwarded" or "X-Real-IP".
+	// Only one is used, as a caller may send the others themselves.
+	Header string `json:"header"`
+
+	// The parsed version of Trusted.
+	trusted []*net.IPNet
+}
+
+// Parse validates the policy, and applies our defaults, which must be
+// done before it is used.
+func (p *ProxyPolicy) Parse() error {
+
+	if p.Header == "" {
+		p.Header = proxyHeaders[0]
+	}
+	p.Header = http.CanonicalHeaderKey(p.Header)
+	if !allows(proxyHeaders, p.Header) {
+		return fmt.Errorf("invalid header '%s' - use X-Forwarded-For, Forwarded or X-Real-IP", p.Header)
+	}
+
+	p.trusted = nil
+	for _, entry := range p.Trusted {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid address '%s'", entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid network '%s'", entry)
+		}
+		p.trusted = append(p.trusted, network)
+	}
+	return nil
+}
+
+//
+// trusts returns true if the given address is one of our proxies.
+//
+func (p *ProxyPolicy) trusts(ip net.IP) bool {
+	for _, network := range p.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP returns the address of the caller who made the request.
+//
+// If the request came from one of our proxies the addresses in our header
+// are walked from right to left, as each proxy appends the address it
+// received the request from, and the first we don't trust is the caller.
+// Anything to the left of that was sent by the caller, and is ignored.
+//
+// An entry which isn't an address stops the walk, so that the last proxy
+// is used rather than an address the caller may have chosen.
+func (p *ProxyPolicy) RemoteIP(req *http.Request) string {
+
+	peer := parseAddress(req.RemoteAddr)
+	if peer == nil {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+		return host
+	}
+	if !p.trusts(peer) {
+		return peer.String()
+	}
+
+	hops := p.hops(req)
+	addr := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == nil {
+			break
+		}
+		addr = hops[i]
+		if !p.trusts(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+//
+// hops returns the addresses listed in our header, in the order they were
+// added.  Entries which aren't addresses are nil.
+//
+func (p *ProxyPolicy) hops(req *http.Request) []net.IP {
+	var hops []net.IP
+
+	for _, value := range req.Header[p.Header] {
+		switch p.Header {
+		case "Forwarded":
+			hops = append(hops, parseForwarded(value)...)
+		case "X-Real-Ip":
+			hops = append(hops, parseAddress(value))
+		default:
+			for _, entry := range strings.Split(value, ",") {
+				hops = append(hops, parseAddress(entry))
+			}
+		}
+	}
+	return hops
+}
+
+//
+// parseForwarded returns the `for` addresses of a Forwarded header, as
+// described in RFC 7239, such as:
+//
+//     for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8::17]:4711"
+//
+// Elements without an address, or with an obfuscated identifier such as
+// "unknown", are nil.
+//
+func parseForwarded(value string) []net.IP {
+	var hops []net.IP
+
+	for _, element := range strings.Split(value, ",") {
+		var ip net.IP
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+				ip = parseAddress(kv[1])
+			}
+		}
+		hops = append(hops, ip)
+	}
+	return hops
+}
+
+//
+// parseAddress parses an address, which may be quoted, have a port, or be
+// an IPv6 address in brackets.  IPv4-mapped IPv6 addresses are returned
+// as the IPv4 address they really are, so that each address only has one
+// spelling.
+//
+func parseAddress(str string) net.IP {
+	str = strings.Trim(strings.TrimSpace(str), "\"")
+
+	if net.ParseIP(str) == nil {
+		if host, _, err := net.SplitHostPort(str); err == nil {
+			str = host
+		} else if strings.HasPrefix(str, "[") && strings.HasSuffix(str, "]") {
+			str = str[1 : len(str)-1]
+		}
+	}
+
+	//
+	// Link-local addresses may have a zone, such as "fe80::1%eth0".
+	//
+	if i := strings.Index(str, "%"); i >= 0 {
+		str = str[:i]
+	}
+
+	ip := net.ParseIP(str)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return ip
+}
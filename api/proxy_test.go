@@ -0,0 +1,169 @@
+//
+// Test our proxy policy.
+//
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/ratelimit"
+)
+
+//
+// Test that the address of the caller is only taken from our header when
+// the request came via one of our proxies, and that callers can't choose
+// their own address.
+//
+func TestProxyRemoteIP(t *testing.T) {
+
+	type testCase struct {
+		name    string
+		header  string
+		remote  string
+		values  map[string][]string
+		trusted bool
+		result  string
+	}
+
+	xff := "X-Forwarded-For"
+	tests := []testCase{
+		// Without trusted proxies the headers are ignored.
+		{"direct", "", "192.0.2.1:1234", nil, false, "192.0.2.1"},
+		{"spoofed without proxies", "", "192.0.2.1:1234", map[string][]string{xff: {"203.0.113.7"}}, false, "192.0.2.1"},
+		{"spoofed from an untrusted address", "", "192.0.2.1:1234", map[string][]string{xff: {"203.0.113.7"}}, true, "192.0.2.1"},
+		{"remote without a port", "", "192.0.2.1", nil, true, "192.0.2.1"},
+
+		// X-Forwarded-For is walked from the right.
+		{"via a proxy", "", "10.0.0.1:1234", map[string][]string{xff: {"203.0.113.7"}}, true, "203.0.113.7"},
+		{"via a proxy without a header", "", "10.0.0.1:1234", nil, true, "10.0.0.1"},
+		{"spoofed via a proxy", "", "10.0.0.1:1234", map[string][]string{xff: {"1.2.3.4, 203.0.113.7"}}, true, "203.0.113.7"},
+		{"spoofed trusted address", "", "10.0.0.1:1234", map[string][]string{xff: {"10.9.9.9, 203.0.113.7"}}, true, "203.0.113.7"},
+		{"spoofed in a header of its own", "", "10.0.0.1:1234", map[string][]string{xff: {"1.2.3.4", "203.0.113.7"}}, true, "203.0.113.7"},
+		{"via several proxies", "", "10.0.0.1:1234", map[string][]string{xff: {"1.2.3.4, 203.0.113.7, 10.0.0.2"}}, true, "203.0.113.7"},
+		{"only proxies", "", "10.0.0.1:1234", map[string][]string{xff: {"10.0.0.3, 10.0.0.2"}}, true, "10.0.0.3"},
+		{"garbage", "", "10.0.0.1:1234", map[string][]string{xff: {"203.0.113.7, garbage"}}, true, "10.0.0.1"},
+		{"garbage behind a proxy", "", "10.0.0.1:1234", map[string][]string{xff: {"garbage, 203.0.113.7"}}, true, "203.0.113.7"},
+		{"empty entry", "", "10.0.0.1:1234", map[string][]string{xff: {"203.0.113.7,"}}, true, "10.0.0.1"},
+		{"with a port", "", "10.0.0.1:1234", map[string][]string{xff: {"203.0.113.7:4711"}}, true, "203.0.113.7"},
+
+		// IPv6, which has several spellings of each address.
+		{"ipv6", "", "10.0.0.1:1234", map[string][]string{xff: {"2001:DB8:0::1"}}, true, "2001:db8::1"},
+		{"ipv6 with a port", "", "10.0.0.1:1234", map[string][]string{xff: {"[2001:db8::1]:443"}}, true, "2001:db8::1"},
+		{"ipv6 in brackets", "", "10.0.0.1:1234", map[string][]string{xff: {"[2001:db8::1]"}}, true, "2001:db8::1"},
+		{"ipv6 with a zone", "", "10.0.0.1:1234", map[string][]string{xff: {"fe80::1%eth0"}}, true, "fe80::1"},
+		{"ipv4-mapped", "", "10.0.0.1:1234", map[string][]string{xff: {"::ffff:203.0.113.7"}}, true, "203.0.113.7"},
+		{"ipv6 proxy", "", "[2001:db8:ffff::1]:1234", map[string][]string{xff: {"203.0.113.7"}}, true, "203.0.113.7"},
+		{"ipv6 direct", "", "[2001:DB8::2]:1234", map[string][]string{xff: {"203.0.113.7"}}, true, "2001:db8::2"},
+		{"ipv4-mapped proxy", "", "[::ffff:10.0.0.1]:1234", map[string][]string{xff: {"203.0.113.7"}}, true, "203.0.113.7"},
+
+		// RFC 7239.
+		{"forwarded", "Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=203.0.113.7;proto=https;by=10.0.0.1"}}, true, "203.0.113.7"},
+		{"forwarded ipv6", "Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}}, true, "2001:db8:cafe::17"},
+		{"forwarded spoofed", "Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=1.2.3.4, for=203.0.113.7"}}, true, "203.0.113.7"},
+		{"forwarded via several proxies", "Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=203.0.113.7", "for=10.0.0.2;proto=http"}}, true, "203.0.113.7"},
+		{"forwarded unknown", "Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=1.2.3.4, for=unknown"}}, true, "10.0.0.1"},
+		{"forwarded without for", "Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {"proto=https"}}, true, "10.0.0.1"},
+		{"forwarded ignores x-forwarded-for", "Forwarded", "10.0.0.1:1234", map[string][]string{xff: {"1.2.3.4"}}, true, "10.0.0.1"},
+		{"forwarded from an untrusted address", "Forwarded", "192.0.2.1:1234", map[string][]string{"Forwarded": {"for=203.0.113.7"}}, true, "192.0.2.1"},
+
+		// X-Real-IP.
+		{"x-real-ip", "X-Real-IP", "10.0.0.1:1234", map[string][]string{"X-Real-Ip": {"203.0.113.7"}}, true, "203.0.113.7"},
+		{"x-real-ip ipv6", "x-real-ip", "10.0.0.1:1234", map[string][]string{"X-Real-Ip": {"2001:db8::1"}}, true, "2001:db8::1"},
+		{"x-real-ip spoofed", "X-Real-IP", "10.0.0.1:1234", map[string][]string{"X-Real-Ip": {"1.2.3.4", "203.0.113.7"}}, true, "203.0.113.7"},
+		{"x-real-ip garbage", "X-Real-IP", "10.0.0.1:1234", map[string][]string{"X-Real-Ip": {"1.2.3.4, 203.0.113.7"}}, true, "10.0.0.1"},
+		{"x-real-ip ignores x-forwarded-for", "X-Real-IP", "10.0.0.1:1234", map[string][]string{xff: {"1.2.3.4"}}, true, "10.0.0.1"},
+		{"x-real-ip from an untrusted address", "X-Real-IP", "192.0.2.1:1234", map[string][]string{"X-Real-Ip": {"203.0.113.7"}}, true, "192.0.2.1"},
+	}
+
+	for _, tc := range tests {
+		p := ProxyPolicy{Header: tc.header}
+		if tc.trusted {
+			p.Trusted = []string{"10.0.0.0/8", "2001:db8:ffff::/48"}
+		}
+		err := p.Parse()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.name, err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		for name, values := range tc.values {
+			req.Header[name] = values
+		}
+
+		out := p.RemoteIP(req)
+		if out != tc.result {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.result, out)
+		}
+	}
+}
+
+//
+// Test that invalid policies are rejected.
+//
+func TestProxyPolicyParse(t *testing.T) {
+	tests := map[string]ProxyPolicy{
+		"invalid header 'Client-Ip'":   {Header: "Client-IP"},
+		"invalid address 'bogus'":      {Trusted: []string{"bogus"}},
+		"invalid network '10.0.0.0/x'": {Trusted: []string{"10.0.0.0/x"}},
+	}
+	for expected, p := range tests {
+		err := p.Parse()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error '%s', got %v", expected, err)
+		}
+	}
+
+	p := ProxyPolicy{Header: "forwarded", Trusted: []string{"192.0.2.1", "2001:db8::1"}}
+	err := p.Parse()
+	if err != nil || p.Header != "Forwarded" || len(p.trusted) != 2 {
+		t.Errorf("Unexpected policy %v %v", p, err)
+	}
+}
+
+//
+// Test that callers can't escape our rate-limits by claiming to be
+// somebody else.
+//
+func TestProxyRateLimit(t *testing.T) {
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.test. 60 IN A 192.0.2.1"))
+
+	config := &Config{
+		RateLimits: ratelimit.Policy{Limits: []ratelimit.Limit{{Period: "hour", Requests: 2}}},
+		Proxies:    ProxyPolicy{Trusted: []string{"10.0.0.0/8"}},
+	}
+	srv := newTestServer(t, Options{Config: config}, addr)
+
+	for i, expected := range []int{200, 200, 429, 429} {
+		req := httptest.NewRequest("GET", "/A/example.test", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i+1)+", 203.0.113.7")
+
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("%d: expected %d, got %d", i, expected, rr.Code)
+		}
+		if rr.Header().Get("X-RateLimit-IP") != "203.0.113.7" {
+			t.Errorf("%d: unexpected client %s", i, rr.Header().Get("X-RateLimit-IP"))
+		}
+	}
+
+	//
+	// Another caller, behind the same proxy, is limited separately.
+	//
+	req := httptest.NewRequest("GET", "/A/example.test", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.8")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Unexpected status-code %d", rr.Code)
+	}
+}
@@ -0,0 +1,476 @@
+//
+// Package api contains the HTTP handlers of our service, which may be
+// mounted within any other Go program:
+//
+//     srv, err := api.New(api.Options{Version: "1.2.3"})
+//     if err != nil {
+//         ..
+//     }
+//     defer srv.Close()
+//     http.Handle("/dns/", http.StripPrefix("/dns", srv))
+//
+// Each Server carries its own resolver, configuration, rate-limiter,
+// watches, history and statistics, so several may be used at once.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/skx/dns-api-go/ratelimit"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+// Options are the dependencies, and settings, of a Server.
+//
+// All of them are optional.
+type Options struct {
+	// Resolver makes our DNS queries, if nil resolver.New() is used.
+	Resolver *resolver.Resolver
+
+	// Config holds the settings of the operator, if nil the defaults
+	// are used.  It is validated, and updated, by Config.Parse.
+	Config *Config
+
+	// Limiter counts the requests of each client, for our rate-limits.
+	// If nil the counters are held in memory.
+	Limiter ratelimit.Limiter
+
+	// Watches is where our watches are stored, if nil they are only
+	// held in memory.
+	Watches WatchStore
+
+	// Keys is where our API keys are stored, if nil they are only
+	// held in memory.
+	Keys KeyStore
+
+	// Usage is where the usage of each client is stored, if nil it is
+	// only held in memory.
+	Usage UsageStore
+
+	// HistoryFile is the path of the file the history of our answers
+	// is stored in.  If empty the history isn't recorded.
+	HistoryFile string
+
+	// Version is reported upon our index-page, and to webhooks.
+	Version string
+}
+
+// Server is an http.Handler which serves our API.
+type Server struct {
+	router   *mux.Router
+	handler  http.Handler
+	resolver *resolver.Resolver
+	config   *Config
+	limiter  ratelimit.Limiter
+	version  string
+
+	// limits holds our *rateLimitPolicies, which may be replaced at
+	// any time.
+	limits atomic.Value
+
+	// retired is non-zero if we've been retired.
+	retired int32
+
+	// stats are the counters we (optionally) submit to a metric-host,
+	// they're protected by the mutex.
+	mutex sync.Mutex
+	stats map[string]int64
+
+	watches *watchManager
+	keys    *keyManager
+	usage   *usageRecorder
+	history *historyStore
+	streams streamCounter
+
+	// webhookClient is the client we deliver notifications with.
+	webhookClient *http.Client
+}
+
+//
+// New creates a server with the given options, loading and starting
+// any stored watches.
+//
+// The server should be closed when it is no longer required.
+//
+func New(opts Options) (*Server, error) {
+
+	s := &Server{
+		resolver: opts.Resolver,
+		config:   opts.Config,
+		limiter:  opts.Limiter,
+		version:  opts.Version,
+		stats:    make(map[string]int64),
+		streams:  streamCounter{open: make(map[string]int)},
+	}
+	if s.resolver == nil {
+		s.resolver = resolver.New()
+	}
+	if s.config == nil {
+		s.config = &Config{}
+	}
+	if s.limiter == nil {
+		s.limiter = ratelimit.NewMemory()
+	}
+	if s.version == "" {
+		s.version = "unreleased"
+	}
+
+	err := s.config.Parse()
+	if err != nil {
+		return nil, err
+	}
+	s.webhookClient = newWebhookClient(&s.config.Webhooks)
+
+	s.router = s.newRouter()
+	s.handler = s.cors(s.router)
+
+	err = s.SetRateLimits(s.config.RateLimits, s.config.Keys.Tiers)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := opts.Keys
+	if keys == nil {
+		keys = NewFileKeyStore("")
+	}
+	s.keys = newKeyManager(keys)
+	err = s.keys.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := opts.Usage
+	if usage == nil {
+		usage = NewFileUsageStore("")
+	}
+	s.usage = newUsageRecorder(usage)
+
+	if opts.HistoryFile != "" {
+		h, err := loadHistory(opts.HistoryFile)
+		if err != nil {
+			return nil, err
+		}
+		s.history = h
+	}
+
+	store := opts.Watches
+	if store == nil {
+		store = NewFileWatchStore("")
+	}
+	s.watches = newWatchManager(s, store)
+	err = s.watches.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+//
+// newRouter creates our router, with all of our route-mappings.
+//
+func (s *Server) newRouter() *mux.Router {
+
+	router := mux.NewRouter()
+
+	//
+	// API end-points
+	//
+	router.HandleFunc("/admin/keys", s.KeysHandler).Methods("GET", "POST")
+	router.HandleFunc("/admin/keys/{id}", s.KeyHandler).Methods("GET", "DELETE")
+	router.HandleFunc("/admin/keys/{id}/rotate", s.KeyHandler).Methods("POST")
+	router.HandleFunc("/admin/usage", s.UsageHandler).Methods("GET")
+	router.HandleFunc("/axfr/{zone}", s.AXFRHandler).Methods("GET")
+	router.HandleFunc("/consistency/{zone}", s.ConsistencyHandler).Methods("GET")
+	router.HandleFunc("/dnsbl/{target}", s.DNSBLHandler).Methods("GET")
+	router.HandleFunc("/email/{domain}", s.EmailHandler).Methods("GET")
+	router.HandleFunc("/history/{type}/{name}", s.HistoryHandler).Methods("GET")
+	router.HandleFunc("/lint/{domain}", s.LintHandler).Methods("GET")
+	router.HandleFunc("/propagation/{type}/{name}", s.PropagationHandler).Methods("GET")
+	router.HandleFunc("/spf/{domain}", s.SPFHandler).Methods("GET")
+	router.HandleFunc("/stream/{type}/{name}", s.StreamHandler).Methods("GET")
+	router.HandleFunc("/watches", s.WatchesHandler).Methods("GET", "POST")
+	router.HandleFunc("/watches/{id}", s.WatchHandler).Methods("GET", "DELETE")
+	router.HandleFunc("/wildcard/{zone}", s.WildcardHandler).Methods("GET")
+	router.HandleFunc("/@{server}/{type}/{value}", s.DNSHandler).Methods("GET")
+	router.HandleFunc("/@{server}/{type}/{value}/", s.DNSHandler).Methods("GET")
+	router.HandleFunc("/{type}/{value}", s.DNSHandler).Methods("GET")
+	router.HandleFunc("/{type}/{value}/", s.DNSHandler).Methods("GET")
+	router.HandleFunc("/openapi.json", s.OpenAPIHandler).Methods("GET")
+	router.HandleFunc("/docs", s.DocsHandler).Methods("GET")
+	router.HandleFunc("/humans.txt", s.HumanHandler).Methods("GET")
+	router.HandleFunc("/robots.txt", s.RobotHandler).Methods("GET")
+	router.HandleFunc("/favicon.ico", s.IconHandler).Methods("GET")
+	router.HandleFunc("/", s.IndexHandler).Methods("GET")
+
+	router.Use(s.account)
+	return router
+}
+
+// ServeHTTP dispatches a request to the appropriate handler.
+func (s *Server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	s.handler.ServeHTTP(res, req)
+}
+
+//
+// routeExists returns true if the given request would be routed to one
+// of our handlers.
+//
+func (s *Server) routeExists(req *http.Request) bool {
+	var match mux.RouteMatch
+	return s.router.Match(req, &match) && match.MatchErr == nil
+}
+
+//
+// Close stops our watches, and writes out our usage and history.
+//
+func (s *Server) Close() error {
+	s.watches.Stop()
+
+	err := s.FlushUsage()
+	if err != nil {
+		return err
+	}
+	return s.FlushHistory()
+}
+
+//
+// FlushHistory writes our history to its file, if it is enabled and has
+// changed.
+//
+func (s *Server) FlushHistory() error {
+	if s.history == nil {
+		return nil
+	}
+	return s.history.Flush()
+}
+
+//
+// SetRetired changes whether we've been retired, if so our handlers
+// return nothing and our index-page explains why.
+//
+func (s *Server) SetRetired(retired bool) {
+	var v int32
+	if retired {
+		v = 1
+	}
+	atomic.StoreInt32(&s.retired, v)
+}
+
+// Retired returns true if we've been retired.
+func (s *Server) Retired() bool {
+	return atomic.LoadInt32(&s.retired) != 0
+}
+
+//
+// Stats returns a copy of our counters, such as the number of queries
+// we've made of each type.
+//
+func (s *Server) Stats() map[string]int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	out := make(map[string]int64, len(s.stats))
+	for key, val := range s.stats {
+		out[key] = val
+	}
+	return out
+}
+
+//
+// RemoteIP retrieves the remote IP address of the requesting HTTP-client,
+// trusting only the proxies of our configuration.
+//
+// This is used for our rate-limiting.
+//
+func (s *Server) RemoteIP(req *http.Request) string {
+	return s.config.Proxies.RemoteIP(req)
+}
+
+// rateLimitPolicies are the rate-limits of anonymous callers, and of the
+// tiers of our API keys.
+type rateLimitPolicies struct {
+	anonymous ratelimit.Policy
+	tiers     map[string]*Tier
+}
+
+// SetRateLimits replaces the rate-limits of anonymous callers, and the
+// tiers of our API keys, which may be done whilst we're serving requests.
+//
+// The costs of the policies, and the features of the tiers, must name
+// our routes, such as "propagation", with lookups being "dns".
+func (s *Server) SetRateLimits(p ratelimit.Policy, tiers map[string]Tier) error {
+	names := make(map[string]bool)
+	s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, e := route.GetPathTemplate()
+		if e == nil {
+			names[routeName(tpl)] = true
+		}
+		return nil
+	})
+
+	check := func(p *ratelimit.Policy) error {
+		err := p.Parse()
+		if err != nil {
+			return err
+		}
+		for route := range p.Costs {
+			if !names[route] {
+				return fmt.Errorf("there is no '%s' route", route)
+			}
+		}
+		return nil
+	}
+
+	limits := &rateLimitPolicies{anonymous: p, tiers: make(map[string]*Tier)}
+	err := check(&limits.anonymous)
+	if err != nil {
+		return fmt.Errorf("invalid 'rate_limits' policy: %s", err.Error())
+	}
+
+	for name, tier := range tiers {
+		t := tier
+		err = t.Parse()
+		if err == nil {
+			err = check(&t.RateLimits)
+		}
+		for _, f := range t.Features {
+			if err == nil && !names[f] {
+				err = fmt.Errorf("there is no '%s' route", f)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tier '%s': %s", name, err.Error())
+		}
+		limits.tiers[name] = &t
+	}
+
+	s.limits.Store(limits)
+	return nil
+}
+
+//
+// rateLimits returns our current rate-limits.
+//
+func (s *Server) rateLimits() *rateLimitPolicies {
+	return s.limits.Load().(*rateLimitPolicies)
+}
+
+//
+// routeName returns the name of a route, given its path, as it is used
+// by our rate-limiting policy.  This is the first component of the path,
+// such as "propagation", or "dns" for lookups.
+//
+func routeName(path string) string {
+	name := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	if strings.HasPrefix(name, "{") || strings.HasPrefix(name, "@") {
+		return "dns"
+	}
+	return name
+}
+
+//
+// currentRouteName returns the name of the route a request was routed
+// to, or "" if there isn't one.
+//
+func currentRouteName(req *http.Request) string {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return ""
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return routeName(tpl)
+}
+
+//
+// rateLimit applies our rate-limits to the given request, and sets the
+// X-RateLimit headers upon the response.
+//
+// Callers who present an API key are limited by its tier, which may also
+// restrict the routes they may use, otherwise by their address.
+//
+// If the request isn't permitted the caller is told so, and we return
+// false.
+//
+func (s *Server) rateLimit(res http.ResponseWriter, req *http.Request) bool {
+	name := currentRouteName(req)
+
+	key, err := s.apiKey(req)
+	if err != nil {
+		sendError(res, http.StatusUnauthorized, err)
+		return false
+	}
+
+	client := s.RemoteIP(req)
+	if key != nil {
+		client = "key:" + key.ID
+	}
+
+	policy, tier := s.clientPolicy(client)
+	if tier != nil && !tier.allows(name) {
+		sendError(res, http.StatusForbidden, fmt.Errorf("The tier of your API key doesn't include '%s'", name))
+		return false
+	}
+	return ratelimit.Check(s.limiter, policy, res, client, name)
+}
+
+//
+// allowBackground charges a lookup we make on behalf of a client, such as
+// for a watch or stream, to their rate-limit.
+//
+func (s *Server) allowBackground(owner string) bool {
+	p, _ := s.clientPolicy(owner)
+
+	cost := p.Cost("dns")
+	if cost == 0 || p.Exempted(owner) {
+		return true
+	}
+	_, _, _, allowed := p.Allow(s.limiter, owner, cost)
+	return allowed
+}
+
+//
+// sendError reports an error to the caller, with the given status-code.
+//
+func sendError(res http.ResponseWriter, status int, err error) {
+	http.Error(res, err.Error(), status)
+
+	// Don't spam stdout when running test-cases.
+	if flag.Lookup("test.v") == nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	}
+}
+
+//
+// sendJSON sends the given object to the caller, as (pretty) JSON.
+//
+func sendJSON(res http.ResponseWriter, obj interface{}) {
+	sendJSONStatus(res, http.StatusOK, obj)
+}
+
+//
+// sendJSONStatus sends the given object to the caller, as (pretty) JSON,
+// with the given status-code.
+//
+func sendJSONStatus(res http.ResponseWriter, status int, obj interface{}) {
+	out, err := json.MarshalIndent(obj, "", "     ")
+	if err != nil {
+		sendError(res, http.StatusInternalServerError, err)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	fmt.Fprintf(res, "%s", out)
+}
@@ -0,0 +1,64 @@
+//
+// This file contains the handler for our SPF evaluation.
+//
+
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+)
+
+//
+// SPFHandler is the handler for our SPF evaluation.
+//
+// It is called via requests like this:
+//
+//     GET /spf/$DOMAIN?ip=$IP&sender=$SENDER&helo=$HELO
+//
+func (s *Server) SPFHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	domain := mux.Vars(req)["domain"]
+	if _, ok := dns.IsDomainName(domain); !ok {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid domain '" + domain + "'")
+		return
+	}
+
+	ip := net.ParseIP(req.URL.Query().Get("ip"))
+	if ip == nil {
+		status = http.StatusBadRequest
+		err = errors.New("Missing or invalid 'ip' parameter")
+		return
+	}
+
+	eval := s.resolver.CheckSPF(domain, ip, req.URL.Query().Get("sender"), req.URL.Query().Get("helo"))
+
+	s.mutex.Lock()
+	s.stats["spf.checks"]++
+	s.mutex.Unlock()
+
+	sendJSON(res, eval)
+}
@@ -0,0 +1,63 @@
+//
+// Test the handler of our SPF evaluation.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test the HTTP handler.
+//
+func TestSPFHandler(t *testing.T) {
+
+	addr := dnstest.Start(t, dnstest.Zone(t,
+		"example.test. 60 IN TXT \"v=spf1 ip4:192.0.2.1 -all\"",
+	))
+	srv := newTestServer(t, Options{}, addr)
+
+	tests := []struct {
+		url    string
+		status int
+		result string
+	}{
+		{"/spf/example.test?ip=192.0.2.1&sender=bob@example.test", http.StatusOK, "pass"},
+		{"/spf/example.test?ip=192.0.2.2", http.StatusOK, "fail"},
+		{"/spf/example.test", http.StatusBadRequest, ""},
+		{"/spf/example.test?ip=steve", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("%s gave status %d, expected %d", test.url, rr.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var eval resolver.SPFEvaluation
+		err = json.Unmarshal(rr.Body.Bytes(), &eval)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", test.url, err)
+		}
+		if eval.Result != test.result {
+			t.Errorf("%s gave %s, expected %s", test.url, eval.Result, test.result)
+		}
+	}
+}
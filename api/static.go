@@ -0,0 +1,150 @@
+//
+// This file contains the handlers for our index-page, and the other
+// static resources we serve.
+//
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/skx/dns-api-go/ratelimit"
+	"github.com/skx/dns-api-go/static"
+)
+
+//
+// Serve a static thing from an embedded resource.
+//
+func serveResource(response http.ResponseWriter, request *http.Request, resource string, mime string) {
+	tmpl, err := static.Resource(resource)
+	if err != nil {
+		fmt.Fprintf(response, err.Error())
+		return
+	}
+	response.Header().Set("Content-Type", mime)
+	fmt.Fprintf(response, string(tmpl))
+}
+
+//
+// RobotHandler handles the request for /robots.txt
+//
+func (s *Server) RobotHandler(res http.ResponseWriter, req *http.Request) {
+	serveResource(res, req, "data/robots.txt", "text/plain")
+}
+
+//
+// HumanHandler handles the request for /humans.txt
+//
+func (s *Server) HumanHandler(res http.ResponseWriter, req *http.Request) {
+	serveResource(res, req, "data/humans.txt", "text/plain")
+}
+
+//
+// IconHandler handles the request for /favicon.ico
+//
+func (s *Server) IconHandler(res http.ResponseWriter, req *http.Request) {
+	serveResource(res, req, "data/favicon.ico", "image/x-icon")
+}
+
+//
+// DocsHandler returns our interactive documentation, which is rendered
+// from our OpenAPI document.
+//
+func (s *Server) DocsHandler(res http.ResponseWriter, req *http.Request) {
+	page, err := static.ExpandResource("data/docs.html")
+	if err != nil {
+		fmt.Fprintf(res, err.Error())
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(res, page)
+}
+
+//
+// IndexHandler returns our front-page.
+//
+// The index-page _should_ be static, but we rewrite the domain-name
+// based upon the incoming request.
+//
+func (s *Server) IndexHandler(res http.ResponseWriter, req *http.Request) {
+
+	//
+	// This is the data that we add to our output-template.
+	//
+	type Pagedata struct {
+		Hostname string
+		Version  string
+		Limits   string
+		History  bool
+	}
+
+	//
+	// Create an instance and populate the hostname + version
+	//
+	var x Pagedata
+	x.Hostname = req.Host
+	x.Version = s.version
+	x.Limits = describeLimits(s.rateLimits().anonymous.Limits)
+	x.History = (s.history != nil)
+
+	//
+	// We load our template from the embedded resource.
+	//
+	file := "data/index.html"
+	if s.Retired() {
+		file = "data/retired.html"
+	}
+
+	//
+	// Load it.
+	//
+	tmpl, err := static.ExpandResource(file)
+	if err != nil {
+		fmt.Fprintf(res, err.Error())
+		return
+	}
+
+	//
+	// Parse our template.
+	//
+	src := string(tmpl)
+	t := template.Must(template.New("tmpl").Parse(src))
+
+	//
+	// Execute the template into a temporary buffer.
+	//
+	buf := &bytes.Buffer{}
+	err = t.Execute(buf, x)
+
+	//
+	// If there were errors, then show them.
+	if err != nil {
+		fmt.Fprintf(res, err.Error())
+		return
+	}
+
+	//
+	// Otherwise send the result to the caller.
+	//
+	buf.WriteTo(res)
+}
+
+//
+// describeLimits describes our rate-limits for our index-page, such as
+// "5 lookups per second and 200 per hour".
+//
+func describeLimits(limits []ratelimit.Limit) string {
+	var out []string
+	for i, l := range limits {
+		if i == 0 {
+			out = append(out, fmt.Sprintf("%d lookups per %s", l.Requests, l.Period))
+		} else {
+			out = append(out, fmt.Sprintf("%d per %s", l.Requests, l.Period))
+		}
+	}
+	return strings.Join(out, " and ")
+}
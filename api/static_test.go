@@ -0,0 +1,78 @@
+//
+// Test our index-page, and other static resources.
+//
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//
+// Test that our static-resources work.
+//
+func TestStaticResources(t *testing.T) {
+
+	//
+	// Wire up our end-points
+	//
+	srv := newTestServer(t, Options{})
+
+	//
+	// The path we're requesting and the expected content-type
+	// of the response.
+	//
+	type TestCase struct {
+		Path string
+		Type string
+	}
+
+	//
+	// The tests
+	//
+	tests := []TestCase{
+		{"/robots.txt", "text/plain"},
+		{"/humans.txt", "text/plain"},
+		{"/favicon.ico", "image/x-icon"},
+		{"/openapi.json", "application/json"},
+		{"/docs", "text/html; charset=utf-8"},
+		{"/", "text/html; charset=utf-8"}}
+
+	//
+	// Run each one.
+	//
+	for _, test := range tests {
+
+		//
+		// Make the request, with the appropriate Accept: header
+		//
+		req, err := http.NewRequest("GET", test.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//
+		// Fake it out
+		//
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		//
+		// Test the status-code is OK
+		//
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("Unexpected status-code: %v", status)
+		}
+
+		//
+		// Test that we got the content-type back
+		//
+		if ctype := rr.Header().Get("Content-Type"); ctype != test.Type {
+			t.Errorf("content-type header does not match: got %v want %v",
+				ctype, test.Type)
+		}
+	}
+
+}
@@ -0,0 +1,427 @@
+//
+// This file contains our live streams, which keep a connection open and
+// push the answer to a lookup each time it changes.
+//
+// Streams are delivered as Server-Sent Events, or over a WebSocket if the
+// client asks to upgrade.  The name is re-resolved when the answer we saw
+// expires, so a stream follows its records as closely as a resolver would,
+// and each lookup counts against the rate-limit of the client.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+	"golang.org/x/net/websocket"
+)
+
+//
+// minStreamInterval is the shortest time we'll wait between lookups, no
+// matter how low the TTL of the records is.
+//
+var minStreamInterval = 5 * time.Second
+
+//
+// maxStreamInterval is the longest time we'll wait between lookups.
+//
+const maxStreamInterval = time.Hour
+
+//
+// streamEmptyInterval is how long we wait before looking up a name which
+// has no records.
+//
+var streamEmptyInterval = time.Minute
+
+//
+// streamKeepalive is how often an idle event-stream is sent a comment, so
+// that proxies don't close it.
+//
+var streamKeepalive = 30 * time.Second
+
+//
+// maxStreamsPerClient is the number of streams each client may have open
+// at once.
+//
+const maxStreamsPerClient = 5
+
+//
+// errTooManyStreams is returned when a client has as many streams open as
+// they may.
+//
+var errTooManyStreams = fmt.Errorf("No more than %d streams may be open at once", maxStreamsPerClient)
+
+//
+// maxStreams is the number of streams which may be open at once, from all
+// clients together, as each has its own resolver loop.
+//
+var maxStreams = 1000
+
+//
+// errStreamsFull is returned when no more streams may be opened by anybody.
+//
+var errStreamsFull = fmt.Errorf("No more streams may be opened at the moment")
+
+//
+// streamCounter counts the open streams of each client, and of them all.
+//
+type streamCounter struct {
+	sync.Mutex
+	open  map[string]int
+	total int
+}
+
+// StreamEvent is the answer to a lookup, pushed to a stream when it
+// changes.
+type StreamEvent struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Time    time.Time     `json:"time"`
+	Records []WatchRecord `json:"records"`
+	Added   []WatchRecord `json:"added"`
+	Removed []WatchRecord `json:"removed"`
+	Changed []WatchChange `json:"changed"`
+
+	// Error is set, and the stream closed, if a lookup could not
+	// be made.
+	Error string `json:"error,omitempty"`
+}
+
+//
+// streamWriter is a connection events may be delivered over.
+//
+type streamWriter interface {
+
+	// Send delivers an event.
+	Send(e StreamEvent) error
+
+	// Ping keeps an idle connection open.
+	Ping() error
+}
+
+//
+// sseWriter delivers events as Server-Sent Events.
+//
+type sseWriter struct {
+	res     http.ResponseWriter
+	flusher http.Flusher
+}
+
+//
+// Send writes an event, and flushes it to the client.
+//
+func (s sseWriter) Send(e StreamEvent) error {
+	name := "change"
+	if e.Error != "" {
+		name = "error"
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.res, "event: %s\ndata: %s\n\n", name, data)
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+//
+// Ping writes a comment, which clients ignore.
+//
+func (s sseWriter) Ping() error {
+	_, err := fmt.Fprint(s.res, ": ping\n\n")
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+//
+// wsWriter delivers events as JSON messages over a WebSocket.
+//
+type wsWriter struct {
+	conn *websocket.Conn
+}
+
+//
+// Send writes an event as a single message.
+//
+func (w wsWriter) Send(e StreamEvent) error {
+	return websocket.JSON.Send(w.conn, e)
+}
+
+//
+// Ping does nothing, as WebSocket clients answer pings themselves and we
+// notice closed connections by reading from them.
+//
+func (w wsWriter) Ping() error {
+	return nil
+}
+
+//
+// acquireStream reserves one of the streams of the given client, returning
+// an error if they, or the server, have as many open as they may.
+//
+func (s *Server) acquireStream(owner string) error {
+	s.streams.Lock()
+	defer s.streams.Unlock()
+
+	if s.streams.open[owner] >= maxStreamsPerClient {
+		return errTooManyStreams
+	}
+	if s.streams.total >= maxStreams {
+		return errStreamsFull
+	}
+	s.streams.open[owner]++
+	s.streams.total++
+	return nil
+}
+
+//
+// releaseStream releases a stream reserved by acquireStream.
+//
+func (s *Server) releaseStream(owner string) {
+	s.streams.Lock()
+	defer s.streams.Unlock()
+
+	s.streams.open[owner]--
+	s.streams.total--
+	if s.streams.open[owner] <= 0 {
+		delete(s.streams.open, owner)
+	}
+}
+
+//
+// streamInterval returns how long to wait before looking up the given
+// records again: until the first of them expires.
+//
+func streamInterval(records []WatchRecord) time.Duration {
+	if len(records) == 0 {
+		return streamEmptyInterval
+	}
+
+	ttl := records[0].TTL
+	for _, r := range records {
+		if r.TTL < ttl {
+			ttl = r.TTL
+		}
+	}
+
+	interval := time.Duration(ttl) * time.Second
+	if interval < minStreamInterval {
+		interval = minStreamInterval
+	}
+	if interval > maxStreamInterval {
+		interval = maxStreamInterval
+	}
+	return interval
+}
+
+//
+// runStream resolves the given name & type until the stop channel is
+// closed, or the writer fails, sending an event each time the answer
+// changes.  The first answer is always sent.
+//
+func (s *Server) runStream(name string, qtype string, owner string, w streamWriter, stop <-chan struct{}) {
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	var (
+		previous []WatchRecord
+		checked  time.Time
+	)
+
+	for {
+
+		//
+		// Our lookups count against the rate-limit of the client,
+		// just as if they'd made them themselves.
+		//
+		if !checked.IsZero() && !s.allowBackground(owner) {
+			w.Send(StreamEvent{Name: name, Type: qtype, Time: time.Now().UTC(), Error: "API rate limit exceeded."})
+			return
+		}
+
+		now := time.Now().UTC()
+		records, err := s.resolveWatch(name, qtype)
+		if err != nil {
+			w.Send(StreamEvent{Name: name, Type: qtype, Time: now, Error: err.Error()})
+			return
+		}
+
+		var event *WatchEvent
+		if checked.IsZero() {
+			event = &WatchEvent{Added: records, Removed: []WatchRecord{}, Changed: []WatchChange{}}
+		} else {
+			event = diffRecords(previous, records, now.Sub(checked))
+		}
+		previous = records
+		checked = now
+
+		if event != nil {
+			err = w.Send(StreamEvent{
+				Name:    name,
+				Type:    qtype,
+				Time:    now,
+				Records: records,
+				Added:   event.Added,
+				Removed: event.Removed,
+				Changed: event.Changed,
+			})
+			if err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			s.stats["stream.events"]++
+			s.mutex.Unlock()
+		}
+
+		timer := time.NewTimer(streamInterval(records))
+	wait:
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-keepalive.C:
+				if w.Ping() != nil {
+					timer.Stop()
+					return
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+}
+
+//
+// websocketHandshake refuses WebSockets opened by pages whose origin our
+// CORS policy doesn't allow.
+//
+func (s *Server) websocketHandshake(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin != "" && s.config.CORS.allowedOrigin(origin) == "" {
+		return fmt.Errorf("Origin '%s' is not permitted", origin)
+	}
+	return nil
+}
+
+//
+// StreamHandler is the handler for streaming the answer to a lookup.
+//
+// It is called via requests like this:
+//
+//     GET /stream/$TYPE/$NAME
+//
+// The events are sent as Server-Sent Events, unless the request is a
+// WebSocket upgrade in which case each is a JSON message.
+//
+func (s *Server) StreamHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	vars := mux.Vars(req)
+	t := strings.ToUpper(vars["type"])
+	v := dns.Fqdn(vars["name"])
+
+	if _, ok := resolver.StringToType[t]; !ok {
+		status = http.StatusNotFound
+		err = errors.New("Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT")
+		return
+	}
+	if _, ok := dns.IsDomainName(v); !ok {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid name '" + v + "'")
+		return
+	}
+
+	owner := s.clientID(req)
+	err = s.acquireStream(owner)
+	if err != nil {
+		status = http.StatusTooManyRequests
+		if err == errStreamsFull {
+			status = http.StatusServiceUnavailable
+		}
+		return
+	}
+	defer s.releaseStream(owner)
+
+	s.mutex.Lock()
+	s.stats["stream.connections"]++
+	s.mutex.Unlock()
+
+	//
+	// A WebSocket is closed when reading from it fails, as we don't
+	// expect our clients to send us anything.
+	//
+	// Browsers don't apply CORS to WebSockets, so we refuse pages
+	// whose origin our policy doesn't allow.  Other clients don't send
+	// an origin, and are always accepted.
+	//
+	if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		websocket.Server{Handshake: s.websocketHandshake, Handler: func(conn *websocket.Conn) {
+			stop := make(chan struct{})
+			go func() {
+				var discard []byte
+				for websocket.Message.Receive(conn, &discard) == nil {
+				}
+				close(stop)
+			}()
+			s.runStream(v, t, owner, wsWriter{conn: conn}, stop)
+		}}.ServeHTTP(res, req)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		status = http.StatusInternalServerError
+		err = errors.New("Streaming is not supported")
+		return
+	}
+
+	h := res.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	stop := make(chan struct{})
+	go func() {
+		<-req.Context().Done()
+		close(stop)
+	}()
+	s.runStream(v, t, owner, sseWriter{res: res, flusher: flusher}, stop)
+}
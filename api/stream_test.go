@@ -0,0 +1,347 @@
+//
+// Test our live streams.
+//
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"golang.org/x/net/websocket"
+)
+
+//
+// changingServer returns a handler which answers with a single A-record,
+// whose address may be changed by calling the returned function.
+//
+func changingServer(t *testing.T, name string, address string) (dns.HandlerFunc, func(string)) {
+	var m sync.Mutex
+
+	handler := func(w dns.ResponseWriter, req *dns.Msg) {
+		m.Lock()
+		current := address
+		m.Unlock()
+
+		rr, err := dns.NewRR(name + " 0 IN A " + current)
+		if err != nil {
+			t.Errorf("Invalid test-record: %s", err)
+			return
+		}
+
+		reply := new(dns.Msg)
+		reply.SetReply(req)
+		reply.Authoritative = true
+		if req.Question[0].Qtype == dns.TypeA {
+			reply.Answer = append(reply.Answer, rr)
+		}
+		w.WriteMsg(reply)
+	}
+
+	change := func(a string) {
+		m.Lock()
+		address = a
+		m.Unlock()
+	}
+	return handler, change
+}
+
+//
+// useFastStreams makes the streams of the given server re-resolve quickly
+// for the duration of a test.
+//
+func useFastStreams(t *testing.T, srv *Server) {
+	oldMin, oldEmpty, oldKeepalive := minStreamInterval, streamEmptyInterval, streamKeepalive
+	minStreamInterval = 20 * time.Millisecond
+	streamEmptyInterval = 20 * time.Millisecond
+	streamKeepalive = 10 * time.Millisecond
+	t.Cleanup(func() {
+		minStreamInterval, streamEmptyInterval, streamKeepalive = oldMin, oldEmpty, oldKeepalive
+	})
+
+	//
+	// Our streams outlive their clients briefly, so wait for them to
+	// close before restoring the settings they use.
+	//
+	t.Cleanup(func() {
+		for i := 0; i < 500; i++ {
+			srv.streams.Lock()
+			open := len(srv.streams.open)
+			srv.streams.Unlock()
+			if open == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("Streams were left open")
+	})
+}
+
+//
+// Test that we re-resolve when the first record expires, within limits.
+//
+func TestStreamInterval(t *testing.T) {
+
+	tests := []struct {
+		ttls     []uint32
+		expected time.Duration
+	}{
+		{nil, streamEmptyInterval},
+		{[]uint32{300, 60, 3600}, time.Minute},
+		{[]uint32{0}, minStreamInterval},
+		{[]uint32{86400}, maxStreamInterval},
+	}
+
+	for _, test := range tests {
+		var records []WatchRecord
+		for _, ttl := range test.ttls {
+			records = append(records, WatchRecord{TTL: ttl})
+		}
+		if got := streamInterval(records); got != test.expected {
+			t.Errorf("TTLs %v gave %s, expected %s", test.ttls, got, test.expected)
+		}
+	}
+}
+
+//
+// Test that the number of streams each client may open is limited.
+//
+func TestStreamLimit(t *testing.T) {
+
+	srv := newTestServer(t, Options{})
+
+	for i := 0; i < maxStreamsPerClient; i++ {
+		if err := srv.acquireStream("192.0.2.1"); err != nil {
+			t.Fatalf("Stream %d was refused: %s", i, err)
+		}
+	}
+	if srv.acquireStream("192.0.2.1") != errTooManyStreams {
+		t.Errorf("Too many streams were allowed")
+	}
+	if srv.acquireStream("192.0.2.2") != nil {
+		t.Errorf("Another client was refused")
+	}
+
+	srv.releaseStream("192.0.2.1")
+	if srv.acquireStream("192.0.2.1") != nil {
+		t.Errorf("A released stream wasn't available")
+	}
+
+	//
+	// The server as a whole is limited too.
+	//
+	oldMax := maxStreams
+	maxStreams = maxStreamsPerClient + 2
+	defer func() { maxStreams = oldMax }()
+
+	if srv.acquireStream("192.0.2.3") != nil {
+		t.Errorf("A stream was refused before the server was full")
+	}
+	if srv.acquireStream("192.0.2.4") != errStreamsFull {
+		t.Errorf("Too many streams were allowed in total")
+	}
+
+	for i := 0; i < maxStreamsPerClient; i++ {
+		srv.releaseStream("192.0.2.1")
+	}
+	srv.releaseStream("192.0.2.2")
+	srv.releaseStream("192.0.2.3")
+	if len(srv.streams.open) != 0 || srv.streams.total != 0 {
+		t.Errorf("Streams weren't released: %v %d", srv.streams.open, srv.streams.total)
+	}
+}
+
+//
+// Test streaming changes as Server-Sent Events.
+//
+func TestStreamSSE(t *testing.T) {
+
+	handler, change := changingServer(t, "www.example.test.", "192.0.2.1")
+	srv := newTestServer(t, Options{}, dnstest.Start(t, handler))
+	useFastStreams(t, srv)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/stream/a/www.example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	//
+	// Read the events, ignoring our keepalives.
+	//
+	events := make(chan StreamEvent, 100)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var e StreamEvent
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e) == nil {
+				events <- e
+			}
+		}
+		close(events)
+	}()
+
+	next := func() StreamEvent {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("The stream was closed")
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for an event")
+		}
+		return StreamEvent{}
+	}
+
+	e := next()
+	if e.Type != "A" || len(e.Records) != 1 || e.Records[0].Value != "192.0.2.1" || len(e.Added) != 1 {
+		t.Fatalf("Unexpected first event: %v", e)
+	}
+
+	change("192.0.2.2")
+	e = next()
+	if len(e.Added) != 1 || e.Added[0].Value != "192.0.2.2" || len(e.Removed) != 1 || e.Removed[0].Value != "192.0.2.1" {
+		t.Errorf("Unexpected change: %v", e)
+	}
+}
+
+//
+// Test streaming changes over a WebSocket.
+//
+func TestStreamWebSocket(t *testing.T) {
+
+	handler, change := changingServer(t, "www.example.test.", "192.0.2.1")
+	srv := newTestServer(t, Options{}, dnstest.Start(t, handler))
+	useFastStreams(t, srv)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/stream/A/www.example.test"
+	conn, err := websocket.Dial(url, "", ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var e StreamEvent
+	err = websocket.JSON.Receive(conn, &e)
+	if err != nil {
+		t.Fatalf("Failed to read the first event: %s", err)
+	}
+	if len(e.Records) != 1 || e.Records[0].Value != "192.0.2.1" {
+		t.Fatalf("Unexpected first event: %v", e)
+	}
+
+	change("192.0.2.3")
+	err = websocket.JSON.Receive(conn, &e)
+	if err != nil {
+		t.Fatalf("Failed to read the change: %s", err)
+	}
+	if len(e.Added) != 1 || e.Added[0].Value != "192.0.2.3" {
+		t.Errorf("Unexpected change: %v", e)
+	}
+}
+
+//
+// Test that WebSockets may only be opened by the origins our CORS policy
+// allows, or by clients which don't send an origin.
+//
+func TestStreamWebSocketOrigin(t *testing.T) {
+
+	handler, _ := changingServer(t, "www.example.test.", "192.0.2.1")
+	config := &Config{CORS: CORSPolicy{Origins: []string{"https://app.example.com"}}}
+	srv := newTestServer(t, Options{Config: config}, dnstest.Start(t, handler))
+	useFastStreams(t, srv)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/stream/A/www.example.test"
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":  true,
+		"https://evil.example.com": false,
+	} {
+		conn, err := websocket.Dial(url, "", origin)
+		if allowed != (err == nil) {
+			t.Errorf("Unexpected result for origin '%s': %v", origin, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/stream/A/www.example.test", nil)
+	if err := srv.websocketHandshake(nil, req); err != nil {
+		t.Errorf("A client without an origin was refused: %s", err)
+	}
+}
+
+//
+// Test that bad requests are rejected before a stream is opened.
+//
+func TestStreamHandlerErrors(t *testing.T) {
+
+	srv := newTestServer(t, Options{})
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/stream/BOGUS/www.example.test", http.StatusNotFound},
+		{"/stream/A/" + strings.Repeat("a", 64) + ".test", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != test.status {
+			t.Errorf("%s gave status %d, expected %d", test.url, rr.Code, test.status)
+		}
+	}
+
+	//
+	// A client with too many streams is refused.
+	//
+	for i := 0; i < maxStreamsPerClient; i++ {
+		srv.acquireStream("192.0.2.1")
+	}
+	defer func() {
+		for i := 0; i < maxStreamsPerClient; i++ {
+			srv.releaseStream("192.0.2.1")
+		}
+	}()
+
+	req, err := http.NewRequest("GET", "/stream/A/www.example.test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status with too many streams: %d", rr.Code)
+	}
+}
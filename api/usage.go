@@ -0,0 +1,763 @@
+//
+// This file contains our usage accounting, which counts the requests of
+// each API key every day.  Callers without a key are counted by network,
+// a /24 for IPv4 and a /64 for IPv6, and once we've seen too many of those
+// in a day the rest are counted together, so that the number of clients we
+// track can't be inflated by anybody who can make requests from many
+// addresses.
+//
+// Requests are counted in memory and added to a store regularly, just as
+// our history is written out, and reported via our admin routes.
+//
+
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+//
+// usageDate is the format of the dates our usage is counted by, which
+// are in UTC.
+//
+const usageDate = "2006-01-02"
+
+//
+// usageRetention is how long our usage is kept for.
+//
+const usageRetention = 400 * 24 * time.Hour
+
+//
+// anonymousClient is the client the requests of callers without an API
+// key are counted under, once we've seen maxUsageNetworks of them in a
+// day.
+//
+const anonymousClient = "anonymous"
+
+//
+// maxUsageNetworks is the most networks whose callers, without an API key,
+// are counted separately each day.
+//
+var maxUsageNetworks = 1000
+
+//
+// maxUsageDays is the longest period a report may cover.
+//
+const maxUsageDays = 366
+
+// Usage counts the requests made by a client.
+type Usage struct {
+	// Date is the day the requests were made, it is empty for totals.
+	Date string `json:"date,omitempty"`
+
+	// Client is "key:" followed by the ID of an API key, "net:"
+	// followed by the network of callers without a key, or
+	// "anonymous" for those beyond the networks we count.
+	Client string `json:"client"`
+
+	// Name is the name of the key, if it still exists.
+	Name string `json:"name,omitempty"`
+
+	Requests int64 `json:"requests"`
+
+	// Routes counts the requests to each route, named as they are
+	// by our rate-limits.
+	Routes map[string]int64 `json:"routes"`
+
+	// Types counts the successful requests for each record-type.
+	Types map[string]int64 `json:"types,omitempty"`
+
+	// Statuses counts the status-codes of the responses.
+	Statuses map[string]int64 `json:"statuses"`
+}
+
+// UsageReport is our usage over a period of days.
+type UsageReport struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Usage has the counts of each client for each day, ordered by
+	// date and client.
+	Usage []Usage `json:"usage"`
+
+	// Totals has the counts of each client over the whole period.
+	Totals []Usage `json:"totals"`
+}
+
+//
+// newUsage returns an empty count for the given client.
+//
+func newUsage(date string, client string) *Usage {
+	return &Usage{
+		Date:     date,
+		Client:   client,
+		Routes:   make(map[string]int64),
+		Types:    make(map[string]int64),
+		Statuses: make(map[string]int64),
+	}
+}
+
+//
+// fields returns our counts, named as they are stored and as our CSV
+// columns are: "requests", "route:dns", "type:A" and "status:200".
+//
+func (u *Usage) fields() map[string]int64 {
+	out := map[string]int64{"requests": u.Requests}
+	for name, n := range u.Routes {
+		out["route:"+name] = n
+	}
+	for name, n := range u.Types {
+		out["type:"+name] = n
+	}
+	for name, n := range u.Statuses {
+		out["status:"+name] = n
+	}
+	return out
+}
+
+//
+// addField adds to the count with the given name, as returned by fields.
+//
+func (u *Usage) addField(field string, n int64) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) == 1 {
+		if parts[0] == "requests" {
+			u.Requests += n
+		}
+		return
+	}
+
+	switch parts[0] {
+	case "route":
+		u.Routes[parts[1]] += n
+	case "type":
+		u.Types[parts[1]] += n
+	case "status":
+		u.Statuses[parts[1]] += n
+	}
+}
+
+//
+// add adds the counts of another client to ours.
+//
+func (u *Usage) add(other Usage) {
+	for field, n := range other.fields() {
+		u.addField(field, n)
+	}
+}
+
+// UsageStore is somewhere our usage may be persisted.
+type UsageStore interface {
+
+	// Add adds the given counts to those stored.
+	Add(usage []Usage) error
+
+	// Load returns the counts of every client between the given
+	// dates, inclusive.
+	Load(from string, to string) ([]Usage, error)
+}
+
+//
+// fileUsageStore stores our usage in a JSON file.  If the path is empty
+// it is only held in memory.
+//
+type fileUsageStore struct {
+	sync.Mutex
+	path   string
+	loaded bool
+
+	// usage is indexed by date, then client.
+	usage map[string]map[string]*Usage
+}
+
+//
+// NewFileUsageStore creates a store which uses the given file.  If the
+// path is empty our usage is only held in memory.
+//
+func NewFileUsageStore(path string) UsageStore {
+	return &fileUsageStore{path: path, usage: make(map[string]map[string]*Usage)}
+}
+
+//
+// load reads the file, the first time we're used.
+//
+func (s *fileUsageStore) load() error {
+	if s.loaded || s.path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var usage []Usage
+		err = json.Unmarshal(data, &usage)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %s", s.path, err.Error())
+		}
+		s.add(usage)
+	}
+	s.loaded = true
+	return nil
+}
+
+//
+// add adds the given counts to those we hold.
+//
+func (s *fileUsageStore) add(usage []Usage) {
+	for _, u := range usage {
+		day, ok := s.usage[u.Date]
+		if !ok {
+			day = make(map[string]*Usage)
+			s.usage[u.Date] = day
+		}
+		c, ok := day[u.Client]
+		if !ok {
+			c = newUsage(u.Date, u.Client)
+			day[u.Client] = c
+		}
+		c.add(u)
+	}
+}
+
+func (s *fileUsageStore) Add(usage []Usage) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return err
+	}
+	s.add(usage)
+
+	//
+	// Forget the days we no longer keep.
+	//
+	expired := time.Now().UTC().Add(-usageRetention).Format(usageDate)
+	for date := range s.usage {
+		if date < expired {
+			delete(s.usage, date)
+		}
+	}
+	return s.write()
+}
+
+func (s *fileUsageStore) Load(from string, to string) ([]Usage, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Usage
+	for date, day := range s.usage {
+		if date < from || date > to {
+			continue
+		}
+		for _, u := range day {
+			c := newUsage(u.Date, u.Client)
+			c.add(*u)
+			out = append(out, *c)
+		}
+	}
+	return out, nil
+}
+
+//
+// write replaces the file with our current usage, via a temporary file
+// which is renamed into place.
+//
+func (s *fileUsageStore) write() error {
+	if s.path == "" {
+		return nil
+	}
+
+	usage := []Usage{}
+	for _, day := range s.usage {
+		for _, u := range day {
+			usage = append(usage, *u)
+		}
+	}
+	sortUsage(usage)
+
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".usage")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+//
+// redisUsageStore stores our usage in a redis hash for each day, whose
+// fields are the client and the name of a count, such as
+// "key:abcd route:dns".
+//
+type redisUsageStore struct {
+	client *redis.Ring
+	prefix string
+}
+
+//
+// NewRedisUsageStore creates a store which uses the given redis.
+//
+func NewRedisUsageStore(client *redis.Ring) UsageStore {
+	return &redisUsageStore{client: client, prefix: "dns-api:usage:"}
+}
+
+func (s *redisUsageStore) Add(usage []Usage) error {
+	pipe := s.client.Pipeline()
+	for _, u := range usage {
+		key := s.prefix + u.Date
+		for field, n := range u.fields() {
+			if n != 0 {
+				pipe.HIncrBy(key, u.Client+" "+field, n)
+			}
+		}
+		pipe.Expire(key, usageRetention)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+func (s *redisUsageStore) Load(from string, to string) ([]Usage, error) {
+	start, err := time.Parse(usageDate, from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse(usageDate, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Usage
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(usageDate)
+		entries, err := s.client.HGetAll(s.prefix + date).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		clients := make(map[string]*Usage)
+		for field, value := range entries {
+			parts := strings.SplitN(field, " ", 2)
+			n, err := strconv.ParseInt(value, 10, 64)
+			if len(parts) != 2 || err != nil {
+				continue
+			}
+			c, ok := clients[parts[0]]
+			if !ok {
+				c = newUsage(date, parts[0])
+				clients[parts[0]] = c
+			}
+			c.addField(parts[1], n)
+		}
+		for _, c := range clients {
+			out = append(out, *c)
+		}
+	}
+	return out, nil
+}
+
+//
+// usageRecorder counts requests in memory, until they're flushed to our
+// store.
+//
+type usageRecorder struct {
+	sync.Mutex
+	store UsageStore
+
+	// pending is indexed by date and client.
+	pending map[string]*Usage
+
+	// networks are those counted separately today, indexed by date.
+	networks map[string]map[string]bool
+}
+
+//
+// newUsageRecorder creates a recorder for the given store.
+//
+func newUsageRecorder(store UsageStore) *usageRecorder {
+	return &usageRecorder{store: store, pending: make(map[string]*Usage), networks: make(map[string]map[string]bool)}
+}
+
+//
+// Record counts a request made by the given client.  The record-type is
+// only counted for successful requests, so that callers can't fill our
+// store with bogus types.
+//
+// Networks beyond the first maxUsageNetworks seen each day are counted
+// as anonymous.
+//
+func (r *usageRecorder) Record(when time.Time, client string, route string, qtype string, status int) {
+	date := when.UTC().Format(usageDate)
+
+	r.Lock()
+	defer r.Unlock()
+
+	if strings.HasPrefix(client, "net:") {
+		seen, ok := r.networks[date]
+		if !ok {
+			r.networks = map[string]map[string]bool{date: {}}
+			seen = r.networks[date]
+		}
+		if !seen[client] && len(seen) >= maxUsageNetworks {
+			client = anonymousClient
+		} else {
+			seen[client] = true
+		}
+	}
+
+	u, ok := r.pending[date+" "+client]
+	if !ok {
+		u = newUsage(date, client)
+		r.pending[date+" "+client] = u
+	}
+	u.Requests++
+	if route != "" {
+		u.Routes[route]++
+	}
+	u.Statuses[strconv.Itoa(status)]++
+	if qtype != "" && status < 400 {
+		u.Types[strings.ToUpper(qtype)]++
+	}
+}
+
+//
+// Flush adds our pending counts to our store.  If that fails they're
+// kept, to be added next time.
+//
+func (r *usageRecorder) Flush() error {
+	r.Lock()
+	pending := r.pending
+	r.pending = make(map[string]*Usage)
+	r.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var usage []Usage
+	for _, u := range pending {
+		usage = append(usage, *u)
+	}
+	err := r.store.Add(usage)
+	if err != nil {
+		r.Lock()
+		for key, u := range pending {
+			if c, ok := r.pending[key]; ok {
+				u.add(*c)
+			}
+			r.pending[key] = u
+		}
+		r.Unlock()
+	}
+	return err
+}
+
+//
+// usageWriter wraps a ResponseWriter, to record the status-code of the
+// response.
+//
+type usageWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *usageWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *usageWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+//
+// Flush allows our streams to be flushed.
+//
+func (w *usageWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//
+// Hijack allows our streams to use websockets.
+//
+func (w *usageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+//
+// account is a middleware which counts each request routed to one of our
+// handlers, other than our admin routes.
+//
+func (s *Server) account(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		route := currentRouteName(req)
+		if route == "admin" {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		start := time.Now()
+		w := &usageWriter{ResponseWriter: res}
+		next.ServeHTTP(w, req)
+
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		client := s.clientID(req)
+		if !strings.HasPrefix(client, "key:") {
+			client = usageNetwork(client)
+		}
+		s.usage.Record(start, client, route, mux.Vars(req)["type"], w.status)
+	})
+}
+
+//
+// usageNetwork returns the client the requests from the given address are
+// counted under, which is its /24 for IPv4 and its /64 for IPv6.
+//
+func usageNetwork(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return anonymousClient
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return "net:" + (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return "net:" + (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+//
+// FlushUsage adds the requests we've counted to our usage store.
+//
+func (s *Server) FlushUsage() error {
+	return s.usage.Flush()
+}
+
+//
+// sortUsage orders counts by date, then client.
+//
+func sortUsage(usage []Usage) {
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Date != usage[j].Date {
+			return usage[i].Date < usage[j].Date
+		}
+		return usage[i].Client < usage[j].Client
+	})
+}
+
+//
+// UsageReport returns our usage between the given dates, inclusive,
+// optionally only for a single client.
+//
+func (s *Server) UsageReport(from string, to string, client string) (*UsageReport, error) {
+	err := s.FlushUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := s.usage.store.Load(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UsageReport{From: from, To: to, Usage: []Usage{}, Totals: []Usage{}}
+	totals := make(map[string]*Usage)
+	for _, u := range usage {
+		if client != "" && u.Client != client {
+			continue
+		}
+		if strings.HasPrefix(u.Client, "key:") {
+			k, ok := s.keys.Get(strings.TrimPrefix(u.Client, "key:"))
+			if ok {
+				u.Name = k.Name
+			}
+		}
+		report.Usage = append(report.Usage, u)
+
+		t, ok := totals[u.Client]
+		if !ok {
+			t = newUsage("", u.Client)
+			t.Name = u.Name
+			totals[u.Client] = t
+		}
+		t.add(u)
+	}
+	for _, t := range totals {
+		report.Totals = append(report.Totals, *t)
+	}
+	sortUsage(report.Usage)
+	sortUsage(report.Totals)
+	return report, nil
+}
+
+//
+// writeUsageCSV writes the daily counts of a report as CSV, with a
+// header-row.  After the date, client, name and number of requests are
+// a column for each route, record-type and status-code.
+//
+func writeUsageCSV(w io.Writer, report *UsageReport) error {
+	var extra []string
+	seen := make(map[string]bool)
+	for _, u := range report.Usage {
+		for field := range u.fields() {
+			if field != "requests" && !seen[field] {
+				seen[field] = true
+				extra = append(extra, field)
+			}
+		}
+	}
+	sort.Strings(extra)
+
+	c := csv.NewWriter(w)
+	c.Write(append([]string{"date", "client", "name", "requests"}, extra...))
+	for _, u := range report.Usage {
+		fields := u.fields()
+		row := []string{u.Date, u.Client, u.Name, strconv.FormatInt(u.Requests, 10)}
+		for _, field := range extra {
+			row = append(row, strconv.FormatInt(fields[field], 10))
+		}
+		c.Write(row)
+	}
+	c.Flush()
+	return c.Error()
+}
+
+//
+// UsageHandler is the handler for reporting our usage.
+//
+// It is called via requests like this:
+//
+//     GET /admin/usage
+//     GET /admin/usage?from=2024-01-01&to=2024-01-31
+//     GET /admin/usage?client=key:4f1c2a9e07d3b815&format=csv
+//
+// The period defaults to the current month, so far.
+//
+func (s *Server) UsageHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if !s.adminAuthenticated(req) {
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		status = http.StatusUnauthorized
+		err = errors.New("Admin routes require authentication")
+		return
+	}
+
+	query := req.URL.Query()
+
+	to := time.Now().UTC()
+	if str := query.Get("to"); str != "" {
+		to, err = time.Parse(usageDate, str)
+		if err != nil {
+			status = http.StatusBadRequest
+			err = fmt.Errorf("Invalid date '%s' - use YYYY-MM-DD", str)
+			return
+		}
+	}
+	from := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if str := query.Get("from"); str != "" {
+		from, err = time.Parse(usageDate, str)
+		if err != nil {
+			status = http.StatusBadRequest
+			err = fmt.Errorf("Invalid date '%s' - use YYYY-MM-DD", str)
+			return
+		}
+	}
+	if from.After(to) {
+		status = http.StatusBadRequest
+		err = errors.New("The start of the period must not be after its end")
+		return
+	}
+	if to.Sub(from) >= maxUsageDays*24*time.Hour {
+		status = http.StatusBadRequest
+		err = fmt.Errorf("Reports may not cover more than %d days", maxUsageDays)
+		return
+	}
+
+	csvFormat := false
+	switch strings.ToLower(query.Get("format")) {
+	case "csv":
+		csvFormat = true
+	case "json":
+	case "":
+		types := acceptedTypes(req.Header.Get("Accept"))
+		csvFormat = len(types) > 0 && types[0] == "text/csv"
+	default:
+		status = http.StatusBadRequest
+		err = fmt.Errorf("Invalid format '%s' - use json|csv", query.Get("format"))
+		return
+	}
+
+	var report *UsageReport
+	report, err = s.UsageReport(from.Format(usageDate), to.Format(usageDate), query.Get("client"))
+	if err != nil {
+		status = http.StatusInternalServerError
+		return
+	}
+
+	if !csvFormat {
+		sendJSON(res, report)
+		return
+	}
+	res.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"usage-%s-%s.csv\"", report.From, report.To))
+	writeUsageCSV(res, report)
+}
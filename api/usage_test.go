@@ -0,0 +1,297 @@
+//
+// Test our usage accounting.
+//
+
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// Test that requests are counted by client, route, type and status.
+//
+func TestUsageReport(t *testing.T) {
+	srv := newKeyServer(t, nil)
+
+	var k APIKey
+	admin(t, srv, "POST", "/admin/keys", `{"name": "billing", "tier": "internal"}`, &k)
+
+	for _, path := range []string{"/A/example.test", "/a/example.test", "/FOO/example.test", "/wildcard/example.test"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", k.Key)
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	keyLookup(srv, "")
+	keyLookup(srv, "dak_bogus")
+
+	//
+	// Callers without keys are counted by network.
+	//
+	req := httptest.NewRequest("GET", "/A/example.test", nil)
+	req.RemoteAddr = "192.0.2.200:1234"
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	var report UsageReport
+	code := admin(t, srv, "GET", "/admin/usage", "", &report)
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected status-code %d", code)
+	}
+
+	today := time.Now().UTC().Format(usageDate)
+	if report.To != today || report.From != today[:8]+"01" {
+		t.Errorf("Unexpected period %s - %s", report.From, report.To)
+	}
+	if len(report.Usage) != 2 || len(report.Totals) != 2 {
+		t.Fatalf("Unexpected usage %v", report)
+	}
+
+	//
+	// Keys sort before networks, and the admin routes aren't counted.
+	//
+	anon := report.Usage[1]
+	if anon.Client != "net:192.0.2.0/24" || anon.Date != today || anon.Requests != 3 || anon.Statuses["200"] != 2 || anon.Statuses["401"] != 1 {
+		t.Errorf("Unexpected anonymous usage %v", anon)
+	}
+
+	u := report.Usage[0]
+	if u.Client != "key:"+k.ID || u.Name != "billing" || u.Requests != 4 {
+		t.Errorf("Unexpected usage %v", u)
+	}
+	if u.Routes["dns"] != 3 || u.Routes["wildcard"] != 1 || u.Statuses["200"] != 3 || u.Statuses["404"] != 1 {
+		t.Errorf("Unexpected counts %v %v", u.Routes, u.Statuses)
+	}
+	if len(u.Types) != 1 || u.Types["A"] != 2 {
+		t.Errorf("Only successful types should be counted %v", u.Types)
+	}
+
+	total := report.Totals[0]
+	if total.Date != "" || total.Client != u.Client || total.Name != "billing" || total.Requests != 4 {
+		t.Errorf("Unexpected totals %v", total)
+	}
+
+	//
+	// Reports may be for a single client, and earlier periods.
+	//
+	report = UsageReport{}
+	admin(t, srv, "GET", "/admin/usage?client=key:"+k.ID, "", &report)
+	if len(report.Usage) != 1 || report.Usage[0].Client != "key:"+k.ID {
+		t.Errorf("Unexpected usage %v", report.Usage)
+	}
+
+	report = UsageReport{}
+	admin(t, srv, "GET", "/admin/usage?from=2020-01-01&to=2020-01-31", "", &report)
+	if report.From != "2020-01-01" || report.To != "2020-01-31" || len(report.Usage) != 0 {
+		t.Errorf("Unexpected usage %v", report)
+	}
+}
+
+//
+// Test that callers without a key are counted by network, until we've
+// seen too many networks in a day.
+//
+func TestUsageNetworks(t *testing.T) {
+	old := maxUsageNetworks
+	maxUsageNetworks = 2
+	defer func() { maxUsageNetworks = old }()
+
+	srv := newKeyServer(t, nil)
+	for _, addr := range []string{"192.0.2.1", "[2001:db8:1:2::7]", "[2001:db8:1:2:ffff::1]", "198.51.100.7", "192.0.2.99", "203.0.113.1"} {
+		req := httptest.NewRequest("GET", "/A/example.test", nil)
+		req.RemoteAddr = addr + ":1234"
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var report UsageReport
+	admin(t, srv, "GET", "/admin/usage", "", &report)
+
+	expected := map[string]int64{
+		"anonymous":             2,
+		"net:192.0.2.0/24":      2,
+		"net:2001:db8:1:2::/64": 2,
+	}
+	if len(report.Usage) != len(expected) {
+		t.Fatalf("Unexpected usage %v", report.Usage)
+	}
+	for _, u := range report.Usage {
+		if expected[u.Client] != u.Requests {
+			t.Errorf("Unexpected usage %v", u)
+		}
+	}
+}
+
+//
+// Test that reports may be exported as CSV.
+//
+func TestUsageCSV(t *testing.T) {
+	srv := newKeyServer(t, nil)
+	keyLookup(srv, "")
+	keyLookup(srv, "")
+
+	from := time.Now().UTC().AddDate(0, 0, -40).Format(usageDate)
+	for _, accept := range []string{"", "text/csv"} {
+		path := "/admin/usage?from=" + from
+		if accept == "" {
+			path += "&format=csv"
+		}
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+			t.Fatalf("Unexpected response %d %v", rr.Code, rr.Header())
+		}
+		if !strings.Contains(rr.Header().Get("Content-Disposition"), "usage-"+from+"-") {
+			t.Errorf("Unexpected disposition %s", rr.Header().Get("Content-Disposition"))
+		}
+
+		rows, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Failed to parse CSV: %s", err)
+		}
+		expected := [][]string{
+			{"date", "client", "name", "requests", "route:dns", "status:200", "type:A"},
+			{time.Now().UTC().Format(usageDate), "net:192.0.2.0/24", "", "2", "2", "2", "2"},
+		}
+		if len(rows) != len(expected) || strings.Join(rows[0], ",") != strings.Join(expected[0], ",") || strings.Join(rows[1], ",") != strings.Join(expected[1], ",") {
+			t.Errorf("Unexpected CSV %v", rows)
+		}
+	}
+}
+
+//
+// Test that bogus reports are refused.
+//
+func TestUsageErrors(t *testing.T) {
+	srv := newKeyServer(t, nil)
+
+	tests := map[string]string{
+		"/admin/usage?from=yesterday":                   "Invalid date 'yesterday'",
+		"/admin/usage?to=2024-13-01":                    "Invalid date '2024-13-01'",
+		"/admin/usage?from=2024-02-01&to=2024-01-01":    "must not be after its end",
+		"/admin/usage?from=2023-01-01&to=2024-01-02":    "more than 366 days",
+		"/admin/usage?format=yaml":                      "Invalid format 'yaml'",
+		"/admin/usage?from=2023-01-01&to=2024-01-01&x=": "",
+	}
+	for path, expected := range tests {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if expected == "" {
+			if rr.Code != http.StatusOK {
+				t.Errorf("Expected %s to succeed, got %d", path, rr.Code)
+			}
+			continue
+		}
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected '%s' for %s, got %d %s", expected, path, rr.Code, rr.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/admin/usage", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the report to require authentication, got %d", rr.Code)
+	}
+}
+
+//
+// Test that our file store persists, and expires, our usage.
+//
+func TestUsageFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	old := time.Now().UTC().Add(-usageRetention - 48*time.Hour).Format(usageDate)
+
+	//
+	// Dates are in UTC, so the second of these is counted the next day.
+	//
+	y, m, d := time.Now().AddDate(0, 0, -10).Date()
+	first := time.Date(y, m, d, 23, 0, 0, 0, time.UTC)
+	second := time.Date(y, m, d, 23, 0, 0, 0, time.FixedZone("", -3600))
+
+	r := newUsageRecorder(NewFileUsageStore(path))
+	r.Record(first, "192.0.2.1", "dns", "mx", 200)
+	r.Record(second, "192.0.2.1", "dns", "a", 200)
+	r.Record(time.Now(), "key:abcd", "propagation", "A", 429)
+	err := r.Flush()
+	if err != nil {
+		t.Fatalf("Failed to flush: %s", err)
+	}
+
+	store := NewFileUsageStore(path)
+	err = store.Add([]Usage{{Date: old, Client: "192.0.2.9", Requests: 1}})
+	if err != nil {
+		t.Fatalf("Failed to add: %s", err)
+	}
+
+	usage, err := store.Load(first.Format(usageDate), second.UTC().Format(usageDate))
+	if err != nil {
+		t.Fatalf("Failed to load: %s", err)
+	}
+	sortUsage(usage)
+	if len(usage) != 2 || usage[0].Date != first.Format(usageDate) || usage[0].Types["MX"] != 1 || usage[1].Date != second.UTC().Format(usageDate) || usage[1].Types["A"] != 1 {
+		t.Errorf("Unexpected usage %v", usage)
+	}
+
+	usage, err = store.Load(old, old)
+	if err != nil || len(usage) != 0 {
+		t.Errorf("Old usage should have been forgotten %v %v", usage, err)
+	}
+
+	today := time.Now().UTC().Format(usageDate)
+	usage, err = store.Load(today, today)
+	if err != nil || len(usage) != 1 || usage[0].Statuses["429"] != 1 || len(usage[0].Types) != 0 {
+		t.Errorf("Unexpected usage %v %v", usage, err)
+	}
+}
+
+//
+// failingUsageStore is a store which can't be written to.
+//
+type failingUsageStore struct {
+	UsageStore
+}
+
+func (s *failingUsageStore) Add(usage []Usage) error {
+	return errors.New("the store is unavailable")
+}
+
+//
+// Test that our counts are kept if they can't be stored.
+//
+func TestUsageFlushFailure(t *testing.T) {
+	store := &failingUsageStore{NewFileUsageStore("")}
+	r := newUsageRecorder(store)
+
+	now := time.Now()
+	r.Record(now, "192.0.2.1", "dns", "A", 200)
+	if r.Flush() == nil {
+		t.Fatalf("Expected the flush to fail")
+	}
+	r.Record(now, "192.0.2.1", "dns", "A", 200)
+
+	store.UsageStore = NewFileUsageStore("")
+	r.store = store.UsageStore
+	err := r.Flush()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	date := now.UTC().Format(usageDate)
+	usage, _ := r.store.Load(date, date)
+	if len(usage) != 1 || usage[0].Requests != 2 {
+		t.Errorf("Unexpected usage %v", usage)
+	}
+}
@@ -0,0 +1,882 @@
+//
+// This file contains our watches, which periodically resolve a name and
+// notify a webhook when its records change.
+//
+// Each watch runs in its own goroutine, and is persisted to a store so
+// that it survives restarts.  The notification is a JSON document which
+// describes the records which were added, removed or had their TTL
+// changed, signed with a secret which is returned when the watch is
+// created.
+//
+
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// minWatchInterval is the shortest interval a watch may be checked at.
+//
+var minWatchInterval = time.Minute
+
+//
+// maxWatchesPerClient is the number of watches each client may create.
+//
+const maxWatchesPerClient = 20
+
+//
+// errTooManyWatches is returned when a client has created as many watches
+// as they may.
+//
+var errTooManyWatches = fmt.Errorf("No more than %d watches may be created", maxWatchesPerClient)
+
+//
+// maxWatches is the number of watches which may exist, from all clients
+// together, so that many clients can't exhaust our memory or file-space.
+//
+var maxWatches = 10000
+
+//
+// errWatchesFull is returned when no more watches may be created by anybody.
+//
+var errWatchesFull = fmt.Errorf("No more watches may be created at the moment")
+
+//
+// watchSignatureHeader is the header which holds the signature of each
+// notification.
+//
+const watchSignatureHeader = "X-DNS-API-Signature"
+
+// Watch is a name & type which is periodically resolved, with changes
+// sent to a webhook.
+type Watch struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Interval string `json:"interval"`
+	Webhook  string `json:"webhook"`
+
+	// Secret is the key used to sign our notifications.  It is only
+	// shown to the caller when the watch is created.
+	Secret string `json:"secret,omitempty"`
+
+	// Owner is the address, or API key, of the client which created
+	// the watch, whose rate-limit our lookups count against.
+	Owner string `json:"owner"`
+
+	Created     time.Time `json:"created"`
+	LastChecked time.Time `json:"last_checked"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	// Records holds the answer seen when we last checked.
+	Records []WatchRecord `json:"records"`
+}
+
+// WatchRecord is a single record seen by a watch.
+type WatchRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
+
+	// MaxTTL is the highest TTL we've seen for this record, which
+	// is the closest we can get to the TTL the zone really has.
+	MaxTTL uint32 `json:"max_ttl"`
+}
+
+// WatchChange is a record whose TTL has changed.
+type WatchChange struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	OldTTL uint32 `json:"old_ttl"`
+	NewTTL uint32 `json:"new_ttl"`
+}
+
+// WatchEvent is the notification we send to the webhook of a watch.
+type WatchEvent struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Time    time.Time     `json:"time"`
+	Added   []WatchRecord `json:"added"`
+	Removed []WatchRecord `json:"removed"`
+	Changed []WatchChange `json:"changed"`
+}
+
+//
+// key returns the identity of a record, ignoring its TTL.
+//
+func (r WatchRecord) key() string {
+	return r.Name + "\t" + r.Type + "\t" + r.Value
+}
+
+// WatchStore is somewhere watches may be persisted.
+type WatchStore interface {
+
+	// Load returns all the stored watches.
+	Load() ([]Watch, error)
+
+	// Save stores, or updates, a watch.
+	Save(w Watch) error
+
+	// Delete removes a watch.
+	Delete(id string) error
+}
+
+//
+// fileWatchStore stores watches in a JSON file.  If the path is empty
+// the watches are only held in memory.
+//
+type fileWatchStore struct {
+	sync.Mutex
+	path    string
+	watches map[string]Watch
+}
+
+//
+// NewFileWatchStore creates a store which uses the given file.  If the
+// path is empty the watches are only held in memory.
+//
+func NewFileWatchStore(path string) WatchStore {
+	return &fileWatchStore{path: path, watches: make(map[string]Watch)}
+}
+
+func (s *fileWatchStore) Load() ([]Watch, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.path != "" {
+		data, err := ioutil.ReadFile(s.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &s.watches)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %s", s.path, err.Error())
+			}
+		}
+	}
+
+	var out []Watch
+	for _, w := range s.watches {
+		out = append(out, w)
+	}
+	return out, nil
+}
+
+func (s *fileWatchStore) Save(w Watch) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.watches[w.ID] = w
+	return s.write()
+}
+
+func (s *fileWatchStore) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.watches, id)
+	return s.write()
+}
+
+//
+// write replaces the file with the current watches.  A temporary file is
+// renamed into place so that a crash never leaves it half-written.
+//
+func (s *fileWatchStore) write() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.watches, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".watches")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+//
+// redisWatchStore stores watches in a redis hash.
+//
+type redisWatchStore struct {
+	client *redis.Ring
+	key    string
+}
+
+//
+// NewRedisWatchStore creates a store which uses the given redis.
+//
+// Only a single server may use the store, as every server which loads it
+// runs every watch, and each would deliver its notifications.  Watches
+// deleted via one server would also keep running upon the others until
+// they restart.
+//
+func NewRedisWatchStore(client *redis.Ring) WatchStore {
+	return &redisWatchStore{client: client, key: "dns-api:watches"}
+}
+
+func (s *redisWatchStore) Load() ([]Watch, error) {
+	entries, err := s.client.HGetAll(s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Watch
+	for id, data := range entries {
+		var w Watch
+		err = json.Unmarshal([]byte(data), &w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse watch %s: %s", id, err.Error())
+		}
+		out = append(out, w)
+	}
+	return out, nil
+}
+
+func (s *redisWatchStore) Save(w Watch) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(s.key, w.ID, data).Err()
+}
+
+func (s *redisWatchStore) Delete(id string) error {
+	return s.client.HDel(s.key, id).Err()
+}
+
+//
+// watchManager holds the running watches.
+//
+type watchManager struct {
+	sync.Mutex
+	server  *Server
+	store   WatchStore
+	running map[string]*runningWatch
+
+	// reserved counts the watches of each owner which are being
+	// created, and are not yet running.
+	reserved map[string]int
+}
+
+//
+// runningWatch is a watch, and the channel which stops it.
+//
+type runningWatch struct {
+	watch Watch
+	stop  chan struct{}
+}
+
+//
+// newWatchManager creates a manager for the watches of the given server,
+// which persists them to the given store.
+//
+func newWatchManager(server *Server, store WatchStore) *watchManager {
+	return &watchManager{
+		server:   server,
+		store:    store,
+		running:  make(map[string]*runningWatch),
+		reserved: make(map[string]int),
+	}
+}
+
+//
+// Start loads the stored watches, and starts them all.
+//
+func (m *watchManager) Start() error {
+	list, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	for _, w := range list {
+		m.start(w)
+	}
+	return nil
+}
+
+//
+// Stop stops all the running watches.
+//
+func (m *watchManager) Stop() {
+	m.Lock()
+	defer m.Unlock()
+
+	for id, r := range m.running {
+		close(r.stop)
+		delete(m.running, id)
+	}
+}
+
+//
+// start launches the goroutine of a watch.  The lock must be held.
+//
+func (m *watchManager) start(w Watch) {
+	interval, _ := time.ParseDuration(w.Interval)
+	if interval < minWatchInterval {
+		interval = minWatchInterval
+	}
+
+	r := &runningWatch{watch: w, stop: make(chan struct{})}
+	m.running[w.ID] = r
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				m.check(r)
+			}
+		}
+	}()
+}
+
+//
+// Add creates a new watch, resolving it to find the records we'll
+// compare against in the future.
+//
+func (m *watchManager) Add(w Watch) (Watch, error) {
+
+	err := m.reserve(w.Owner)
+	if err != nil {
+		return w, err
+	}
+	defer m.release(w.Owner)
+
+	id := make([]byte, 8)
+	secret := make([]byte, 16)
+	rand.Read(id)
+	rand.Read(secret)
+
+	w.ID = hex.EncodeToString(id)
+	w.Secret = hex.EncodeToString(secret)
+	w.Created = time.Now().UTC()
+	w.LastChecked = w.Created
+	w.Records = []WatchRecord{}
+
+	records, err := m.server.resolveWatch(w.Name, w.Type)
+	if err != nil {
+		w.LastError = err.Error()
+	} else {
+		w.Records = records
+	}
+
+	err = m.store.Save(w)
+	if err != nil {
+		return w, err
+	}
+
+	//
+	// The watch is running before our reservation is released, so that
+	// it is always counted.
+	//
+	m.Lock()
+	m.start(w)
+	m.Unlock()
+	return w, nil
+}
+
+//
+// reserve claims a place for a new watch of the given owner, if they, and
+// the server, have room for one.  The place is counted until release is
+// called, so that concurrent requests can't exceed our limits while their
+// watches are being resolved.
+//
+func (m *watchManager) reserve(owner string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	count := m.reserved[owner]
+	total := len(m.running)
+	for _, n := range m.reserved {
+		total += n
+	}
+	for _, r := range m.running {
+		if r.watch.Owner == owner {
+			count++
+		}
+	}
+
+	if count >= maxWatchesPerClient {
+		return errTooManyWatches
+	}
+	if total >= maxWatches {
+		return errWatchesFull
+	}
+	m.reserved[owner]++
+	return nil
+}
+
+//
+// release gives up a place claimed by reserve.
+//
+func (m *watchManager) release(owner string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.reserved[owner]--
+	if m.reserved[owner] <= 0 {
+		delete(m.reserved, owner)
+	}
+}
+
+//
+// Remove stops and deletes the given watch, if it belongs to the owner.
+//
+func (m *watchManager) Remove(id string, owner string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	r, ok := m.running[id]
+	if !ok || r.watch.Owner != owner {
+		return false, nil
+	}
+	close(r.stop)
+	delete(m.running, id)
+	return true, m.store.Delete(id)
+}
+
+//
+// List returns the watches belonging to the given owner, without their
+// secrets.
+//
+func (m *watchManager) List(owner string) []Watch {
+	m.Lock()
+	defer m.Unlock()
+
+	out := []Watch{}
+	for _, r := range m.running {
+		if r.watch.Owner == owner {
+			w := r.watch
+			w.Secret = ""
+			out = append(out, w)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+//
+// check resolves a watch, and notifies its webhook of any changes.
+//
+func (m *watchManager) check(r *runningWatch) {
+
+	m.Lock()
+	w := r.watch
+	m.Unlock()
+
+	//
+	// Our lookups count against the rate-limit of the client who
+	// created the watch, just as if they'd made them themselves.
+	//
+	if !m.server.allowBackground(w.Owner) {
+		return
+	}
+
+	now := time.Now().UTC()
+	records, err := m.server.resolveWatch(w.Name, w.Type)
+
+	var event *WatchEvent
+	if err != nil {
+		w.LastError = err.Error()
+	} else {
+		w.LastError = ""
+		event = diffRecords(w.Records, records, now.Sub(w.LastChecked))
+		w.Records = records
+	}
+	w.LastChecked = now
+
+	if event != nil {
+		event.ID = w.ID
+		event.Name = w.Name
+		event.Type = w.Type
+		event.Time = now
+		err = m.server.sendWebhook(w, event)
+		if err != nil {
+			w.LastError = err.Error()
+		}
+	}
+
+	//
+	// The watch might have been deleted while we were busy.
+	//
+	m.Lock()
+	if _, ok := m.running[w.ID]; !ok {
+		m.Unlock()
+		return
+	}
+	r.watch = w
+	m.Unlock()
+
+	err = m.store.Save(w)
+	if err != nil {
+		fmt.Printf("Failed to save watch %s: %s\n", w.ID, err.Error())
+	}
+}
+
+//
+// resolveWatch looks up the records of a watch.  A name which doesn't
+// exist has no records, rather than being an error.
+//
+func (s *Server) resolveWatch(name string, qtype string) ([]WatchRecord, error) {
+
+	results, err := s.resolver.Lookup(name, qtype, resolver.DefaultQueryOptions())
+	if err != nil {
+		if _, ok := err.(resolver.NoSuchDomain); !ok {
+			return nil, err
+		}
+	}
+
+	out := []WatchRecord{}
+	for _, result := range results {
+		ttl, _ := strconv.ParseUint(result["ttl"], 10, 32)
+		out = append(out, WatchRecord{
+			Name:   result["name"],
+			Type:   result["type"],
+			Value:  result["value"],
+			TTL:    uint32(ttl),
+			MaxTTL: uint32(ttl),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key() < out[j].key() })
+	return out, nil
+}
+
+//
+// diffRecords compares the records seen previously with those seen now,
+// returning an event if anything changed.  The MaxTTL of the current
+// records is updated from the previous ones.
+//
+// Because our resolvers count TTLs down as they cache records we only
+// report a TTL change when that can't explain it: when the TTL is higher
+// than any we've seen before, or has dropped by more than the time which
+// has passed since we last looked.
+//
+func diffRecords(old []WatchRecord, current []WatchRecord, elapsed time.Duration) *WatchEvent {
+
+	event := &WatchEvent{
+		Added:   []WatchRecord{},
+		Removed: []WatchRecord{},
+		Changed: []WatchChange{},
+	}
+
+	previous := make(map[string]WatchRecord)
+	for _, r := range old {
+		previous[r.key()] = r
+	}
+	seen := make(map[string]bool)
+
+	for i, r := range current {
+		seen[r.key()] = true
+
+		p, ok := previous[r.key()]
+		if !ok {
+			event.Added = append(event.Added, r)
+			continue
+		}
+
+		current[i].MaxTTL = p.MaxTTL
+		if r.TTL > p.MaxTTL {
+			current[i].MaxTTL = r.TTL
+		}
+
+		slack := uint32(elapsed/time.Second) + 2
+		lowered := p.TTL > slack && r.TTL < p.TTL-slack
+		if r.TTL > p.MaxTTL || lowered {
+			event.Changed = append(event.Changed, WatchChange{Name: r.Name, Type: r.Type, Value: r.Value, OldTTL: p.MaxTTL, NewTTL: r.TTL})
+			current[i].MaxTTL = r.TTL
+		}
+	}
+
+	for _, r := range old {
+		if !seen[r.key()] {
+			event.Removed = append(event.Removed, r)
+		}
+	}
+
+	if len(event.Added) == 0 && len(event.Removed) == 0 && len(event.Changed) == 0 {
+		return nil
+	}
+	return event
+}
+
+//
+// newWebhookClient creates the client we deliver notifications with.
+// Every address it connects to is checked against the given policy, which
+// prevents names from being re-resolved to internal addresses.
+//
+func newWebhookClient(policy *resolver.NetworkPolicy) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: func(network, address string, c syscall.RawConn) error {
+					host, _, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+					return policy.AllowedWebhook(net.ParseIP(host))
+				},
+			}).DialContext,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+//
+// signWebhook returns the signature of a notification.
+//
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//
+// sendWebhook POSTs a signed event to the webhook of a watch.
+//
+func (s *Server) sendWebhook(w Watch, event *WatchEvent) error {
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dns-api-go/"+s.version)
+	req.Header.Set(watchSignatureHeader, signWebhook(w.Secret, body))
+
+	res, err := s.webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to notify %s: %s", w.Webhook, err.Error())
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Failed to notify %s: %s", w.Webhook, res.Status)
+	}
+	return nil
+}
+
+//
+// validateWatch checks a watch the caller wishes to create.
+//
+func (s *Server) validateWatch(w *Watch) (int, error) {
+
+	if _, ok := dns.IsDomainName(w.Name); !ok || w.Name == "" {
+		return http.StatusBadRequest, errors.New("Invalid name '" + w.Name + "'")
+	}
+	w.Type = strings.ToUpper(w.Type)
+	if _, ok := resolver.StringToType[w.Type]; !ok {
+		return http.StatusBadRequest, errors.New("Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT")
+	}
+
+	if w.Interval == "" {
+		w.Interval = "1h"
+	}
+	interval, err := time.ParseDuration(w.Interval)
+	if err != nil || interval < minWatchInterval {
+		return http.StatusBadRequest, fmt.Errorf("The interval must be a duration of at least %s", minWatchInterval)
+	}
+
+	u, err := url.Parse(w.Webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return http.StatusBadRequest, errors.New("The webhook must be an http or https URL")
+	}
+
+	//
+	// Hostnames are checked when we connect to them, but we can
+	// reject addresses now.
+	//
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		err = s.config.Webhooks.AllowedWebhook(ip)
+		if err != nil {
+			return http.StatusForbidden, err
+		}
+	}
+	return 0, nil
+}
+
+//
+// WatchesHandler is the handler for listing, and creating, watches.
+//
+// It is called via requests like this:
+//
+//     GET  /watches
+//     POST /watches  {"name": "example.com", "type": "A", "interval": "5m", "webhook": "https://..."}
+//
+func (s *Server) WatchesHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	owner := s.clientID(req)
+
+	if req.Method == "GET" {
+		sendJSON(res, s.watches.List(owner))
+		return
+	}
+
+	var w Watch
+	err = json.NewDecoder(http.MaxBytesReader(res, req.Body, 4096)).Decode(&w)
+	if err != nil {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid JSON: " + err.Error())
+		return
+	}
+
+	watch := Watch{
+		Name:     dns.Fqdn(w.Name),
+		Type:     w.Type,
+		Interval: w.Interval,
+		Webhook:  w.Webhook,
+		Owner:    owner,
+	}
+	status, err = s.validateWatch(&watch)
+	if err != nil {
+		return
+	}
+
+	watch, err = s.watches.Add(watch)
+	if err != nil {
+		status = http.StatusInternalServerError
+		if err == errTooManyWatches {
+			status = http.StatusTooManyRequests
+		}
+		if err == errWatchesFull {
+			status = http.StatusServiceUnavailable
+		}
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["watches.created"]++
+	s.mutex.Unlock()
+
+	sendJSONStatus(res, http.StatusCreated, watch)
+}
+
+//
+// WatchHandler is the handler for viewing, and deleting, a single watch.
+//
+// It is called via requests like this:
+//
+//     GET    /watches/$ID
+//     DELETE /watches/$ID
+//
+func (s *Server) WatchHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	owner := s.clientID(req)
+	id := mux.Vars(req)["id"]
+
+	if req.Method == "DELETE" {
+		var found bool
+		found, err = s.watches.Remove(id, owner)
+		if err != nil {
+			status = http.StatusInternalServerError
+			return
+		}
+		if !found {
+			status = http.StatusNotFound
+			err = errors.New("No such watch")
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	for _, w := range s.watches.List(owner) {
+		if w.ID == id {
+			sendJSON(res, w)
+			return
+		}
+	}
+	status = http.StatusNotFound
+	err = errors.New("No such watch")
+}
@@ -0,0 +1,331 @@
+//
+// Test our watches.
+//
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test the comparison of record-sets.
+//
+func TestDiffRecords(t *testing.T) {
+
+	old := []WatchRecord{
+		{Name: "a.test.", Type: "A", Value: "192.0.2.1", TTL: 300, MaxTTL: 300},
+		{Name: "a.test.", Type: "A", Value: "192.0.2.2", TTL: 250, MaxTTL: 300},
+		{Name: "a.test.", Type: "A", Value: "192.0.2.3", TTL: 300, MaxTTL: 300},
+	}
+
+	//
+	// Nothing changed, other than the TTLs counting down.
+	//
+	same := []WatchRecord{
+		{Name: "a.test.", Type: "A", Value: "192.0.2.1", TTL: 240},
+		{Name: "a.test.", Type: "A", Value: "192.0.2.2", TTL: 190},
+		{Name: "a.test.", Type: "A", Value: "192.0.2.3", TTL: 300},
+	}
+	if event := diffRecords(old, same, time.Minute); event != nil {
+		t.Errorf("Unexpected event: %v", event)
+	}
+	if same[0].MaxTTL != 300 {
+		t.Errorf("The maximum TTL wasn't retained: %v", same[0])
+	}
+
+	//
+	// One record added, one removed, one raised and one lowered.
+	//
+	changed := []WatchRecord{
+		{Name: "a.test.", Type: "A", Value: "192.0.2.1", TTL: 3600},
+		{Name: "a.test.", Type: "A", Value: "192.0.2.2", TTL: 60},
+		{Name: "a.test.", Type: "A", Value: "192.0.2.4", TTL: 300},
+	}
+	event := diffRecords(old, changed, time.Minute)
+	if event == nil {
+		t.Fatalf("Expected an event")
+	}
+	if len(event.Added) != 1 || event.Added[0].Value != "192.0.2.4" {
+		t.Errorf("Unexpected additions: %v", event.Added)
+	}
+	if len(event.Removed) != 1 || event.Removed[0].Value != "192.0.2.3" {
+		t.Errorf("Unexpected removals: %v", event.Removed)
+	}
+	if len(event.Changed) != 2 || event.Changed[0].NewTTL != 3600 || event.Changed[1].NewTTL != 60 {
+		t.Errorf("Unexpected changes: %v", event.Changed)
+	}
+	if changed[1].MaxTTL != 60 {
+		t.Errorf("The maximum TTL wasn't lowered: %v", changed[1])
+	}
+}
+
+//
+// Test that watches are persisted to a file.
+//
+func TestFileWatchStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "watches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watches.json")
+
+	s := NewFileWatchStore(path)
+	for _, id := range []string{"one", "two"} {
+		err = s.Save(Watch{ID: id, Name: "example.test.", Type: "A", Secret: "s3cret"})
+		if err != nil {
+			t.Fatalf("Failed to save: %s", err)
+		}
+	}
+	err = s.Delete("one")
+	if err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+
+	list, err := NewFileWatchStore(path).Load()
+	if err != nil {
+		t.Fatalf("Failed to load: %s", err)
+	}
+	if len(list) != 1 || list[0].ID != "two" || list[0].Secret != "s3cret" {
+		t.Errorf("Unexpected watches: %v", list)
+	}
+
+	//
+	// A missing file is empty, but a corrupt one is an error.
+	//
+	list, err = NewFileWatchStore(filepath.Join(dir, "missing.json")).Load()
+	if err != nil || len(list) != 0 {
+		t.Errorf("Unexpected result loading a missing file: %v %v", list, err)
+	}
+	ioutil.WriteFile(path, []byte("{"), 0644)
+	_, err = NewFileWatchStore(path).Load()
+	if err == nil {
+		t.Errorf("Expected an error loading a corrupt file")
+	}
+}
+
+//
+// Test creating a watch, being notified of a change, and deleting it.
+//
+func TestWatches(t *testing.T) {
+
+	//
+	// Our zone changes its answer when we tell it to.
+	//
+	var lock sync.Mutex
+	answer := "example.test. 60 IN A 192.0.2.1"
+	addr := dnstest.Start(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		lock.Lock()
+		records := answer
+		lock.Unlock()
+		dnstest.Zone(t, records)(w, req)
+	})
+	config := &Config{Webhooks: resolver.NetworkPolicy{Allow: []string{"127.0.0.1"}}}
+	config.Webhooks.Parse()
+	srv := newTestServer(t, Options{Config: config}, addr)
+
+	//
+	// Our webhook records what it was sent.
+	//
+	events := make(chan []byte, 10)
+	signatures := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signatures <- r.Header.Get(watchSignatureHeader)
+		events <- body
+	}))
+	defer hook.Close()
+
+	oldInterval := minWatchInterval
+	minWatchInterval = 50 * time.Millisecond
+	defer func() { minWatchInterval = oldInterval }()
+
+	request := func(method string, url string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.0.2.100:1234"
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//
+	// Bogus watches are rejected.
+	//
+	for body, status := range map[string]int{
+		`{`: http.StatusBadRequest,
+		`{"name": "example.test", "type": "BOGUS", "webhook": "http://127.0.0.1/"}`:                    http.StatusBadRequest,
+		`{"name": "example.test", "type": "A", "interval": "1ms", "webhook": "http://127.0.0.1/"}`:     http.StatusBadRequest,
+		`{"name": "example.test", "type": "A", "webhook": "ftp://127.0.0.1/"}`:                         http.StatusBadRequest,
+		`{"name": "example.test", "type": "A", "webhook": "http://10.0.0.1/"}`:                         http.StatusForbidden,
+		`{"name": "bad..name", "type": "A", "interval": "1m", "webhook": "http://127.0.0.1/"}`:         http.StatusBadRequest,
+		`{"name": "example.test", "type": "A", "interval": "forever", "webhook": "http://127.0.0.1/"}`: http.StatusBadRequest,
+	} {
+		rr := request("POST", "/watches", body)
+		if rr.Code != status {
+			t.Errorf("%s gave status %d, expected %d", body, rr.Code, status)
+		}
+	}
+
+	//
+	// Create a real one.
+	//
+	rr := request("POST", "/watches", `{"name": "example.test", "type": "a", "interval": "50ms", "webhook": "`+hook.URL+`/hook"}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Failed to create the watch: %d %v %s", rr.Code, rr.Header(), rr.Body.String())
+	}
+	var w Watch
+	err := json.Unmarshal(rr.Body.Bytes(), &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.ID == "" || w.Secret == "" || w.Type != "A" || len(w.Records) != 1 || w.Records[0].Value != "192.0.2.1" {
+		t.Fatalf("Unexpected watch: %v", w)
+	}
+
+	//
+	// The secret isn't shown again, and other clients can't see it.
+	//
+	var list []Watch
+	json.Unmarshal(request("GET", "/watches", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Secret != "" {
+		t.Errorf("Unexpected watches: %v", list)
+	}
+	req, _ := http.NewRequest("GET", "/watches/"+w.ID, nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	other := httptest.NewRecorder()
+	srv.ServeHTTP(other, req)
+	if other.Code != http.StatusNotFound {
+		t.Errorf("Another client could see our watch: %d", other.Code)
+	}
+
+	//
+	// Change the answer, and wait to be told.
+	//
+	lock.Lock()
+	answer = "example.test. 60 IN A 192.0.2.2"
+	lock.Unlock()
+
+	select {
+	case body := <-events:
+		var event WatchEvent
+		err = json.Unmarshal(body, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.ID != w.ID || len(event.Added) != 1 || len(event.Removed) != 1 || event.Added[0].Value != "192.0.2.2" {
+			t.Errorf("Unexpected event: %s", body)
+		}
+		if sig := <-signatures; sig != signWebhook(w.Secret, body) {
+			t.Errorf("Invalid signature: %s", sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the webhook")
+	}
+
+	//
+	// Delete it, which only the owner may do.
+	//
+	req, _ = http.NewRequest("DELETE", "/watches/"+w.ID, nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	other = httptest.NewRecorder()
+	srv.ServeHTTP(other, req)
+	if other.Code != http.StatusNotFound {
+		t.Errorf("Another client deleted our watch: %d", other.Code)
+	}
+	if rr = request("DELETE", "/watches/"+w.ID, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Failed to delete the watch: %d", rr.Code)
+	}
+	if rr = request("GET", "/watches/"+w.ID, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("The watch still exists: %d", rr.Code)
+	}
+}
+
+//
+// Test that webhooks may not be delivered to forbidden addresses, even
+// via a hostname.
+//
+func TestWebhookPolicy(t *testing.T) {
+
+	srv := newTestServer(t, Options{})
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("The webhook should not have been delivered")
+	}))
+	defer hook.Close()
+
+	_, port, _ := net.SplitHostPort(hook.Listener.Addr().String())
+	err := srv.sendWebhook(Watch{Webhook: "http://localhost:" + port + "/", Secret: "x"}, &WatchEvent{})
+	if err == nil {
+		t.Errorf("Expected the delivery to be refused")
+	}
+}
+
+//
+// Test that clients, and the server, may only create so many watches, even
+// when they're created at the same time.
+//
+func TestWatchLimits(t *testing.T) {
+
+	addr := dnstest.Start(t, dnstest.Zone(t, "example.test. 60 IN A 192.0.2.1"))
+	config := &Config{Webhooks: resolver.NetworkPolicy{Allow: []string{"127.0.0.1"}}}
+	config.Webhooks.Parse()
+	srv := newTestServer(t, Options{Config: config}, addr)
+	defer srv.watches.Stop()
+
+	create := func(client string) int {
+		body := `{"name": "example.test", "type": "A", "webhook": "http://127.0.0.1/hook"}`
+		req := httptest.NewRequest("POST", "/watches", bytes.NewBufferString(body))
+		req.RemoteAddr = client + ":1234"
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	codes := make(map[int]int)
+	for i := 0; i < maxWatchesPerClient+10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code := create("192.0.2.100")
+			lock.Lock()
+			codes[code]++
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	if codes[http.StatusCreated] != maxWatchesPerClient || codes[http.StatusTooManyRequests] != 10 {
+		t.Errorf("Unexpected results: %v", codes)
+	}
+
+	oldMax := maxWatches
+	maxWatches = maxWatchesPerClient + 1
+	defer func() { maxWatches = oldMax }()
+
+	if code := create("198.51.100.1"); code != http.StatusCreated {
+		t.Errorf("Failed to create a watch: %d", code)
+	}
+	if code := create("198.51.100.2"); code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status-code %d, when the server is full", code)
+	}
+}
@@ -0,0 +1,71 @@
+//
+// This file contains the handler for our wildcard-detection.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// WildcardHandler is the handler for our wildcard-detection.
+//
+// It is called via requests like this:
+//
+//     GET /wildcard/$ZONE
+//
+func (s *Server) WildcardHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		status int
+		err    error
+	)
+	defer func() {
+		if nil != err {
+			sendError(res, status, err)
+		}
+	}()
+
+	if s.Retired() {
+		status = http.StatusForbidden
+		err = errors.New("[]")
+		return
+	}
+	if !s.rateLimit(res, req) {
+		return
+	}
+
+	zone := mux.Vars(req)["zone"]
+	if _, ok := dns.IsDomainName(zone); !ok || dns.Fqdn(zone) == "." {
+		status = http.StatusBadRequest
+		err = errors.New("Invalid zone '" + zone + "'")
+		return
+	}
+
+	//
+	// Everything beneath a name which doesn't exist doesn't exist
+	// either, so there's no point probing.
+	//
+	_, err = s.resolver.Query(zone, "SOA", resolver.DefaultQueryOptions())
+	if err != nil {
+		status = http.StatusNotFound
+		return
+	}
+
+	report, err := s.resolver.DetectWildcards(zone)
+	if err != nil {
+		status = http.StatusInternalServerError
+		return
+	}
+
+	s.mutex.Lock()
+	s.stats["wildcard.checks"]++
+	s.mutex.Unlock()
+
+	sendJSON(res, report)
+}
@@ -0,0 +1,74 @@
+//
+// Test the handler of our wildcard-detection.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test that answers are marked when the caller asks.
+//
+func TestMarkWildcards(t *testing.T) {
+
+	srv := newTestServer(t, Options{}, dnstest.Start(t, dnstest.Wildcards(t)))
+
+	tests := []struct {
+		url      string
+		wildcard string
+	}{
+		{"/A/typo.example.test?wildcard=1", "true"},
+		{"/A/typo.example.test", ""},
+		{"/A/www.example.test?wildcard=1", ""},
+		{"/CNAME/typo.cname.example.test?wildcard=1", "true"},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s gave status %d", test.url, rr.Code)
+		}
+
+		var results []map[string]string
+		err = json.Unmarshal(rr.Body.Bytes(), &results)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", test.url, err)
+		}
+		if len(results) != 1 || results[0]["wildcard"] != test.wildcard {
+			t.Errorf("%s gave %v, expected wildcard='%s'", test.url, results, test.wildcard)
+		}
+	}
+
+	//
+	// The report is available too, but not for names which don't
+	// exist.
+	//
+	for url, status := range map[string]int{
+		"/wildcard/example.test":  http.StatusOK,
+		"/wildcard/missing.test":  http.StatusNotFound,
+		"/wildcard/bad..zone.com": http.StatusBadRequest,
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != status {
+			t.Errorf("%s gave status %d, expected %d", url, rr.Code, status)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+//
+// The `bulk` subcommand, which performs each of the lookups listed upon
+// STDIN, and reports the answers together.
+//
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+// bulkResult is the outcome of a single lookup made by our `bulk`
+// subcommand.
+type bulkResult struct {
+	// Name is the name we looked up.
+	Name string `json:"name"`
+
+	// Type is the type of the lookup.
+	Type string `json:"type"`
+
+	// Answers are the records we received, if any.
+	Answers []map[string]string `json:"answers"`
+
+	// Error describes why the lookup failed, if it did.
+	Error string `json:"error,omitempty"`
+
+	// rrs are the resource-records our answers were created from.
+	rrs []dns.RR
+}
+
+//
+// parseBulk reads our list of lookups, one per line.
+//
+// Each line is either a name, which is looked up with the given default
+// type, or a type followed by a name.  Blank lines, and comments, are
+// ignored.
+//
+func parseBulk(in io.Reader, defType string) ([]bulkResult, error) {
+	var lookups []bulkResult
+
+	scanner := bufio.NewScanner(in)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		switch len(fields) {
+		case 1:
+			lookups = append(lookups, bulkResult{Name: fields[0], Type: defType})
+		case 2:
+			lookups = append(lookups, bulkResult{Name: fields[1], Type: fields[0]})
+		default:
+			return nil, fmt.Errorf("line %d: expected `NAME` or `TYPE NAME`, got '%s'", line, text)
+		}
+	}
+	return lookups, scanner.Err()
+}
+
+//
+// runBulk performs each of the given lookups, with no more than parallel
+// running at once, and writes the results to out in the given format.
+//
+// JSON groups the answers by lookup, along with any error.  The other
+// formats show all the answers together, as our API would, with the
+// errors reported upon STDERR.
+//
+// The number of lookups which failed is returned.
+//
+func runBulk(rs *resolver.Resolver, out io.Writer, lookups []bulkResult, opts resolver.QueryOptions, format string, parallel int) (int, error) {
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for i := range lookups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *bulkResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			r.Type = strings.ToUpper(r.Type)
+			answers, rrs, err := lookup(rs, r.Type, r.Name, opts)
+			if err != nil {
+				r.Error = err.Error()
+			}
+			r.Answers = answers
+			r.rrs = rrs
+			if r.Answers == nil {
+				r.Answers = []map[string]string{}
+			}
+		}(&lookups[i])
+	}
+	wg.Wait()
+
+	//
+	// Count the failures, which are reported upon STDERR unless we're
+	// writing JSON.
+	//
+	grouped := strings.EqualFold(format, "json")
+	var all []map[string]string
+	var rrs []dns.RR
+	failed := 0
+	for _, r := range lookups {
+		all = append(all, r.Answers...)
+		rrs = append(rrs, r.rrs...)
+		if r.Error != "" {
+			failed++
+			if !grouped {
+				fmt.Fprintf(os.Stderr, "Error: %s %s: %s\n", r.Type, r.Name, r.Error)
+			}
+		}
+	}
+
+	if grouped {
+		if lookups == nil {
+			lookups = []bulkResult{}
+		}
+		return failed, writeJSON(out, lookups)
+	}
+	if strings.EqualFold(format, "table") {
+		return failed, writeTable(out, all)
+	}
+	return failed, writeRecords(out, format, all, rrs)
+}
+
+//
+// bulkMain is our `bulk` subcommand.
+//
+func bulkMain(args []string) int {
+
+	fs := flag.NewFlagSet("bulk", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: dns-api-go bulk [flags] < names.txt\n\n")
+		fmt.Fprintf(fs.Output(), "Each line of input is either `NAME` or `TYPE NAME`.\n\n")
+		fs.PrintDefaults()
+	}
+	l := addLookupFlags(fs)
+	defType := fs.String("type", "A", "The type of lookup for lines which only contain a name.")
+	parallel := fs.Int("parallel", 4, "The number of lookups to perform at once.")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	rs := resolver.New()
+	opts, err := l.options(rs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	lookups, err := parseBulk(os.Stdin, *defType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	failed, err := runBulk(rs, os.Stdout, lookups, opts, *l.format, *parallel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
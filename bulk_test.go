@@ -0,0 +1,170 @@
+//
+// Test our `bulk` subcommand.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test that our input is parsed correctly.
+//
+func TestParseBulk(t *testing.T) {
+
+	input := `
+# Our domains
+example.test
+MX example.test
+
+  txt   example.test
+`
+	lookups, err := parseBulk(strings.NewReader(input), "A")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expect := []bulkResult{
+		{Name: "example.test", Type: "A"},
+		{Name: "example.test", Type: "MX"},
+		{Name: "example.test", Type: "txt"},
+	}
+	if len(lookups) != len(expect) {
+		t.Fatalf("Expected %d lookups, got %d", len(expect), len(lookups))
+	}
+	for i, l := range lookups {
+		if l.Name != expect[i].Name || l.Type != expect[i].Type {
+			t.Errorf("Lookup %d: expected %v, got %v", i, expect[i], l)
+		}
+	}
+
+	_, err = parseBulk(strings.NewReader("example.test\nA example.test extra\n"), "A")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error for line 2, got %v", err)
+	}
+}
+
+//
+// Test that each lookup is reported, in order, as JSON.
+//
+func TestBulkJSON(t *testing.T) {
+	rs := newTestResolver(t)
+
+	lookups, err := parseBulk(strings.NewReader("example.test\nmx example.test\nmissing.test\nFOO example.test\nMX empty.test\n"), "A")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var out bytes.Buffer
+	failed, err := runBulk(rs, &out, lookups, resolver.DefaultQueryOptions(), "json", 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if failed != 2 {
+		t.Errorf("Expected two failures, got %d", failed)
+	}
+
+	var results []bulkResult
+	err = json.Unmarshal(out.Bytes(), &results)
+	if err != nil {
+		t.Fatalf("Invalid JSON: %s\n%s", err, out.String())
+	}
+
+	expect := []struct {
+		Type    string
+		Name    string
+		Answers int
+		Error   string
+	}{
+		{"A", "example.test", 1, ""},
+		{"MX", "example.test", 1, ""},
+		{"A", "missing.test", 0, "no such domain"},
+		{"FOO", "example.test", 0, "Invalid lookup-type"},
+		{"MX", "empty.test", 0, ""},
+	}
+	if len(results) != len(expect) {
+		t.Fatalf("Expected %d results, got %d", len(expect), len(results))
+	}
+	for i, e := range expect {
+		r := results[i]
+		if r.Type != e.Type || r.Name != e.Name {
+			t.Errorf("Result %d: expected %s %s, got %s %s", i, e.Type, e.Name, r.Type, r.Name)
+		}
+		if len(r.Answers) != e.Answers {
+			t.Errorf("Result %d: expected %d answers, got %d", i, e.Answers, len(r.Answers))
+		}
+		if !strings.Contains(r.Error, e.Error) || (e.Error == "" && r.Error != "") {
+			t.Errorf("Result %d: unexpected error '%s'", i, r.Error)
+		}
+	}
+	if !strings.Contains(out.String(), `"answers": []`) {
+		t.Errorf("Empty answers should be an empty array:\n%s", out.String())
+	}
+}
+
+//
+// Test that all the answers may be shown in a single table.
+//
+func TestBulkTable(t *testing.T) {
+	rs := newTestResolver(t)
+
+	lookups := []bulkResult{
+		{Name: "example.test", Type: "A"},
+		{Name: "example.test", Type: "TXT"},
+	}
+
+	var out bytes.Buffer
+	failed, err := runBulk(rs, &out, lookups, resolver.DefaultQueryOptions(), "table", 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if failed != 0 {
+		t.Errorf("Unexpected failures: %d", failed)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and two rows, got:\n%s", out.String())
+	}
+	if strings.Join(strings.Fields(lines[1]), " ") != "example.test. 60 A 192.0.2.1" {
+		t.Errorf("Unexpected row '%s'", lines[1])
+	}
+	if strings.Join(strings.Fields(lines[2]), " ") != "example.test. 60 TXT v=spf1 -all" {
+		t.Errorf("Unexpected row '%s'", lines[2])
+	}
+}
+
+//
+// Test that all the answers may be written together in the other formats
+// of our API.
+//
+func TestBulkZone(t *testing.T) {
+	rs := newTestResolver(t)
+
+	lookups := []bulkResult{
+		{Name: "example.test", Type: "A"},
+		{Name: "missing.test", Type: "A"},
+		{Name: "example.test", Type: "TXT"},
+	}
+
+	var out bytes.Buffer
+	failed, err := runBulk(rs, &out, lookups, resolver.DefaultQueryOptions(), "zone", 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if failed != 1 {
+		t.Errorf("Expected one failure, got %d", failed)
+	}
+
+	expect := "example.test.\t60\tIN\tA\t192.0.2.1\n" +
+		"example.test.\t60\tIN\tTXT\t\"v=spf1 -all\"\n"
+	if out.String() != expect {
+		t.Errorf("Unexpected output:\n%q", out.String())
+	}
+}
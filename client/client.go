@@ -0,0 +1,316 @@
+//
+// Package client is a client for the dns-api-go HTTP API.
+//
+// Rather than parsing the JSON we return by hand callers can use the
+// typed methods here, which retry failed requests with a backoff, and
+// honour our rate-limit:
+//
+//     c := client.New("https://dns-api.org")
+//     records, err := c.Lookup(ctx, "A", "steve.fi")
+//
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a lookup found no records.
+var ErrNotFound = errors.New("no records found")
+
+// Record is a single record returned by a lookup.
+type Record struct {
+	Name  string
+	Type  string
+	Value string
+	TTL   uint32
+
+	// Wildcard is true if the record was probably synthesized from
+	// a wildcard, which is only reported when asked for.
+	Wildcard bool
+}
+
+// UnmarshalJSON decodes a record from the map of strings we return.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var raw map[string]string
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	r.Name = raw["name"]
+	r.Type = raw["type"]
+	r.Value = raw["value"]
+	r.Wildcard = raw["wildcard"] == "true"
+	if raw["ttl"] != "" {
+		var ttl uint64
+		ttl, err = strconv.ParseUint(raw["ttl"], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid TTL '%s'", raw["ttl"])
+		}
+		r.TTL = uint32(ttl)
+	}
+	return nil
+}
+
+// APIError is returned when the server rejected a request.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error returns the message the server gave us.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// RateLimitError is returned when our rate-limit has been exceeded, and
+// we couldn't, or wouldn't, wait for it to reset.
+type RateLimitError struct {
+	// Limit is the number of requests we may make each hour.
+	Limit int64
+
+	// Remaining is the number of requests we have left.
+	Remaining int64
+
+	// Delay is how long we must wait before trying again.
+	Delay time.Duration
+
+	// IP is the address our requests are counted against.
+	IP string
+}
+
+// Error describes the limit which was exceeded.
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate-limit of %d requests exceeded, retry in %s", e.Limit, e.Delay)
+}
+
+// Query is a single lookup, as made by LookupAll.
+type Query struct {
+	Type string
+	Name string
+}
+
+// Result is the outcome of a single lookup made by LookupAll.
+type Result struct {
+	Query   Query
+	Records []Record
+	Err     error
+}
+
+// Client makes requests against a server.
+type Client struct {
+	// BaseURL is the address of the server, such as
+	// "https://dns-api.org".
+	BaseURL string
+
+	// HTTPClient is used to make our requests.
+	HTTPClient *http.Client
+
+	// APIKey is sent with our requests, if it is set, so that they're
+	// limited by the tier of the key rather than by our address.
+	APIKey string
+
+	// MaxRetries is the number of times a failed request is retried.
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, which doubles
+	// for each subsequent one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// MaxRateLimitWait is the longest we'll wait for our rate-limit
+	// to reset, rather than returning a RateLimitError.
+	MaxRateLimitWait time.Duration
+
+	// Concurrency is the number of lookups LookupAll makes at once.
+	Concurrency int
+}
+
+//
+// New creates a client for the server at the given URL, with our default
+// settings.
+//
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:          strings.TrimRight(baseURL, "/"),
+		HTTPClient:       &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:       3,
+		Backoff:          500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		MaxRateLimitWait: time.Minute,
+		Concurrency:      4,
+	}
+}
+
+//
+// Lookup resolves the records of the given type for the given name.
+//
+// If there are no such records ErrNotFound is returned.
+//
+func (c *Client) Lookup(ctx context.Context, qtype string, name string) ([]Record, error) {
+	var records []Record
+
+	path := "/" + url.PathEscape(strings.ToUpper(qtype)) + "/" + url.PathEscape(name)
+	err := c.get(ctx, path, &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+//
+// LookupAll makes each of the given lookups, several at once, returning
+// their results in the same order.
+//
+func (c *Client) LookupAll(ctx context.Context, queries []Query) []Result {
+	results := make([]Result, len(queries))
+
+	workers := c.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				q := queries[n]
+				records, err := c.Lookup(ctx, q.Type, q.Name)
+				results[n] = Result{Query: q, Records: records, Err: err}
+			}
+		}()
+	}
+
+	for n := range queries {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+//
+// get fetches the given path, decoding the JSON response into out, and
+// retrying failures.
+//
+// Server errors, and failures to connect, are retried after a backoff.
+// If we hit our rate-limit we'll wait for it to reset, if that isn't too
+// long.  Other errors are returned immediately.
+//
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	backoff := c.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, path, out)
+		if err == nil {
+			return nil
+		}
+
+		var delay time.Duration
+		switch e := err.(type) {
+		case *RateLimitError:
+			if e.Delay > c.MaxRateLimitWait {
+				return err
+			}
+			delay = e.Delay
+			if delay < backoff {
+				delay = backoff
+			}
+		case *APIError:
+			if e.StatusCode < 500 {
+				return err
+			}
+			delay = backoff
+		default:
+			if err == ErrNotFound || ctx.Err() != nil {
+				return err
+			}
+			delay = backoff
+		}
+
+		if attempt >= c.MaxRetries {
+			return err
+		}
+
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+//
+// do makes a single request.
+//
+func (c *Client) do(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequest("GET", c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		return rateLimitError(res.Header)
+	case res.StatusCode == http.StatusNotFound && strings.TrimSpace(string(body)) == "[]":
+		return ErrNotFound
+	case res.StatusCode != http.StatusOK:
+		return &APIError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+//
+// rateLimitError creates the error for a rate-limited response, from its
+// X-RateLimit headers.
+//
+func rateLimitError(h http.Header) *RateLimitError {
+	limit, _ := strconv.ParseInt(h.Get("X-RateLimit-Limit"), 10, 64)
+	remaining, _ := strconv.ParseInt(h.Get("X-RateLimit-Remaining"), 10, 64)
+	delay, _ := strconv.ParseInt(h.Get("X-RateLimit-Delay"), 10, 64)
+
+	return &RateLimitError{
+		Limit:     limit,
+		Remaining: remaining,
+		Delay:     time.Duration(delay) * time.Second,
+		IP:        h.Get("X-RateLimit-IP"),
+	}
+}
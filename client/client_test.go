@@ -0,0 +1,206 @@
+//
+// Test our client against a fake server.
+//
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// fakeServer returns a client for a server which answers each request
+// with the next of the given handlers, and counts the requests made.
+//
+func fakeServer(t *testing.T, handlers ...http.HandlerFunc) (*Client, *int) {
+	var (
+		m     sync.Mutex
+		count int
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		m.Lock()
+		n := count
+		count++
+		m.Unlock()
+
+		if n >= len(handlers) {
+			t.Errorf("Unexpected request %d: %s", n, req.URL.Path)
+			http.Error(res, "unexpected", http.StatusInternalServerError)
+			return
+		}
+		handlers[n](res, req)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := New(ts.URL + "/")
+	c.Backoff = time.Millisecond
+	c.MaxBackoff = 5 * time.Millisecond
+	return c, &count
+}
+
+//
+// reply returns a handler which sends the given status and body.
+//
+func reply(status int, body string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(status)
+		fmt.Fprint(res, body)
+	}
+}
+
+//
+// limited returns a handler which reports our rate-limit was exceeded.
+//
+func limited(delay string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		h := res.Header()
+		h.Set("X-RateLimit-Limit", "200")
+		h.Set("X-RateLimit-Remaining", "0")
+		h.Set("X-RateLimit-Delay", delay)
+		h.Set("X-RateLimit-IP", "192.0.2.1")
+		http.Error(res, "API rate limit exceeded.", http.StatusTooManyRequests)
+	}
+}
+
+//
+// Test decoding a successful lookup.
+//
+func TestLookup(t *testing.T) {
+
+	c, _ := fakeServer(t, func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/MX/example.com" {
+			t.Errorf("Unexpected path: %s", req.URL.Path)
+		}
+		fmt.Fprint(res, `[{"name": "example.com.", "type": "MX", "value": "10\tmail.example.com.", "ttl": "300", "wildcard": "true"}]`)
+	})
+
+	records, err := c.Lookup(context.Background(), "mx", "example.com")
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	expected := Record{Name: "example.com.", Type: "MX", Value: "10\tmail.example.com.", TTL: 300, Wildcard: true}
+	if len(records) != 1 || records[0] != expected {
+		t.Errorf("Unexpected records: %v", records)
+	}
+}
+
+//
+// Test that our API key is sent, if we have one.
+//
+func TestLookupAPIKey(t *testing.T) {
+
+	c, _ := fakeServer(t,
+		func(res http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("X-API-Key") != "" {
+				t.Errorf("Unexpected key '%s'", req.Header.Get("X-API-Key"))
+			}
+			fmt.Fprint(res, `[]`)
+		},
+		func(res http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("X-API-Key") != "dak_secret" {
+				t.Errorf("Unexpected key '%s'", req.Header.Get("X-API-Key"))
+			}
+			fmt.Fprint(res, `[]`)
+		})
+
+	c.Lookup(context.Background(), "A", "example.com")
+	c.APIKey = "dak_secret"
+	c.Lookup(context.Background(), "A", "example.com")
+}
+
+//
+// Test the errors we return, and which of them are retried.
+//
+func TestLookupErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		requests int
+		check    func(error) bool
+	}{
+		{
+			"not found",
+			[]http.HandlerFunc{reply(http.StatusNotFound, "[]\n")},
+			1,
+			func(err error) bool { return err == ErrNotFound },
+		},
+		{
+			"bad request",
+			[]http.HandlerFunc{reply(http.StatusNotFound, "Invalid lookup-type\n")},
+			1,
+			func(err error) bool {
+				e, ok := err.(*APIError)
+				return ok && e.StatusCode == http.StatusNotFound && e.Message == "Invalid lookup-type"
+			},
+		},
+		{
+			"server errors are retried",
+			[]http.HandlerFunc{reply(http.StatusBadGateway, "oops"), reply(http.StatusInternalServerError, "oops"), reply(http.StatusOK, "[]")},
+			3,
+			func(err error) bool { return err == nil },
+		},
+		{
+			"retries are limited",
+			[]http.HandlerFunc{reply(500, "a"), reply(500, "b"), reply(500, "c"), reply(500, "d")},
+			4,
+			func(err error) bool {
+				e, ok := err.(*APIError)
+				return ok && e.Message == "d"
+			},
+		},
+		{
+			"short rate-limits are waited for",
+			[]http.HandlerFunc{limited("0"), reply(http.StatusOK, "[]")},
+			2,
+			func(err error) bool { return err == nil },
+		},
+		{
+			"long rate-limits are returned",
+			[]http.HandlerFunc{limited("3600")},
+			1,
+			func(err error) bool {
+				e, ok := err.(*RateLimitError)
+				return ok && e.Limit == 200 && e.Remaining == 0 && e.Delay == time.Hour && e.IP == "192.0.2.1"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		c, count := fakeServer(t, test.handlers...)
+		_, err := c.Lookup(context.Background(), "A", "example.com")
+		if !test.check(err) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if *count != test.requests {
+			t.Errorf("%s: made %d requests, expected %d", test.name, *count, test.requests)
+		}
+	}
+}
+
+//
+// Test that we stop waiting when our context is cancelled.
+//
+func TestLookupCancelled(t *testing.T) {
+
+	c, _ := fakeServer(t, limited("30"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.Lookup(ctx, "A", "example.com")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("We waited for the rate-limit, not the context")
+	}
+}
@@ -0,0 +1,79 @@
+//
+// Test our client package against our real server.
+//
+
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skx/dns-api-go/api"
+	"github.com/skx/dns-api-go/client"
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// Test lookups made with our client.
+//
+func TestClient(t *testing.T) {
+
+	rs := resolver.New()
+	rs.Nameservers = []string{dnstest.Start(t, dnstest.Zone(t,
+		"example.test. 300 IN MX 10 mail.example.test.",
+		"mail.example.test. 60 IN A 192.0.2.1",
+		"mail.example.test. 60 IN A 192.0.2.2",
+	))}
+
+	srv, err := api.New(api.Options{Resolver: rs})
+	if err != nil {
+		t.Fatalf("Failed to create server: %s", err)
+	}
+	defer srv.Close()
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	c := client.New(ts.URL)
+	ctx := context.Background()
+
+	records, err := c.Lookup(ctx, "mx", "example.test")
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	expected := client.Record{Name: "example.test.", Type: "MX", Value: "10\tmail.example.test.", TTL: 300}
+	if len(records) != 1 || records[0] != expected {
+		t.Errorf("Unexpected records: %v", records)
+	}
+
+	_, err = c.Lookup(ctx, "A", "missing.example.test")
+	if err != client.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	_, err = c.Lookup(ctx, "BOGUS", "example.test")
+	if e, ok := err.(*client.APIError); !ok || e.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected an APIError, got %v", err)
+	}
+
+	results := c.LookupAll(ctx, []client.Query{
+		{Type: "A", Name: "mail.example.test"},
+		{Type: "AAAA", Name: "mail.example.test"},
+		{Type: "MX", Name: "example.test"},
+	})
+	if len(results) != 3 {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+	if results[0].Err != nil || len(results[0].Records) != 2 {
+		t.Errorf("Unexpected A result: %v", results[0])
+	}
+	if results[1].Err != client.ErrNotFound {
+		t.Errorf("Unexpected AAAA result: %v", results[1])
+	}
+	if results[2].Err != nil || results[2].Query.Type != "MX" || len(results[2].Records) != 1 {
+		t.Errorf("Unexpected MX result: %v", results[2])
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/skx/dns-api-go
 
-go 1.12
+go 1.15
 
 require (
 	github.com/go-redis/redis v6.15.9+incompatible
@@ -14,7 +14,7 @@ require (
 	github.com/robfig/cron v1.2.0
 	github.com/skx/golang-metrics v0.0.0-20190325085214-453332cf54e8
 	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97 // indirect
-	golang.org/x/net v0.0.0-20210726213435-c6fcb2dbf985 // indirect
+	golang.org/x/net v0.0.0-20210726213435-c6fcb2dbf985
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac // indirect
 	gopkg.in/yaml.v2 v2.2.2 // indirect
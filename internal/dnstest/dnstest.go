@@ -0,0 +1,320 @@
+//
+// Package dnstest contains the local stand-in nameservers our test-cases
+// query, rather than the real internet.
+//
+package dnstest
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// Start launches a local stand-in nameserver, listening upon both UDP and
+// TCP, which answers queries via the given handler.
+//
+// It returns the "host:port" address of the server, and arranges for it
+// to be stopped when the test completes.
+//
+func Start(t *testing.T, handler dns.HandlerFunc) string {
+	return StartOn(t, "127.0.0.1:0", handler)
+}
+
+//
+// bindAttempts is how many ports we'll try, when we're free to choose one,
+// as the TCP port matching a UDP port may already be in use.
+//
+const bindAttempts = 10
+
+//
+// StartOn is like Start, but binds to the given address.
+//
+func StartOn(t *testing.T, bind string, handler dns.HandlerFunc) string {
+
+	attempts := 1
+	if _, port, err := net.SplitHostPort(bind); err == nil && port == "0" {
+		attempts = bindAttempts
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		var pc net.PacketConn
+		var l net.Listener
+		pc, l, err = listen(bind)
+		if err == nil {
+			serve(t, pc, l, handler)
+			return pc.LocalAddr().String()
+		}
+	}
+	t.Fatalf("Failed to listen upon %s: %s", bind, err)
+	return ""
+}
+
+//
+// listen binds to the given address with UDP, and then with TCP upon the
+// same port.
+//
+func listen(bind string) (net.PacketConn, net.Listener, error) {
+	pc, err := net.ListenPacket("udp", bind)
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+	return pc, l, nil
+}
+
+//
+// serve answers the queries received upon the given UDP and TCP sockets,
+// until the test completes.
+//
+func serve(t *testing.T, pc net.PacketConn, l net.Listener, handler dns.HandlerFunc) {
+	udp := &dns.Server{PacketConn: pc, Handler: handler}
+	tcp := &dns.Server{Listener: l, Handler: handler}
+
+	for _, s := range []*dns.Server{udp, tcp} {
+		started := make(chan bool)
+		s.NotifyStartedFunc = func() { close(started) }
+		go s.ActivateAndServe()
+		<-started
+	}
+
+	t.Cleanup(func() {
+		udp.Shutdown()
+		tcp.Shutdown()
+	})
+}
+
+//
+// StartAuthoritative launches stand-in servers upon a number of loopback
+// addresses, all sharing one port which it returns.  That port should be
+// used as the port of the resolver under test.
+//
+// Addresses with a nil handler are left without a server.
+//
+func StartAuthoritative(t *testing.T, servers map[string]dns.HandlerFunc) string {
+
+	var err error
+	for i := 0; i < bindAttempts; i++ {
+
+		//
+		// Find a port which is free.
+		//
+		var pc net.PacketConn
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+		pc.Close()
+
+		//
+		// Bind every address before serving any, so that we can try
+		// another port if one of them is in use.
+		//
+		type bound struct {
+			pc      net.PacketConn
+			l       net.Listener
+			handler dns.HandlerFunc
+		}
+		var all []bound
+		for addr, handler := range servers {
+			if handler == nil {
+				continue
+			}
+			var b bound
+			b.pc, b.l, err = listen(net.JoinHostPort(addr, port))
+			if err != nil {
+				break
+			}
+			b.handler = handler
+			all = append(all, b)
+		}
+
+		if err == nil {
+			for _, b := range all {
+				serve(t, b.pc, b.l, b.handler)
+			}
+			return port
+		}
+		for _, b := range all {
+			b.pc.Close()
+			b.l.Close()
+		}
+	}
+	t.Fatalf("Failed to listen upon a shared port: %s", err)
+	return ""
+}
+
+//
+// ClosedAddress returns the address of a UDP port nothing is listening
+// upon.
+//
+func ClosedAddress(t *testing.T) string {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.LocalAddr().String()
+	l.Close()
+	return addr
+}
+
+//
+// Zone returns a handler which answers, authoritatively, from the given
+// records which are in zone-file format.
+//
+// Names with no records at all receive NXDOMAIN, names with records of
+// other types receive an empty answer.
+//
+func Zone(t *testing.T, records ...string) dns.HandlerFunc {
+
+	var rrs []dns.RR
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("Invalid test-record '%s': %s", record, err)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Authoritative = true
+
+		q := req.Question[0]
+		found := false
+		for _, rr := range rrs {
+			if !strings.EqualFold(rr.Header().Name, q.Name) {
+				continue
+			}
+			found = true
+			if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		if !found {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	}
+}
+
+//
+// Wildcards returns a handler which is a stand-in for a zone with
+// "*.example.test" A & MX records, and "*.cname.example.test" CNAME
+// record, along with an explicit record for "www.example.test".
+//
+func Wildcards(t *testing.T) dns.HandlerFunc {
+
+	explicit := Zone(t,
+		"example.test. 60 IN SOA ns1.example.test. hostmaster.example.test. 1 7200 900 1209600 3600",
+		"www.example.test. 60 IN A 192.0.2.1",
+		"other.test. 60 IN SOA ns1.other.test. hostmaster.other.test. 1 7200 900 1209600 3600",
+	)
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		q := req.Question[0]
+		name := strings.ToLower(q.Name)
+
+		if name == "www.example.test." || !strings.HasSuffix(name, ".example.test.") {
+			explicit(w, req)
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Authoritative = true
+
+		var records []string
+		if strings.HasSuffix(name, ".cname.example.test.") {
+			records = []string{name + " 60 IN CNAME www.example.test."}
+		} else {
+			switch q.Qtype {
+			case dns.TypeA:
+				records = []string{name + " 300 IN A 192.0.2.99"}
+			case dns.TypeMX:
+				records = []string{name + " 300 IN MX 10 mx.example.test.", name + " 300 IN MX 20 mx2.example.test."}
+			}
+		}
+		for _, record := range records {
+			rr, _ := dns.NewRR(record)
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	}
+}
+
+// The TSIG key our stand-in primary requires.
+const (
+	KeyName = "transfer.key."
+	Secret  = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+)
+
+//
+// StartPrimary launches a stand-in primary server, which allows transfers
+// of example.test only to those who sign their request with KeyName.
+//
+// It returns the "host:port" address of the server.
+//
+func StartPrimary(t *testing.T) string {
+
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	soa1 := rr("example.test. 300 IN SOA ns1.example.test. hostmaster.example.test. 1 3600 600 86400 60")
+	soa3 := rr("example.test. 300 IN SOA ns1.example.test. hostmaster.example.test. 3 3600 600 86400 60")
+	ns := rr("example.test. 300 IN NS ns1.example.test.")
+	old := rr("www.example.test. 300 IN A 192.0.2.1")
+	cur := rr("www.example.test. 300 IN A 192.0.2.3")
+	mx := rr("example.test. 300 IN MX 10 mail.example.test.")
+
+	handler := func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		if req.IsTsig() == nil || w.TsigStatus() != nil {
+			m.Rcode = dns.RcodeRefused
+			w.WriteMsg(m)
+			return
+		}
+
+		switch req.Question[0].Qtype {
+		case dns.TypeAXFR:
+			m.Answer = []dns.RR{soa3, ns, cur, mx, soa3}
+		case dns.TypeIXFR:
+			m.Answer = []dns.RR{soa3, soa1, old, soa3, cur, soa3}
+		}
+		m.SetTsig(KeyName, dns.HmacSHA256, 300, time.Now().Unix())
+		w.WriteMsg(m)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dns.Server{
+		Listener:   l,
+		Handler:    dns.HandlerFunc(handler),
+		TsigSecret: map[string]string{KeyName: Secret},
+	}
+	started := make(chan bool)
+	s.NotifyStartedFunc = func() { close(started) }
+	go s.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { s.Shutdown() })
+
+	return l.Addr().String()
+}
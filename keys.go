@@ -0,0 +1,182 @@
+//
+// The `keys` subcommand, which manages the API keys of a server via its
+// admin routes.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skx/dns-api-go/api"
+)
+
+//
+// keysUsage describes the actions of our `keys` subcommand.
+//
+const keysUsage = `Usage: dns-api-go keys [flags] ACTION [args]
+
+Actions:
+  list               List the keys.
+  create NAME TIER   Create a key, showing its secret.
+  rotate ID          Replace the secret of a key, showing the new one.
+  revoke ID          Delete a key.
+
+`
+
+//
+// adminClient makes requests to the admin routes of a server.
+//
+type adminClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+//
+// do makes a request, decoding the JSON response into out if it isn't
+// nil.
+//
+func (c *adminClient) do(method string, path string, body interface{}, out interface{}) error {
+	var in io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		in = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.base, "/")+path, in)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+//
+// runKeys performs the given action, writing its result to out.
+//
+func runKeys(c *adminClient, out io.Writer, args []string) error {
+
+	if len(args) < 1 {
+		return fmt.Errorf("no action given")
+	}
+
+	expect := map[string]int{"list": 1, "create": 3, "rotate": 2, "revoke": 2}
+	n, ok := expect[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown action '%s'", args[0])
+	}
+	if len(args) != n {
+		return fmt.Errorf("the '%s' action takes %d arguments", args[0], n-1)
+	}
+
+	switch args[0] {
+	case "list":
+		var keys []api.APIKey
+		err := c.do("GET", "/admin/keys", nil, &keys)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tNAME\tTIER\tCREATED\tROTATED\n")
+		for _, k := range keys {
+			rotated := "-"
+			if !k.Rotated.IsZero() {
+				rotated = k.Rotated.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Tier, k.Created.Format(time.RFC3339), rotated)
+		}
+		return w.Flush()
+
+	case "create":
+		var k api.APIKey
+		err := c.do("POST", "/admin/keys", map[string]string{"name": args[1], "tier": args[2]}, &k)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, k)
+
+	case "rotate":
+		var k api.APIKey
+		err := c.do("POST", "/admin/keys/"+url.PathEscape(args[1])+"/rotate", nil, &k)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, k)
+
+	default:
+		err := c.do("DELETE", "/admin/keys/"+url.PathEscape(args[1]), nil, nil)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "Revoked %s\n", args[1])
+		return err
+	}
+}
+
+//
+// keysMain is our `keys` subcommand.
+//
+func keysMain(args []string) int {
+
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), keysUsage)
+		fs.PrintDefaults()
+	}
+	server := fs.String("url", "http://127.0.0.1:9999", "The URL of the server.")
+	token := fs.String("token", os.Getenv("DNS_API_ADMIN_TOKEN"), "An admin token, which defaults to $DNS_API_ADMIN_TOKEN.")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 1
+	}
+
+	c := &adminClient{
+		base:  *server,
+		token: *token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+	err := runKeys(c, os.Stdout, fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+//
+// Test our `keys` subcommand.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/skx/dns-api-go/api"
+)
+
+//
+// newKeysClient starts a server with an admin token, returning a client
+// for its admin routes.
+//
+func newKeysClient(t *testing.T, token string) *adminClient {
+	config := &api.Config{
+		Keys: api.KeyConfig{
+			AdminTokens: []string{"admin-secret"},
+			Tiers:       map[string]api.Tier{"internal": {}},
+		},
+	}
+	srv, err := api.New(api.Options{Config: config})
+	if err != nil {
+		t.Fatalf("Failed to create server: %s", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	return &adminClient{base: ts.URL, token: token, http: ts.Client()}
+}
+
+//
+// Test that keys may be created, listed, rotated and revoked.
+//
+func TestKeysActions(t *testing.T) {
+	c := newKeysClient(t, "admin-secret")
+
+	var out bytes.Buffer
+	err := runKeys(c, &out, []string{"create", "billing", "internal"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var k api.APIKey
+	err = json.Unmarshal(out.Bytes(), &k)
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %s", out.String(), err)
+	}
+	if k.Name != "billing" || k.Tier != "internal" || k.Key == "" {
+		t.Errorf("Unexpected key %v", k)
+	}
+
+	out.Reset()
+	err = runKeys(c, &out, []string{"list"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], k.ID+"  billing  internal") {
+		t.Errorf("Unexpected listing:\n%s", out.String())
+	}
+
+	out.Reset()
+	err = runKeys(c, &out, []string{"rotate", k.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var rotated api.APIKey
+	err = json.Unmarshal(out.Bytes(), &rotated)
+	if err != nil || rotated.ID != k.ID || rotated.Key == k.Key {
+		t.Errorf("Unexpected rotation %v %v", rotated, err)
+	}
+
+	out.Reset()
+	err = runKeys(c, &out, []string{"revoke", k.ID})
+	if err != nil || out.String() != "Revoked "+k.ID+"\n" {
+		t.Errorf("Unexpected revocation %q %v", out.String(), err)
+	}
+
+	err = runKeys(c, &out, []string{"revoke", k.ID})
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a missing key, got %v", err)
+	}
+}
+
+//
+// Test that bogus actions, and tokens, are reported.
+//
+func TestKeysErrors(t *testing.T) {
+	tests := map[string][]string{
+		"no action given":           {},
+		"unknown action 'delete'":   {"delete", "x"},
+		"takes 2 arguments":         {"create", "billing"},
+		"takes 0 arguments":         {"list", "extra"},
+		"Unknown tier 'gold'":       {"create", "billing", "gold"},
+		"the 'rotate' action takes": {"rotate"},
+	}
+
+	c := newKeysClient(t, "admin-secret")
+	for expected, args := range tests {
+		err := runKeys(c, &bytes.Buffer{}, args)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected '%s' for %v, got %v", expected, args, err)
+		}
+	}
+
+	bad := newKeysClient(t, "wrong")
+	err := runKeys(bad, &bytes.Buffer{}, []string{"list"})
+	if err == nil || !strings.Contains(err.Error(), http.StatusText(http.StatusUnauthorized)) {
+		t.Errorf("Expected the token to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+//
+// The `query` subcommand, which performs a lookup locally with the same
+// logic, and options, as our DNS handler.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/api"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// lookupFlags holds the flags shared by our `query` and `bulk`
+// subcommands.
+//
+type lookupFlags struct {
+	// format is the output-format, a table or any format of our API.
+	format *string
+
+	// server is a specific nameserver to query.
+	server *string
+
+	// params are the query-options, named as the parameters of our
+	// DNS handler.
+	params map[string]*string
+}
+
+//
+// addLookupFlags registers our shared flags upon the given set.
+//
+func addLookupFlags(fs *flag.FlagSet) *lookupFlags {
+	l := &lookupFlags{
+		format: fs.String("format", "json", "The output-format, "+formatNames()+"."),
+		server: fs.String("server", "", "The nameserver to query, rather than those in /etc/resolv.conf."),
+		params: make(map[string]*string),
+	}
+
+	l.params["rd"] = fs.String("rd", "", "Set the recursion-desired bit, 0 or 1.")
+	l.params["cd"] = fs.String("cd", "", "Set the checking-disabled bit, 0 or 1.")
+	l.params["do"] = fs.String("do", "", "Set the DNSSEC OK bit, 0 or 1.")
+	l.params["edns"] = fs.String("edns", "", "Send an EDNS OPT record, 0 or 1.")
+	l.params["timeout"] = fs.String("timeout", "", "The timeout for each nameserver, such as 2s.")
+	l.params["class"] = fs.String("class", "", "The query class, IN, CH or HS.")
+	l.params["ecs"] = fs.String("ecs", "", "The EDNS Client Subnet to send, such as 203.0.113.0/24.")
+	return l
+}
+
+//
+// options converts our flags into the options of a query, validating
+// them exactly as our DNS handler would.
+//
+func (l *lookupFlags) options(rs *resolver.Resolver) (resolver.QueryOptions, error) {
+
+	if !validFormat(*l.format) {
+		return resolver.QueryOptions{}, fmt.Errorf("Invalid format '%s' - use %s", *l.format, formatNames())
+	}
+
+	values := url.Values{}
+	for name, val := range l.params {
+		if *val != "" {
+			values.Set(name, *val)
+		}
+	}
+	opts, err := resolver.ParseQueryOptions(values, "")
+	if err != nil {
+		return opts, err
+	}
+
+	//
+	// We're run by the operator, rather than a remote caller, so any
+	// nameserver may be queried.
+	//
+	if *l.server != "" {
+		policy := &resolver.NetworkPolicy{Allow: []string{"0.0.0.0/0", "::/0"}}
+		err = policy.Parse()
+		if err != nil {
+			return opts, err
+		}
+		opts.Server, err = rs.TargetServer(*l.server, policy)
+	}
+	return opts, err
+}
+
+//
+// formatNames returns the names of our output-formats, which are those of
+// our API along with a table.
+//
+func formatNames() string {
+	return api.FormatNames() + "|table"
+}
+
+//
+// validFormat returns true if we can write our answers in the named
+// format.
+//
+func validFormat(name string) bool {
+	for _, f := range strings.Split(formatNames(), "|") {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// lookup performs a single query, validating the type as our DNS handler
+// does, and returns the answers, both as our API's records and as the
+// resource-records they were created from.
+//
+func lookup(rs *resolver.Resolver, qtype string, name string, opts resolver.QueryOptions) ([]map[string]string, []dns.RR, error) {
+
+	qtype = strings.ToUpper(qtype)
+	if _, ok := resolver.StringToType[qtype]; !ok {
+		return nil, nil, errors.New("Invalid lookup-type - use A|AAAA|CNAME|MX|NS|PTR|SOA|TXT")
+	}
+	if opts.Class != dns.ClassINET && qtype != "TXT" {
+		return nil, nil, errors.New("The CH and HS classes may only be used for TXT lookups")
+	}
+	r, err := rs.Query(name, qtype, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resolver.Answers(r), r.Answer, nil
+}
+
+//
+// writeJSON writes the given object, prettily, as our API would.
+//
+func writeJSON(out io.Writer, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "     ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+//
+// writeRecords writes the given answers in the named format of our API,
+// ending with a newline for the benefit of the terminal.
+//
+func writeRecords(out io.Writer, format string, results []map[string]string, rrs []dns.RR) error {
+	var buf bytes.Buffer
+	err := api.WriteRecords(&buf, format, results, rrs)
+	if err != nil {
+		return err
+	}
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	_, err = buf.WriteTo(out)
+	return err
+}
+
+//
+// writeTable writes the given answers as a table, with one row for each.
+//
+func writeTable(out io.Writer, results []map[string]string) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tTTL\tTYPE\tVALUE\n")
+	for _, r := range results {
+
+		//
+		// MX values contain a TAB, between the preference and the
+		// host, which would otherwise break our columns.
+		//
+		value := strings.Replace(r["value"], "\t", " ", -1)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r["name"], r["ttl"], r["type"], value)
+	}
+	return w.Flush()
+}
+
+//
+// runQuery performs the given lookup, and writes the answers to out in
+// the given format.
+//
+func runQuery(rs *resolver.Resolver, out io.Writer, qtype string, name string, opts resolver.QueryOptions, format string) error {
+
+	results, rrs, err := lookup(rs, qtype, name, opts)
+	if err != nil {
+		return err
+	}
+	if len(results) < 1 {
+		return fmt.Errorf("No results for %s %s", strings.ToUpper(qtype), name)
+	}
+
+	if strings.EqualFold(format, "table") {
+		return writeTable(out, results)
+	}
+	return writeRecords(out, format, results, rrs)
+}
+
+//
+// queryMain is our `query` subcommand.
+//
+func queryMain(args []string) int {
+
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: dns-api-go query [flags] TYPE NAME\n\n")
+		fs.PrintDefaults()
+	}
+	l := addLookupFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	rs := resolver.New()
+	opts, err := l.options(rs)
+	if err == nil {
+		err = runQuery(rs, os.Stdout, fs.Arg(0), fs.Arg(1), opts, *l.format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
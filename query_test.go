@@ -0,0 +1,211 @@
+//
+// Test our `query` subcommand.
+//
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+	"github.com/skx/dns-api-go/resolver"
+)
+
+//
+// newTestResolver creates a resolver which sends its queries to a
+// stand-in server, answering from a small zone.
+//
+func newTestResolver(t *testing.T) *resolver.Resolver {
+	addr := dnstest.Start(t, dnstest.Zone(t,
+		"example.test. 60 IN A 192.0.2.1",
+		"example.test. 60 IN MX 10 mail.example.test.",
+		"example.test. 60 IN TXT \"v=spf1 -all\"",
+		"empty.test. 60 IN A 192.0.2.2",
+	))
+
+	rs := resolver.New()
+	rs.Nameservers = []string{addr}
+	return rs
+}
+
+//
+// Test that our lookups are formatted as JSON, as our API would.
+//
+func TestQueryJSON(t *testing.T) {
+	rs := newTestResolver(t)
+
+	var out bytes.Buffer
+	err := runQuery(rs, &out, "mx", "example.test", resolver.DefaultQueryOptions(), "json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expect := `[
+     {
+          "name": "example.test.",
+          "ttl": "60",
+          "type": "MX",
+          "value": "10\tmail.example.test."
+     }
+]
+`
+	if out.String() != expect {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+//
+// Test that our lookups may be formatted as a table.
+//
+func TestQueryTable(t *testing.T) {
+	rs := newTestResolver(t)
+
+	var out bytes.Buffer
+	err := runQuery(rs, &out, "MX", "example.test", resolver.DefaultQueryOptions(), "table")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a header and one row, got:\n%s", out.String())
+	}
+	if strings.Join(strings.Fields(lines[0]), " ") != "NAME TTL TYPE VALUE" {
+		t.Errorf("Unexpected header '%s'", lines[0])
+	}
+	if strings.Join(strings.Fields(lines[1]), " ") != "example.test. 60 MX 10 mail.example.test." {
+		t.Errorf("Unexpected row '%s'", lines[1])
+	}
+}
+
+//
+// Test that our lookups may be written in the other formats of our API.
+//
+func TestQueryFormats(t *testing.T) {
+	rs := newTestResolver(t)
+
+	tests := []struct {
+		Format string
+		Output string
+	}{
+		{"compact", `[{"name":"example.test.","ttl":"60","type":"MX","value":"10\tmail.example.test."}]` + "\n"},
+		{"zone", "example.test.\t60\tIN\tMX\t10 mail.example.test.\n"},
+		{"CSV", "name,ttl,type,value\nexample.test.,60,MX,10\tmail.example.test.\n"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := runQuery(rs, &out, "MX", "example.test", resolver.DefaultQueryOptions(), test.Format)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", test.Format, err)
+		}
+		if out.String() != test.Output {
+			t.Errorf("Unexpected %s output:\n%q", test.Format, out.String())
+		}
+	}
+}
+
+//
+// Test the failures of our lookups.
+//
+func TestQueryErrors(t *testing.T) {
+	rs := newTestResolver(t)
+
+	tests := []struct {
+		Type  string
+		Name  string
+		Class string
+		Error string
+	}{
+		{"FOO", "example.test", "", "Invalid lookup-type"},
+		{"A", "example.test", "CH", "The CH and HS classes may only be used for TXT lookups"},
+		{"A", "missing.test", "", "no such domain missing.test."},
+		{"MX", "empty.test", "", "No results for MX empty.test"},
+	}
+
+	for _, test := range tests {
+		opts := resolver.DefaultQueryOptions()
+		if test.Class != "" {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			l := addLookupFlags(fs)
+			fs.Parse([]string{"-class", test.Class})
+
+			var err error
+			opts, err = l.options(rs)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		}
+
+		var out bytes.Buffer
+		err := runQuery(rs, &out, test.Type, test.Name, opts, "json")
+		if err == nil {
+			t.Errorf("Expected an error for %s %s", test.Type, test.Name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("Unexpected error for %s %s: %s", test.Type, test.Name, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Unexpected output for %s %s: %s", test.Type, test.Name, out.String())
+		}
+	}
+}
+
+//
+// Test that our flags are validated as our DNS handler's parameters are.
+//
+func TestLookupFlags(t *testing.T) {
+	rs := newTestResolver(t)
+
+	tests := []struct {
+		Args  []string
+		Error string
+	}{
+		{[]string{}, ""},
+		{[]string{"-format", "table", "-do", "1", "-timeout", "2s"}, ""},
+		{[]string{"-format", "yaml"}, ""},
+		{[]string{"-server", "192.0.2.53:5353"}, ""},
+		{[]string{"-format", "xml"}, "Invalid format 'xml'"},
+		{[]string{"-do", "yes"}, "Invalid value for 'do'"},
+		{[]string{"-do", "1", "-edns", "0"}, "The DO bit requires EDNS"},
+		{[]string{"-server", "192.0.2.53:none"}, "Invalid server port"},
+	}
+
+	for _, test := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		l := addLookupFlags(fs)
+		err := fs.Parse(test.Args)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %s", test.Args, err)
+		}
+
+		_, err = l.options(rs)
+		if test.Error == "" {
+			if err != nil {
+				t.Errorf("Unexpected error for %v: %s", test.Args, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("Expected error '%s' for %v, got %v", test.Error, test.Args, err)
+		}
+	}
+
+	//
+	// Private nameservers, upon any port, may be queried locally.
+	//
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := addLookupFlags(fs)
+	fs.Parse([]string{"-server", "10.0.0.1:5353"})
+	opts, err := l.options(rs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if opts.Server != "10.0.0.1:5353" {
+		t.Errorf("Unexpected server '%s'", opts.Server)
+	}
+}
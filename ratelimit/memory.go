@@ -0,0 +1,181 @@
+//
+// This file contains our in-memory limiter, which is used when redis
+// isn't available.
+//
+
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxClients is the number of clients a MemoryLimiter will track
+// by default.
+const DefaultMaxClients = 100000
+
+//
+// sweepInterval is how often we look for idle clients to forget.
+//
+const sweepInterval = time.Minute
+
+// MemoryLimiter holds its counters in memory, so they're lost when we
+// restart, and aren't shared with other servers.
+//
+// Each client has a token-bucket per limit, which holds its burst and
+// refills steadily over its period, rather than being reset once per
+// period.  Clients are forgotten once their buckets are full again, so
+// memory is only used for those who've made requests recently.
+type MemoryLimiter struct {
+	// MaxClients is the most buckets we'll track.  If more are active
+	// those idle for longest are forgotten, which forgives any requests
+	// they've made.
+	MaxClients int
+
+	mutex   sync.Mutex
+	clients map[string]*bucket
+	swept   time.Time
+
+	// now returns the current time, and is replaced by our tests.
+	now func() time.Time
+}
+
+//
+// bucket holds the state of one client, for one limit.
+//
+type bucket struct {
+	// used is the number of units counted against the client, which
+	// drain away at the rate of their limit.
+	used float64
+
+	// limit is the limit the client was last checked against.
+	limit Limit
+
+	// seen is when the client last made a request.
+	seen time.Time
+}
+
+//
+// NewMemory creates a limiter which stores its counters in memory.
+//
+func NewMemory() *MemoryLimiter {
+	return &MemoryLimiter{
+		MaxClients: DefaultMaxClients,
+		clients:    make(map[string]*bucket),
+		now:        time.Now,
+	}
+}
+
+// AllowN implements Limiter.
+func (l *MemoryLimiter) AllowN(key string, limit Limit, n int64) (int64, time.Duration, bool) {
+	period := limit.Duration()
+	if period == 0 || limit.Requests < 1 {
+		return 0, time.Hour, false
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	key = key + ":" + limit.Period
+	b, ok := l.clients[key]
+	if !ok {
+		b = &bucket{}
+		l.clients[key] = b
+	}
+	b.drain(now, limit)
+
+	capacity := float64(limit.Capacity())
+	perUnit := float64(period) / float64(limit.Requests)
+	if b.used+float64(n) > capacity {
+		wait := time.Duration((b.used + float64(n) - capacity) * perUnit)
+		return int64(math.Floor(capacity - b.used)), wait, false
+	}
+
+	b.used = math.Max(0, b.used+float64(n))
+	return int64(math.Floor(capacity - b.used)), time.Duration(b.used * perUnit), true
+}
+
+//
+// drain removes the units which have expired since the client was last
+// seen.
+//
+func (b *bucket) drain(now time.Time, limit Limit) {
+	if !b.seen.IsZero() {
+		b.used = math.Max(0, b.used-b.expired(now))
+	}
+	b.limit = limit
+	b.seen = now
+}
+
+//
+// expired returns the number of units which have expired since the
+// client was last seen.
+//
+func (b *bucket) expired(now time.Time) float64 {
+	return float64(now.Sub(b.seen)) / float64(b.limit.Duration()) * float64(b.limit.Requests)
+}
+
+//
+// idle returns true if the client's count has drained to zero, in which
+// case forgetting them makes no difference.
+//
+func (b *bucket) idle(now time.Time) bool {
+	return b.expired(now) >= b.used
+}
+
+//
+// sweep forgets idle clients, once a minute, or if we're tracking too
+// many clients.
+//
+// The lock must be held by the caller.
+//
+func (l *MemoryLimiter) sweep(now time.Time) {
+	full := l.MaxClients > 0 && len(l.clients) >= l.MaxClients
+	if !full && now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+
+	for key, b := range l.clients {
+		if b.idle(now) {
+			delete(l.clients, key)
+		}
+	}
+	if l.MaxClients <= 0 || len(l.clients) < l.MaxClients {
+		return
+	}
+
+	//
+	// We're still full, so forget those seen least recently.  We free
+	// a tenth of our space so that we don't do this upon every request.
+	//
+	keys := make([]string, 0, len(l.clients))
+	for key := range l.clients {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.clients[keys[i]].seen.Before(l.clients[keys[j]].seen)
+	})
+
+	keep := l.MaxClients - l.MaxClients/10 - 1
+	if keep < 0 {
+		keep = 0
+	}
+	for _, key := range keys[:len(keys)-keep] {
+		delete(l.clients, key)
+	}
+}
+
+//
+// Len returns the number of buckets we're tracking.
+//
+func (l *MemoryLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.clients)
+}
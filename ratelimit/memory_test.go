@@ -0,0 +1,159 @@
+//
+// Test our in-memory limiter.
+//
+
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+//
+// newTestLimiter creates a limiter whose clock we control.
+//
+func newTestLimiter() (*MemoryLimiter, *time.Time) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewMemory()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+//
+// Test that clients are limited, and their buckets refill over time.
+//
+func TestMemoryLimiter(t *testing.T) {
+	l, now := newTestLimiter()
+	limit := Limit{Period: "hour", Requests: 3}
+
+	tests := []struct {
+		Advance   time.Duration
+		Cost      int64
+		Remaining int64
+		Delay     time.Duration
+		Allowed   bool
+	}{
+		{0, 1, 2, 20 * time.Minute, true},
+		{0, 1, 1, 40 * time.Minute, true},
+		{0, 1, 0, time.Hour, true},
+		{0, 1, 0, 20 * time.Minute, false},
+		{10 * time.Minute, 1, 0, 10 * time.Minute, false},
+		{10 * time.Minute, 1, 0, time.Hour, true},
+		{0, 1, 0, 20 * time.Minute, false},
+		{2 * time.Hour, 2, 1, 40 * time.Minute, true},
+		{0, 2, 1, 20 * time.Minute, false},
+	}
+
+	for i, test := range tests {
+		*now = now.Add(test.Advance)
+		remaining, delay, allowed := l.AllowN("192.0.2.1", limit, test.Cost)
+		if remaining != test.Remaining || delay != test.Delay || allowed != test.Allowed {
+			t.Errorf("%d: got %d %s %t, expected %d %s %t", i, remaining, delay, allowed, test.Remaining, test.Delay, test.Allowed)
+		}
+	}
+
+	//
+	// Other clients, and other limits, have their own buckets.
+	//
+	remaining, _, allowed := l.AllowN("192.0.2.2", limit, 1)
+	if remaining != 2 || !allowed {
+		t.Errorf("Unexpected result for a second client %d %t", remaining, allowed)
+	}
+	remaining, _, allowed = l.AllowN("192.0.2.1", Limit{Period: "minute", Requests: 5}, 1)
+	if remaining != 4 || !allowed {
+		t.Errorf("Unexpected result for a second limit %d %t", remaining, allowed)
+	}
+
+	_, _, allowed = l.AllowN("192.0.2.3", Limit{Period: "fortnight", Requests: 5}, 1)
+	if allowed {
+		t.Errorf("An invalid limit should allow nothing")
+	}
+}
+
+//
+// Test that bursts are limited, whilst the rate over the period isn't.
+//
+func TestMemoryLimiterBurst(t *testing.T) {
+	l, now := newTestLimiter()
+	limit := Limit{Period: "minute", Requests: 60, Burst: 5}
+
+	for i := 0; i < 5; i++ {
+		_, _, allowed := l.AllowN("192.0.2.1", limit, 1)
+		if !allowed {
+			t.Fatalf("%d: request should be allowed", i)
+		}
+	}
+	_, delay, allowed := l.AllowN("192.0.2.1", limit, 1)
+	if allowed || delay != time.Second {
+		t.Errorf("The burst should be exceeded, got %t %s", allowed, delay)
+	}
+
+	//
+	// A request per second may be made all minute.
+	//
+	for i := 0; i < 60; i++ {
+		*now = now.Add(time.Second)
+		_, _, allowed = l.AllowN("192.0.2.1", limit, 1)
+		if !allowed {
+			t.Fatalf("%d: request should be allowed", i)
+		}
+	}
+}
+
+//
+// Test that idle clients are forgotten.
+//
+func TestMemoryLimiterIdle(t *testing.T) {
+	l, now := newTestLimiter()
+	limit := Limit{Period: "hour", Requests: 60}
+
+	l.AllowN("192.0.2.1", limit, 2)
+	l.AllowN("192.0.2.2", limit, 1)
+	if l.Len() != 2 {
+		t.Fatalf("Expected two clients, got %d", l.Len())
+	}
+
+	//
+	// After a minute the second client's request has expired, but
+	// not both of the first client's.
+	//
+	*now = now.Add(time.Minute)
+	l.AllowN("192.0.2.3", limit, 1)
+	if l.Len() != 2 {
+		t.Errorf("Expected two clients, got %d", l.Len())
+	}
+
+	*now = now.Add(time.Hour)
+	l.AllowN("192.0.2.3", limit, 1)
+	if l.Len() != 1 {
+		t.Errorf("Expected one client, got %d", l.Len())
+	}
+}
+
+//
+// Test that we never track more than our maximum number of clients,
+// forgetting those seen least recently.
+//
+func TestMemoryLimiterMaxClients(t *testing.T) {
+	l, now := newTestLimiter()
+	l.MaxClients = 10
+	limit := Limit{Period: "hour", Requests: 200}
+
+	for i := 0; i < 100; i++ {
+		*now = now.Add(time.Millisecond)
+		l.AllowN(fmt.Sprintf("192.0.2.%d", i), limit, 1)
+		if l.Len() > l.MaxClients {
+			t.Fatalf("Tracking %d clients", l.Len())
+		}
+	}
+
+	_, ok := l.clients["192.0.2.99:hour"]
+	if !ok {
+		t.Errorf("The most recent client should be tracked")
+	}
+	_, ok = l.clients["192.0.2.0:hour"]
+	if ok {
+		t.Errorf("The oldest client should have been forgotten")
+	}
+}
@@ -0,0 +1,208 @@
+//
+// This file contains our rate-limiting policy, which may be supplied by
+// the operator.
+//
+
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// periods are the periods a limit may apply to.
+//
+var periods = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// Limit is the number of units a client may use each period.
+type Limit struct {
+	// Period is the period the limit applies to, one of "second",
+	// "minute", "hour" or "day".
+	Period string `json:"period"`
+
+	// Requests is the number of units which may be used each period.
+	Requests int64 `json:"requests"`
+
+	// Burst is the most units which may be used at once.  It defaults
+	// to, and may not exceed, Requests.
+	Burst int64 `json:"burst"`
+}
+
+// Duration returns the length of the limit's period.
+func (l Limit) Duration() time.Duration {
+	return periods[l.Period]
+}
+
+// Capacity returns the most units which may be used at once.
+func (l Limit) Capacity() int64 {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// Parse validates the limit.
+func (l *Limit) Parse() error {
+	l.Period = strings.ToLower(l.Period)
+	if l.Duration() == 0 {
+		return fmt.Errorf("invalid period '%s' - use second, minute, hour or day", l.Period)
+	}
+	if l.Requests < 1 {
+		return fmt.Errorf("the %s limit must allow at least one request", l.Period)
+	}
+	if l.Burst < 0 || l.Burst > l.Requests {
+		return fmt.Errorf("the burst of the %s limit must be between 1 and %d", l.Period, l.Requests)
+	}
+	return nil
+}
+
+// Policy holds the limits applied to every client, the cost of each of
+// our routes, and the clients who aren't limited.
+//
+// The zero policy limits clients to 200 requests per hour.
+type Policy struct {
+	// Limits are applied to every client, who must be within all of
+	// them for their request to be allowed.
+	Limits []Limit `json:"limits"`
+
+	// Costs are the units used by a request to each route, such as
+	// "propagation", which default to one.  Lookups are "dns", and a
+	// cost of zero means the route isn't limited.
+	Costs map[string]int64 `json:"costs"`
+
+	// Exempt are the networks whose clients aren't limited, either in
+	// CIDR notation or single addresses.
+	Exempt []string `json:"exempt"`
+
+	// The parsed version of Exempt.
+	exempt []*net.IPNet
+}
+
+// DefaultLimits are the limits of a policy which doesn't specify any.
+var DefaultLimits = []Limit{{Period: "hour", Requests: 200}}
+
+// Parse validates the policy, and applies our defaults, which must be
+// done before it is used.
+func (p *Policy) Parse() error {
+
+	if len(p.Limits) == 0 {
+		p.Limits = append([]Limit{}, DefaultLimits...)
+	}
+
+	seen := make(map[string]bool)
+	for i := range p.Limits {
+		err := p.Limits[i].Parse()
+		if err != nil {
+			return err
+		}
+		if seen[p.Limits[i].Period] {
+			return fmt.Errorf("there may only be one %s limit", p.Limits[i].Period)
+		}
+		seen[p.Limits[i].Period] = true
+	}
+
+	//
+	// Sort our limits so the shortest is checked first.
+	//
+	sort.Slice(p.Limits, func(i, j int) bool {
+		return p.Limits[i].Duration() < p.Limits[j].Duration()
+	})
+
+	for route, cost := range p.Costs {
+		if cost < 0 {
+			return fmt.Errorf("the cost of '%s' may not be negative", route)
+		}
+		for _, l := range p.Limits {
+			if cost > l.Capacity() {
+				return fmt.Errorf("the cost of '%s' exceeds the %s limit of %d", route, l.Period, l.Capacity())
+			}
+		}
+	}
+
+	p.exempt = nil
+	for _, entry := range p.Exempt {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid address '%s'", entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid network '%s'", entry)
+		}
+		p.exempt = append(p.exempt, network)
+	}
+	return nil
+}
+
+// Cost returns the units used by a request to the given route.
+func (p *Policy) Cost(route string) int64 {
+	cost, ok := p.Costs[route]
+	if !ok {
+		return 1
+	}
+	return cost
+}
+
+// Exempted returns true if the given client isn't limited.
+func (p *Policy) Exempted(key string) bool {
+	ip := net.ParseIP(key)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.exempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow records a request made by the given client, which uses the given
+// number of units, against each of our limits.
+//
+// It returns the most restrictive limit, which is the first that was
+// exceeded, or that with the fewest units remaining, along with the
+// units remaining, the delay and whether the request is allowed.  A
+// request which isn't allowed isn't counted against any of our limits.
+func (p *Policy) Allow(l Limiter, key string, cost int64) (Limit, int64, time.Duration, bool) {
+	var (
+		limit     Limit
+		remaining int64 = -1
+		delay     time.Duration
+	)
+
+	for i, lim := range p.Limits {
+		left, wait, allowed := l.AllowN(key, lim, cost)
+		if !allowed {
+
+			//
+			// The request isn't made, so it shouldn't count
+			// against the limits which allowed it.
+			//
+			for _, earlier := range p.Limits[:i] {
+				l.AllowN(key, earlier, -cost)
+			}
+			return lim, left, wait, false
+		}
+		if remaining < 0 || left < remaining {
+			limit, remaining, delay = lim, left, wait
+		}
+	}
+	return limit, remaining, delay, true
+}
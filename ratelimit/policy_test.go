@@ -0,0 +1,162 @@
+//
+// Test our rate-limiting policy.
+//
+
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// Test that policies are validated, and our defaults applied.
+//
+func TestPolicyParse(t *testing.T) {
+
+	var p Policy
+	err := p.Parse()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(p.Limits) != 1 || p.Limits[0].Requests != 200 || p.Limits[0].Duration() != time.Hour {
+		t.Errorf("Unexpected default limits %v", p.Limits)
+	}
+	if p.Cost("dns") != 1 {
+		t.Errorf("Unexpected default cost %d", p.Cost("dns"))
+	}
+
+	p = Policy{
+		Limits: []Limit{{Period: "Day", Requests: 1000}, {Period: "second", Requests: 5, Burst: 2}},
+		Costs:  map[string]int64{"propagation": 2, "openapi": 0},
+		Exempt: []string{"10.0.0.0/8", "2001:db8::1"},
+	}
+	err = p.Parse()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if p.Limits[0].Period != "second" || p.Limits[1].Period != "day" {
+		t.Errorf("Limits should be sorted by their period %v", p.Limits)
+	}
+	if p.Cost("propagation") != 2 || p.Cost("openapi") != 0 {
+		t.Errorf("Unexpected costs")
+	}
+
+	tests := []struct {
+		Policy Policy
+		Error  string
+	}{
+		{Policy{Limits: []Limit{{Period: "fortnight", Requests: 1}}}, "invalid period"},
+		{Policy{Limits: []Limit{{Period: "hour"}}}, "must allow at least one request"},
+		{Policy{Limits: []Limit{{Period: "hour", Requests: 5, Burst: 10}}}, "burst of the hour limit"},
+		{Policy{Limits: []Limit{{Period: "hour", Requests: 5}, {Period: "HOUR", Requests: 6}}}, "only be one hour limit"},
+		{Policy{Costs: map[string]int64{"dns": -1}}, "may not be negative"},
+		{Policy{Limits: []Limit{{Period: "minute", Requests: 60, Burst: 2}}, Costs: map[string]int64{"lint": 3}}, "exceeds the minute limit"},
+		{Policy{Exempt: []string{"10.0.0.0/33"}}, "invalid network"},
+		{Policy{Exempt: []string{"example.com"}}, "invalid address"},
+	}
+
+	for _, test := range tests {
+		err = test.Policy.Parse()
+		if err == nil || !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("Expected error '%s', got %v", test.Error, err)
+		}
+	}
+}
+
+//
+// Test that the right clients are exempt.
+//
+func TestPolicyExempted(t *testing.T) {
+	p := Policy{Exempt: []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}}
+	err := p.Parse()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	tests := map[string]bool{
+		"10.1.2.3":        true,
+		"11.0.0.1":        false,
+		"192.0.2.1":       true,
+		"192.0.2.2":       false,
+		"2001:db8::53":    true,
+		"2001:db9::53":    false,
+		"::ffff:10.0.0.1": true,
+		"":                false,
+		"not-an-ip":       false,
+	}
+	for ip, expected := range tests {
+		if p.Exempted(ip) != expected {
+			t.Errorf("Unexpected result for '%s', expected %t", ip, expected)
+		}
+	}
+}
+
+//
+// Test that every limit of a policy is applied, with the most restrictive
+// reported in our headers, and that a rejected request isn't counted
+// against the limits which allowed it.
+//
+func TestCheck(t *testing.T) {
+	l, now := newTestLimiter()
+
+	p := Policy{
+		Limits: []Limit{{Period: "minute", Requests: 3}, {Period: "hour", Requests: 5}},
+		Costs:  map[string]int64{"propagation": 2, "docs": 0},
+		Exempt: []string{"10.0.0.0/8"},
+	}
+	err := p.Parse()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		Advance   time.Duration
+		Route     string
+		Limit     string
+		Remaining string
+		Allowed   bool
+	}{
+		{0, "dns", "3", "2", true},
+		{0, "propagation", "3", "0", true},
+		{0, "dns", "3", "0", false},
+		{time.Minute, "dns", "5", "1", true},
+		{0, "propagation", "5", "1", false},
+		{0, "dns", "5", "0", true},
+		{0, "docs", "", "", true},
+	}
+
+	for i, test := range tests {
+		*now = now.Add(test.Advance)
+		rr := httptest.NewRecorder()
+		ok := Check(l, &p, rr, "192.0.2.1", test.Route)
+
+		if ok != test.Allowed {
+			t.Errorf("%d: unexpected result %t", i, ok)
+		}
+		if rr.Header().Get("X-RateLimit-Limit") != test.Limit {
+			t.Errorf("%d: unexpected limit '%s'", i, rr.Header().Get("X-RateLimit-Limit"))
+		}
+		if rr.Header().Get("X-RateLimit-Remaining") != test.Remaining {
+			t.Errorf("%d: unexpected remaining '%s'", i, rr.Header().Get("X-RateLimit-Remaining"))
+		}
+		if !ok && rr.Code != 429 {
+			t.Errorf("%d: unexpected status-code %d", i, rr.Code)
+		}
+	}
+
+	//
+	// Exempt clients are never limited.
+	//
+	for i := 0; i < 10; i++ {
+		rr := httptest.NewRecorder()
+		if !Check(l, &p, rr, "10.0.0.1", "dns") {
+			t.Fatalf("%d: an exempt client was limited", i)
+		}
+		if rr.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("Unexpected headers for an exempt client")
+		}
+	}
+}
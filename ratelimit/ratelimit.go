@@ -0,0 +1,72 @@
+//
+// Package ratelimit limits the number of requests each client may make
+// to our API.
+//
+// The counters may be held in redis, which allows them to be shared by
+// several servers, or in memory for a single server.
+//
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limiter counts the requests made by each client.
+//
+// It is implemented by RedisLimiter and MemoryLimiter.
+type Limiter interface {
+	// AllowN records a request made by the given client, which uses
+	// n units of the given limit.  It returns the number of units
+	// remaining, the time until they're all available again, or
+	// until the request may be retried if it isn't allowed, and
+	// whether the request is within the limit.
+	//
+	// A request which isn't allowed uses no units, and a negative n
+	// returns units which were used, so that a request may be refunded
+	// when another limit rejects it.
+	AllowN(key string, limit Limit, n int64) (int64, time.Duration, bool)
+}
+
+//
+// Check applies our policy to a request made by a client, to the given
+// route, and sets the X-RateLimit headers upon the response.
+//
+// If the limit has been exceeded the caller is told so, and we return
+// false.
+//
+func Check(l Limiter, p *Policy, res http.ResponseWriter, key string, route string) bool {
+
+	//
+	// Some clients, and routes, aren't limited.
+	//
+	cost := p.Cost(route)
+	if cost == 0 || p.Exempted(key) {
+		return true
+	}
+
+	//
+	// Lookup the current stats.
+	//
+	limit, remaining, delay, allowed := p.Allow(l, key, cost)
+
+	//
+	// We'll return the rate-limit headers to the caller.
+	//
+	h := res.Header()
+	h.Set("X-RateLimit-Limit", strconv.FormatInt(limit.Requests, 10))
+	h.Set("X-RateLimit-IP", key)
+	h.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	delaySec := int64((delay + time.Second - 1) / time.Second)
+	h.Set("X-RateLimit-Delay", strconv.FormatInt(delaySec, 10))
+
+	//
+	// If the limit has been exceeded tell the client.
+	//
+	if !allowed {
+		http.Error(res, "API rate limit exceeded.", 429)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+//
+// This file contains our redis-based limiter.
+//
+
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/go-redis/redis_rate"
+)
+
+// RedisLimiter holds its counters in redis, so that they may be shared
+// by several servers.
+//
+// Each limit is counted in fixed windows of its period.  A burst is
+// enforced by a second window, of the time it takes to earn the burst.
+type RedisLimiter struct {
+	limiter *redis_rate.Limiter
+}
+
+//
+// NewRedis creates a limiter which stores its counters in the given
+// redis ring.
+//
+func NewRedis(ring *redis.Ring) *RedisLimiter {
+	return &RedisLimiter{limiter: redis_rate.NewLimiter(ring)}
+}
+
+// AllowN implements Limiter.
+func (l *RedisLimiter) AllowN(key string, limit Limit, n int64) (int64, time.Duration, bool) {
+	period := limit.Duration()
+	if period == 0 || limit.Requests < 1 {
+		return 0, time.Hour, false
+	}
+
+	//
+	// The counters are named after the period, as redis_rate doesn't
+	// include it in their names.
+	//
+	name := key + ":" + limit.Period
+	count, delay, allowed := l.limiter.AllowN(name, limit.Requests, period, n)
+	remaining := limit.Requests - count
+
+	window := period * time.Duration(limit.Capacity()) / time.Duration(limit.Requests)
+	burst := limit.Capacity() < limit.Requests && window >= time.Second
+	if burst {
+		used, burstDelay, burstAllowed := l.limiter.AllowN(name+":burst", limit.Capacity(), window, n)
+		if limit.Capacity()-used < remaining {
+			remaining = limit.Capacity() - used
+		}
+		if allowed && !burstAllowed {
+			allowed, delay = false, burstDelay
+		}
+	}
+
+	//
+	// redis_rate counts requests even if they exceed the limit, so
+	// we take back those which weren't allowed.
+	//
+	if !allowed && n > 0 {
+		l.limiter.AllowN(name, limit.Requests, period, -n)
+		if burst {
+			l.limiter.AllowN(name+":burst", limit.Capacity(), window, -n)
+		}
+	}
+
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, delay, allowed
+}
@@ -0,0 +1,131 @@
+//
+// This file contains our zone-transfer support, which allows the
+// contents of a zone to be retrieved via AXFR, or the changes since
+// a given serial via IXFR.
+//
+
+package resolver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// maxTransferRecords is the largest zone we'll return to a caller.
+//
+var maxTransferRecords = 100000
+
+//
+// transferTimeout bounds each read of the transfer.
+//
+var transferTimeout = 10 * time.Second
+
+// TransferZone is a zone which may be transferred, along with the
+// servers it may be transferred from and the TSIG key to use.
+type TransferZone struct {
+	// Zone is the name of the zone.
+	Zone string `json:"zone"`
+
+	// Servers, if non-empty, are the only servers the zone may be
+	// transferred from.  The first is used by default.
+	Servers []string `json:"servers"`
+
+	// KeyName, Algorithm & Secret configure an optional TSIG key.
+	KeyName   string `json:"key_name"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret"`
+}
+
+// Parse validates a transfer-zone, and converts the names it contains to
+// their canonical form.
+func (z *TransferZone) Parse() error {
+
+	if _, ok := dns.IsDomainName(z.Zone); !ok || z.Zone == "" {
+		return fmt.Errorf("'%s' is not a valid zone", z.Zone)
+	}
+	z.Zone = dns.Fqdn(strings.ToLower(z.Zone))
+
+	for i, server := range z.Servers {
+		addr, err := ServerAddress(server)
+		if err != nil {
+			return err
+		}
+		z.Servers[i] = addr
+	}
+
+	if z.KeyName == "" {
+		return nil
+	}
+	z.KeyName = dns.Fqdn(strings.ToLower(z.KeyName))
+	if z.Algorithm == "" {
+		z.Algorithm = dns.HmacSHA256
+	}
+	z.Algorithm = dns.Fqdn(strings.ToLower(z.Algorithm))
+	switch z.Algorithm {
+	case dns.HmacSHA1, dns.HmacSHA256, dns.HmacSHA512:
+	default:
+		return fmt.Errorf("unsupported TSIG algorithm '%s'", z.Algorithm)
+	}
+	if _, err := base64.StdEncoding.DecodeString(z.Secret); err != nil || z.Secret == "" {
+		return fmt.Errorf("the TSIG secret for '%s' is not valid base64", z.KeyName)
+	}
+	return nil
+}
+
+// Transfer performs a zone-transfer of the given zone, from the given
+// "host:port", returning the records received.
+//
+// If serial is non-zero an IXFR is made, asking for the changes since
+// that serial, otherwise an AXFR is made.
+func Transfer(zone *TransferZone, server string, serial uint32) ([]dns.RR, error) {
+
+	m := new(dns.Msg)
+	if serial > 0 {
+		m.SetIxfr(zone.Zone, serial, ".", ".")
+	} else {
+		m.SetAxfr(zone.Zone)
+	}
+
+	t := &dns.Transfer{
+		DialTimeout:  transferTimeout,
+		ReadTimeout:  transferTimeout,
+		WriteTimeout: transferTimeout,
+	}
+	if zone.KeyName != "" {
+		t.TsigSecret = map[string]string{zone.KeyName: zone.Secret}
+		m.SetTsig(zone.KeyName, zone.Algorithm, 300, time.Now().Unix())
+	}
+
+	ch, err := t.In(m, server)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []dns.RR
+	for env := range ch {
+		if env.Error != nil {
+			return nil, env.Error
+		}
+		records = append(records, env.RR...)
+		if len(records) > maxTransferRecords {
+
+			//
+			// The transfer is abandoned, but its goroutine
+			// won't finish until we've read what it sends.
+			//
+			t.Close()
+			for range ch {
+			}
+			return nil, fmt.Errorf("The zone %s has more than %d records", zone.Zone, maxTransferRecords)
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("The transfer of %s returned no records", zone.Zone)
+	}
+	return records, nil
+}
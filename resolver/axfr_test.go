@@ -0,0 +1,112 @@
+//
+// Test our zone-transfer support.
+//
+
+package resolver
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test that AXFR and IXFR transfers work, and require the right key.
+//
+func TestTransfer(t *testing.T) {
+
+	addr := dnstest.StartPrimary(t)
+
+	zone := &TransferZone{Zone: "example.test", KeyName: "Transfer.Key", Secret: dnstest.Secret}
+	if err := zone.Parse(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	records, err := Transfer(zone, addr, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(records) != 5 {
+		t.Errorf("Unexpected AXFR records: %v", records)
+	}
+
+	records, err = Transfer(zone, addr, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(records) != 6 || !strings.Contains(records[2].String(), "192.0.2.1") {
+		t.Errorf("Unexpected IXFR records: %v", records)
+	}
+
+	//
+	// Without the key we're refused.
+	//
+	unsigned := &TransferZone{Zone: "example.test"}
+	if err := unsigned.Parse(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	_, err = Transfer(unsigned, addr, 0)
+	if err == nil {
+		t.Errorf("Expected an error transferring without a key")
+	}
+}
+
+//
+// Test that a transfer which is too large is abandoned, without leaking
+// the goroutine which reads it.
+//
+func TestTransferTooLarge(t *testing.T) {
+
+	oldMax := maxTransferRecords
+	maxTransferRecords = 1
+	defer func() { maxTransferRecords = oldMax }()
+
+	//
+	// Our server sends the zone in several messages.
+	//
+	soa, _ := dns.NewRR("example.test. 300 IN SOA ns1.example.test. hostmaster.example.test. 1 3600 600 86400 60")
+	ns, _ := dns.NewRR("example.test. 300 IN NS ns1.example.test.")
+	a, _ := dns.NewRR("www.example.test. 300 IN A 192.0.2.1")
+	handler := func(w dns.ResponseWriter, req *dns.Msg) {
+		for _, rrs := range [][]dns.RR{{soa, ns}, {a}, {a}, {soa}} {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.Answer = rrs
+			w.WriteMsg(m)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dns.Server{Listener: l, Handler: dns.HandlerFunc(handler)}
+	started := make(chan bool)
+	s.NotifyStartedFunc = func() { close(started) }
+	go s.ActivateAndServe()
+	<-started
+	defer s.Shutdown()
+
+	zone := &TransferZone{Zone: "example.test"}
+	if err = zone.Parse(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	before := runtime.NumGoroutine()
+	_, err = Transfer(zone, l.Addr().String(), 0)
+	if err == nil || !strings.Contains(err.Error(), "more than 1 records") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("%d goroutines are running, rather than %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
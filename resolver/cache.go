@@ -0,0 +1,95 @@
+//
+// This file contains a simple cache, whose entries expire after a
+// per-entry time-to-live, which we use to avoid repeating DNS lookups
+// that we know the answer to.
+//
+
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// ttlCache is a size-limited map of entries which expire.
+//
+type ttlCache struct {
+	sync.Mutex
+
+	// max is the maximum number of entries we'll hold.
+	max int
+
+	// entries holds the cached values.
+	entries map[string]cacheEntry
+}
+
+//
+// cacheEntry is a single cached value, and the time it expires.
+//
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+//
+// newTTLCache creates a cache which holds at most max entries.
+//
+func newTTLCache(max int) *ttlCache {
+	return &ttlCache{
+		max:     max,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+//
+// Get returns the cached value of the given key, if it hasn't expired,
+// and the time left until it does.
+//
+func (c *ttlCache) Get(key string) (interface{}, time.Duration, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	left := time.Until(entry.expires)
+	if left <= 0 {
+		delete(c.entries, key)
+		return nil, 0, false
+	}
+	return entry.value, left, true
+}
+
+//
+// Set stores a value in the cache for the given duration.
+//
+// If the cache is full expired entries are removed, and if that doesn't
+// free any space an arbitrary entry is discarded.
+//
+func (c *ttlCache) Set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.max {
+		now := time.Now()
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.max {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = cacheEntry{value: value, expires: time.Now().Add(ttl)}
+}
@@ -0,0 +1,465 @@
+//
+// This file contains our consistency-check, which queries every
+// authoritative nameserver of a zone directly and reports upon any
+// disagreements between them.
+//
+// Secondaries which lag behind their primary are the most common
+// problem we expect to find.
+//
+
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// The status of each nameserver address we query.
+//
+const (
+	serverOK          = "ok"
+	serverLame        = "lame"
+	serverUnreachable = "unreachable"
+	serverNotQueried  = "not queried"
+)
+
+//
+// consistencyTimeout is how long we wait for each authoritative server.
+//
+var consistencyTimeout = 3 * time.Second
+
+//
+// The nameservers are chosen by whoever controls the zone, so we limit
+// how many of them, and their addresses, we'll query, and how many of
+// those queries may be in flight at once.
+//
+var (
+	maxConsistencyHosts     = 10
+	maxConsistencyAddresses = 20
+	consistencyWorkers      = 5
+)
+
+//
+// parentTimeout bounds the time we spend asking the servers of the parent
+// zone for its delegation.
+//
+var parentTimeout = 10 * time.Second
+
+// ConsistencyReport is the result of checking the nameservers of a zone.
+type ConsistencyReport struct {
+	// Zone is the zone we checked.
+	Zone string `json:"zone"`
+
+	// Type is the additional record-type we compared, if any.
+	Type string `json:"type,omitempty"`
+
+	// ParentNS holds the nameservers the parent zone delegates to.
+	ParentNS []string `json:"parent_ns"`
+
+	// ChildNS holds the nameservers the zone itself lists.
+	ChildNS []string `json:"child_ns"`
+
+	// Servers holds the results from each nameserver address.
+	Servers []ServerReport `json:"servers"`
+
+	// Problems is a human-readable list of the problems found.
+	Problems []string `json:"problems"`
+
+	// Consistent is true if no problems were found.
+	Consistent bool `json:"consistent"`
+}
+
+// ServerReport holds the results of querying a single address of a
+// single nameserver.
+type ServerReport struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Status  string   `json:"status"`
+	Serial  uint32   `json:"serial,omitempty"`
+	Answers []string `json:"answers,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// CheckConsistency queries each authoritative nameserver of the given
+// zone, and compares their SOA serials and, if qtype is non-empty, their
+// answers for that record-type.
+//
+// The nameservers are chosen by whoever controls the zone, so only the
+// addresses permitted by the policy are queried, and the others are
+// reported as not queried.  So are those beyond the first
+// maxConsistencyHosts nameservers, or maxConsistencyAddresses addresses.
+func (rs *Resolver) CheckConsistency(zone string, qtype string, policy *NetworkPolicy) (*ConsistencyReport, error) {
+
+	zone = dns.Fqdn(strings.ToLower(zone))
+	if zone == "." {
+		return nil, errors.New("The root zone cannot be checked")
+	}
+
+	report := &ConsistencyReport{
+		Zone:     zone,
+		Type:     qtype,
+		Problems: []string{},
+	}
+
+	//
+	// Find the nameservers according to the parent, and the child.
+	//
+	var err error
+	report.ParentNS, err = rs.parentNameservers(zone, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := rs.Query(zone, "NS", DefaultQueryOptions())
+	if err == nil {
+		report.ChildNS = nsNames(r.Answer, zone)
+	}
+
+	//
+	// Any disagreement there is worth noting.
+	//
+	for _, ns := range report.ParentNS {
+		if !containsString(report.ChildNS, ns) {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s is listed by the parent zone, but not by %s", ns, zone))
+		}
+	}
+	for _, ns := range report.ChildNS {
+		if !containsString(report.ParentNS, ns) {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s is listed by %s, but not by the parent zone", ns, zone))
+		}
+	}
+
+	//
+	// Now find the addresses of every nameserver mentioned by either.
+	//
+	seen := make(map[string]bool)
+	for i, ns := range mergeStrings(report.ParentNS, report.ChildNS) {
+		if i >= maxConsistencyHosts {
+			report.Servers = append(report.Servers, ServerReport{
+				Name:   ns,
+				Status: serverNotQueried,
+				Error:  fmt.Sprintf("No more than %d nameservers are checked", maxConsistencyHosts),
+			})
+			continue
+		}
+
+		addrs := rs.HostAddresses(ns)
+		if len(addrs) == 0 {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s has no addresses", ns))
+		}
+		for _, ip := range addrs {
+			s := ServerReport{Name: ns, Address: ip.String()}
+			if err := rs.allowedServer(ip, policy); err != nil {
+				s.Status = serverNotQueried
+				s.Error = err.Error()
+			} else if !seen[s.Address] && len(seen) >= maxConsistencyAddresses {
+				s.Status = serverNotQueried
+				s.Error = fmt.Sprintf("No more than %d addresses are queried", maxConsistencyAddresses)
+			} else {
+				seen[s.Address] = true
+			}
+			report.Servers = append(report.Servers, s)
+		}
+	}
+
+	//
+	// Query each address once, even if several nameservers share it,
+	// with a few queries in flight at a time.
+	//
+	first := make(map[string]*ServerReport)
+	jobs := make(chan *ServerReport)
+	var wg sync.WaitGroup
+	for i := 0; i < consistencyWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				rs.checkServer(s, zone, qtype)
+			}
+		}()
+	}
+	for i := range report.Servers {
+		s := &report.Servers[i]
+		if s.Status == serverNotQueried || first[s.Address] != nil {
+			continue
+		}
+		first[s.Address] = s
+		jobs <- s
+	}
+	close(jobs)
+	wg.Wait()
+
+	//
+	// The other nameservers with the same address share its results.
+	//
+	for i := range report.Servers {
+		s := &report.Servers[i]
+		if f := first[s.Address]; f != nil && f != s {
+			name := s.Name
+			*s = *f
+			s.Name = name
+		}
+	}
+
+	report.Problems = append(report.Problems, compareServers(report)...)
+	report.Consistent = len(report.Problems) == 0
+	return report, nil
+}
+
+//
+// parentNameservers finds the parent zone of the given zone, and asks its
+// servers for the names of the nameservers it delegates our zone to.
+//
+func (rs *Resolver) parentNameservers(zone string, policy *NetworkPolicy) ([]string, error) {
+	resp, err := rs.parentDelegation(zone, policy)
+	if err != nil {
+		return nil, err
+	}
+	return nsNames(append(resp.Answer, resp.Ns...), zone), nil
+}
+
+//
+// parentDelegation finds the parent zone of the given zone, and asks its
+// servers for the delegation of our zone, returning the first response
+// which contains one.
+//
+// Only the addresses of the parent servers permitted by the policy are
+// queried, each of them once, and we give up after parentTimeout.
+//
+func (rs *Resolver) parentDelegation(zone string, policy *NetworkPolicy) (*dns.Msg, error) {
+
+	labels := dns.SplitDomainName(zone)
+
+	//
+	// Walk up the tree until we find the enclosing zone, which is the
+	// first name to have its own nameservers.
+	//
+	for i := 1; i <= len(labels); i++ {
+		parent := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		r, err := rs.Query(parent, "NS", DefaultQueryOptions())
+		if err != nil {
+			continue
+		}
+		hosts := nsNames(r.Answer, parent)
+		if len(hosts) == 0 {
+			continue
+		}
+
+		//
+		// Ask the parent servers, non-recursively, for the
+		// delegation.  The first to give us one wins.
+		//
+		opts := DefaultQueryOptions()
+		opts.Recursion = false
+		opts.Timeout = consistencyTimeout
+
+		deadline := time.Now().Add(parentTimeout)
+		tried := make(map[string]bool)
+		for _, host := range hosts {
+			for _, ip := range rs.HostAddresses(host) {
+				if tried[ip.String()] || rs.allowedServer(ip, policy) != nil {
+					continue
+				}
+				tried[ip.String()] = true
+
+				left := time.Until(deadline)
+				if left <= 0 {
+					return nil, fmt.Errorf("Timed out asking the servers of %s for the delegation of %s", parent, zone)
+				}
+				if left < opts.Timeout {
+					opts.Timeout = left
+				}
+
+				var resp *dns.Msg
+				resp, err = Exchange(net.JoinHostPort(ip.String(), rs.Port), zone, dns.TypeNS, opts)
+				if err != nil || resp == nil {
+					continue
+				}
+				if len(nsNames(append(resp.Answer, resp.Ns...), zone)) > 0 {
+					return resp, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("The parent zone %s has no delegation for %s", parent, zone)
+	}
+	return nil, fmt.Errorf("Failed to find the parent zone of %s", zone)
+}
+
+//
+// allowedServer returns an error if the policy doesn't permit us to query
+// the nameserver with the given address.
+//
+func (rs *Resolver) allowedServer(ip net.IP, policy *NetworkPolicy) error {
+	port, err := strconv.Atoi(rs.Port)
+	if err != nil {
+		return fmt.Errorf("Invalid server port '%s'", rs.Port)
+	}
+	return policy.AllowedTarget(ip, port)
+}
+
+//
+// checkServer queries a single nameserver address for the SOA record of
+// the zone, and optionally the given type, updating the report.
+//
+func (rs *Resolver) checkServer(s *ServerReport, zone string, qtype string) {
+
+	opts := DefaultQueryOptions()
+	opts.Recursion = false
+	opts.Timeout = consistencyTimeout
+
+	addr := net.JoinHostPort(s.Address, rs.Port)
+
+	r, err := Exchange(addr, zone, dns.TypeSOA, opts)
+	if err != nil || r == nil {
+		s.Status = serverUnreachable
+		if err != nil {
+			s.Error = err.Error()
+		}
+		return
+	}
+
+	//
+	// A server which isn't authoritative for the zone is lame.
+	//
+	if r.Rcode != dns.RcodeSuccess || !r.Authoritative {
+		s.Status = serverLame
+		s.Error = fmt.Sprintf("Non-authoritative %s response", dns.RcodeToString[r.Rcode])
+		return
+	}
+	for _, rr := range r.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			s.Serial = soa.Serial
+		}
+	}
+	if s.Serial == 0 {
+		s.Status = serverLame
+		s.Error = "No SOA record was returned"
+		return
+	}
+	s.Status = serverOK
+
+	if qtype == "" {
+		return
+	}
+
+	r, err = Exchange(addr, zone, StringToType[qtype], opts)
+	if err != nil || r == nil {
+		s.Status = serverUnreachable
+		if err != nil {
+			s.Error = err.Error()
+		}
+		return
+	}
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == StringToType[qtype] {
+			s.Answers = append(s.Answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	sort.Strings(s.Answers)
+}
+
+//
+// compareServers looks for disagreements between the servers which
+// responded, and for those that didn't.
+//
+func compareServers(report *ConsistencyReport) []string {
+
+	var problems []string
+
+	serials := make(map[uint32][]string)
+	answers := make(map[string][]string)
+
+	for _, s := range report.Servers {
+		name := fmt.Sprintf("%s/%s", s.Name, s.Address)
+
+		switch s.Status {
+		case serverNotQueried:
+			continue
+		case serverOK:
+			serials[s.Serial] = append(serials[s.Serial], name)
+			key := strings.Join(s.Answers, "\n")
+			answers[key] = append(answers[key], name)
+		default:
+			problems = append(problems, fmt.Sprintf("%s is %s: %s", name, s.Status, s.Error))
+		}
+	}
+
+	if len(serials) > 1 {
+		var details []string
+		for serial, names := range serials {
+			details = append(details, fmt.Sprintf("%d (%s)", serial, strings.Join(names, ", ")))
+		}
+		sort.Strings(details)
+		problems = append(problems, "SOA serials differ: "+strings.Join(details, "; "))
+	}
+
+	if report.Type != "" && len(answers) > 1 {
+		var details []string
+		for key, names := range answers {
+			if key == "" {
+				key = "no records"
+			}
+			details = append(details, fmt.Sprintf("[%s] (%s)", strings.Replace(key, "\n", ", ", -1), strings.Join(names, ", ")))
+		}
+		sort.Strings(details)
+		problems = append(problems, fmt.Sprintf("%s records differ: %s", report.Type, strings.Join(details, "; ")))
+	}
+	return problems
+}
+
+//
+// nsNames returns the sorted, lower-cased, targets of the NS records in
+// the given list which belong to the given name.
+//
+func nsNames(rrs []dns.RR, name string) []string {
+	var names []string
+
+	for _, rr := range rrs {
+		ns, ok := rr.(*dns.NS)
+		if !ok || !strings.EqualFold(ns.Hdr.Name, name) {
+			continue
+		}
+		host := strings.ToLower(ns.Ns)
+		if !containsString(names, host) {
+			names = append(names, host)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+//
+// containsString tests whether the given string is in the list.
+//
+func containsString(list []string, str string) bool {
+	for _, entry := range list {
+		if entry == str {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// mergeStrings returns the sorted union of the two lists.
+//
+func mergeStrings(a []string, b []string) []string {
+	var out []string
+	for _, entry := range append(append([]string{}, a...), b...) {
+		if !containsString(out, entry) {
+			out = append(out, entry)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,198 @@
+//
+// Test our consistency-checking of a zone's nameservers.
+//
+
+package resolver
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test a zone with a lagging secondary, and a broken delegation.
+//
+func TestCheckConsistency(t *testing.T) {
+
+	oldTimeout := consistencyTimeout
+	consistencyTimeout = time.Second
+	defer func() { consistencyTimeout = oldTimeout }()
+
+	//
+	// Our resolver knows the parent zone, the child's view of its
+	// nameservers, and the addresses of them all.
+	//
+	resolver := dnstest.Start(t, dnstest.Zone(t,
+		"test. 60 IN NS a.nic.test.",
+		"a.nic.test. 60 IN A 127.0.0.2",
+		"example.test. 60 IN NS ns1.example.test.",
+		"example.test. 60 IN NS ns3.example.test.",
+		"ns1.example.test. 60 IN A 127.0.0.3",
+		"ns2.example.test. 60 IN A 127.0.0.4",
+		"ns3.example.test. 60 IN A 127.0.0.5",
+	))
+	rs := newTestResolver(resolver)
+
+	//
+	// The parent delegates to ns1 & ns2, which disagree about the
+	// serial and the address of the zone.  ns3 doesn't respond.
+	//
+	parent := func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		for _, ns := range []string{"ns1.example.test.", "ns2.example.test."} {
+			m.Ns = append(m.Ns, &dns.NS{Hdr: dns.RR_Header{Name: "example.test.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: ns})
+		}
+		w.WriteMsg(m)
+	}
+
+	rs.Port = dnstest.StartAuthoritative(t, map[string]dns.HandlerFunc{
+		"127.0.0.2": parent,
+		"127.0.0.3": dnstest.Zone(t,
+			"example.test. 60 IN SOA ns1.example.test. hostmaster.example.test. 5 3600 600 86400 60",
+			"example.test. 60 IN A 192.0.2.1"),
+		"127.0.0.4": dnstest.Zone(t,
+			"example.test. 60 IN SOA ns1.example.test. hostmaster.example.test. 4 3600 600 86400 60",
+			"example.test. 60 IN A 192.0.2.2"),
+	})
+
+	report, err := rs.CheckConsistency("Example.TEST", "A", allowPolicy(t, "127.0.0.0/8"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if strings.Join(report.ParentNS, ",") != "ns1.example.test.,ns2.example.test." {
+		t.Errorf("Unexpected parent NS: %v", report.ParentNS)
+	}
+	if strings.Join(report.ChildNS, ",") != "ns1.example.test.,ns3.example.test." {
+		t.Errorf("Unexpected child NS: %v", report.ChildNS)
+	}
+	if len(report.Servers) != 3 {
+		t.Fatalf("Unexpected servers: %v", report.Servers)
+	}
+	if report.Consistent {
+		t.Errorf("The zone should not be consistent")
+	}
+
+	expected := []string{
+		"ns2.example.test. is listed by the parent zone, but not by example.test.",
+		"ns3.example.test. is listed by example.test., but not by the parent zone",
+		"ns3.example.test./127.0.0.5 is unreachable",
+		"SOA serials differ: 4 (ns2.example.test./127.0.0.4); 5 (ns1.example.test./127.0.0.3)",
+		"A records differ: [192.0.2.1] (ns1.example.test./127.0.0.3); [192.0.2.2] (ns2.example.test./127.0.0.4)",
+	}
+	all := strings.Join(report.Problems, "\n")
+	for _, e := range expected {
+		if !strings.Contains(all, e) {
+			t.Errorf("Missing problem '%s' in:\n%s", e, all)
+		}
+	}
+	if len(report.Problems) != len(expected) {
+		t.Errorf("Unexpected problem count:\n%s", all)
+	}
+
+	//
+	// Only the servers our policy allows are queried, so reserved
+	// addresses can't be probed.
+	//
+	report, err = rs.CheckConsistency("example.test", "A", allowPolicy(t, "127.0.0.2", "127.0.0.4"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	statuses := make(map[string]string)
+	for _, s := range report.Servers {
+		statuses[s.Address] = s.Status
+	}
+	if statuses["127.0.0.3"] != serverNotQueried || statuses["127.0.0.4"] != serverOK || statuses["127.0.0.5"] != serverNotQueried {
+		t.Errorf("Unexpected servers: %v", report.Servers)
+	}
+	if len(report.Problems) != 2 {
+		t.Errorf("Unexpected problems: %v", report.Problems)
+	}
+
+	_, err = rs.CheckConsistency("example.test", "A", allowPolicy(t))
+	if err == nil || !strings.Contains(err.Error(), "no delegation") {
+		t.Errorf("Expected the parent not to be queried, got %v", err)
+	}
+}
+
+//
+// Test that each address is only queried once, however many nameservers
+// share it, and that only so many nameservers are checked.
+//
+func TestCheckConsistencyLimits(t *testing.T) {
+
+	oldHosts := maxConsistencyHosts
+	maxConsistencyHosts = 2
+	defer func() { maxConsistencyHosts = oldHosts }()
+
+	resolver := dnstest.Start(t, dnstest.Zone(t,
+		"test. 60 IN NS a.nic.test.",
+		"a.nic.test. 60 IN A 127.0.0.2",
+		"example.test. 60 IN NS ns1.example.test.",
+		"example.test. 60 IN NS ns2.example.test.",
+		"example.test. 60 IN NS ns3.example.test.",
+		"ns1.example.test. 60 IN A 127.0.0.3",
+		"ns2.example.test. 60 IN A 127.0.0.3",
+		"ns3.example.test. 60 IN A 127.0.0.3",
+	))
+	rs := newTestResolver(resolver)
+
+	var lock sync.Mutex
+	queries := 0
+	zone := dnstest.Zone(t,
+		"example.test. 60 IN SOA ns1.example.test. hostmaster.example.test. 5 3600 600 86400 60",
+		"example.test. 60 IN NS ns1.example.test.",
+		"example.test. 60 IN NS ns2.example.test.",
+		"example.test. 60 IN NS ns3.example.test.")
+	rs.Port = dnstest.StartAuthoritative(t, map[string]dns.HandlerFunc{
+		"127.0.0.2": zone,
+		"127.0.0.3": func(w dns.ResponseWriter, req *dns.Msg) {
+			lock.Lock()
+			queries++
+			lock.Unlock()
+			zone(w, req)
+		},
+	})
+
+	report, err := rs.CheckConsistency("example.test", "", allowPolicy(t, "127.0.0.0/8"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !report.Consistent || len(report.Servers) != 3 {
+		t.Fatalf("Unexpected report: %v %v", report.Servers, report.Problems)
+	}
+	for i, status := range []string{serverOK, serverOK, serverNotQueried} {
+		s := report.Servers[i]
+		if s.Status != status {
+			t.Errorf("Unexpected status of %s: %s", s.Name, s.Status)
+		}
+		if status == serverOK && s.Serial != 5 {
+			t.Errorf("Unexpected serial of %s: %d", s.Name, s.Serial)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if queries != 1 {
+		t.Errorf("The shared address was queried %d times", queries)
+	}
+}
+
+//
+// allowPolicy returns a policy which only allows the given networks, or
+// our defaults if there are none.
+//
+func allowPolicy(t *testing.T, networks ...string) *NetworkPolicy {
+	p := &NetworkPolicy{Allow: networks}
+	err := p.Parse()
+	if err != nil {
+		t.Fatalf("Invalid policy: %s", err)
+	}
+	return p
+}
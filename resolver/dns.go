@@ -0,0 +1,460 @@
+//
+// Perform a DNS query of a given name & type and return an array of
+// maps with suitable results.
+//
+
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// StringToType is a map of DNS query-types, we only cover the few we
+// care about.
+//
+var StringToType = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"NS":    dns.TypeNS,
+	"PTR":   dns.TypePTR,
+	"SOA":   dns.TypeSOA,
+	"TXT":   dns.TypeTXT,
+}
+
+//
+// The bounds we allow for a caller-supplied query timeout.
+//
+const (
+	minQueryTimeout = 100 * time.Millisecond
+	maxQueryTimeout = 10 * time.Second
+)
+
+//
+// The prefix-lengths we truncate a client's own address to, when they
+// ask us to derive an EDNS Client Subnet from it, as RFC 7871 suggests.
+//
+const (
+	clientSubnetIPv4 = 24
+	clientSubnetIPv6 = 56
+)
+
+// QueryOptions holds the settings which control how a single query is
+// sent to the upstream nameservers.
+//
+// The zero-value isn't useful, use DefaultQueryOptions to get the
+// settings we've historically used.
+type QueryOptions struct {
+	// Recursion sets the RD (recursion desired) bit.
+	Recursion bool
+
+	// CheckingDisabled sets the CD bit, asking a validating resolver
+	// to return data even if DNSSEC validation fails.
+	CheckingDisabled bool
+
+	// DNSSEC sets the DO bit, asking for signatures to be returned.
+	DNSSEC bool
+
+	// EDNS controls whether we add an OPT record to the query.  It is
+	// implied by DNSSEC and ClientSubnet, which can't be sent without.
+	EDNS bool
+
+	// Timeout bounds the time we'll wait for each nameserver.
+	Timeout time.Duration
+
+	// Class is the query class, almost always ClassINET.
+	Class uint16
+
+	// ClientSubnet is the network, in CIDR notation, to send as an
+	// EDNS0 Client Subnet option.  Empty means no option is sent.
+	ClientSubnet string
+
+	// Server, if set, is the "address:port" of a nameserver to query
+	// instead of our usual resolvers.
+	Server string
+}
+
+// DefaultQueryOptions returns the options used when a caller doesn't
+// specify any of their own.
+func DefaultQueryOptions() QueryOptions {
+	return QueryOptions{
+		Recursion: true,
+		Timeout:   5 * time.Second,
+		Class:     dns.ClassINET,
+	}
+}
+
+// ParseQueryOptions updates the default query-options from the given
+// query-string parameters, as submitted to our DNS handler.
+//
+// We support `rd`, `cd`, `do`, `edns`, `timeout`, `class` and `ecs`.
+// The client is the address of the remote caller, which is used when
+// they ask for `ecs=client`.
+func ParseQueryOptions(values url.Values, client string) (QueryOptions, error) {
+	opts := DefaultQueryOptions()
+
+	//
+	// The boolean flags all share the same handling.
+	//
+	flags := []struct {
+		name  string
+		value *bool
+	}{
+		{"rd", &opts.Recursion},
+		{"cd", &opts.CheckingDisabled},
+		{"do", &opts.DNSSEC},
+		{"edns", &opts.EDNS},
+	}
+	for _, f := range flags {
+		str := values.Get(f.name)
+		if str == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid value for '%s' - use 0 or 1", f.name)
+		}
+		*f.value = b
+	}
+
+	//
+	// The timeout must be a duration, and within sane bounds.
+	//
+	if str := values.Get("timeout"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid timeout '%s' - use a duration such as 2s", str)
+		}
+		if d < minQueryTimeout || d > maxQueryTimeout {
+			return opts, fmt.Errorf("Invalid timeout '%s' - must be between %s and %s", str, minQueryTimeout, maxQueryTimeout)
+		}
+		opts.Timeout = d
+	}
+
+	//
+	// Only the classes people actually use are permitted.
+	//
+	if str := values.Get("class"); str != "" {
+		switch strings.ToUpper(str) {
+		case "IN":
+			opts.Class = dns.ClassINET
+		case "CH":
+			opts.Class = dns.ClassCHAOS
+		case "HS":
+			opts.Class = dns.ClassHESIOD
+		default:
+			return opts, fmt.Errorf("Invalid class '%s' - use IN|CH|HS", str)
+		}
+	}
+
+	//
+	// The client-subnet is either given explicitly, or derived from
+	// the caller's own address.
+	//
+	if str := values.Get("ecs"); str != "" {
+		subnet, err := parseClientSubnet(str, client)
+		if err != nil {
+			return opts, err
+		}
+		opts.ClientSubnet = subnet
+	}
+
+	//
+	// The DO bit and client-subnets are sent in the OPT record, so they
+	// enable EDNS unless the caller explicitly disabled it.
+	//
+	if (opts.DNSSEC || opts.ClientSubnet != "") && values.Get("edns") == "" {
+		opts.EDNS = true
+	}
+
+	//
+	// Finally test the combinations make sense.
+	//
+	if opts.DNSSEC && !opts.EDNS {
+		return opts, errors.New("The DO bit requires EDNS - do=1 cannot be used with edns=0")
+	}
+	if opts.CheckingDisabled && !opts.Recursion {
+		return opts, errors.New("Checking-disabled only applies to recursive queries - cd=1 cannot be used with rd=0")
+	}
+	if opts.ClientSubnet != "" && !opts.EDNS {
+		return opts, errors.New("Client-subnets require EDNS - ecs cannot be used with edns=0")
+	}
+	return opts, nil
+}
+
+//
+// parseClientSubnet converts the value of an `ecs` parameter into the
+// network we'll send upstream.
+//
+// The special value "client" truncates the caller's own address, otherwise
+// the value must be a network in CIDR notation.  Either way the host-bits
+// are cleared.
+//
+func parseClientSubnet(str string, client string) (string, error) {
+
+	if strings.ToLower(str) == "client" {
+		ip := net.ParseIP(client)
+		if ip == nil {
+			return "", fmt.Errorf("Cannot derive a client-subnet from '%s'", client)
+		}
+		if ip.To4() != nil {
+			str = fmt.Sprintf("%s/%d", ip, clientSubnetIPv4)
+		} else {
+			str = fmt.Sprintf("%s/%d", ip, clientSubnetIPv6)
+		}
+	}
+
+	_, subnet, err := net.ParseCIDR(str)
+	if err != nil {
+		return "", fmt.Errorf("Invalid client-subnet '%s' - use a network such as 203.0.113.0/24, or 'client'", str)
+	}
+	return subnet.String(), nil
+}
+
+//
+// clientSubnetOption builds the EDNS0 option for the given network, which
+// must already have been validated by parseClientSubnet.
+//
+func clientSubnetOption(cidr string) *dns.EDNS0_SUBNET {
+	_, subnet, _ := net.ParseCIDR(cidr)
+	ones, _ := subnet.Mask.Size()
+
+	e := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+	}
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		e.Family = 1
+		e.Address = ip4
+	} else {
+		e.Family = 2
+		e.Address = subnet.IP
+	}
+	return e
+}
+
+// ClientSubnetScope returns the scope prefix-length from the EDNS0 Client
+// Subnet option of the given response, if one is present.
+//
+// The scope tells us how widely the answer may be shared: an answer with
+// scope /16 applies to every client within that /16.
+func ClientSubnetScope(r *dns.Msg) (int, bool) {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return int(e.SourceScope), true
+		}
+	}
+	return 0, false
+}
+
+// Lookup will perform a DNS query, using our nameservers, and return an
+// array of maps of the response.
+func (rs *Resolver) Lookup(name string, ltype string, opts QueryOptions) ([]map[string]string, error) {
+
+	r, err := rs.Query(name, ltype, opts)
+	if err != nil {
+		return nil, err
+	}
+	return Answers(r), nil
+}
+
+// Answers converts the answer-section of a response into the array of
+// maps we return to our callers.
+func Answers(r *dns.Msg) []map[string]string {
+
+	var results []map[string]string
+
+	for _, ent := range r.Answer {
+		results = append(results, FormatRR(ent))
+	}
+	return results
+}
+
+// Query performs the actual DNS query of the given name & type, returning
+// the whole response message.
+//
+// If the name doesn't exist a NoSuchDomain error is returned.
+func (rs *Resolver) Query(name string, ltype string, opts QueryOptions) (*dns.Msg, error) {
+
+	servers := rs.Nameservers
+	if opts.Server != "" {
+		servers = []string{opts.Server}
+	}
+	if len(servers) == 0 {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil || conf == nil {
+			return nil, fmt.Errorf("Cannot initialize the local resolver: %s", err)
+		}
+		for _, server := range conf.Servers {
+			servers = append(servers, net.JoinHostPort(server, conf.Port))
+		}
+	}
+
+	r, err := localQuery(servers, dns.Fqdn(name), ltype, opts)
+	if err != nil || r == nil {
+		return nil, fmt.Errorf("Cannot retrieve the list of name servers for %s", name)
+
+	}
+	if r.Rcode == dns.RcodeNameError {
+		return nil, NoSuchDomain(dns.Fqdn(name))
+	}
+	return r, nil
+}
+
+// NoSuchDomain is the error returned by Query when the name we asked for
+// doesn't exist, which allows callers to tell that apart from failures.
+type NoSuchDomain string
+
+func (n NoSuchDomain) Error() string {
+	return "no such domain " + string(n)
+}
+
+// FormatRR converts a single resource-record into the map we return
+// to our callers.
+func FormatRR(ent dns.RR) map[string]string {
+
+	tmp := make(map[string]string)
+
+	tmp["name"] = ent.Header().Name
+	tmp["ttl"] = fmt.Sprintf("%d", ent.Header().Ttl)
+
+	//
+	// Lookup the value
+	//
+	switch ent.(type) {
+	case *dns.A:
+		a := ent.(*dns.A).A
+		tmp["type"] = "A"
+		tmp["value"] = fmt.Sprintf("%s", a)
+	case *dns.AAAA:
+		aaaa := ent.(*dns.AAAA).AAAA
+		tmp["type"] = "AAAA"
+		tmp["value"] = fmt.Sprintf("%s", aaaa)
+
+	case *dns.CNAME:
+		cname := ent.(*dns.CNAME).Target
+		tmp["type"] = "CNAME"
+		tmp["value"] = cname
+	case *dns.MX:
+		mxName := ent.(*dns.MX).Mx
+		mxPrio := ent.(*dns.MX).Preference
+		tmp["type"] = "MX"
+		tmp["value"] = fmt.Sprintf("%d\t%s", mxPrio, mxName)
+	case *dns.NS:
+		nameserver := ent.(*dns.NS).Ns
+		tmp["type"] = "NS"
+		tmp["value"] = nameserver
+	case *dns.PTR:
+		ptr := ent.(*dns.PTR).Ptr
+		tmp["type"] = "PTR"
+		tmp["value"] = ptr
+	case *dns.SOA:
+		serial := ent.(*dns.SOA).Serial
+		tmp["type"] = "SOA"
+		tmp["value"] = fmt.Sprintf("%d", serial)
+	case *dns.TXT:
+		txt := ent.(*dns.TXT).Txt
+		tmp["type"] = "TXT"
+		tmp["value"] = fmt.Sprintf("%s", txt[0])
+	default:
+		//
+		// Anything else, such as the RRSIG records returned
+		// when the DO bit is set, is shown in presentation format.
+		//
+		tmp["type"] = dns.TypeToString[ent.Header().Rrtype]
+		tmp["value"] = strings.TrimPrefix(ent.String(), ent.Header().String())
+	}
+	return tmp
+}
+
+//
+// Given a thing to lookup, and a type, do the necessary.
+//
+// e.g. "steve.fi" "txt"
+//
+func localQuery(servers []string, qname string, lookupType string, opts QueryOptions) (*dns.Msg, error) {
+	qtype := StringToType[lookupType]
+
+	for _, server := range servers {
+		r, err := Exchange(server, qname, qtype, opts)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil || r.Rcode == dns.RcodeNameError || r.Rcode == dns.RcodeSuccess {
+			return r, err
+		}
+	}
+	return nil, errors.New("No name server to answer the question")
+}
+
+// Exchange sends a single query to the nameserver at the given
+// "host:port", and returns the response.
+func Exchange(server string, qname string, qtype uint16, opts QueryOptions) (*dns.Msg, error) {
+
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	m.RecursionDesired = opts.Recursion
+	m.CheckingDisabled = opts.CheckingDisabled
+	m.Question[0].Qclass = opts.Class
+	if opts.EDNS || opts.DNSSEC || opts.ClientSubnet != "" {
+		m.SetEdns0(4096, opts.DNSSEC)
+		if opts.ClientSubnet != "" {
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option, clientSubnetOption(opts.ClientSubnet))
+		}
+	}
+
+	c := &dns.Client{
+		Timeout: opts.Timeout,
+	}
+	r, _, err := c.Exchange(m, server)
+	if err != nil {
+		return nil, err
+	}
+
+	//
+	// Signed answers are frequently too large for UDP, so
+	// retry over TCP if we were truncated.
+	//
+	if r != nil && r.Truncated {
+		tcp := &dns.Client{Net: "tcp", Timeout: opts.Timeout}
+		r, _, err = tcp.Exchange(m, server)
+	}
+	return r, err
+}
+
+// HostAddresses returns the IPv4 and IPv6 addresses of the given host,
+// as found via our usual resolvers.
+func (rs *Resolver) HostAddresses(host string) []net.IP {
+	var addrs []net.IP
+
+	for _, t := range []string{"A", "AAAA"} {
+		r, err := rs.Query(host, t, DefaultQueryOptions())
+		if err != nil {
+			continue
+		}
+		for _, rr := range r.Answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, v.A)
+			case *dns.AAAA:
+				addrs = append(addrs, v.AAAA)
+			}
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,159 @@
+//
+// Test our DNS-query helpers.
+//
+
+package resolver
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// newTestResolver creates a resolver which sends its queries to the given
+// stand-in servers.
+//
+func newTestResolver(servers ...string) *Resolver {
+	rs := New()
+	rs.Nameservers = servers
+	return rs
+}
+
+//
+// Test that our query-options are parsed, and validated, correctly.
+//
+func TestParseQueryOptions(t *testing.T) {
+
+	//
+	// Valid options, and the settings we expect as a result.
+	//
+	valid := []struct {
+		Query  string
+		Expect QueryOptions
+	}{
+		{"", DefaultQueryOptions()},
+		{"rd=0", QueryOptions{Recursion: false, Timeout: 5 * time.Second, Class: dns.ClassINET}},
+		{"cd=1", QueryOptions{Recursion: true, CheckingDisabled: true, Timeout: 5 * time.Second, Class: dns.ClassINET}},
+		{"do=1", QueryOptions{Recursion: true, DNSSEC: true, EDNS: true, Timeout: 5 * time.Second, Class: dns.ClassINET}},
+		{"edns=1", QueryOptions{Recursion: true, EDNS: true, Timeout: 5 * time.Second, Class: dns.ClassINET}},
+		{"do=1&edns=1", QueryOptions{Recursion: true, DNSSEC: true, EDNS: true, Timeout: 5 * time.Second, Class: dns.ClassINET}},
+		{"timeout=2s", QueryOptions{Recursion: true, Timeout: 2 * time.Second, Class: dns.ClassINET}},
+		{"class=ch", QueryOptions{Recursion: true, Timeout: 5 * time.Second, Class: dns.ClassCHAOS}},
+		{"rd=false&edns=true&class=HS", QueryOptions{Recursion: false, EDNS: true, Timeout: 5 * time.Second, Class: dns.ClassHESIOD}},
+		{"ecs=203.0.113.7/24", QueryOptions{Recursion: true, EDNS: true, Timeout: 5 * time.Second, Class: dns.ClassINET, ClientSubnet: "203.0.113.0/24"}},
+		{"ecs=2001:db8::1/48", QueryOptions{Recursion: true, EDNS: true, Timeout: 5 * time.Second, Class: dns.ClassINET, ClientSubnet: "2001:db8::/48"}},
+		{"ecs=client", QueryOptions{Recursion: true, EDNS: true, Timeout: 5 * time.Second, Class: dns.ClassINET, ClientSubnet: "192.0.2.0/24"}},
+	}
+
+	for _, test := range valid {
+		values, _ := url.ParseQuery(test.Query)
+		opts, err := ParseQueryOptions(values, "192.0.2.1")
+		if err != nil {
+			t.Errorf("Unexpected error parsing '%s': %s", test.Query, err.Error())
+			continue
+		}
+		if opts != test.Expect {
+			t.Errorf("Options mismatch for '%s': got %v want %v", test.Query, opts, test.Expect)
+		}
+	}
+
+	//
+	// Invalid options, and the error we expect to see.
+	//
+	invalid := []struct {
+		Query string
+		Error string
+	}{
+		{"rd=maybe", "Invalid value for 'rd'"},
+		{"do=2", "Invalid value for 'do'"},
+		{"timeout=soon", "Invalid timeout"},
+		{"timeout=1ms", "must be between"},
+		{"timeout=1m", "must be between"},
+		{"class=ANY", "Invalid class"},
+		{"do=1&edns=0", "requires EDNS"},
+		{"cd=1&rd=0", "only applies to recursive queries"},
+		{"ecs=203.0.113.0", "Invalid client-subnet"},
+		{"ecs=client&edns=0", "require EDNS"},
+	}
+
+	for _, test := range invalid {
+		values, _ := url.ParseQuery(test.Query)
+		_, err := ParseQueryOptions(values, "192.0.2.1")
+		if err == nil {
+			t.Errorf("Expected an error parsing '%s', got none", test.Query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("Unexpected error for '%s': %s", test.Query, err.Error())
+		}
+	}
+}
+
+//
+// Test that a client-subnet is sent upstream, and the scope returned.
+//
+func TestClientSubnet(t *testing.T) {
+
+	//
+	// Our stand-in server echoes the client-subnet back with a /16
+	// scope, and reports the subnet it was sent, if any.
+	//
+	seen := make(chan string, 1)
+	addr := dnstest.Start(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.10"),
+		})
+		subnet := ""
+		if opt := req.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+					subnet = e.String()
+					e.SourceScope = 16
+					m.SetEdns0(4096, false)
+					m.IsEdns0().Option = append(m.IsEdns0().Option, e)
+				}
+			}
+		}
+		seen <- subnet
+		w.WriteMsg(m)
+	})
+	rs := newTestResolver(addr)
+
+	opts := DefaultQueryOptions()
+	opts.ClientSubnet = "198.51.100.0/24"
+
+	r, err := rs.Query("example.com", "A", opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if subnet := <-seen; subnet != "198.51.100.0/24/0" {
+		t.Errorf("The server saw the wrong client-subnet: '%s'", subnet)
+	}
+	scope, ok := ClientSubnetScope(r)
+	if !ok || scope != 16 {
+		t.Errorf("Unexpected scope: %d %v", scope, ok)
+	}
+
+	//
+	// Without a subnet we send nothing, and get no scope.
+	//
+	r, err = rs.Query("example.com", "A", DefaultQueryOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if subnet := <-seen; subnet != "" {
+		t.Errorf("The server saw a client-subnet we didn't send: '%s'", subnet)
+	}
+	if _, ok := ClientSubnetScope(r); ok {
+		t.Errorf("Found a scope we didn't expect")
+	}
+}
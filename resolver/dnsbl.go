@@ -0,0 +1,355 @@
+//
+// This file contains our blocklist-check, which looks up an IP address
+// or a domain in a number of DNS-based blocklists (DNSBLs and RHSBLs).
+//
+// Listings are published as A records beneath the zone of each list,
+// the address returned encodes the reason, and a TXT record usually
+// gives a human-readable explanation too.
+//
+
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// The status of each list we query.
+//
+const (
+	blocklistListed   = "listed"
+	blocklistClean    = "clean"
+	blocklistTimeout  = "timeout"
+	blocklistNotValid = "error"
+)
+
+//
+// dnsblTimeout is how long we wait for the answer from each list.
+//
+var dnsblTimeout = 3 * time.Second
+
+//
+// blocklistRefusals are the return-codes which lists use to refuse our
+// query, rather than to report a listing.  Spamhaus refuses queries made
+// via public resolvers, or which exceed its limits.
+//
+// They only apply to lists whose own code-table doesn't mention them.
+//
+var blocklistRefusals = map[string]string{
+	"127.0.1.255":     "Queries for IP addresses are not permitted, or the limit has been exceeded",
+	"127.255.255.252": "The name of the list is mistyped",
+	"127.255.255.254": "Queries via public resolvers are not permitted",
+	"127.255.255.255": "The limit on queries has been exceeded",
+}
+
+//
+// dnsblNegativeTTL is how long we cache the fact that a target is not
+// listed.
+//
+var dnsblNegativeTTL = 5 * time.Minute
+
+// Blocklist is a DNS-based blocklist, of either IP addresses or domains.
+type Blocklist struct {
+	// Name is a human-readable name for the list.
+	Name string `json:"name"`
+
+	// Zone is the zone beneath which listings are published.
+	Zone string `json:"zone"`
+
+	// Type is "ip" for a DNSBL, or "domain" for an RHSBL.
+	Type string `json:"type"`
+
+	// Codes maps the addresses the list returns to their meaning.
+	Codes map[string]string `json:"codes"`
+}
+
+//
+// Parse validates a blocklist, defaulting its type to "ip".
+//
+func (b *Blocklist) Parse() error {
+
+	if b.Name == "" {
+		return fmt.Errorf("the list for '%s' has no name", b.Zone)
+	}
+	if _, ok := dns.IsDomainName(b.Zone); !ok || b.Zone == "" {
+		return fmt.Errorf("'%s' is not a valid zone", b.Zone)
+	}
+	b.Zone = strings.ToLower(strings.TrimSuffix(b.Zone, "."))
+
+	if b.Type == "" {
+		b.Type = "ip"
+	}
+	if b.Type != "ip" && b.Type != "domain" {
+		return fmt.Errorf("unknown type '%s', use ip or domain", b.Type)
+	}
+
+	for code := range b.Codes {
+		if net.ParseIP(code) == nil {
+			return fmt.Errorf("the return-code '%s' is not an address", code)
+		}
+	}
+	return nil
+}
+
+// DefaultBlocklists are the lists we query if the configuration-file
+// doesn't list any of its own.
+var DefaultBlocklists = []Blocklist{
+	{
+		Name: "Spamhaus ZEN",
+		Zone: "zen.spamhaus.org",
+		Type: "ip",
+		Codes: map[string]string{
+			"127.0.0.2":  "SBL - Spamhaus SBL data",
+			"127.0.0.3":  "SBL - Spamhaus SBL CSS data",
+			"127.0.0.4":  "XBL - CBL data",
+			"127.0.0.9":  "SBL - Spamhaus DROP/EDROP data",
+			"127.0.0.10": "PBL - ISP maintained",
+			"127.0.0.11": "PBL - Spamhaus maintained",
+		},
+	},
+	{
+		Name: "SpamCop",
+		Zone: "bl.spamcop.net",
+		Type: "ip",
+		Codes: map[string]string{
+			"127.0.0.2": "Listed in SpamCop",
+		},
+	},
+	{
+		Name: "Barracuda",
+		Zone: "b.barracudacentral.org",
+		Type: "ip",
+		Codes: map[string]string{
+			"127.0.0.2": "Listed in the Barracuda Reputation Block List",
+		},
+	},
+	{
+		Name: "Spamhaus DBL",
+		Zone: "dbl.spamhaus.org",
+		Type: "domain",
+		Codes: map[string]string{
+			"127.0.1.2":   "Spam domain",
+			"127.0.1.4":   "Phishing domain",
+			"127.0.1.5":   "Malware domain",
+			"127.0.1.6":   "Botnet C&C domain",
+			"127.0.1.102": "Abused legit spam",
+			"127.0.1.103": "Abused spammed redirector domain",
+			"127.0.1.104": "Abused legit phish",
+			"127.0.1.105": "Abused legit malware",
+			"127.0.1.106": "Abused legit botnet C&C",
+		},
+	},
+}
+
+// BlocklistReport is the result of looking up a target in each of the
+// configured blocklists.
+type BlocklistReport struct {
+	// Target is the IP address or domain we looked up.
+	Target string `json:"target"`
+
+	// Type is "ip" or "domain".
+	Type string `json:"type"`
+
+	// Results holds the result from each list.
+	Results []BlocklistResult `json:"results"`
+
+	// Listed holds the names of the lists the target is listed in.
+	Listed []string `json:"listed"`
+
+	// TimedOut holds the names of the lists which didn't answer.
+	TimedOut []string `json:"timed_out"`
+}
+
+// BlocklistResult is the result of looking up a target in a single list.
+type BlocklistResult struct {
+	Name   string `json:"name"`
+	Zone   string `json:"zone"`
+	Query  string `json:"query"`
+	Status string `json:"status"`
+
+	// Codes holds the addresses returned, and Meanings their
+	// decoded meaning according to the list's code-table.
+	Codes    []string `json:"codes,omitempty"`
+	Meanings []string `json:"meanings,omitempty"`
+
+	// Reason is the text of the list's TXT record, if any.
+	Reason string `json:"reason,omitempty"`
+
+	// TTL is the time-to-live of the listing, or the time it has left
+	// if it came from our cache.
+	TTL uint32 `json:"ttl,omitempty"`
+
+	// Cached is true if this result came from our cache.
+	Cached bool `json:"cached"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// CheckBlocklists looks up the given IP address, or domain, in each of
+// the given lists of the appropriate type, in parallel.
+func (rs *Resolver) CheckBlocklists(target string, lists []Blocklist) (*BlocklistReport, error) {
+
+	report := &BlocklistReport{
+		Target:   target,
+		Type:     "domain",
+		Results:  []BlocklistResult{},
+		Listed:   []string{},
+		TimedOut: []string{},
+	}
+
+	//
+	// Work out the name we prepend to each zone.
+	//
+	prefix := ""
+	if ip := net.ParseIP(target); ip != nil {
+		arpa, err := dns.ReverseAddr(ip.String())
+		if err != nil {
+			return nil, err
+		}
+		arpa = strings.TrimSuffix(arpa, ".in-addr.arpa.")
+		prefix = strings.TrimSuffix(arpa, ".ip6.arpa.")
+		report.Target = ip.String()
+		report.Type = "ip"
+	} else {
+		if _, ok := dns.IsDomainName(target); !ok || !strings.Contains(strings.Trim(target, "."), ".") {
+			return nil, fmt.Errorf("'%s' is neither an IP address nor a domain", target)
+		}
+		prefix = strings.ToLower(strings.TrimSuffix(target, "."))
+		report.Target = prefix
+	}
+
+	var selected []Blocklist
+	for _, list := range lists {
+		if list.Type == report.Type {
+			selected = append(selected, list)
+			report.Results = append(report.Results, BlocklistResult{
+				Name:  list.Name,
+				Zone:  list.Zone,
+				Query: dns.Fqdn(prefix + "." + list.Zone),
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range report.Results {
+		wg.Add(1)
+		go func(res *BlocklistResult, list Blocklist) {
+			defer wg.Done()
+			rs.checkBlocklist(res, list)
+		}(&report.Results[i], selected[i])
+	}
+	wg.Wait()
+
+	for _, res := range report.Results {
+		switch res.Status {
+		case blocklistListed:
+			report.Listed = append(report.Listed, res.Name)
+		case blocklistTimeout:
+			report.TimedOut = append(report.TimedOut, res.Name)
+		}
+	}
+	return report, nil
+}
+
+//
+// checkBlocklist looks up a single name in a single list, updating the
+// result.
+//
+func (rs *Resolver) checkBlocklist(res *BlocklistResult, list Blocklist) {
+
+	//
+	// Cached listings report the time they have left, rather than the
+	// TTL they were first given.
+	//
+	if cached, left, ok := rs.blocklists.Get(res.Query); ok {
+		*res = cached.(BlocklistResult)
+		res.Cached = true
+		if res.TTL > 0 {
+			res.TTL = uint32((left + time.Second - 1) / time.Second)
+		}
+		return
+	}
+
+	opts := DefaultQueryOptions()
+	opts.Timeout = dnsblTimeout
+
+	r, err := rs.Query(res.Query, "A", opts)
+	if err != nil {
+		if _, ok := err.(NoSuchDomain); ok {
+			res.Status = blocklistClean
+			rs.blocklists.Set(res.Query, *res, dnsblNegativeTTL)
+			return
+		}
+		res.Status = blocklistTimeout
+		res.Error = err.Error()
+		return
+	}
+
+	for _, rr := range r.Answer {
+		a, ok := rr.(*dns.A)
+		if !ok {
+			continue
+		}
+		if res.TTL == 0 || a.Hdr.Ttl < res.TTL {
+			res.TTL = a.Hdr.Ttl
+		}
+		code := a.A.String()
+		res.Codes = append(res.Codes, code)
+
+		meaning, ok := list.Codes[code]
+		if !ok {
+			meaning = "Unknown return-code"
+			if reason, refused := blocklistRefusals[code]; refused {
+				res.Status = blocklistNotValid
+				res.Error = "The list refused the query: " + reason
+				meaning = reason
+			}
+		}
+		res.Meanings = append(res.Meanings, meaning)
+	}
+
+	//
+	// A refusal isn't cached, as it says nothing about the target.
+	//
+	if res.Status == blocklistNotValid {
+		return
+	}
+
+	if len(res.Codes) == 0 {
+		res.Status = blocklistClean
+		rs.blocklists.Set(res.Query, *res, dnsblNegativeTTL)
+		return
+	}
+
+	//
+	// Genuine listings are always within 127.0.0.0/8, anything else
+	// suggests a wildcard or a misbehaving list.
+	//
+	res.Status = blocklistListed
+	for _, code := range res.Codes {
+		if !strings.HasPrefix(code, "127.") {
+			res.Status = blocklistNotValid
+			res.Error = "The list returned an address outside 127.0.0.0/8"
+		}
+	}
+
+	//
+	// The reason is optional, so failures here are ignored.
+	//
+	if res.Status == blocklistListed {
+		reasons, e := rs.txtRecords(res.Query)
+		if e == nil {
+			sort.Strings(reasons)
+			res.Reason = strings.Join(reasons, "; ")
+		}
+	}
+
+	rs.blocklists.Set(res.Query, *res, time.Duration(res.TTL)*time.Second)
+}
@@ -0,0 +1,243 @@
+//
+// Test our blocklist-check.
+//
+
+package resolver
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// testBlocklists are the lists our stand-in server publishes.
+//
+var testBlocklists = []Blocklist{
+	{Name: "One", Zone: "one.test", Type: "ip", Codes: map[string]string{"127.0.0.2": "Spam source", "127.0.0.4": "Exploited host"}},
+	{Name: "Two", Zone: "two.test", Type: "ip", Codes: map[string]string{"127.0.0.2": "Listed"}},
+	{Name: "Slow", Zone: "slow.test", Type: "ip"},
+	{Name: "Domains", Zone: "rhs.test", Type: "domain", Codes: map[string]string{"127.0.1.2": "Spam domain"}},
+}
+
+//
+// blocklistServer starts a stand-in for our lists, returning a resolver
+// which uses it and a counter of the queries it received.
+//
+func blocklistServer(t *testing.T) (*Resolver, *int32) {
+
+	var count int32
+
+	zone := dnstest.Zone(t,
+		"3.2.0.192.one.test. 300 IN A 127.0.0.2",
+		"3.2.0.192.one.test. 60 IN A 127.0.0.4",
+		"3.2.0.192.one.test. 300 IN TXT \"Listed, see https://one.test/192.0.2.3\"",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.one.test. 300 IN A 127.0.0.2",
+		"3.2.0.192.two.test. 300 IN A 10.0.0.1",
+		"spammer.example.rhs.test. 300 IN A 127.0.1.2",
+		"3.2.0.192.refused.test. 300 IN A 127.255.255.254",
+	)
+
+	addr := dnstest.Start(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&count, 1)
+
+		//
+		// The slow list never answers.
+		//
+		if strings.HasSuffix(req.Question[0].Name, ".slow.test.") {
+			return
+		}
+		zone(w, req)
+	})
+	rs := newTestResolver(addr)
+
+	old := dnsblTimeout
+	dnsblTimeout = 250 * time.Millisecond
+	t.Cleanup(func() { dnsblTimeout = old })
+
+	return rs, &count
+}
+
+//
+// Test looking up addresses.
+//
+func TestCheckBlocklistsIP(t *testing.T) {
+
+	rs, _ := blocklistServer(t)
+
+	report, err := rs.CheckBlocklists("192.0.2.3", testBlocklists)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if report.Type != "ip" || len(report.Results) != 3 {
+		t.Fatalf("Unexpected report: %v", report)
+	}
+
+	one := report.Results[0]
+	if one.Status != blocklistListed || one.Query != "3.2.0.192.one.test." {
+		t.Errorf("Unexpected result: %v", one)
+	}
+	if len(one.Meanings) != 2 || one.TTL != 60 {
+		t.Errorf("Unexpected codes: %v", one)
+	}
+	if !strings.Contains(one.Reason, "https://one.test/192.0.2.3") {
+		t.Errorf("Unexpected reason: %s", one.Reason)
+	}
+
+	//
+	// The second list returned something bogus, and the third timed
+	// out.
+	//
+	if report.Results[1].Status != blocklistNotValid {
+		t.Errorf("Unexpected result: %v", report.Results[1])
+	}
+	if report.Results[2].Status != blocklistTimeout {
+		t.Errorf("Unexpected result: %v", report.Results[2])
+	}
+	if len(report.Listed) != 1 || report.Listed[0] != "One" {
+		t.Errorf("Unexpected listings: %v", report.Listed)
+	}
+	if len(report.TimedOut) != 1 || report.TimedOut[0] != "Slow" {
+		t.Errorf("Unexpected timeouts: %v", report.TimedOut)
+	}
+
+	//
+	// IPv6 addresses are reversed nibble-by-nibble, and an address
+	// which isn't listed is clean.
+	//
+	report, err = rs.CheckBlocklists("2001:db8::1", testBlocklists[:1])
+	if err != nil || report.Results[0].Status != blocklistListed {
+		t.Errorf("Unexpected IPv6 result: %v %v", report, err)
+	}
+	report, err = rs.CheckBlocklists("192.0.2.4", testBlocklists[:1])
+	if err != nil || report.Results[0].Status != blocklistClean {
+		t.Errorf("Unexpected clean result: %v %v", report, err)
+	}
+}
+
+//
+// Test that a list refusing our query isn't reported as a listing, and
+// that the refusal isn't cached.
+//
+func TestCheckBlocklistsRefused(t *testing.T) {
+
+	rs, count := blocklistServer(t)
+	lists := []Blocklist{{Name: "Refused", Zone: "refused.test", Type: "ip"}}
+
+	for i := 0; i < 2; i++ {
+		report, err := rs.CheckBlocklists("192.0.2.3", lists)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		res := report.Results[0]
+		if res.Status != blocklistNotValid || res.Cached || !strings.Contains(res.Error, "public resolvers") {
+			t.Errorf("Unexpected result: %v", res)
+		}
+		if len(report.Listed) != 0 {
+			t.Errorf("Unexpected listings: %v", report.Listed)
+		}
+	}
+	if atomic.LoadInt32(count) != 2 {
+		t.Errorf("Unexpected query count %d", atomic.LoadInt32(count))
+	}
+}
+
+//
+// Test looking up domains, and that only domain-lists are used.
+//
+func TestCheckBlocklistsDomain(t *testing.T) {
+
+	rs, _ := blocklistServer(t)
+
+	report, err := rs.CheckBlocklists("Spammer.Example.", testBlocklists)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if report.Type != "domain" || report.Target != "spammer.example" || len(report.Results) != 1 {
+		t.Fatalf("Unexpected report: %v", report)
+	}
+	if report.Results[0].Status != blocklistListed || report.Results[0].Meanings[0] != "Spam domain" {
+		t.Errorf("Unexpected result: %v", report.Results[0])
+	}
+
+	for _, bogus := range []string{"localhost", "bad..name", ""} {
+		_, err = rs.CheckBlocklists(bogus, testBlocklists)
+		if err == nil {
+			t.Errorf("Expected an error looking up '%s'", bogus)
+		}
+	}
+}
+
+//
+// Test that results are cached, but that timeouts aren't.
+//
+func TestCheckBlocklistsCache(t *testing.T) {
+
+	rs, count := blocklistServer(t)
+
+	_, err := rs.CheckBlocklists("192.0.2.3", testBlocklists[:2])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	before := atomic.LoadInt32(count)
+
+	report, err := rs.CheckBlocklists("192.0.2.3", testBlocklists)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !report.Results[0].Cached || !report.Results[1].Cached || report.Results[2].Cached {
+		t.Errorf("Unexpected caching: %v", report.Results)
+	}
+	if atomic.LoadInt32(count) == before {
+		t.Errorf("The slow list should have been queried")
+	}
+
+	//
+	// Only the slow list should have been queried.
+	//
+	after := atomic.LoadInt32(count)
+	_, err = rs.CheckBlocklists("192.0.2.3", testBlocklists[:2])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if atomic.LoadInt32(count) != after {
+		t.Errorf("Cached results were looked up again")
+	}
+}
+
+//
+// Test the expiry and eviction of our cache.
+//
+func TestTTLCache(t *testing.T) {
+
+	c := newTTLCache(2)
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, time.Millisecond)
+	c.Set("never", 3, 0)
+
+	if v, left, ok := c.Get("a"); !ok || v.(int) != 1 || left > time.Hour || left < 59*time.Minute {
+		t.Errorf("Unexpected value: %v %s", v, left)
+	}
+	if _, _, ok := c.Get("never"); ok {
+		t.Errorf("Entries without a TTL shouldn't be cached")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if _, _, ok := c.Get("b"); ok {
+		t.Errorf("Expired entries should not be returned")
+	}
+
+	//
+	// The cache never grows beyond its limit.
+	//
+	c.Set("c", 4, time.Hour)
+	c.Set("d", 5, time.Hour)
+	c.Set("e", 6, time.Hour)
+	if len(c.entries) > 2 {
+		t.Errorf("The cache has grown to %d entries", len(c.entries))
+	}
+}
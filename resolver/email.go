@@ -0,0 +1,718 @@
+//
+// This file contains our email-authentication report, which gathers the
+// DNS records which affect the delivery of mail to, and from, a domain
+// and reports upon any problems found with them.
+//
+// "Why is our mail going to spam?" is the most common question we're
+// asked, and the answer is almost always in these records.
+//
+
+package resolver
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultDKIMSelectors are the selectors we look for if the caller, and
+// the configuration-file, doesn't give us any.
+var DefaultDKIMSelectors = []string{
+	"default",
+	"dkim",
+	"google",
+	"k1",
+	"mail",
+	"s1",
+	"s2",
+	"selector1",
+	"selector2",
+}
+
+//
+// mtaSTSPolicyURL is the location of the MTA-STS policy of a domain, it is
+// changed by our test-cases.
+//
+var mtaSTSPolicyURL = "https://mta-sts.%s/.well-known/mta-sts.txt"
+
+//
+// The mail-exchangers are chosen by whoever controls the domain, so we
+// limit how many of them, and of their addresses and PTR names, we look
+// up, and how long we'll spend doing so.
+//
+var (
+	maxMXHosts     = 10
+	maxMXAddresses = 4
+	maxMXNames     = 4
+	mxTimeout      = 10 * time.Second
+)
+
+//
+// mtaSTSClient is used to fetch MTA-STS policies.
+//
+var mtaSTSClient = newMTASTSClient(reservedNetworks)
+
+//
+// newMTASTSClient creates a client for fetching MTA-STS policies, which
+// refuses to connect to the given networks.
+//
+// Policies are fetched from whatever the domain of our caller resolves
+// to, so without this we could be used to probe internal networks.  We
+// don't follow redirects either, as RFC 8461 forbids them.
+//
+func newMTASTSClient(denied []*net.IPNet) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: func(network, address string, c syscall.RawConn) error {
+					host, _, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+					ip := net.ParseIP(host)
+					if ip4 := ip.To4(); ip4 != nil {
+						ip = ip4
+					}
+					if ip == nil || containsIP(denied, ip) {
+						return TargetDenied{fmt.Sprintf("Fetching policies from the reserved address %s is not permitted", host)}
+					}
+					return nil
+				},
+			}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// EmailReport is the result of examining the mail-related records of a
+// domain.
+type EmailReport struct {
+	Domain string `json:"domain"`
+
+	MX     []MXHost      `json:"mx"`
+	SPF    *SPFRecord    `json:"spf"`
+	DMARC  *DMARCRecord  `json:"dmarc"`
+	MTASTS *MTASTSRecord `json:"mta_sts"`
+	TLSRPT *TLSRPTRecord `json:"tls_rpt"`
+	DKIM   []DKIMKey     `json:"dkim"`
+
+	// Errors are problems which will cause mail to be rejected, or
+	// to be treated as suspicious.
+	Errors []string `json:"errors"`
+
+	// Warnings are weaknesses, or missing best-practices.
+	Warnings []string `json:"warnings"`
+}
+
+// MXHost is a single mail-exchanger, and its addresses.
+type MXHost struct {
+	Preference uint16      `json:"preference"`
+	Host       string      `json:"host"`
+	Addresses  []MXAddress `json:"addresses"`
+}
+
+// MXAddress is the address of a mail-exchanger, with its reverse DNS.
+type MXAddress struct {
+	Address string   `json:"address"`
+	PTR     []string `json:"ptr"`
+
+	// Confirmed is true if one of the PTR names resolves back to
+	// this address, i.e. the address has forward-confirmed rDNS.
+	Confirmed bool `json:"confirmed"`
+}
+
+// SPFRecord is the SPF policy of a domain.
+type SPFRecord struct {
+	Record string   `json:"record"`
+	Terms  []string `json:"terms"`
+}
+
+// DMARCRecord is the DMARC policy of a domain.
+type DMARCRecord struct {
+	Record string            `json:"record"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// MTASTSRecord is the MTA-STS record of a domain, and the policy it
+// advertises.
+type MTASTSRecord struct {
+	Record string            `json:"record"`
+	ID     string            `json:"id"`
+	Policy map[string]string `json:"policy,omitempty"`
+	MX     []string          `json:"mx,omitempty"`
+}
+
+// TLSRPTRecord is the SMTP TLS reporting record of a domain.
+type TLSRPTRecord struct {
+	Record string   `json:"record"`
+	RUA    []string `json:"rua"`
+}
+
+// DKIMKey is a DKIM public key found under one of the selectors we tried.
+type DKIMKey struct {
+	Selector string `json:"selector"`
+	Record   string `json:"record"`
+	KeyType  string `json:"key_type"`
+	Bits     int    `json:"bits"`
+}
+
+// CheckEmail builds the email-authentication report for the given domain,
+// looking for DKIM keys under each of the given selectors.
+func (rs *Resolver) CheckEmail(domain string, selectors []string) *EmailReport {
+
+	domain = dns.Fqdn(strings.ToLower(domain))
+
+	report := &EmailReport{
+		Domain:   domain,
+		MX:       []MXHost{},
+		DKIM:     []DKIMKey{},
+		Errors:   []string{},
+		Warnings: []string{},
+	}
+
+	rs.checkMX(report)
+	rs.checkSPF(report)
+	rs.checkDMARC(report)
+	rs.checkMTASTS(report)
+	rs.checkTLSRPT(report)
+	rs.checkDKIM(report, selectors)
+	return report
+}
+
+//
+// errorf adds an error to the report.
+//
+func (r *EmailReport) errorf(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+//
+// warnf adds a warning to the report.
+//
+func (r *EmailReport) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+//
+// txtRecords returns the TXT records of the given name, each of which
+// has had its strings joined together, as RFC 7208 requires.
+//
+func (rs *Resolver) txtRecords(name string) ([]string, error) {
+	var out []string
+
+	r, err := rs.Query(name, "TXT", DefaultQueryOptions())
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			out = append(out, strings.Join(txt.Txt, ""))
+		}
+	}
+	return out, nil
+}
+
+//
+// txtWithPrefix returns the TXT records of the given name which begin
+// with the given version-tag, compared case-insensitively.
+//
+func (rs *Resolver) txtWithPrefix(name string, prefix string) []string {
+	var out []string
+
+	records, _ := rs.txtRecords(name)
+	for _, txt := range records {
+		if strings.HasPrefix(strings.ToLower(txt), strings.ToLower(prefix)) {
+			out = append(out, txt)
+		}
+	}
+	return out
+}
+
+//
+// parseTags parses a list of "key=value" tags, separated by semi-colons,
+// as used by DMARC, DKIM, MTA-STS and TLS-RPT records.
+//
+func parseTags(record string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, part := range strings.Split(record, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		tags[key] = strings.TrimSpace(kv[1])
+	}
+	return tags
+}
+
+//
+// checkMX finds the mail-exchangers of the domain, their addresses and
+// the reverse DNS of those.
+//
+// The hosts are checked in parallel, and any which haven't been checked
+// after mxTimeout are left without their addresses.
+//
+func (rs *Resolver) checkMX(report *EmailReport) {
+
+	r, err := rs.Query(report.Domain, "MX", DefaultQueryOptions())
+	if err == nil {
+		for _, rr := range r.Answer {
+			if mx, ok := rr.(*dns.MX); ok {
+				report.MX = append(report.MX, MXHost{Preference: mx.Preference, Host: strings.ToLower(mx.Mx)})
+			}
+		}
+	}
+
+	if len(report.MX) == 0 {
+		report.errorf("%s has no MX records", report.Domain)
+		return
+	}
+
+	//
+	// A "null MX" says the domain doesn't accept mail.
+	//
+	if len(report.MX) == 1 && report.MX[0].Host == "." {
+		report.warnf("%s has a null MX record, and accepts no mail", report.Domain)
+		return
+	}
+
+	hosts := len(report.MX)
+	if hosts > maxMXHosts {
+		report.warnf("%s has %d MX records, only the first %d were checked", report.Domain, hosts, maxMXHosts)
+		hosts = maxMXHosts
+	}
+
+	type result struct {
+		index     int
+		addresses []MXAddress
+	}
+	results := make(chan result, hosts)
+	for i := 0; i < hosts; i++ {
+		go func(i int, host string) {
+			results <- result{index: i, addresses: rs.mxAddresses(host)}
+		}(i, report.MX[i].Host)
+	}
+
+	timeout := time.After(mxTimeout)
+	for done := 0; done < hosts; done++ {
+		select {
+		case res := <-results:
+			report.MX[res.index].Addresses = res.addresses
+		case <-timeout:
+			report.warnf("Timed out checking the MX hosts of %s", report.Domain)
+			done = hosts
+		}
+	}
+
+	for _, mx := range report.MX[:hosts] {
+		if mx.Addresses == nil {
+			continue
+		}
+		if len(mx.Addresses) == 0 {
+			report.errorf("The MX host %s has no addresses", mx.Host)
+		}
+		for _, a := range mx.Addresses {
+			if len(a.PTR) == 0 {
+				report.warnf("The MX host %s has no reverse DNS for %s", mx.Host, a.Address)
+			} else if !a.Confirmed {
+				report.warnf("The reverse DNS of %s (%s) doesn't resolve back to it", a.Address, strings.Join(a.PTR, ", "))
+			}
+		}
+	}
+}
+
+//
+// mxAddresses finds the addresses of a mail-exchanger, and checks their
+// reverse DNS in parallel.
+//
+func (rs *Resolver) mxAddresses(host string) []MXAddress {
+	addrs := rs.HostAddresses(host)
+	if len(addrs) > maxMXAddresses {
+		addrs = addrs[:maxMXAddresses]
+	}
+
+	var wg sync.WaitGroup
+	out := make([]MXAddress, len(addrs))
+	for i, ip := range addrs {
+		wg.Add(1)
+		go func(a *MXAddress, ip net.IP) {
+			defer wg.Done()
+
+			a.Address = ip.String()
+			a.PTR = rs.reverseNames(ip)
+			if len(a.PTR) > maxMXNames {
+				a.PTR = a.PTR[:maxMXNames]
+			}
+			for _, ptr := range a.PTR {
+				for _, fwd := range rs.HostAddresses(ptr) {
+					if fwd.Equal(ip) {
+						a.Confirmed = true
+					}
+				}
+			}
+		}(&out[i], ip)
+	}
+	wg.Wait()
+	return out
+}
+
+//
+// reverseNames returns the PTR names of the given address.
+//
+func (rs *Resolver) reverseNames(ip net.IP) []string {
+	var names []string
+
+	arpa, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil
+	}
+	r, err := rs.Query(arpa, "PTR", DefaultQueryOptions())
+	if err != nil {
+		return nil
+	}
+	for _, rr := range r.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			names = append(names, strings.ToLower(ptr.Ptr))
+		}
+	}
+	return names
+}
+
+//
+// checkSPF finds, and sanity-checks, the SPF record of the domain.
+//
+// The full evaluation of a record is left to our SPF endpoint, here we
+// only look for the common mistakes.
+//
+func (rs *Resolver) checkSPF(report *EmailReport) {
+
+	records := rs.txtWithPrefix(report.Domain, "v=spf1")
+	if len(records) == 0 {
+		report.warnf("%s has no SPF record", report.Domain)
+		return
+	}
+	if len(records) > 1 {
+		report.errorf("%s has %d SPF records, which is a permanent error", report.Domain, len(records))
+	}
+
+	spf := &SPFRecord{Record: records[0], Terms: strings.Fields(records[0])[1:]}
+	report.SPF = spf
+
+	lookups := 0
+	final := false
+	for _, term := range spf.Terms {
+		t := strings.ToLower(term)
+		mech := strings.TrimLeft(t, "+-~?")
+
+		switch {
+		case mech == "all":
+			final = true
+			switch t[0] {
+			case '+', 'a':
+				report.errorf("The SPF record ends with %s, which allows anybody to send mail as %s", term, report.Domain)
+			case '?':
+				report.warnf("The SPF record ends with %s, which makes no assertion", term)
+			}
+		case strings.HasPrefix(t, "redirect="):
+			final = true
+			lookups++
+		case mech == "ptr" || strings.HasPrefix(mech, "ptr:"):
+			report.warnf("The SPF record uses the 'ptr' mechanism, which is deprecated")
+			lookups++
+		case mech == "a" || mech == "mx" || strings.HasPrefix(mech, "a:") || strings.HasPrefix(mech, "a/") ||
+			strings.HasPrefix(mech, "mx:") || strings.HasPrefix(mech, "mx/") ||
+			strings.HasPrefix(mech, "include:") || strings.HasPrefix(mech, "exists:"):
+			lookups++
+		}
+	}
+
+	if !final {
+		report.warnf("The SPF record has no 'all' mechanism or redirect, so defaults to neutral")
+	}
+	if lookups > 10 {
+		report.errorf("The SPF record uses %d DNS-querying mechanisms, more than the limit of 10", lookups)
+	}
+}
+
+//
+// checkDMARC finds, and sanity-checks, the DMARC policy of the domain.
+//
+func (rs *Resolver) checkDMARC(report *EmailReport) {
+
+	name := "_dmarc." + report.Domain
+	records := rs.txtWithPrefix(name, "v=DMARC1")
+	if len(records) == 0 {
+		report.warnf("%s has no DMARC record at %s", report.Domain, name)
+		return
+	}
+	if len(records) > 1 {
+		report.errorf("%s has %d DMARC records, so none will be used", name, len(records))
+	}
+
+	report.DMARC = &DMARCRecord{Record: records[0], Tags: parseTags(records[0])}
+	tags := report.DMARC.Tags
+
+	switch strings.ToLower(tags["p"]) {
+	case "":
+		report.errorf("The DMARC record has no policy ('p=' tag)")
+	case "none":
+		report.warnf("The DMARC policy is p=none, so failing mail is only monitored")
+	case "quarantine", "reject":
+	default:
+		report.errorf("The DMARC record has an invalid policy 'p=%s'", tags["p"])
+	}
+
+	if tags["rua"] == "" {
+		report.warnf("The DMARC record has no 'rua' tag, so no aggregate reports will be received")
+	}
+	if pct, ok := tags["pct"]; ok {
+		if n, err := strconv.Atoi(pct); err != nil || n < 100 {
+			report.warnf("The DMARC policy only applies to %s%% of failing mail", pct)
+		}
+	}
+}
+
+//
+// checkMTASTS finds the MTA-STS record of the domain, and if present
+// fetches the policy it advertises.
+//
+func (rs *Resolver) checkMTASTS(report *EmailReport) {
+
+	domain := strings.TrimSuffix(report.Domain, ".")
+	records := rs.txtWithPrefix("_mta-sts."+report.Domain, "v=STSv1")
+	if len(records) == 0 {
+		return
+	}
+	if len(records) > 1 {
+		report.errorf("%s has %d MTA-STS records, so none will be used", domain, len(records))
+	}
+
+	sts := &MTASTSRecord{Record: records[0], ID: parseTags(records[0])["id"]}
+	report.MTASTS = sts
+	if sts.ID == "" {
+		report.errorf("The MTA-STS record has no 'id' tag")
+	}
+
+	policy, mx, err := fetchMTASTSPolicy(domain)
+	if err != nil {
+		report.errorf("Failed to fetch the MTA-STS policy: %s", err.Error())
+		return
+	}
+	sts.Policy = policy
+	sts.MX = mx
+
+	switch policy["mode"] {
+	case "enforce":
+	case "testing":
+		report.warnf("The MTA-STS policy is in testing mode, so it isn't enforced")
+	case "none":
+		report.warnf("The MTA-STS policy mode is none, so it is disabled")
+	default:
+		report.errorf("The MTA-STS policy has an invalid mode '%s'", policy["mode"])
+	}
+
+	//
+	// Every MX host should be covered by the policy, otherwise
+	// senders who enforce it will not deliver to that host.
+	//
+	for _, host := range report.MX {
+		if host.Host == "." {
+			continue
+		}
+		if !mtaSTSMatches(mx, host.Host) {
+			report.errorf("The MX host %s isn't permitted by the MTA-STS policy", host.Host)
+		}
+	}
+}
+
+//
+// fetchMTASTSPolicy retrieves, and parses, the MTA-STS policy of the domain.
+//
+// We return the "key: value" pairs, along with the list of MX patterns
+// which is the only key that may be repeated.
+//
+func fetchMTASTSPolicy(domain string) (map[string]string, []string, error) {
+
+	resp, err := mtaSTSClient.Get(fmt.Sprintf(mtaSTSPolicyURL, domain))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return nil, nil, fmt.Errorf("redirected to '%s', which isn't permitted", resp.Header.Get("Location"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("status-code %d", resp.StatusCode)
+	}
+
+	policy := make(map[string]string)
+	var mx []string
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 64*1024))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		if key == "mx" {
+			mx = append(mx, strings.ToLower(value))
+			continue
+		}
+		policy[key] = value
+	}
+
+	if policy["version"] != "STSv1" {
+		return nil, nil, errors.New("the policy has no 'version: STSv1' line")
+	}
+	return policy, mx, nil
+}
+
+//
+// mtaSTSMatches tests whether the given host is matched by one of the MX
+// patterns of an MTA-STS policy, which may have a leading wildcard label.
+//
+func mtaSTSMatches(patterns []string, host string) bool {
+	host = strings.TrimSuffix(host, ".")
+
+	for _, p := range patterns {
+		p = strings.TrimSuffix(p, ".")
+		if p == host {
+			return true
+		}
+		if strings.HasPrefix(p, "*.") {
+			parts := strings.SplitN(host, ".", 2)
+			if len(parts) == 2 && parts[1] == p[2:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+// checkTLSRPT finds the SMTP TLS reporting record of the domain.
+//
+func (rs *Resolver) checkTLSRPT(report *EmailReport) {
+
+	records := rs.txtWithPrefix("_smtp._tls."+report.Domain, "v=TLSRPTv1")
+	if len(records) == 0 {
+		if report.MTASTS != nil {
+			report.warnf("%s has an MTA-STS policy, but no TLS-RPT record to receive failure reports", report.Domain)
+		}
+		return
+	}
+	if len(records) > 1 {
+		report.errorf("%s has %d TLS-RPT records, so none will be used", report.Domain, len(records))
+	}
+
+	rpt := &TLSRPTRecord{Record: records[0], RUA: []string{}}
+	for _, rua := range strings.Split(parseTags(records[0])["rua"], ",") {
+		if rua = strings.TrimSpace(rua); rua != "" {
+			rpt.RUA = append(rpt.RUA, rua)
+		}
+	}
+	if len(rpt.RUA) == 0 {
+		report.errorf("The TLS-RPT record has no 'rua' destination")
+	}
+	report.TLSRPT = rpt
+}
+
+//
+// checkDKIM looks for DKIM keys under each of the given selectors, and
+// reports upon their strength.
+//
+func (rs *Resolver) checkDKIM(report *EmailReport, selectors []string) {
+
+	for _, selector := range selectors {
+
+		records := rs.txtWithPrefix(selector+"._domainkey."+report.Domain, "")
+		if len(records) == 0 {
+			continue
+		}
+
+		key := DKIMKey{Selector: selector, Record: records[0]}
+		tags := parseTags(records[0])
+
+		key.KeyType = strings.ToLower(tags["k"])
+		if key.KeyType == "" {
+			key.KeyType = "rsa"
+		}
+
+		p := strings.Replace(tags["p"], " ", "", -1)
+		if p == "" {
+			report.warnf("The DKIM key for selector '%s' has been revoked", selector)
+			report.DKIM = append(report.DKIM, key)
+			continue
+		}
+
+		bits, err := dkimKeyBits(key.KeyType, p)
+		if err != nil {
+			report.errorf("The DKIM key for selector '%s' is invalid: %s", selector, err.Error())
+		}
+		key.Bits = bits
+
+		if key.KeyType == "rsa" && bits > 0 && bits < 1024 {
+			report.errorf("The DKIM key for selector '%s' is only %d bits, and will be ignored by most receivers", selector, bits)
+		} else if key.KeyType == "rsa" && bits > 0 && bits < 2048 {
+			report.warnf("The DKIM key for selector '%s' is only %d bits, 2048 is recommended", selector, bits)
+		}
+		report.DKIM = append(report.DKIM, key)
+	}
+
+	if len(report.DKIM) == 0 {
+		report.warnf("No DKIM keys were found for the selectors: %s", strings.Join(selectors, ", "))
+	}
+}
+
+//
+// dkimKeyBits returns the size of the given base64-encoded public key.
+//
+func dkimKeyBits(keyType string, p string) (int, error) {
+
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return 0, errors.New("the key is not valid base64")
+	}
+
+	switch keyType {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return 0, errors.New("the key is not a valid public key")
+		}
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return 0, errors.New("the key is not an RSA key")
+		}
+		return rsaKey.N.BitLen(), nil
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return 0, errors.New("the key is not a valid ed25519 key")
+		}
+		return 256, nil
+	}
+	return 0, fmt.Errorf("unknown key type '%s'", keyType)
+}
@@ -0,0 +1,277 @@
+//
+// Test our email-authentication report.
+//
+
+package resolver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test a domain with a little bit of everything wrong.
+//
+func TestCheckEmail(t *testing.T) {
+
+	//
+	// Generate a weak RSA key, and an ed25519 key, for DKIM.
+	//
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&weak.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := dnstest.Start(t, dnstest.Zone(t,
+		"example.test. 60 IN MX 10 mx1.example.test.",
+		"example.test. 60 IN MX 20 mx2.example.test.",
+		"mx1.example.test. 60 IN A 192.0.2.1",
+		"1.2.0.192.in-addr.arpa. 60 IN PTR mx1.example.test.",
+		"mx2.example.test. 60 IN A 192.0.2.2",
+		"example.test. 60 IN TXT \"v=spf1 mx \" \"ptr ?all\"",
+		"example.test. 60 IN TXT \"v=spf1 -all\"",
+		"example.test. 60 IN TXT \"google-site-verification=xxx\"",
+		"_dmarc.example.test. 60 IN TXT \"v=DMARC1; p=none; pct=50\"",
+		"_mta-sts.example.test. 60 IN TXT \"v=STSv1; id=20240101\"",
+		"_smtp._tls.example.test. 60 IN TXT \"v=TLSRPTv1; rua=mailto:tls@example.test\"",
+		fmt.Sprintf("s1._domainkey.example.test. 60 IN TXT \"v=DKIM1; k=rsa; p=%s\"", base64.StdEncoding.EncodeToString(der)),
+		fmt.Sprintf("s2._domainkey.example.test. 60 IN TXT \"v=DKIM1; k=ed25519; p=%s\"", base64.StdEncoding.EncodeToString(edPub)),
+		"old._domainkey.example.test. 60 IN TXT \"v=DKIM1; p=\"",
+	))
+	rs := newTestResolver(addr)
+
+	//
+	// The MTA-STS policy only covers mx1.
+	//
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.test" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "version: STSv1\r\nmode: testing\r\nmx: mx1.example.test\r\nmax_age: 86400\r\n")
+	}))
+	defer ts.Close()
+
+	oldURL := mtaSTSPolicyURL
+	mtaSTSPolicyURL = ts.URL + "/%s"
+	defer func() { mtaSTSPolicyURL = oldURL }()
+
+	oldClient := mtaSTSClient
+	mtaSTSClient = newMTASTSClient(nil)
+	defer func() { mtaSTSClient = oldClient }()
+
+	report := rs.CheckEmail("example.test", []string{"s1", "s2", "old", "missing"})
+
+	//
+	// The records should have been found, and parsed.
+	//
+	if len(report.MX) != 2 || report.MX[0].Addresses[0].Confirmed != true {
+		t.Errorf("Unexpected MX hosts: %v", report.MX)
+	}
+	if report.SPF == nil || report.SPF.Record != "v=spf1 mx ptr ?all" {
+		t.Errorf("Unexpected SPF: %v", report.SPF)
+	}
+	if report.DMARC == nil || report.DMARC.Tags["p"] != "none" {
+		t.Errorf("Unexpected DMARC: %v", report.DMARC)
+	}
+	if report.MTASTS == nil || report.MTASTS.ID != "20240101" || report.MTASTS.Policy["mode"] != "testing" {
+		t.Errorf("Unexpected MTA-STS: %v", report.MTASTS)
+	}
+	if report.TLSRPT == nil || report.TLSRPT.RUA[0] != "mailto:tls@example.test" {
+		t.Errorf("Unexpected TLS-RPT: %v", report.TLSRPT)
+	}
+	if len(report.DKIM) != 3 || report.DKIM[0].Bits != 1024 || report.DKIM[1].Bits != 256 {
+		t.Errorf("Unexpected DKIM: %v", report.DKIM)
+	}
+
+	//
+	// And the problems noted.
+	//
+	errors := []string{
+		"has 2 SPF records",
+		"mx2.example.test. isn't permitted by the MTA-STS policy",
+	}
+	warnings := []string{
+		"no reverse DNS for 192.0.2.2",
+		"'ptr' mechanism, which is deprecated",
+		"ends with ?all",
+		"p=none",
+		"no 'rua' tag",
+		"only applies to 50% of failing mail",
+		"testing mode",
+		"selector 's1' is only 1024 bits",
+		"selector 'old' has been revoked",
+	}
+
+	all := strings.Join(report.Errors, "\n")
+	for _, e := range errors {
+		if !strings.Contains(all, e) {
+			t.Errorf("Missing error '%s' in:\n%s", e, all)
+		}
+	}
+	if len(report.Errors) != len(errors) {
+		t.Errorf("Unexpected errors:\n%s", all)
+	}
+
+	all = strings.Join(report.Warnings, "\n")
+	for _, w := range warnings {
+		if !strings.Contains(all, w) {
+			t.Errorf("Missing warning '%s' in:\n%s", w, all)
+		}
+	}
+	if len(report.Warnings) != len(warnings) {
+		t.Errorf("Unexpected warnings:\n%s", all)
+	}
+}
+
+//
+// Test a domain which has nothing configured.
+//
+func TestCheckEmailMissing(t *testing.T) {
+
+	addr := dnstest.Start(t, dnstest.Zone(t, "empty.test. 60 IN A 192.0.2.1"))
+	rs := newTestResolver(addr)
+
+	report := rs.CheckEmail("empty.test", []string{"default"})
+
+	if report.SPF != nil || report.DMARC != nil || report.MTASTS != nil || report.TLSRPT != nil {
+		t.Errorf("Unexpected records: %v", report)
+	}
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0], "has no MX records") {
+		t.Errorf("Unexpected errors: %v", report.Errors)
+	}
+	for _, w := range []string{"no SPF record", "no DMARC record", "No DKIM keys were found"} {
+		if !strings.Contains(strings.Join(report.Warnings, "\n"), w) {
+			t.Errorf("Missing warning '%s' in %v", w, report.Warnings)
+		}
+	}
+}
+
+//
+// Test that only so many mail-exchangers, and addresses, are checked, and
+// that we don't wait too long for them.
+//
+func TestCheckEmailLimits(t *testing.T) {
+
+	oldHosts, oldAddresses, oldTimeout := maxMXHosts, maxMXAddresses, mxTimeout
+	maxMXHosts, maxMXAddresses = 2, 1
+	defer func() { maxMXHosts, maxMXAddresses, mxTimeout = oldHosts, oldAddresses, oldTimeout }()
+
+	zone := dnstest.Zone(t,
+		"example.test. 60 IN MX 10 mx1.example.test.",
+		"example.test. 60 IN MX 20 mx2.example.test.",
+		"example.test. 60 IN MX 30 mx3.example.test.",
+		"mx1.example.test. 60 IN A 192.0.2.1",
+		"mx1.example.test. 60 IN A 192.0.2.2",
+		"mx2.example.test. 60 IN A 192.0.2.3",
+		"mx3.example.test. 60 IN A 192.0.2.4",
+	)
+
+	//
+	// Reverse lookups are slow, when we want them to be.
+	//
+	var slow int32
+	addr := dnstest.Start(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		if req.Question[0].Qtype == dns.TypePTR && atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		zone(w, req)
+	})
+	rs := newTestResolver(addr)
+
+	report := rs.CheckEmail("example.test", nil)
+	if len(report.MX) != 3 || len(report.MX[0].Addresses) != 1 || len(report.MX[1].Addresses) != 1 || report.MX[2].Addresses != nil {
+		t.Errorf("Unexpected MX hosts: %v", report.MX)
+	}
+	if !strings.Contains(strings.Join(report.Warnings, "\n"), "has 3 MX records, only the first 2 were checked") {
+		t.Errorf("Missing warning in %v", report.Warnings)
+	}
+
+	atomic.StoreInt32(&slow, 1)
+	mxTimeout = 50 * time.Millisecond
+	report = rs.CheckEmail("example.test", nil)
+	if report.MX[0].Addresses != nil || !strings.Contains(strings.Join(report.Warnings, "\n"), "Timed out checking the MX hosts") {
+		t.Errorf("Unexpected report: %v %v", report.MX, report.Warnings)
+	}
+}
+
+//
+// Test the matching of MTA-STS patterns.
+//
+func TestMTASTSMatches(t *testing.T) {
+
+	patterns := []string{"mail.example.com", "*.example.net"}
+
+	tests := map[string]bool{
+		"mail.example.com.": true,
+		"mx.example.com.":   false,
+		"mx.example.net.":   true,
+		"a.mx.example.net.": false,
+		"example.net.":      false,
+	}
+	for host, expected := range tests {
+		if mtaSTSMatches(patterns, host) != expected {
+			t.Errorf("Unexpected result for %s", host)
+		}
+	}
+}
+
+//
+// Test that MTA-STS policies aren't fetched from reserved addresses, and
+// that redirects aren't followed.
+//
+func TestFetchMTASTSPolicyRefused(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect.test" {
+			http.Redirect(w, r, "http://192.0.2.1/policy", http.StatusFound)
+			return
+		}
+		fmt.Fprintf(w, "version: STSv1\r\nmode: enforce\r\n")
+	}))
+	defer ts.Close()
+
+	oldURL := mtaSTSPolicyURL
+	mtaSTSPolicyURL = ts.URL + "/%s"
+	defer func() { mtaSTSPolicyURL = oldURL }()
+
+	_, _, err := fetchMTASTSPolicy("example.test")
+	if err == nil || !strings.Contains(err.Error(), "reserved address 127.0.0.1") {
+		t.Errorf("Expected the loopback address to be refused, got %v", err)
+	}
+
+	oldClient := mtaSTSClient
+	mtaSTSClient = newMTASTSClient(nil)
+	defer func() { mtaSTSClient = oldClient }()
+
+	_, _, err = fetchMTASTSPolicy("redirect.test")
+	if err == nil || !strings.Contains(err.Error(), "redirected to 'http://192.0.2.1/policy'") {
+		t.Errorf("Expected the redirect to be refused, got %v", err)
+	}
+
+	policy, _, err := fetchMTASTSPolicy("example.test")
+	if err != nil || policy["mode"] != "enforce" {
+		t.Errorf("Unexpected policy %v %v", policy, err)
+	}
+}
@@ -0,0 +1,595 @@
+//
+// This file contains our domain-linter, which runs a number of rules
+// over the live records of a domain and reports upon the problems it
+// finds.
+//
+// Each rule is a function which receives a lintTarget, from which it
+// may look up whichever records it needs.  Lookups are cached, so the
+// rules may share them freely.
+//
+
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+//
+// The severity of each finding.
+//
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+	lintInfo    = "info"
+)
+
+// MaxLintNames is the number of additional names a caller may ask us
+// to check.
+const MaxLintNames = 20
+
+//
+// cloudSuffixes are the suffixes of hostnames which belong to hosting
+// providers, where anybody may claim a name which isn't in use.  A CNAME
+// pointing to such a name which doesn't exist may be hijacked.
+//
+var cloudSuffixes = []string{
+	".s3.amazonaws.com.",
+	".cloudfront.net.",
+	".elasticbeanstalk.com.",
+	".azurewebsites.net.",
+	".cloudapp.net.",
+	".cloudapp.azure.com.",
+	".blob.core.windows.net.",
+	".trafficmanager.net.",
+	".herokuapp.com.",
+	".herokudns.com.",
+	".github.io.",
+	".netlify.app.",
+	".pages.dev.",
+	".myshopify.com.",
+	".ghost.io.",
+	".surge.sh.",
+}
+
+//
+// lintRule is a single rule, and the function which checks it.
+//
+type lintRule struct {
+	name  string
+	check func(t *lintTarget) []LintFinding
+}
+
+//
+// lintRules are all the rules we run, in the order they're reported.
+//
+var lintRules = []lintRule{
+	{"cname-apex", lintCNAMEApex},
+	{"cname-other-data", lintCNAMEOtherData},
+	{"mx-cname", lintMXCNAME},
+	{"ns-cname", lintNSCNAME},
+	{"missing-glue", lintMissingGlue},
+	{"ns-unresolvable", lintNSUnresolvable},
+	{"ttl-inconsistent", lintTTLs},
+	{"soa-timers", lintSOATimers},
+	{"dangling-cname", lintDanglingCNAME},
+	{"aaaa-parity", lintAAAAParity},
+}
+
+// LintReport is the result of linting a domain.
+type LintReport struct {
+	// Domain is the domain we checked.
+	Domain string `json:"domain"`
+
+	// Names are the names, within the domain, whose records were
+	// examined in addition to those of the MX & NS hosts.
+	Names []string `json:"names"`
+
+	// Rules are the names of the rules which were run.
+	Rules []string `json:"rules"`
+
+	// Findings holds the problems found, errors first.
+	Findings []LintFinding `json:"findings"`
+
+	// Errors & Warnings count the findings of each severity.
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+}
+
+// LintFinding is a single problem found by a rule.
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+}
+
+//
+// lintTarget is the domain being linted, along with a cache of the
+// lookups made by the rules.
+//
+type lintTarget struct {
+	// rs makes our queries.
+	rs *Resolver
+
+	// policy controls which nameservers we may query directly.
+	policy *NetworkPolicy
+
+	// zone is the fully-qualified name of the domain.
+	zone string
+
+	// names are the names whose records are examined.
+	names []string
+
+	mutex   sync.Mutex
+	answers map[string]lintAnswer
+}
+
+//
+// lintAnswer is a cached lookup.
+//
+type lintAnswer struct {
+	rrs []dns.RR
+	err error
+}
+
+//
+// newLintTarget creates a target for the given domain, which will
+// examine the apex, "www", and the given additional names.
+//
+func newLintTarget(rs *Resolver, domain string, extra []string, policy *NetworkPolicy) *lintTarget {
+
+	t := &lintTarget{
+		rs:      rs,
+		policy:  policy,
+		zone:    dns.Fqdn(strings.ToLower(domain)),
+		answers: make(map[string]lintAnswer),
+	}
+	t.names = []string{t.zone, "www." + t.zone}
+
+	for _, name := range extra {
+		name = dns.Fqdn(strings.ToLower(name))
+		if !dns.IsSubDomain(t.zone, name) {
+			name = name + t.zone
+		}
+		if !containsString(t.names, name) {
+			t.names = append(t.names, name)
+		}
+	}
+	return t
+}
+
+//
+// lookup returns the answer-section of the given query, which may include
+// CNAMEs and the records of other names.
+//
+// A name which doesn't exist gives a noSuchDomain error.
+//
+func (t *lintTarget) lookup(name string, qtype string) ([]dns.RR, error) {
+	key := strings.ToLower(dns.Fqdn(name)) + "/" + qtype
+
+	t.mutex.Lock()
+	cached, ok := t.answers[key]
+	t.mutex.Unlock()
+	if ok {
+		return cached.rrs, cached.err
+	}
+
+	var rrs []dns.RR
+	r, err := t.rs.Query(name, qtype, DefaultQueryOptions())
+	if err == nil {
+		rrs = r.Answer
+	}
+
+	t.mutex.Lock()
+	t.answers[key] = lintAnswer{rrs: rrs, err: err}
+	t.mutex.Unlock()
+	return rrs, err
+}
+
+//
+// records returns the records of the given type which belong to the
+// given name itself.
+//
+func (t *lintTarget) records(name string, qtype string) []dns.RR {
+	var out []dns.RR
+
+	rrs, _ := t.lookup(name, qtype)
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == StringToType[qtype] && strings.EqualFold(rr.Header().Name, name) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+//
+// cname returns the target of the CNAME record of the given name, or ""
+// if there is none.
+//
+func (t *lintTarget) cname(name string) string {
+	rrs := t.records(name, "CNAME")
+	if len(rrs) == 0 {
+		return ""
+	}
+	return strings.ToLower(rrs[0].(*dns.CNAME).Target)
+}
+
+//
+// addresses returns the A & AAAA records of the given name, following
+// any CNAMEs.
+//
+func (t *lintTarget) addresses(name string) (v4 []dns.RR, v6 []dns.RR) {
+	rrs, _ := t.lookup(name, "A")
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeA {
+			v4 = append(v4, rr)
+		}
+	}
+	rrs, _ = t.lookup(name, "AAAA")
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeAAAA {
+			v6 = append(v6, rr)
+		}
+	}
+	return v4, v6
+}
+
+//
+// mxHosts returns the hosts the MX records of the domain point to.
+//
+func (t *lintTarget) mxHosts() []string {
+	var hosts []string
+	for _, rr := range t.records(t.zone, "MX") {
+		host := strings.ToLower(rr.(*dns.MX).Mx)
+		if host != "." && !containsString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+//
+// nsHosts returns the hosts the NS records of the domain point to.
+//
+func (t *lintTarget) nsHosts() []string {
+	rrs, _ := t.lookup(t.zone, "NS")
+	return nsNames(rrs, t.zone)
+}
+
+//
+// finding is a helper to create a finding.
+//
+func finding(rule string, severity string, name string, format string, args ...interface{}) LintFinding {
+	return LintFinding{Rule: rule, Severity: severity, Name: name, Message: fmt.Sprintf(format, args...)}
+}
+
+//
+// lintCNAMEApex reports a CNAME at the apex of the domain, which can't
+// coexist with the SOA & NS records every zone must have.
+//
+func lintCNAMEApex(t *lintTarget) []LintFinding {
+	if target := t.cname(t.zone); target != "" {
+		return []LintFinding{finding("cname-apex", lintError, t.zone,
+			"The zone apex is a CNAME (to %s), which is not permitted alongside the SOA and NS records", target)}
+	}
+	return nil
+}
+
+//
+// lintCNAMEOtherData reports names which have a CNAME and other records,
+// which RFC 1034 forbids.
+//
+func lintCNAMEOtherData(t *lintTarget) []LintFinding {
+	var out []LintFinding
+
+	for _, name := range t.names {
+		if t.cname(name) == "" {
+			continue
+		}
+
+		var others []string
+		for _, qtype := range []string{"A", "AAAA", "MX", "TXT", "NS", "SOA"} {
+			if len(t.records(name, qtype)) > 0 {
+				others = append(others, qtype)
+			}
+		}
+		if len(others) > 0 {
+			out = append(out, finding("cname-other-data", lintError, name,
+				"%s has a CNAME record alongside %s records", name, strings.Join(others, ", ")))
+		}
+	}
+	return out
+}
+
+//
+// lintMXCNAME reports MX records which point to a CNAME, which RFC 2181
+// forbids.
+//
+func lintMXCNAME(t *lintTarget) []LintFinding {
+	var out []LintFinding
+	for _, host := range t.mxHosts() {
+		if target := t.cname(host); target != "" {
+			out = append(out, finding("mx-cname", lintError, host,
+				"The MX host %s is a CNAME (to %s), MX records must point to a name with addresses", host, target))
+		}
+	}
+	return out
+}
+
+//
+// lintNSCNAME reports NS records which point to a CNAME, which RFC 2181
+// forbids.
+//
+func lintNSCNAME(t *lintTarget) []LintFinding {
+	var out []LintFinding
+	for _, host := range t.nsHosts() {
+		if target := t.cname(host); target != "" {
+			out = append(out, finding("ns-cname", lintError, host,
+				"The nameserver %s is a CNAME (to %s), NS records must point to a name with addresses", host, target))
+		}
+	}
+	return out
+}
+
+//
+// lintMissingGlue reports nameservers within the domain itself for which
+// the parent zone doesn't supply glue, without which they can't be found.
+//
+func lintMissingGlue(t *lintTarget) []LintFinding {
+	var out []LintFinding
+
+	resp, err := t.rs.parentDelegation(t.zone, t.policy)
+	if err != nil {
+		return []LintFinding{finding("missing-glue", lintWarning, t.zone, "Failed to find the delegation: %s", err.Error())}
+	}
+
+	for _, host := range nsNames(append(resp.Answer, resp.Ns...), t.zone) {
+		if !dns.IsSubDomain(t.zone, host) {
+			continue
+		}
+		glue := false
+		for _, rr := range resp.Extra {
+			if strings.EqualFold(rr.Header().Name, host) && (rr.Header().Rrtype == dns.TypeA || rr.Header().Rrtype == dns.TypeAAAA) {
+				glue = true
+			}
+		}
+		if !glue {
+			out = append(out, finding("missing-glue", lintError, host,
+				"The nameserver %s is within %s, but the parent zone has no glue records for it", host, t.zone))
+		}
+	}
+	return out
+}
+
+//
+// lintNSUnresolvable reports nameservers which have no addresses.
+//
+func lintNSUnresolvable(t *lintTarget) []LintFinding {
+	var out []LintFinding
+	for _, host := range t.nsHosts() {
+		v4, v6 := t.addresses(host)
+		if len(v4) == 0 && len(v6) == 0 {
+			out = append(out, finding("ns-unresolvable", lintError, host,
+				"The nameserver %s has no A or AAAA records", host))
+		}
+	}
+	return out
+}
+
+//
+// lintTTLs reports RRsets whose records have differing TTLs, which RFC
+// 2181 deprecates.
+//
+func lintTTLs(t *lintTarget) []LintFinding {
+	var out []LintFinding
+
+	for _, name := range t.names {
+		for _, qtype := range []string{"A", "AAAA", "MX", "NS", "TXT"} {
+			ttls := make(map[uint32]bool)
+			for _, rr := range t.records(name, qtype) {
+				ttls[rr.Header().Ttl] = true
+			}
+			if len(ttls) < 2 {
+				continue
+			}
+
+			var list []string
+			for ttl := range ttls {
+				list = append(list, fmt.Sprintf("%d", ttl))
+			}
+			sort.Strings(list)
+			out = append(out, finding("ttl-inconsistent", lintWarning, name,
+				"The %s records of %s have differing TTLs: %s", qtype, name, strings.Join(list, ", ")))
+		}
+	}
+	return out
+}
+
+//
+// lintSOATimers reports SOA timers outside the recommended ranges.
+//
+func lintSOATimers(t *lintTarget) []LintFinding {
+	var out []LintFinding
+
+	for _, rr := range t.records(t.zone, "SOA") {
+		soa := rr.(*dns.SOA)
+
+		check := func(ok bool, format string, args ...interface{}) {
+			if !ok {
+				out = append(out, finding("soa-timers", lintWarning, t.zone, format, args...))
+			}
+		}
+		check(soa.Refresh >= 1200 && soa.Refresh <= 86400,
+			"The SOA refresh of %d is outside the recommended range of 1200-86400 seconds", soa.Refresh)
+		check(soa.Retry >= 120 && soa.Retry < soa.Refresh,
+			"The SOA retry of %d should be at least 120 seconds, and less than the refresh of %d", soa.Retry, soa.Refresh)
+		check(soa.Expire >= 604800 && soa.Expire <= 2419200,
+			"The SOA expire of %d is outside the recommended range of 604800-2419200 seconds", soa.Expire)
+		check(soa.Expire >= 7*soa.Refresh,
+			"The SOA expire of %d should be at least seven times the refresh of %d", soa.Expire, soa.Refresh)
+		check(soa.Minttl >= 300 && soa.Minttl <= 86400,
+			"The SOA minimum (negative-caching) TTL of %d is outside the recommended range of 300-86400 seconds", soa.Minttl)
+	}
+	return out
+}
+
+//
+// lintDanglingCNAME reports CNAMEs which point to names that don't
+// exist, which is an error if the name belongs to a provider where
+// anybody could register it.
+//
+func lintDanglingCNAME(t *lintTarget) []LintFinding {
+	var out []LintFinding
+
+	for _, name := range mergeStrings(t.names, t.mxHosts()) {
+		target := t.cname(name)
+		if target == "" {
+			continue
+		}
+		_, err := t.lookup(target, "A")
+		if _, ok := err.(NoSuchDomain); !ok {
+			continue
+		}
+
+		cloud := false
+		for _, suffix := range cloudSuffixes {
+			if strings.HasSuffix(target, suffix) {
+				cloud = true
+			}
+		}
+		if cloud {
+			out = append(out, finding("dangling-cname", lintError, name,
+				"%s is a CNAME to %s, which doesn't exist and could be claimed by anybody", name, target))
+		} else {
+			out = append(out, finding("dangling-cname", lintWarning, name,
+				"%s is a CNAME to %s, which doesn't exist", name, target))
+		}
+	}
+	return out
+}
+
+//
+// lintAAAAParity reports names which have IPv4 addresses but no IPv6
+// addresses.
+//
+func lintAAAAParity(t *lintTarget) []LintFinding {
+	var out []LintFinding
+
+	names := mergeStrings(mergeStrings(t.names, t.mxHosts()), t.nsHosts())
+	for _, name := range names {
+		v4, v6 := t.addresses(name)
+		if len(v4) > 0 && len(v6) == 0 {
+			out = append(out, finding("aaaa-parity", lintInfo, name,
+				"%s has IPv4 addresses, but no IPv6 addresses", name))
+		}
+	}
+	return out
+}
+
+//
+// ValidateLintRules returns an error if any of the given rules is
+// unknown.
+//
+func ValidateLintRules(names []string) error {
+	_, err := findLintRules(names)
+	return err
+}
+
+//
+// findLintRules returns the rules with the given names, or all of them if
+// the list is empty.
+//
+func findLintRules(names []string) ([]lintRule, error) {
+
+	if len(names) == 0 {
+		return lintRules, nil
+	}
+
+	var out []lintRule
+	for _, name := range names {
+		found := false
+		for _, rule := range lintRules {
+			if rule.name == name {
+				out = append(out, rule)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown rule '%s'", name)
+		}
+	}
+	return out, nil
+}
+
+// LintDomain runs the named rules, or all of them if none are given,
+// over the given domain.  Additional names within the domain may be
+// given to have their records examined too.
+//
+// The nameservers of the domain are only queried directly if the policy
+// permits it.
+func (rs *Resolver) LintDomain(domain string, names []string, rules []string, policy *NetworkPolicy) (*LintReport, error) {
+
+	run, err := findLintRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newLintTarget(rs, domain, names, policy)
+
+	//
+	// The domain must exist for the report to make any sense.
+	//
+	_, err = t.lookup(t.zone, "SOA")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LintReport{
+		Domain:   t.zone,
+		Names:    t.names,
+		Rules:    []string{},
+		Findings: []LintFinding{},
+	}
+
+	results := make([][]LintFinding, len(run))
+
+	var wg sync.WaitGroup
+	for i, rule := range run {
+		report.Rules = append(report.Rules, rule.name)
+
+		wg.Add(1)
+		go func(i int, rule lintRule) {
+			defer wg.Done()
+			results[i] = rule.check(t)
+		}(i, rule)
+	}
+	wg.Wait()
+
+	for _, found := range results {
+		report.Findings = append(report.Findings, found...)
+	}
+
+	//
+	// Errors first, then warnings, and then everything else.
+	//
+	order := map[string]int{lintError: 0, lintWarning: 1, lintInfo: 2}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return order[report.Findings[i].Severity] < order[report.Findings[j].Severity]
+	})
+
+	for _, f := range report.Findings {
+		switch f.Severity {
+		case lintError:
+			report.Errors++
+		case lintWarning:
+			report.Warnings++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,131 @@
+//
+// Test our domain-linter, one rule at a time.
+//
+
+package resolver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// lintSOA is a SOA record with sensible timers.
+//
+const lintSOA = "example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 1 7200 900 1209600 3600"
+
+//
+// Test each rule against a stand-in zone which breaks it, and one which
+// doesn't.
+//
+func TestLintRules(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		rule    func(t *lintTarget) []LintFinding
+		records []string
+		names   []string
+		found   []string
+	}{
+		{"apex CNAME", lintCNAMEApex,
+			[]string{"example.test. 60 IN CNAME elsewhere.test."},
+			nil, []string{"error:example.test."}},
+		{"apex without CNAME", lintCNAMEApex,
+			[]string{lintSOA, "example.test. 60 IN A 192.0.2.1"},
+			nil, nil},
+
+		{"CNAME and other data", lintCNAMEOtherData,
+			[]string{lintSOA, "www.example.test. 60 IN CNAME example.test.", "www.example.test. 60 IN TXT \"hello\"", "shop.example.test. 60 IN CNAME shops.test."},
+			[]string{"shop"}, []string{"error:www.example.test."}},
+
+		{"MX to CNAME", lintMXCNAME,
+			[]string{lintSOA, "example.test. 60 IN MX 10 mail.example.test.", "example.test. 60 IN MX 20 mx.example.test.", "mail.example.test. 60 IN CNAME mx.example.test.", "mx.example.test. 60 IN A 192.0.2.1"},
+			nil, []string{"error:mail.example.test."}},
+
+		{"NS to CNAME", lintNSCNAME,
+			[]string{lintSOA, "example.test. 60 IN NS ns1.example.test.", "example.test. 60 IN NS ns2.example.test.", "ns1.example.test. 60 IN CNAME ns2.example.test.", "ns2.example.test. 60 IN A 192.0.2.1"},
+			nil, []string{"error:ns1.example.test."}},
+
+		{"NS without addresses", lintNSUnresolvable,
+			[]string{lintSOA, "example.test. 60 IN NS ns1.example.test.", "example.test. 60 IN NS ns2.example.test.", "ns1.example.test. 60 IN A 192.0.2.1"},
+			nil, []string{"error:ns2.example.test."}},
+
+		{"TTLs", lintTTLs,
+			[]string{lintSOA, "example.test. 60 IN A 192.0.2.1", "example.test. 300 IN A 192.0.2.2", "www.example.test. 60 IN A 192.0.2.1", "www.example.test. 60 IN A 192.0.2.2"},
+			nil, []string{"warning:example.test."}},
+
+		{"SOA timers", lintSOATimers,
+			[]string{"example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 1 60 900 86400 3600"},
+			nil, []string{"warning:example.test.", "warning:example.test.", "warning:example.test."}},
+		{"sensible SOA timers", lintSOATimers,
+			[]string{lintSOA},
+			nil, nil},
+
+		{"dangling CNAMEs", lintDanglingCNAME,
+			[]string{lintSOA, "www.example.test. 60 IN CNAME gone.s3.amazonaws.com.", "old.example.test. 60 IN CNAME gone.example.test.", "ok.example.test. 60 IN CNAME example.test."},
+			[]string{"old", "ok"}, []string{"warning:old.example.test.", "error:www.example.test."}},
+
+		{"AAAA parity", lintAAAAParity,
+			[]string{lintSOA, "example.test. 60 IN A 192.0.2.1", "example.test. 60 IN AAAA 2001:db8::1", "www.example.test. 60 IN A 192.0.2.1", "example.test. 60 IN MX 10 mx.example.test.", "mx.example.test. 60 IN A 192.0.2.2"},
+			nil, []string{"info:mx.example.test.", "info:www.example.test."}},
+	}
+
+	for _, test := range tests {
+		rs := newTestResolver(dnstest.Start(t, dnstest.Zone(t, test.records...)))
+
+		var found []string
+		for _, f := range test.rule(newLintTarget(rs, "example.test", test.names, allowPolicy(t))) {
+			found = append(found, f.Severity+":"+f.Name)
+		}
+		if strings.Join(found, ",") != strings.Join(test.found, ",") {
+			t.Errorf("%s: found %v, expected %v", test.name, found, test.found)
+		}
+	}
+}
+
+//
+// Test that nameservers within the zone need glue.
+//
+func TestLintMissingGlue(t *testing.T) {
+
+	oldTimeout := consistencyTimeout
+	consistencyTimeout = time.Second
+	defer func() { consistencyTimeout = oldTimeout }()
+
+	rs := newTestResolver(dnstest.Start(t, dnstest.Zone(t,
+		"test. 60 IN NS a.nic.test.",
+		"a.nic.test. 60 IN A 127.0.0.2",
+	)))
+
+	//
+	// The parent only has glue for ns1.
+	//
+	parent := func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		for _, ns := range []string{"ns1.example.test.", "ns2.example.test.", "ns.example.net."} {
+			m.Ns = append(m.Ns, &dns.NS{Hdr: dns.RR_Header{Name: "example.test.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: ns})
+		}
+		glue, _ := dns.NewRR("ns1.example.test. 60 IN A 192.0.2.1")
+		m.Extra = append(m.Extra, glue)
+		w.WriteMsg(m)
+	}
+	rs.Port = dnstest.StartAuthoritative(t, map[string]dns.HandlerFunc{"127.0.0.2": parent})
+
+	found := lintMissingGlue(newLintTarget(rs, "example.test", nil, allowPolicy(t, "127.0.0.2")))
+	if len(found) != 1 || found[0].Name != "ns2.example.test." || found[0].Severity != lintError {
+		t.Errorf("Unexpected findings: %v", found)
+	}
+
+	//
+	// The parent can't be queried if our policy doesn't allow it.
+	//
+	found = lintMissingGlue(newLintTarget(rs, "example.test", nil, allowPolicy(t)))
+	if len(found) != 1 || !strings.Contains(found[0].Message, "no delegation") {
+		t.Errorf("Unexpected findings: %v", found)
+	}
+}
@@ -0,0 +1,186 @@
+//
+// This file contains our propagation-checker, which asks a number of
+// public resolvers the same question to see whether they agree.
+//
+// This answers the common question "has my change propagated yet?".
+//
+
+package resolver
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// PublicResolver is a named recursive resolver.
+type PublicResolver struct {
+	// Name is a human-readable name for the resolver.
+	Name string `json:"name"`
+
+	// Address is the IP address of the resolver, with an optional port.
+	Address string `json:"address"`
+}
+
+// DefaultResolvers are the resolvers we query if the configuration-file
+// doesn't list any of its own.
+var DefaultResolvers = []PublicResolver{
+	{Name: "Cloudflare", Address: "1.1.1.1:53"},
+	{Name: "Google", Address: "8.8.8.8:53"},
+	{Name: "OpenDNS", Address: "208.67.222.222:53"},
+	{Name: "Quad9", Address: "9.9.9.9:53"},
+}
+
+// PropagationReport is the result of asking many resolvers the same
+// question.
+type PropagationReport struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Results holds the answer from each resolver.
+	Results []ResolverResult `json:"results"`
+
+	// Groups holds the distinct answers, and who returned each.
+	Groups []AnswerGroup `json:"groups"`
+
+	// Consistent is true if every resolver returned the same answer.
+	Consistent bool `json:"consistent"`
+}
+
+// ResolverResult holds the response of a single resolver.
+type ResolverResult struct {
+	Resolver string   `json:"resolver"`
+	Address  string   `json:"address"`
+	Rcode    string   `json:"rcode,omitempty"`
+	Answers  []string `json:"answers"`
+	TTL      uint32   `json:"ttl"`
+	Latency  int64    `json:"latency_ms"`
+	Error    string   `json:"error,omitempty"`
+
+	// Outlier is set if this resolver disagrees with the majority,
+	// or failed to answer at all.
+	Outlier bool `json:"outlier"`
+}
+
+// AnswerGroup is a distinct answer, and the resolvers which returned it.
+type AnswerGroup struct {
+	Rcode     string   `json:"rcode"`
+	Answers   []string `json:"answers"`
+	Resolvers []string `json:"resolvers"`
+}
+
+// CheckPropagation asks each of the given resolvers for the records of
+// the given name & type, in parallel, and compares their answers.
+func CheckPropagation(name string, qtype string, resolvers []PublicResolver) *PropagationReport {
+
+	report := &PropagationReport{
+		Name:    dns.Fqdn(name),
+		Type:    qtype,
+		Results: make([]ResolverResult, len(resolvers)),
+	}
+
+	var wg sync.WaitGroup
+	for i, r := range resolvers {
+		wg.Add(1)
+		go func(res *ResolverResult, r PublicResolver) {
+			defer wg.Done()
+			askResolver(res, r, report.Name, qtype)
+		}(&report.Results[i], r)
+	}
+	wg.Wait()
+
+	//
+	// Group the resolvers which answered by their answer.
+	//
+	groups := make(map[string]*AnswerGroup)
+	var keys []string
+	for _, res := range report.Results {
+		if res.Error != "" {
+			continue
+		}
+		key := res.Rcode + "\n" + strings.Join(res.Answers, "\n")
+		g, ok := groups[key]
+		if !ok {
+			g = &AnswerGroup{Rcode: res.Rcode, Answers: res.Answers}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.Resolvers = append(g.Resolvers, res.Resolver)
+	}
+
+	//
+	// The largest group comes first.
+	//
+	sort.SliceStable(keys, func(i, j int) bool {
+		return len(groups[keys[i]].Resolvers) > len(groups[keys[j]].Resolvers)
+	})
+	report.Groups = []AnswerGroup{}
+	for _, key := range keys {
+		report.Groups = append(report.Groups, *groups[key])
+	}
+
+	//
+	// Anybody outside a clear majority is an outlier, as is anybody
+	// who failed.  If the top two groups are tied there is no
+	// majority, so we can't say who is wrong.
+	//
+	majority := ""
+	if len(keys) == 1 || (len(keys) > 1 && len(groups[keys[0]].Resolvers) > len(groups[keys[1]].Resolvers)) {
+		majority = keys[0]
+	}
+	for i, res := range report.Results {
+		key := res.Rcode + "\n" + strings.Join(res.Answers, "\n")
+		if res.Error != "" || (majority != "" && key != majority) {
+			report.Results[i].Outlier = true
+		}
+	}
+
+	report.Consistent = len(keys) == 1
+	for _, res := range report.Results {
+		if res.Error != "" {
+			report.Consistent = false
+		}
+	}
+	return report
+}
+
+//
+// askResolver queries a single resolver, recording the result.
+//
+func askResolver(res *ResolverResult, r PublicResolver, name string, qtype string) {
+
+	res.Resolver = r.Name
+	res.Address = r.Address
+	res.Answers = []string{}
+
+	start := time.Now()
+	m, err := Exchange(r.Address, name, StringToType[qtype], DefaultQueryOptions())
+	res.Latency = int64(time.Since(start) / time.Millisecond)
+
+	if err != nil || m == nil {
+		res.Error = "No response"
+		if err != nil {
+			res.Error = err.Error()
+		}
+		return
+	}
+
+	res.Rcode = dns.RcodeToString[m.Rcode]
+
+	//
+	// The TTL we report is the lowest of the answers we care about.
+	//
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype != StringToType[qtype] {
+			continue
+		}
+		res.Answers = append(res.Answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		if len(res.Answers) == 1 || rr.Header().Ttl < res.TTL {
+			res.TTL = rr.Header().Ttl
+		}
+	}
+	sort.Strings(res.Answers)
+}
@@ -0,0 +1,74 @@
+//
+// Test our propagation-checker.
+//
+
+package resolver
+
+import (
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test that answers are grouped, and outliers found.
+//
+func TestCheckPropagation(t *testing.T) {
+
+	updated := dnstest.Start(t, dnstest.Zone(t, "example.com. 300 IN A 192.0.2.2"))
+	stale := dnstest.Start(t, dnstest.Zone(t, "example.com. 120 IN A 192.0.2.1"))
+
+	resolvers := []PublicResolver{
+		{Name: "one", Address: updated},
+		{Name: "two", Address: updated},
+		{Name: "three", Address: stale},
+		{Name: "broken", Address: dnstest.ClosedAddress(t)},
+	}
+
+	report := CheckPropagation("example.com", "A", resolvers)
+
+	if report.Consistent {
+		t.Errorf("The results should not be consistent")
+	}
+	if len(report.Results) != 4 {
+		t.Fatalf("Unexpected results: %v", report.Results)
+	}
+	if len(report.Groups) != 2 {
+		t.Fatalf("Unexpected groups: %v", report.Groups)
+	}
+	if report.Groups[0].Answers[0] != "192.0.2.2" || len(report.Groups[0].Resolvers) != 2 {
+		t.Errorf("Unexpected majority group: %v", report.Groups[0])
+	}
+
+	outliers := map[string]bool{"one": false, "two": false, "three": true, "broken": true}
+	for _, res := range report.Results {
+		if res.Outlier != outliers[res.Resolver] {
+			t.Errorf("Unexpected outlier status for %s: %v", res.Resolver, res.Outlier)
+		}
+	}
+	if report.Results[2].TTL != 120 {
+		t.Errorf("Unexpected TTL: %d", report.Results[2].TTL)
+	}
+	if report.Results[3].Error == "" {
+		t.Errorf("Expected an error from the broken resolver")
+	}
+}
+
+//
+// Test that a tie has no outliers, other than failures.
+//
+func TestCheckPropagationTie(t *testing.T) {
+
+	a := dnstest.Start(t, dnstest.Zone(t, "example.com. 300 IN A 192.0.2.2"))
+	b := dnstest.Start(t, dnstest.Zone(t, "example.com. 300 IN A 192.0.2.1"))
+
+	report := CheckPropagation("example.com", "A", []PublicResolver{{Name: "a", Address: a}, {Name: "b", Address: b}})
+	for _, res := range report.Results {
+		if res.Outlier {
+			t.Errorf("%s should not be an outlier", res.Resolver)
+		}
+	}
+	if report.Consistent {
+		t.Errorf("The results should not be consistent")
+	}
+}
@@ -0,0 +1,33 @@
+//
+// Package resolver performs the DNS queries behind our API, and the
+// analysis built upon them: consistency, propagation, email & SPF checks,
+// blocklists, linting, wildcards and zone-transfers.
+//
+// Everything is driven by a Resolver, which holds the nameservers to use,
+// so that several may be used at once with different settings.
+//
+package resolver
+
+// Resolver makes our DNS queries.
+//
+// Use New to create one, the zero-value isn't usable.
+type Resolver struct {
+	// Nameservers, if non-empty, replaces the servers listed in
+	// /etc/resolv.conf.  Each entry is a "host:port" pair.
+	Nameservers []string
+
+	// Port is used when we query nameservers we've discovered for
+	// ourselves, such as the authoritative servers of a zone.
+	Port string
+
+	// blocklists caches the answers of the blocklists we've queried.
+	blocklists *ttlCache
+}
+
+// New creates a resolver which uses the nameservers in /etc/resolv.conf.
+func New() *Resolver {
+	return &Resolver{
+		Port:       "53",
+		blocklists: newTTLCache(10000),
+	}
+}
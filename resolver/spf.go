@@ -0,0 +1,853 @@
+//
+// This file contains an implementation of the SPF check_host() function,
+// as described in RFC 7208, which tells us whether a given IP address
+// is permitted to send mail on behalf of a domain.
+//
+// As well as the result we record a trace of every term we evaluated,
+// so that callers can see exactly which mechanism matched, and why.
+//
+
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// The results check_host() may return.
+//
+const (
+	spfPass      = "pass"
+	spfFail      = "fail"
+	spfSoftFail  = "softfail"
+	spfNeutral   = "neutral"
+	spfNone      = "none"
+	spfPermError = "permerror"
+	spfTempError = "temperror"
+)
+
+//
+// The limits RFC 7208 places upon an evaluation.
+//
+const (
+	spfMaxLookups     = 10
+	spfMaxVoidLookups = 2
+	spfMaxNames       = 10
+)
+
+//
+// spfQualifiers maps the qualifier of a directive to the result it gives
+// if its mechanism matches.
+//
+var spfQualifiers = map[byte]string{
+	'+': spfPass,
+	'-': spfFail,
+	'~': spfSoftFail,
+	'?': spfNeutral,
+}
+
+// SPFEvaluation is the result of evaluating the SPF policy of a domain for
+// a given sender.
+type SPFEvaluation struct {
+	Domain string `json:"domain"`
+	IP     string `json:"ip"`
+	Sender string `json:"sender"`
+	Helo   string `json:"helo,omitempty"`
+
+	// Result is one of pass, fail, softfail, neutral, none, permerror
+	// or temperror.
+	Result string `json:"result"`
+
+	// Mechanism is the term which matched, if any.
+	Mechanism string `json:"mechanism,omitempty"`
+
+	// Explanation is the expanded "exp=" text of a failing domain.
+	Explanation string `json:"explanation,omitempty"`
+
+	// Lookups is the number of DNS-querying terms we evaluated.
+	Lookups int `json:"lookups"`
+
+	// VoidLookups is the number of queries which returned nothing.
+	VoidLookups int `json:"void_lookups"`
+
+	// Trace holds each step of the evaluation.
+	Trace []SPFStep `json:"trace"`
+}
+
+// SPFStep is a single step of an evaluation.
+type SPFStep struct {
+	// Depth is the level of include/redirect nesting.
+	Depth int `json:"depth"`
+
+	// Domain is the domain whose record is being evaluated.
+	Domain string `json:"domain"`
+
+	// Term is the directive or modifier evaluated, or empty when the
+	// step is the retrieval of the record itself.
+	Term string `json:"term,omitempty"`
+
+	// Result describes what happened.
+	Result string `json:"result"`
+}
+
+//
+// spfError is an error which terminates the evaluation with the given
+// result - either a permerror or a temperror.
+//
+type spfError struct {
+	result string
+	msg    string
+}
+
+func (e spfError) Error() string {
+	return e.result + ": " + e.msg
+}
+
+//
+// spfChecker holds the state of an evaluation, which is shared between
+// the records it includes or is redirected to.
+//
+type spfChecker struct {
+	rs     *Resolver
+	ip     net.IP
+	sender string
+	helo   string
+	eval   *SPFEvaluation
+}
+
+// CheckSPF evaluates the SPF policy of the given domain, for mail sent
+// from the given IP address by the given sender.
+//
+// The sender may be an address, or a bare domain in which case the
+// local-part is assumed to be "postmaster".  The helo name is optional.
+func (rs *Resolver) CheckSPF(domain string, ip net.IP, sender string, helo string) *SPFEvaluation {
+
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	if sender == "" {
+		sender = "postmaster@" + domain
+	}
+	if !strings.Contains(sender, "@") {
+		sender = "postmaster@" + sender
+	}
+	if strings.HasPrefix(sender, "@") {
+		sender = "postmaster" + sender
+	}
+
+	eval := &SPFEvaluation{
+		Domain: domain,
+		IP:     ip.String(),
+		Sender: sender,
+		Helo:   helo,
+		Trace:  []SPFStep{},
+	}
+	c := &spfChecker{rs: rs, ip: ip, sender: sender, helo: helo, eval: eval}
+
+	//
+	// IPv4-mapped addresses are treated as IPv4.
+	//
+	if ip4 := ip.To4(); ip4 != nil {
+		c.ip = ip4
+	}
+
+	result, err := c.checkHost(domain, 0)
+	if err != nil {
+		result = err.(spfError).result
+	}
+	eval.Result = result
+	return eval
+}
+
+//
+// step adds an entry to the trace.
+//
+func (c *spfChecker) step(depth int, domain string, term string, result string) {
+	c.eval.Trace = append(c.eval.Trace, SPFStep{Depth: depth, Domain: domain, Term: term, Result: result})
+}
+
+//
+// countLookup records a DNS-querying term, and fails if the limit has
+// been exceeded.
+//
+func (c *spfChecker) countLookup() error {
+	c.eval.Lookups++
+	if c.eval.Lookups > spfMaxLookups {
+		return spfError{spfPermError, fmt.Sprintf("more than %d DNS-querying terms", spfMaxLookups)}
+	}
+	return nil
+}
+
+//
+// resolve looks up the records of the given type, counting the lookups
+// which return nothing against the void-lookup limit.
+//
+func (c *spfChecker) resolve(name string, qtype string) ([]dns.RR, error) {
+
+	var out []dns.RR
+
+	r, err := c.rs.Query(name, qtype, DefaultQueryOptions())
+	if err != nil {
+		if _, ok := err.(NoSuchDomain); !ok {
+			return nil, spfError{spfTempError, fmt.Sprintf("failed to lookup %s %s: %s", qtype, name, err.Error())}
+		}
+	} else {
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == StringToType[qtype] {
+				out = append(out, rr)
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		c.eval.VoidLookups++
+		if c.eval.VoidLookups > spfMaxVoidLookups {
+			return nil, spfError{spfPermError, fmt.Sprintf("more than %d lookups returned no records", spfMaxVoidLookups)}
+		}
+	}
+	return out, nil
+}
+
+//
+// addresses returns the addresses of the given name, of the same family
+// as the address we're testing.
+//
+func (c *spfChecker) addresses(name string) ([]net.IP, error) {
+	var out []net.IP
+
+	qtype := "AAAA"
+	if c.ip.To4() != nil {
+		qtype = "A"
+	}
+
+	rrs, err := c.resolve(name, qtype)
+	for _, rr := range rrs {
+		switch v := rr.(type) {
+		case *dns.A:
+			out = append(out, v.A)
+		case *dns.AAAA:
+			out = append(out, v.AAAA)
+		}
+	}
+	return out, err
+}
+
+//
+// spfRecord finds the single SPF record of the given domain.
+//
+func (rs *Resolver) spfRecord(domain string) (string, error) {
+
+	r, err := rs.Query(domain, "TXT", DefaultQueryOptions())
+	if err != nil {
+		if _, ok := err.(NoSuchDomain); ok {
+			return "", nil
+		}
+		return "", spfError{spfTempError, fmt.Sprintf("failed to lookup TXT %s: %s", domain, err.Error())}
+	}
+
+	var records []string
+	for _, rr := range r.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		record := strings.Join(txt.Txt, "")
+		lower := strings.ToLower(record)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			records = append(records, record)
+		}
+	}
+
+	if len(records) > 1 {
+		return "", spfError{spfPermError, fmt.Sprintf("%s has %d SPF records", domain, len(records))}
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+	return records[0], nil
+}
+
+//
+// checkHost implements check_host() for the given domain.
+//
+func (c *spfChecker) checkHost(domain string, depth int) (string, error) {
+
+	if _, ok := dns.IsDomainName(domain); !ok || !strings.Contains(domain, ".") {
+		c.step(depth, domain, "", "none: invalid domain")
+		return spfNone, nil
+	}
+
+	record, err := c.rs.spfRecord(domain)
+	if err != nil {
+		c.step(depth, domain, "", err.Error())
+		return "", err
+	}
+	if record == "" {
+		c.step(depth, domain, "", "none: no SPF record")
+		return spfNone, nil
+	}
+	c.step(depth, domain, "", "record: "+record)
+
+	terms := strings.Fields(record)[1:]
+
+	//
+	// Find the modifiers first, as they apply regardless of where they
+	// appear in the record.
+	//
+	redirect := ""
+	exp := ""
+	for _, term := range terms {
+		name, value, ok := spfModifier(term)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "redirect":
+			if redirect != "" {
+				err = spfError{spfPermError, "more than one redirect modifier"}
+			}
+			redirect = value
+		case "exp":
+			if exp != "" {
+				err = spfError{spfPermError, "more than one exp modifier"}
+			}
+			exp = value
+		}
+		if err != nil {
+			c.step(depth, domain, term, err.Error())
+			return "", err
+		}
+	}
+
+	//
+	// Now the directives, in order.
+	//
+	for _, term := range terms {
+		if _, _, ok := spfModifier(term); ok {
+			continue
+		}
+
+		qualifier := byte('+')
+		mechanism := term
+		if _, ok := spfQualifiers[term[0]]; ok {
+			qualifier = term[0]
+			mechanism = term[1:]
+		}
+
+		var match bool
+		match, err = c.mechanism(domain, mechanism, depth)
+		if err != nil {
+			c.step(depth, domain, term, err.Error())
+			return "", err
+		}
+		if !match {
+			c.step(depth, domain, term, "no match")
+			continue
+		}
+
+		result := spfQualifiers[qualifier]
+		c.step(depth, domain, term, "match: "+result)
+		if depth == 0 {
+			c.eval.Mechanism = term
+		}
+		if result == spfFail && exp != "" {
+			c.explain(domain, exp)
+		}
+		return result, nil
+	}
+
+	//
+	// Nothing matched, so follow any redirect.
+	//
+	if redirect != "" {
+		err = c.countLookup()
+		if err != nil {
+			c.step(depth, domain, "redirect="+redirect, err.Error())
+			return "", err
+		}
+
+		var target string
+		target, err = c.expand(redirect, domain, false)
+		if err != nil {
+			c.step(depth, domain, "redirect="+redirect, err.Error())
+			return "", err
+		}
+		c.step(depth, domain, "redirect="+redirect, "redirecting to "+target)
+
+		c.eval.Explanation = ""
+		var result string
+		result, err = c.checkHost(target, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if result == spfNone {
+			err = spfError{spfPermError, "the redirect target " + target + " has no SPF record"}
+			c.step(depth, domain, "redirect="+redirect, err.Error())
+			return "", err
+		}
+		if depth == 0 {
+			c.eval.Mechanism = "redirect=" + redirect
+		}
+		return result, nil
+	}
+
+	c.step(depth, domain, "", "no match: "+spfNeutral)
+	return spfNeutral, nil
+}
+
+//
+// spfModifier tests whether the given term is a modifier, returning its
+// name and value if so.
+//
+func spfModifier(term string) (string, string, bool) {
+
+	i := strings.Index(term, "=")
+	if i < 1 {
+		return "", "", false
+	}
+
+	//
+	// A modifier name is alphanumeric, and is followed by "=".  The
+	// mechanisms can't contain "=" before a ":" or "/".
+	//
+	name := term[:i]
+	if strings.ContainsAny(name, ":/") {
+		return "", "", false
+	}
+	return strings.ToLower(name), term[i+1:], true
+}
+
+//
+// mechanism evaluates a single mechanism, returning true if it matched.
+//
+func (c *spfChecker) mechanism(domain string, mechanism string, depth int) (bool, error) {
+
+	//
+	// Split into the name, and everything after it.
+	//
+	end := strings.IndexAny(mechanism, ":/")
+	if end < 0 {
+		end = len(mechanism)
+	}
+	name := strings.ToLower(mechanism[:end])
+	rest := mechanism[end:]
+
+	//
+	// Most mechanisms take an optional domain-spec after a colon, and
+	// "a" and "mx" also take CIDR lengths after that.
+	//
+	spec := ""
+	cidr := ""
+	if strings.HasPrefix(rest, ":") {
+		spec = rest[1:]
+		if name == "a" || name == "mx" {
+			if i := strings.Index(spec, "/"); i >= 0 {
+				cidr = spec[i:]
+				spec = spec[:i]
+			}
+		}
+	} else {
+		cidr = rest
+	}
+
+	//
+	// Expand the macros in the domain-spec, or default to the
+	// current domain.
+	//
+	var err error
+	target := domain
+	if spec != "" && name != "ip4" && name != "ip6" {
+		target, err = c.expand(spec, domain, false)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	switch name {
+	case "all":
+		if rest != "" {
+			return false, spfError{spfPermError, "invalid 'all' mechanism"}
+		}
+		return true, nil
+
+	case "include":
+		if spec == "" {
+			return false, spfError{spfPermError, "include requires a domain"}
+		}
+		if err = c.countLookup(); err != nil {
+			return false, err
+		}
+		var result string
+		result, err = c.checkHost(target, depth+1)
+		if err != nil {
+			return false, err
+		}
+		switch result {
+		case spfPass:
+			return true, nil
+		case spfNone:
+			return false, spfError{spfPermError, "the included domain " + target + " has no SPF record"}
+		}
+		return false, nil
+
+	case "a", "mx":
+		if err = c.countLookup(); err != nil {
+			return false, err
+		}
+		var mask net.IPMask
+		mask, err = c.cidrMask(cidr)
+		if err != nil {
+			return false, err
+		}
+
+		hosts := []string{target}
+		if name == "mx" {
+			hosts = nil
+			var rrs []dns.RR
+			rrs, err = c.resolve(target, "MX")
+			if err != nil {
+				return false, err
+			}
+			if len(rrs) > spfMaxNames {
+				return false, spfError{spfPermError, fmt.Sprintf("%s has more than %d MX records", target, spfMaxNames)}
+			}
+			for _, rr := range rrs {
+				hosts = append(hosts, rr.(*dns.MX).Mx)
+			}
+		}
+
+		for _, host := range hosts {
+			var addrs []net.IP
+			addrs, err = c.addresses(host)
+			if err != nil {
+				return false, err
+			}
+			for _, addr := range addrs {
+				n := net.IPNet{IP: addr.Mask(mask), Mask: mask}
+				if n.Contains(c.ip) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case "ptr":
+		if err = c.countLookup(); err != nil {
+			return false, err
+		}
+		for _, ptr := range c.validatedNames() {
+			if ptr == strings.ToLower(target) || strings.HasSuffix(ptr, "."+strings.ToLower(target)) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "ip4", "ip6":
+		if spec == "" {
+			return false, spfError{spfPermError, name + " requires a network"}
+		}
+		if !strings.Contains(spec, "/") {
+			if name == "ip4" {
+				spec += "/32"
+			} else {
+				spec += "/128"
+			}
+		}
+		var ip net.IP
+		var n *net.IPNet
+		ip, n, err = net.ParseCIDR(spec)
+		if err != nil || (name == "ip4") != (ip.To4() != nil) {
+			return false, spfError{spfPermError, "invalid network '" + spec + "'"}
+		}
+		return n.Contains(c.ip), nil
+
+	case "exists":
+		if spec == "" {
+			return false, spfError{spfPermError, "exists requires a domain"}
+		}
+		if err = c.countLookup(); err != nil {
+			return false, err
+		}
+		var rrs []dns.RR
+		rrs, err = c.resolve(target, "A")
+		if err != nil {
+			return false, err
+		}
+		return len(rrs) > 0, nil
+	}
+
+	return false, spfError{spfPermError, "unknown mechanism '" + name + "'"}
+}
+
+//
+// cidrMask returns the mask to use for an "a" or "mx" mechanism, which
+// may specify an IPv4 length, an IPv6 length, or both: "/24//64".
+//
+func (c *spfChecker) cidrMask(cidr string) (net.IPMask, error) {
+
+	v4, v6 := 32, 128
+
+	if cidr != "" {
+		parts := strings.SplitN(cidr, "//", 2)
+		var err error
+		if parts[0] != "" {
+			v4, err = strconv.Atoi(strings.TrimPrefix(parts[0], "/"))
+			if err != nil || v4 < 0 || v4 > 32 || !strings.HasPrefix(parts[0], "/") {
+				return nil, spfError{spfPermError, "invalid CIDR length '" + cidr + "'"}
+			}
+		}
+		if len(parts) == 2 {
+			v6, err = strconv.Atoi(parts[1])
+			if err != nil || v6 < 0 || v6 > 128 {
+				return nil, spfError{spfPermError, "invalid CIDR length '" + cidr + "'"}
+			}
+		}
+	}
+
+	if c.ip.To4() != nil {
+		return net.CIDRMask(v4, 32), nil
+	}
+	return net.CIDRMask(v6, 128), nil
+}
+
+//
+// validatedNames returns the PTR names of the address we're testing,
+// which resolve back to it.
+//
+func (c *spfChecker) validatedNames() []string {
+	var out []string
+
+	arpa, err := dns.ReverseAddr(c.ip.String())
+	if err != nil {
+		return nil
+	}
+	rrs, err := c.resolve(arpa, "PTR")
+	if err != nil {
+		return nil
+	}
+
+	for i, rr := range rrs {
+		if i >= spfMaxNames {
+			break
+		}
+		name := strings.ToLower(strings.TrimSuffix(rr.(*dns.PTR).Ptr, "."))
+		addrs, e := c.addresses(name)
+		if e != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.Equal(c.ip) {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	return out
+}
+
+//
+// explain sets the explanation of a failure, from the TXT record which
+// the exp= modifier points at.  Failures here are silently ignored.
+//
+func (c *spfChecker) explain(domain string, exp string) {
+
+	target, err := c.expand(exp, domain, false)
+	if err != nil {
+		return
+	}
+	records, err := c.rs.txtRecords(target)
+	if err != nil || len(records) != 1 {
+		return
+	}
+	text, err := c.expand(records[0], domain, true)
+	if err == nil {
+		c.eval.Explanation = text
+	}
+}
+
+//
+// expand expands the macros in the given string.
+//
+// The "c", "r" and "t" macros are only permitted in explanations.
+//
+func (c *spfChecker) expand(str string, domain string, explanation bool) (string, error) {
+
+	var out strings.Builder
+
+	for i := 0; i < len(str); i++ {
+		if str[i] != '%' {
+			out.WriteByte(str[i])
+			continue
+		}
+		if i+1 >= len(str) {
+			return "", spfError{spfPermError, "invalid macro in '" + str + "'"}
+		}
+
+		i++
+		switch str[i] {
+		case '%':
+			out.WriteByte('%')
+			continue
+		case '_':
+			out.WriteByte(' ')
+			continue
+		case '-':
+			out.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", spfError{spfPermError, "invalid macro in '" + str + "'"}
+		}
+
+		end := strings.Index(str[i:], "}")
+		if end < 0 {
+			return "", spfError{spfPermError, "unterminated macro in '" + str + "'"}
+		}
+		value, err := c.macro(str[i+1:i+end], domain, explanation)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+		i += end
+	}
+
+	result := out.String()
+
+	//
+	// Domain-names which are too long have labels removed from the
+	// left until they fit.
+	//
+	if !explanation {
+		for len(result) > 253 && strings.Contains(result, ".") {
+			result = result[strings.Index(result, ".")+1:]
+		}
+	}
+	return result, nil
+}
+
+//
+// macro expands the body of a single "%{...}" macro.
+//
+func (c *spfChecker) macro(body string, domain string, explanation bool) (string, error) {
+
+	invalid := spfError{spfPermError, "invalid macro '%{" + body + "}'"}
+	if body == "" {
+		return "", invalid
+	}
+
+	letter := body[0]
+	escape := letter >= 'A' && letter <= 'Z'
+	lower := letter | 0x20
+
+	local := c.sender[:strings.LastIndex(c.sender, "@")]
+	senderDomain := c.sender[strings.LastIndex(c.sender, "@")+1:]
+
+	var value string
+	switch lower {
+	case 's':
+		value = c.sender
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = domain
+	case 'i':
+		value = spfDottedIP(c.ip)
+	case 'p':
+		value = "unknown"
+		if names := c.validatedNames(); len(names) > 0 {
+			value = names[0]
+		}
+	case 'v':
+		value = "in-addr"
+		if c.ip.To4() == nil {
+			value = "ip6"
+		}
+	case 'h':
+		value = c.helo
+	case 'c', 'r', 't':
+		if !explanation {
+			return "", invalid
+		}
+		switch lower {
+		case 'c':
+			value = c.ip.String()
+		case 'r':
+			value = "unknown"
+		case 't':
+			value = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	default:
+		return "", invalid
+	}
+
+	//
+	// Now the transformers: an optional count of labels to keep, an
+	// optional "r" to reverse, and the delimiters to split upon.
+	//
+	rest := body[1:]
+	digits := ""
+	for len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9' {
+		digits += rest[:1]
+		rest = rest[1:]
+	}
+	reverse := false
+	if len(rest) > 0 && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse = true
+		rest = rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", invalid
+		}
+		delimiters = rest
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(delimiters, r)
+	})
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if digits != "" {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n == 0 {
+			return "", invalid
+		}
+		if n < len(parts) {
+			parts = parts[len(parts)-n:]
+		}
+	}
+	value = strings.Join(parts, ".")
+
+	if escape {
+		value = strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+	}
+	return value, nil
+}
+
+//
+// spfDottedIP returns the address as used by the "i" macro: dotted-quad
+// for IPv4, and dot-separated nibbles for IPv6.
+//
+func spfDottedIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	var nibbles []string
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, fmt.Sprintf("%x", b>>4), fmt.Sprintf("%x", b&0xf))
+	}
+	return strings.Join(nibbles, ".")
+}
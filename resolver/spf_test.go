@@ -0,0 +1,172 @@
+//
+// Test our SPF evaluator.
+//
+
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test the macro examples from RFC 7208, section 7.4.
+//
+func TestSPFMacros(t *testing.T) {
+
+	tests := []struct {
+		ip     string
+		macro  string
+		result string
+	}{
+		{"192.0.2.3", "%{s}", "strong-bad@email.example.com"},
+		{"192.0.2.3", "%{o}", "email.example.com"},
+		{"192.0.2.3", "%{d}", "email.example.com"},
+		{"192.0.2.3", "%{d4}", "email.example.com"},
+		{"192.0.2.3", "%{d3}", "email.example.com"},
+		{"192.0.2.3", "%{d2}", "example.com"},
+		{"192.0.2.3", "%{d1}", "com"},
+		{"192.0.2.3", "%{dr}", "com.example.email"},
+		{"192.0.2.3", "%{d2r}", "example.email"},
+		{"192.0.2.3", "%{l}", "strong-bad"},
+		{"192.0.2.3", "%{l-}", "strong.bad"},
+		{"192.0.2.3", "%{lr}", "strong-bad"},
+		{"192.0.2.3", "%{lr-}", "bad.strong"},
+		{"192.0.2.3", "%{l1r-}", "strong"},
+		{"192.0.2.3", "%{ir}.%{v}._spf.%{d2}", "3.2.0.192.in-addr._spf.example.com"},
+		{"192.0.2.3", "%{lr-}.lp._spf.%{d2}", "bad.strong.lp._spf.example.com"},
+		{"192.0.2.3", "%{lr-}.lp.%{ir}.%{v}._spf.%{d2}", "bad.strong.lp.3.2.0.192.in-addr._spf.example.com"},
+		{"192.0.2.3", "%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+		{"192.0.2.3", "%{d2}.trusted-domains.example.net", "example.com.trusted-domains.example.net"},
+		{"2001:db8::cb01", "%{ir}.%{v}._spf.%{d2}", "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"},
+		{"192.0.2.3", "%%%_%-", "% %20"},
+		{"192.0.2.3", "%{L}", "strong-bad"},
+		{"192.0.2.3", "%{S}", "strong-bad%40email.example.com"},
+	}
+
+	for _, test := range tests {
+		c := &spfChecker{
+			ip:     net.ParseIP(test.ip),
+			sender: "strong-bad@email.example.com",
+			eval:   &SPFEvaluation{},
+		}
+		if ip4 := c.ip.To4(); ip4 != nil {
+			c.ip = ip4
+		}
+
+		out, err := c.expand(test.macro, "email.example.com", false)
+		if err != nil {
+			t.Errorf("Unexpected error expanding %s: %s", test.macro, err)
+			continue
+		}
+		if out != test.result {
+			t.Errorf("Expanding %s gave '%s', expected '%s'", test.macro, out, test.result)
+		}
+	}
+
+	//
+	// Invalid macros are permanent errors.
+	//
+	c := &spfChecker{ip: net.ParseIP("192.0.2.3").To4(), sender: "a@b.example", eval: &SPFEvaluation{}}
+	for _, macro := range []string{"%", "%{", "%{x}", "%{d0}", "%{c}", "%a"} {
+		_, err := c.expand(macro, "b.example", false)
+		if err == nil {
+			t.Errorf("Expected an error expanding %s", macro)
+		} else if err.(spfError).result != spfPermError {
+			t.Errorf("Expected a permerror expanding %s, got %s", macro, err)
+		}
+	}
+}
+
+//
+// Test evaluating a set of policies.
+//
+func TestCheckSPF(t *testing.T) {
+
+	addr := dnstest.Start(t, dnstest.Zone(t,
+		"example.test. 60 IN TXT \"v=spf1 ip4:192.0.2.0/28 \" \"include:_spf.example.test -all\"",
+		"example.test. 60 IN MX 10 mx.example.test.",
+		"mx.example.test. 60 IN A 198.51.100.10",
+		"_spf.example.test. 60 IN TXT \"v=spf1 mx:example.test a:web.example.test/24 ~all\"",
+		"web.example.test. 60 IN A 203.0.113.5",
+		"six.test. 60 IN TXT \"v=spf1 ip6:2001:db8::/32 ?all\"",
+		"redirect.test. 60 IN TXT \"v=spf1 redirect=example.test\"",
+		"exists.test. 60 IN TXT \"v=spf1 exists:%{ir}.allow.exists.test -all exp=explain.exists.test\"",
+		"4.2.0.192.allow.exists.test. 60 IN A 127.0.0.2",
+		"explain.exists.test. 60 IN TXT \"%{i} is not one of %{d}'s servers\"",
+		"two.test. 60 IN TXT \"v=spf1 -all\"",
+		"two.test. 60 IN TXT \"v=spf1 +all\"",
+		"loop.test. 60 IN TXT \"v=spf1 include:loop.test -all\"",
+		"void.test. 60 IN TXT \"v=spf1 a:a.void.test a:b.void.test a:c.void.test -all\"",
+		"bad.test. 60 IN TXT \"v=spf1 ip4:300.0.0.1 -all\"",
+		"noneinclude.test. 60 IN TXT \"v=spf1 include:nowhere.test -all\"",
+		"nowhere.test. 60 IN A 192.0.2.1",
+		"plain.test. 60 IN TXT \"v=spf1\"",
+	))
+	rs := newTestResolver(addr)
+
+	tests := []struct {
+		domain    string
+		ip        string
+		result    string
+		mechanism string
+	}{
+		{"example.test", "192.0.2.1", spfPass, "ip4:192.0.2.0/28"},
+		{"example.test", "198.51.100.10", spfPass, "include:_spf.example.test"},
+		{"example.test", "203.0.113.99", spfPass, "include:_spf.example.test"},
+		{"example.test", "192.0.2.99", spfFail, "-all"},
+		{"example.test", "2001:db8::1", spfFail, "-all"},
+		{"six.test", "2001:db8::1", spfPass, "ip6:2001:db8::/32"},
+		{"six.test", "192.0.2.1", spfNeutral, "?all"},
+		{"redirect.test", "192.0.2.1", spfPass, "redirect=example.test"},
+		{"redirect.test", "192.0.2.99", spfFail, "redirect=example.test"},
+		{"exists.test", "192.0.2.4", spfPass, "exists:%{ir}.allow.exists.test"},
+		{"exists.test", "192.0.2.5", spfFail, "-all"},
+		{"two.test", "192.0.2.1", spfPermError, ""},
+		{"loop.test", "192.0.2.1", spfPermError, ""},
+		{"void.test", "192.0.2.1", spfPermError, ""},
+		{"bad.test", "192.0.2.1", spfPermError, ""},
+		{"noneinclude.test", "192.0.2.1", spfPermError, ""},
+		{"nowhere.test", "192.0.2.1", spfNone, ""},
+		{"missing.test", "192.0.2.1", spfNone, ""},
+		{"plain.test", "192.0.2.1", spfNeutral, ""},
+	}
+
+	for _, test := range tests {
+		eval := rs.CheckSPF(test.domain, net.ParseIP(test.ip), "", "")
+		if eval.Result != test.result {
+			t.Errorf("%s from %s gave %s, expected %s: %v", test.domain, test.ip, eval.Result, test.result, eval.Trace)
+		}
+		if eval.Mechanism != test.mechanism {
+			t.Errorf("%s from %s matched '%s', expected '%s'", test.domain, test.ip, eval.Mechanism, test.mechanism)
+		}
+		if len(eval.Trace) == 0 {
+			t.Errorf("%s from %s had no trace", test.domain, test.ip)
+		}
+	}
+
+	//
+	// The explanation of a failure should be expanded.
+	//
+	eval := rs.CheckSPF("exists.test", net.ParseIP("192.0.2.5"), "", "")
+	if eval.Explanation != "192.0.2.5 is not one of exists.test's servers" {
+		t.Errorf("Unexpected explanation '%s'", eval.Explanation)
+	}
+
+	//
+	// The include loop should have hit the lookup limit.
+	//
+	eval = rs.CheckSPF("loop.test", net.ParseIP("192.0.2.1"), "", "")
+	if eval.Lookups != spfMaxLookups+1 {
+		t.Errorf("Unexpected lookup count %d", eval.Lookups)
+	}
+
+	//
+	// The sender defaults to the postmaster of the domain.
+	//
+	if eval.Sender != "postmaster@loop.test" {
+		t.Errorf("Unexpected sender '%s'", eval.Sender)
+	}
+}
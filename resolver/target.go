@@ -0,0 +1,250 @@
+//
+// This file contains the code which allows callers to send their queries
+// to a specific nameserver, rather than to our local resolver(s).
+//
+// Because this would otherwise allow our public service to be used to
+// probe internal networks, or to reflect traffic at a victim, the
+// addresses which may be targeted are restricted by a policy.
+//
+
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+//
+// reservedNetworks are the ranges which may never be targeted, unless
+// they are explicitly allowed in the configuration-file.
+//
+// These cover loopback, private, link-local, carrier-grade NAT, multicast
+// and the other special-purpose ranges.
+//
+var reservedNetworks, _ = parseNetworks([]string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+})
+
+// TargetDenied is the error returned when a policy forbids the querying
+// of a nameserver, as opposed to the server being malformed.
+type TargetDenied struct {
+	Reason string
+}
+
+func (t TargetDenied) Error() string {
+	return t.Reason
+}
+
+// NetworkPolicy is a list of networks which are explicitly allowed, and
+// a list of networks which are explicitly denied.
+//
+// Entries are either networks in CIDR notation, or single addresses.
+type NetworkPolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+
+	// The parsed versions of the lists above.
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// Parse converts the textual networks of a policy into their parsed form,
+// which must be done before it is used.
+func (p *NetworkPolicy) Parse() error {
+	var err error
+
+	p.allow, err = parseNetworks(p.Allow)
+	if err != nil {
+		return err
+	}
+	p.deny, err = parseNetworks(p.Deny)
+	return err
+}
+
+// AllowedTarget tests whether the given nameserver address, and port,
+// may be queried directly under the given policy.
+//
+// Explicit denials always win, and explicitly allowed networks may be
+// queried upon any port.  Otherwise, if the allow-list is non-empty the
+// address must be within it, it must not be reserved, and the port must
+// be the standard DNS port.
+func (p *NetworkPolicy) AllowedTarget(ip net.IP, port int) error {
+
+	//
+	// IPv4-mapped IPv6 addresses are treated as the IPv4 address
+	// they really are.
+	//
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if containsIP(p.deny, ip) {
+		return TargetDenied{fmt.Sprintf("Queries to %s are not permitted", ip)}
+	}
+	if containsIP(p.allow, ip) {
+		return nil
+	}
+	if len(p.allow) > 0 {
+		return TargetDenied{fmt.Sprintf("Queries to %s are not permitted", ip)}
+	}
+	if containsIP(reservedNetworks, ip) {
+		return TargetDenied{fmt.Sprintf("Queries to the reserved address %s are not permitted", ip)}
+	}
+	if port != 53 {
+		return TargetDenied{fmt.Sprintf("Queries to port %d are not permitted", port)}
+	}
+	return nil
+}
+
+// AllowedWebhook tests whether a webhook may be delivered to the given
+// address under the given policy.
+//
+// This is the same as AllowedTarget, except that any port may be used.
+func (p *NetworkPolicy) AllowedWebhook(ip net.IP) error {
+
+	if ip == nil {
+		return TargetDenied{"Webhooks must be delivered to an IP address"}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if containsIP(p.deny, ip) {
+		return TargetDenied{fmt.Sprintf("Webhooks to %s are not permitted", ip)}
+	}
+	if containsIP(p.allow, ip) {
+		return nil
+	}
+	if len(p.allow) > 0 {
+		return TargetDenied{fmt.Sprintf("Webhooks to %s are not permitted", ip)}
+	}
+	if containsIP(reservedNetworks, ip) {
+		return TargetDenied{fmt.Sprintf("Webhooks to the reserved address %s are not permitted", ip)}
+	}
+	return nil
+}
+
+// TargetServer converts a caller-supplied server, in the form `host`,
+// `host:port` or `[ipv6]:port`, into the "address:port" we should query.
+//
+// Hostnames are resolved using our normal resolvers, and every address
+// they resolve to must be permitted by the policy - otherwise a hostname
+// which is partially public would be a route into internal networks.
+//
+// We return an address rather than the hostname so that the name cannot
+// be re-resolved, to something else, when the query is actually made.
+func (rs *Resolver) TargetServer(server string, policy *NetworkPolicy) (string, error) {
+
+	host, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+		portStr = "53"
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("Invalid server port '%s'", portStr)
+	}
+
+	//
+	// Find the addresses of the server.
+	//
+	var addrs []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = append(addrs, ip)
+	} else {
+		if _, ok := dns.IsDomainName(host); !ok || host == "" {
+			return "", fmt.Errorf("Invalid server '%s'", host)
+		}
+		addrs = rs.HostAddresses(host)
+	}
+
+	if len(addrs) == 0 {
+		return "", errors.New("Failed to find the address of server '" + host + "'")
+	}
+
+	//
+	// Every address must be allowed.
+	//
+	for _, ip := range addrs {
+		err = policy.AllowedTarget(ip, port)
+		if err != nil {
+			return "", err
+		}
+	}
+	return net.JoinHostPort(addrs[0].String(), strconv.Itoa(port)), nil
+}
+
+// ServerAddress validates the address of a nameserver, which must be an
+// IP address, and adds the default port if none was present.
+func ServerAddress(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		port = "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("'%s' is not an IP address", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+//
+// parseNetworks parses a list of networks, allowing bare addresses to be
+// used as a shorthand for a single-host network.
+//
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, entry := range entries {
+
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid network", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+//
+// containsIP tests whether the given address is inside any of the networks.
+//
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+//
+// Test the restrictions upon which nameservers may be queried.
+//
+
+package resolver
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test our policy decisions.
+//
+func TestAllowedTarget(t *testing.T) {
+
+	open := &NetworkPolicy{}
+	if err := open.Parse(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	restricted := &NetworkPolicy{
+		Allow: []string{"192.0.2.0/24", "127.0.0.1"},
+		Deny:  []string{"192.0.2.66"},
+	}
+	if err := restricted.Parse(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		Policy  *NetworkPolicy
+		IP      string
+		Port    int
+		Allowed bool
+	}{
+		// Public addresses are fine, upon port 53.
+		{open, "8.8.8.8", 53, true},
+		{open, "2001:4860:4860::8888", 53, true},
+		{open, "8.8.8.8", 5353, false},
+
+		// Reserved ranges are not.
+		{open, "127.0.0.1", 53, false},
+		{open, "10.1.2.3", 53, false},
+		{open, "192.168.1.1", 53, false},
+		{open, "169.254.169.254", 53, false},
+		{open, "::1", 53, false},
+		{open, "fd00::1", 53, false},
+		{open, "::ffff:10.0.0.1", 53, false},
+
+		// An allow-list restricts us, but permits any port.
+		{restricted, "192.0.2.1", 53, true},
+		{restricted, "127.0.0.1", 5353, true},
+		{restricted, "127.0.0.2", 53, false},
+		{restricted, "8.8.8.8", 53, false},
+
+		// Denials win.
+		{restricted, "192.0.2.66", 53, false},
+	}
+
+	for _, test := range tests {
+		err := test.Policy.AllowedTarget(net.ParseIP(test.IP), test.Port)
+		if test.Allowed && err != nil {
+			t.Errorf("Expected %s:%d to be allowed, got %s", test.IP, test.Port, err)
+		}
+		if !test.Allowed && err == nil {
+			t.Errorf("Expected %s:%d to be denied", test.IP, test.Port)
+		}
+	}
+}
+
+//
+// Test that hostnames are resolved, and every address checked.
+//
+func TestTargetServer(t *testing.T) {
+
+	//
+	// Our resolver knows of two names, one of which has a private
+	// address alongside its public one.
+	//
+	addr := dnstest.Start(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		if q.Qtype == dns.TypeA {
+			hdr := dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}
+			switch q.Name {
+			case "ns1.example.com.":
+				m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("192.0.2.1")})
+			case "sneaky.example.com.":
+				m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("192.0.2.2")})
+				m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("10.0.0.1")})
+			}
+		}
+		w.WriteMsg(m)
+	})
+	rs := newTestResolver(addr)
+
+	policy := &NetworkPolicy{}
+
+	tests := []struct {
+		Server string
+		Result string
+		Error  string
+	}{
+		{"192.0.2.1", "192.0.2.1:53", ""},
+		{"192.0.2.1:53", "192.0.2.1:53", ""},
+		{"[2001:db8::1]:53", "[2001:db8::1]:53", ""},
+		{"ns1.example.com", "192.0.2.1:53", ""},
+		{"sneaky.example.com", "", "reserved address 10.0.0.1"},
+		{"missing.example.com", "", "Failed to find the address"},
+		{"192.0.2.1:http", "", "Invalid server port"},
+		{"192.0.2.1:1234", "", "port 1234 are not permitted"},
+	}
+
+	for _, test := range tests {
+		out, err := rs.TargetServer(test.Server, policy)
+		if test.Error == "" {
+			if err != nil {
+				t.Errorf("Unexpected error for %s: %s", test.Server, err)
+			} else if out != test.Result {
+				t.Errorf("Unexpected result for %s: %s != %s", test.Server, out, test.Result)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("Expected error '%s' for %s, got %v", test.Error, test.Server, err)
+		}
+	}
+}
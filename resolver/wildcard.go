@@ -0,0 +1,207 @@
+//
+// This file contains our wildcard-detection.
+//
+// A zone with a "*" record answers for every name beneath it, which can
+// be confusing when a name that was never created appears to exist.  We
+// detect that by asking for a random label, which nobody would create
+// deliberately, and seeing whether we receive an answer.
+//
+
+package resolver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// WildcardReport is the result of probing a zone for wildcard records.
+type WildcardReport struct {
+	// Zone is the name we probed beneath.
+	Zone string `json:"zone"`
+
+	// Wildcard is true if any wildcard records were found.
+	Wildcard bool `json:"wildcard"`
+
+	// Types holds the records each wildcarded type synthesizes.
+	Types []WildcardType `json:"types"`
+}
+
+// WildcardType holds the records a wildcard synthesizes for one type.
+type WildcardType struct {
+	Type    string   `json:"type"`
+	Targets []string `json:"targets"`
+	TTL     uint32   `json:"ttl"`
+}
+
+//
+// randomLabel returns a label which is vanishingly unlikely to exist.
+//
+func randomLabel() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return "wildcard-probe-" + hex.EncodeToString(buf)
+}
+
+//
+// probeWildcard asks for the given type of a random name beneath the
+// given parent, returning the records which belong to that name.  If a
+// wildcard is present these records are what it synthesizes.
+//
+func (rs *Resolver) probeWildcard(parent string, qtype string, opts QueryOptions) ([]dns.RR, error) {
+
+	name := dns.Fqdn(randomLabel() + "." + strings.TrimSuffix(parent, "."))
+
+	r, err := rs.Query(name, qtype, opts)
+	if err != nil {
+		if _, ok := err.(NoSuchDomain); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var out []dns.RR
+	for _, rr := range r.Answer {
+		if strings.EqualFold(rr.Header().Name, name) && rr.Header().Rrtype != dns.TypeRRSIG {
+			out = append(out, rr)
+		}
+	}
+	return out, nil
+}
+
+//
+// rdata returns the textual form of a record, without its header.
+//
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+//
+// MarkWildcards flags the answers to a query which were likely to have
+// been synthesized from a wildcard, by adding a "wildcard" key to them.
+//
+// Signed answers tell us directly, as the labels-field of the signature
+// is less than the number of labels of the owner.  Otherwise we probe a
+// random sibling of the name, and flag the records which it shares.
+//
+func (rs *Resolver) MarkWildcards(results []map[string]string, r *dns.Msg, name string, qtype string, opts QueryOptions) {
+
+	name = dns.Fqdn(name)
+
+	synthesized := make(map[string]bool)
+
+	for _, rr := range r.Answer {
+		sig, ok := rr.(*dns.RRSIG)
+		if ok && int(sig.Labels) < dns.CountLabel(sig.Hdr.Name) {
+			synthesized[strings.ToLower(sig.Hdr.Name)+"/"+dns.TypeToString[sig.TypeCovered]] = true
+		}
+	}
+
+	//
+	// Probing needs a parent, so there's nothing to do for a TLD.
+	//
+	probed := make(map[string]bool)
+	labels := dns.SplitDomainName(name)
+	if len(labels) > 1 {
+		rrs, err := rs.probeWildcard(strings.Join(labels[1:], "."), qtype, opts)
+		if err == nil {
+			for _, rr := range rrs {
+				probed[dns.TypeToString[rr.Header().Rrtype]+rdata(rr)] = true
+			}
+		}
+	}
+
+	for i, rr := range r.Answer {
+		if i >= len(results) {
+			break
+		}
+		owner := strings.ToLower(rr.Header().Name)
+		rtype := dns.TypeToString[rr.Header().Rrtype]
+
+		//
+		// Signatures are marked along with what they cover.
+		//
+		if sig, ok := rr.(*dns.RRSIG); ok && synthesized[owner+"/"+dns.TypeToString[sig.TypeCovered]] {
+			results[i]["wildcard"] = "true"
+			continue
+		}
+		if synthesized[owner+"/"+rtype] || (strings.EqualFold(owner, name) && probed[rtype+rdata(rr)]) {
+			results[i]["wildcard"] = "true"
+		}
+	}
+}
+
+// DetectWildcards probes the given zone for wildcard records of each of
+// the types we support.
+func (rs *Resolver) DetectWildcards(zone string) (*WildcardReport, error) {
+
+	zone = dns.Fqdn(strings.ToLower(zone))
+
+	report := &WildcardReport{
+		Zone:  zone,
+		Types: []WildcardType{},
+	}
+
+	//
+	// Find every type in parallel.
+	//
+	var types []string
+	for t := range StringToType {
+		if t != "SOA" {
+			types = append(types, t)
+		}
+	}
+	found := make([][]dns.RR, len(types))
+	failed := make([]error, len(types))
+
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+			found[i], failed[i] = rs.probeWildcard(zone, t, DefaultQueryOptions())
+		}(i, t)
+	}
+	wg.Wait()
+
+	for _, err := range failed {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//
+	// A CNAME wildcard is returned for every type, so group the
+	// records by their own type rather than the type we asked for.
+	//
+	records := make(map[string]*WildcardType)
+	for _, rrs := range found {
+		for _, rr := range rrs {
+			t := dns.TypeToString[rr.Header().Rrtype]
+			if records[t] == nil {
+				records[t] = &WildcardType{Type: t, TTL: rr.Header().Ttl}
+			}
+			w := records[t]
+			if !containsString(w.Targets, rdata(rr)) {
+				w.Targets = append(w.Targets, rdata(rr))
+			}
+			if rr.Header().Ttl < w.TTL {
+				w.TTL = rr.Header().Ttl
+			}
+		}
+	}
+
+	for _, w := range records {
+		sort.Strings(w.Targets)
+		report.Types = append(report.Types, *w)
+	}
+	sort.Slice(report.Types, func(i, j int) bool {
+		return report.Types[i].Type < report.Types[j].Type
+	})
+	report.Wildcard = len(report.Types) > 0
+	return report, nil
+}
@@ -0,0 +1,80 @@
+//
+// Test our wildcard-detection.
+//
+
+package resolver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/skx/dns-api-go/internal/dnstest"
+)
+
+//
+// Test finding the wildcards of a zone.
+//
+func TestDetectWildcards(t *testing.T) {
+
+	rs := newTestResolver(dnstest.Start(t, dnstest.Wildcards(t)))
+
+	report, err := rs.DetectWildcards("Example.Test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !report.Wildcard || len(report.Types) != 2 {
+		t.Fatalf("Unexpected report: %v", report)
+	}
+	if report.Types[0].Type != "A" || strings.Join(report.Types[0].Targets, ",") != "192.0.2.99" || report.Types[0].TTL != 300 {
+		t.Errorf("Unexpected A wildcard: %v", report.Types[0])
+	}
+	if report.Types[1].Type != "MX" || len(report.Types[1].Targets) != 2 {
+		t.Errorf("Unexpected MX wildcard: %v", report.Types[1])
+	}
+
+	//
+	// A CNAME wildcard is only reported once.
+	//
+	report, err = rs.DetectWildcards("cname.example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(report.Types) != 1 || report.Types[0].Type != "CNAME" || report.Types[0].Targets[0] != "www.example.test." {
+		t.Errorf("Unexpected report: %v", report)
+	}
+
+	report, err = rs.DetectWildcards("other.test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if report.Wildcard || len(report.Types) != 0 {
+		t.Errorf("Unexpected report: %v", report)
+	}
+}
+
+//
+// Test that signed answers are recognised from their RRSIG.
+//
+func TestMarkWildcardsSigned(t *testing.T) {
+
+	rs := newTestResolver(dnstest.Start(t, dnstest.Zone(t)))
+
+	m := new(dns.Msg)
+	for _, record := range []string{
+		"a.example.test. 60 IN A 192.0.2.1",
+		"a.example.test. 60 IN RRSIG A 13 2 60 20300101000000 20200101000000 12345 example.test. AAAA",
+	} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+
+	results := Answers(m)
+	rs.MarkWildcards(results, m, "a.example.test", "A", DefaultQueryOptions())
+	if results[0]["wildcard"] != "true" || results[1]["wildcard"] != "true" {
+		t.Errorf("Unexpected results: %v", results)
+	}
+}
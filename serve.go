@@ -0,0 +1,342 @@
+//
+// The `serve` subcommand, which runs our HTTP-server.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/go-redis/redis"
+	graphite "github.com/marpaia/graphite-golang"
+	"github.com/robfig/cron"
+	"github.com/skx/dns-api-go/api"
+	"github.com/skx/dns-api-go/ratelimit"
+	_ "github.com/skx/golang-metrics"
+)
+
+//
+// Our API server.
+//
+var server *api.Server
+
+// Handle to our metrics-host
+var metrics *graphite.Graphite
+
+// MetricsFromEnvironment sets up a carbon connection from the environment
+// if suitable values are found.
+func MetricsFromEnvironment() {
+
+	//
+	// Get the hostname to connect to.
+	//
+	host := os.Getenv("METRICS_HOST")
+	if host == "" {
+		host = os.Getenv("METRICS")
+	}
+
+	// No host then we'll return
+	if host == "" {
+		return
+	}
+
+	// Split the into Host + Port
+	ho, pr, err := net.SplitHostPort(host)
+	if err != nil {
+		// If that failed we assume the port was missing
+		ho = host
+		pr = "2003"
+	}
+
+	// Setup the protocol to use
+	protocol := os.Getenv("METRICS_PROTOCOL")
+	if protocol == "" {
+		protocol = "udp"
+	}
+
+	// Ensure that the port is an integer
+	port, err := strconv.Atoi(pr)
+	if err == nil {
+		metrics, err = graphite.GraphiteFactory(protocol, ho, port, "")
+
+		if err != nil {
+			fmt.Printf("Error setting up metrics - skipping - %s\n", err.Error())
+		}
+	} else {
+		fmt.Printf("Error setting up metrics - failed to convert port to number - %s\n", err.Error())
+
+	}
+}
+
+//
+// This function is called every 30 seconds if we were launched
+// with a METRICS environmental-variable.
+//
+func submitMetrics() {
+	if metrics != nil {
+		for key, val := range server.Stats() {
+			v := os.Getenv("METRICS_VERBOSE")
+			if v != "" {
+				fmt.Printf("%s %d\n", key, val)
+			}
+			metrics.SimpleSend(key, fmt.Sprintf("%d", val))
+		}
+	}
+}
+
+//
+// reloadOnHangup reloads the rate-limits, and the tiers of our API keys,
+// from the given configuration-file each time we receive a SIGHUP.
+//
+// If the file is invalid the current limits are retained.
+//
+func reloadOnHangup(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		c, err := api.LoadConfig(path)
+		if err == nil {
+			err = server.SetRateLimits(c.RateLimits, c.Keys.Tiers)
+		}
+		if err != nil {
+			fmt.Printf("Error reloading %s: %s\n", path, err.Error())
+			continue
+		}
+		fmt.Printf("Reloaded the rate-limits, and tiers, from %s\n", path)
+	}
+}
+
+//
+// closeOnTerminate closes our server when we're asked to terminate, so
+// that the usage and history recorded since they were last written out
+// aren't lost, and then exits.
+//
+func closeOnTerminate() {
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+
+	<-term
+	err := server.Close()
+	if err != nil {
+		fmt.Printf("Error closing: %s\n", err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+//
+// serve launches our HTTP-server, on the given host & port.
+//
+func serve(host string, port int) {
+
+	//
+	// Bind the router.
+	//
+	http.Handle("/", server)
+
+	//
+	// Show where we'll bind
+	//
+	bind := fmt.Sprintf("%s:%d", host, port)
+	fmt.Printf("Launching the server on http://%s\n", bind)
+
+	//
+	// Launch the server.
+	//
+	err := http.ListenAndServe(bind, server)
+	if err != nil {
+		fmt.Printf("\nError: %s\n", err.Error())
+	}
+}
+
+//
+// serveMain is our `serve` subcommand, which launches our HTTP-server.
+//
+func serveMain(args []string) int {
+
+	//
+	// The command-line flags we support
+	//
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: dns-api-go serve [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	host := fs.String("host", "127.0.0.1", "The IP to bind upon.")
+	cfg := fs.String("config", "", "The path to a JSON configuration-file.")
+	red := fs.String("redis-server", "", "The address of a redis-server to store rate-limiting data, rather than memory.")
+	port := fs.Int("port", 9999, "The port to bind upon.")
+	vers := fs.Bool("version", false, "Show our version and exit.")
+	watchFile := fs.String("watches", "", "The path to a file to store watches in, if redis isn't used.")
+	keyFile := fs.String("keys", "", "The path to a file to store API keys in, if redis isn't used.")
+	usageFile := fs.String("usage", "", "The path to a file to store the usage of each client in, if redis isn't used.")
+	historyFile := fs.String("history", "", "The path to a file to record the history of answers in.")
+
+	//
+	// Parse the flags
+	//
+	fs.Parse(args)
+
+	//
+	// Showing the version?
+	//
+	if *vers {
+		fmt.Printf("dns-api-go %s\n", version)
+		return 0
+	}
+
+	opts := api.Options{
+		HistoryFile: *historyFile,
+		Version:     version,
+	}
+
+	//
+	// Load our configuration-file, if we were given one.
+	//
+	if *cfg != "" {
+		c, err := api.LoadConfig(*cfg)
+		if err != nil {
+			fmt.Printf("Error loading configuration: %s\n", err.Error())
+			return 1
+		}
+		opts.Config = c
+	}
+
+	//
+	// If we have a redis-server defined then use it.
+	//
+	if *red != "" {
+
+		//
+		// Setup a redis-connection for rate-limiting.
+		//
+		ring := redis.NewRing(&redis.RingOptions{
+			Addrs: map[string]string{
+				"server1": *red,
+			},
+		})
+
+		//
+		// And point the rate-limiter to it
+		//
+		opts.Limiter = ratelimit.NewRedis(ring)
+
+		//
+		// Watches, API keys and usage are stored there too, unless
+		// a file was given.
+		//
+		opts.Watches = api.NewRedisWatchStore(ring)
+		opts.Keys = api.NewRedisKeyStore(ring)
+		opts.Usage = api.NewRedisUsageStore(ring)
+	}
+	if *watchFile != "" {
+		opts.Watches = api.NewFileWatchStore(*watchFile)
+	}
+	if *keyFile != "" {
+		opts.Keys = api.NewFileKeyStore(*keyFile)
+	}
+	if *usageFile != "" {
+		opts.Usage = api.NewFileUsageStore(*usageFile)
+	}
+
+	//
+	// Create our server, which loads and starts any watches.
+	//
+	var err error
+	server, err = api.New(opts)
+	if err != nil {
+		fmt.Printf("Error starting: %s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Our rate-limits are reloaded from our configuration-file when
+	// we receive a SIGHUP.
+	//
+	if *cfg != "" {
+		go reloadOnHangup(*cfg)
+	}
+
+	//
+	// Our usage and history are written out when we're terminated.
+	//
+	go closeOnTerminate()
+
+	//
+	// `/tmp/retired` exists, so we're done.
+	//
+	_, err = os.Stat("/tmp/retired")
+	server.SetRetired(err == nil)
+
+	//
+	// Write out our history, if it is enabled, regularly.
+	//
+	if *historyFile != "" {
+		c := cron.New()
+		c.AddFunc("@every 30s", func() {
+			if e := server.FlushHistory(); e != nil {
+				fmt.Printf("Error saving history: %s\n", e.Error())
+			}
+		})
+		c.Start()
+	}
+
+	//
+	// Add the requests we've counted to our usage store regularly.
+	//
+	usageCron := cron.New()
+	usageCron.AddFunc("@every 30s", func() {
+		if e := server.FlushUsage(); e != nil {
+			fmt.Printf("Error saving usage: %s\n", e.Error())
+		}
+	})
+	usageCron.Start()
+
+	//
+	// If we have a metrics-host then we'll submit metrics there
+	//
+	MetricsFromEnvironment()
+
+	//
+	// If we did setup metrics-stuff then we'll want to submit metrics
+	// regularly.  Fire up a job to do that every half-minute.
+	//
+	if metrics != nil {
+		c := cron.New()
+		c.AddFunc("@every 30s", func() { submitMetrics() })
+		c.Start()
+	}
+
+	//
+	// Create a cron-job to test our file
+	//
+	c := cron.New()
+	c.AddFunc("@every 30s", func() {
+
+		//
+		// Lookup our file.
+		//
+		_, e := os.Stat("/tmp/retired")
+		server.SetRetired(e == nil)
+		fmt.Printf("Updated retired to %v\n", server.Retired())
+
+	})
+	c.Start()
+
+	//
+	// And finally start our HTTP-server
+	//
+	//
+	// We only return if that failed.
+	//
+	serve(*host, *port)
+	return 1
+}
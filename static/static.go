@@ -3,7 +3,7 @@
 //
 // Local edits will be lost.
 //
-package main
+package static
 
 import (
 	"bytes"
@@ -47,6 +47,11 @@ func init() {
 	tmp.Length = 121200
 	RESOURCES = append(RESOURCES, tmp)
 
+	tmp.Filename = "data/docs.html"
+	tmp.Contents = "1f8b08000000000004ff9d5a7b73db3612ff3f9f02653231d5889493e63a575b52a74ddabbdcb54dc64967ee46f5dd402424d2a64816806c6b527ff7db5d0024f8909b9cdb48141e8bddc53e7e0b62fec5ebb7af3efcfbdd0f2cd3bb62f9688e5face0e576118832583e626c9e099ee2033cee84e62cc9b854422f82bdde447f0d66b6afc8cb6b2645b108f85e67950c5826c5661164fb1d2f55acef74c0663e9d92efc42248854a645eebbc2a039654a5162590feeedd1b9656c97e07bf38f6b14d25d9eb5fdeb3a2aaaef7b56a69e95c176289135e772644ecbb925525b025bc894c09799327623e33f306fcdce4e2b6aea4f698b9cd539d2d5281f322fa31657999eb9c17914a782116cf415373a50f8560fa5003152deef42c510ada1fe76552ec53c1e0e76c5d555a69c9eb78979731b4d0faa47cf6d13c33b6e6c9f55656fb328d92aaa8e4d9e3cd37f8dfb919706fbe62603aabd2765a9aabbae08733e00d858ed645955c9fbb5e58cff07ec6be3eadef9a76e434e245be2dcf5802e20ad95ba6aaa375951e46d629ab520c074ba1f68566b514ed941dbf8b32916f337dc65e9efacb5737426e8aeaf68c81d5540db5f98cb4895a25ebf0d57ac56fb869f5b57ba56657bfef853c44cfe3e72fe297a4e02b859468ece791eaeed3679199cde07ff6762fd9db5a94be214fc11c130186c85391c638f0864ba66a91b0052bf74571fec8cebe10aa2a6e04e32c78024e1430bee579a934ab80ac23471436fb3221734f050c0cabf5d5c4aafd36cbc11cb1853d7dcae06b65685d4eda7d61c840cda556c08137249602b63811e1ec3f8f7f9bcda62c082631ec7aaec360164c9addc33930134568dbd05343249c43d7e9397ccdcd1a7121caadcea0e5d9339f899610b2404357f9e5654bd15897f99442ef658923cf1fdd3b7dbda620b24685a924133bced632179be230656a9f648c2b167029f981551b506e52c934e8abcf50784fd34343c531996f58f845b7c9632508ce3dfe70ac193aa66f3ba53bc0d36c5c57753819a717a3c5b1c5c28a128cb1d20819b067e332c5b9163bf5a74b807e450241104cc776f134cd5155bc7827ab5a489d0b35c6c28ed70f33304ae87cb8c53e4bcf1a0ec1ba765cb36f618110d7e8363f63c124606768af9e797cbfcf8b94e94ca06deec8402174a15142c88788d73585a6f94d59ef75583b21c9a453b0d2a08e70e53ac68c71def6e1f0f346a3756c4d1154e89e579054f7bbae49d034a0fa240ce64a14a07596145ca9c509321b611692556186456a77b2f4fd8f2cb30677fd7d9f4b91f6bd8a26c5bc86409486b84045891648c437bc08d1ab3180e1c3a4ef6ef8f724163cc9c23efb53e69415e65376f399abded8456f3a6b3a0b60a250e2887acc3305de132471f2899ab2666ed8d25a8641ca358f70fb82a9ddc789df9397d49e9740a0d9df82af456139a1e7a5539fa18046da6e0559e897d616d12e8db9225163a596376bec4834cd6f3a022106a8510aa74b5a756a0499b69b04536b373113456d32ff89c79e07b2d81f7f30b3dd8d7bfcccaf317222e702720cba07faca36bf111068c14309504dd96dae33ea815d8491e8e539640ee4b5eb415a1edeba6961cd4b64bae6089b0c668144d8f1aabd44bde288d69b289743ebeab26ddbe54ae5e5d6b65233518f373968275835bb7a09a293e53676da4f7a762775962bcf0ab10784c3ae58cb7c17026d34d9496f0c6df702880ccca9e79b440ce369307012e885f9495129507a18c4ed9603f34620da6fb78b13b09c54dcbddd84c197406d09b9b54fd3e03cd2515cef551692619f77c6dc777e19eb1b737dcb5fd72926941b70a306f2983d84cf063b041fd1e0ad6704f7e051a24caa54fc7af1e655b5ab2102971a15d409037df7c73f320523d0080592115758e0729fb0c423176f1abbb29075d1b1a616cba231ed6a7d70891955e3d46c200d5b7637c3ccf343a0e7db503048cde833bae5b20432adaf063f1bc2679440dd2a57555e86a040f05b4f127ff3ee8d34e085e68b00286e94621c9038d465e08d802875c5d0e6a16b2b001c55ec56b0db4a5e035c0467bf152752380a3ba8403404b2b5280510820801987e93dfc5fe4ac36d9ffdc721c6565b346ae006666e100ff093d9f071d5e2a46730eb5bd48f1968b4f334e88188fe16d45e24c5df68288dde4d608a75f52b0c90afb812a189dab80cac498aefa9f8bd9682ef8c825556dd968833213ee6105e20746aec91a0f48ec2d0deaec5a10d31c0ca635ee71134da04e9f6188756d7088b31ad2830670815abe0c5e96970d9ea164620bebf8e6dbddafdb532150af1132962b80b3f2c5b56a41f70dc7ba832a04e497879a2a15e2e53864700422a32182c4150003022ee43a81182c81e0efd623402da9d1cec3666cea79438bf359913b4f35f2073ccbfa16b14bc506d2385cdd8f0d4eeff872a34f6d18bebca480ed598b8f5758106dc1faaabda925eefb586ac67dd7bad4b06ffa2546c3846157cbe5327cbf730613e33639193a4c893ebf104857f8615931fc25e0cb7790b8c6207f5733802a33c0f00c1c9e49161afd7d2afca9d500a8201c8d2f022facc000de7394df599802d69f101aceb17d89150c4982470bb7e2bbb6872644d2125800c6fc5cf911e051911fd13f90c56c60b18d14e2f8f733b125e69e385d61093b162ee44a533fc685119a2d433361656da31a8b10f348ebc34687bacc39db18f365db5fe3eee508eabd8ce5c05ff8abe7bf726fa2744954b601566f9d11105a1539db19c4730d00fdf38f248386ed6a5dd5f105113c4ce87636c4cfa404526fa35d4bd09a1c4d995aacaa0a3eb2731bfe277a19b0cae5bdcf2836a3d864338d25cef212cadfbf0ee2e43f30a4d3f8116b54f12b07450dab76c0dc18577dd19f50f33605e136ad1640c5a6e8702b2ed59aa9df88ff76f7f89c1b280d57c7308e9678de7a4210e984ce97c67ca5e74b00ec89e64aceb6e567ac797dd4e0f087b75196ef20518f74ff92ed7117d2248f01b2fa06ccb1162f43b5e032238f44bb9acef870488ad5eb6425f58d5fc9db80ab39efb11e225e34469c7d0a915c780b90ca385013a3747516a03d41c3b4981da089123bbbd0085212762def31ae7ecabd353dc6c83a223b7ff98574c4bcacbad90c1f903a8ad0f1914388b8bf344c4546769fa0adb4260cdc2098f110722da2634ac0e9043bd39cd8cbbd911f6640b60dc7c038628aa8d66c587a990b13a1ce5d78817305a986393a622ec167d9246f875dff1820f8fb5f764d51f59007615349b520324e2f280d65a57caeb70fb071da928007204fd8dc420f9c8f71b532af6d03785bbc8769f303a665e9c24c04d25cf580dda0334e317dd9d3a7b600056c09e1d18f156a6ef92828865d580828750a7971fc070a66c005551b110509a4ee4a9e984d4a8f63b54e1c4af706cac1fd505f5d953fef6cc84a065e7e4c0b7470af37d47710cf8732683fc49477086157c6c16b4310de637a052a1e656bd1855fb7cd0d983e563706ee7d9b12791cd6fdf8304fdac610fec16ee30bd33b845d483bc7569cfc77a1051dcf15d5d60aafb783f88ddf6dcb26e8e4051d68ff79eaca6b0edc6004b7245871c98438371ece7ebe5938e967af8b27fc2155cd8c32132164a6f93c0b354370b074339c41f3c98632dca3861b2ba85715f9fb8d3c15e22b502b7f97332e99479be21e262c32aed4386e5afad9eb0dcdde17b288bee06a599e6ebc2950ce6d94a627ed0270a940a20076e3fd712fe6580ee31b2cf67f0883f5fb72ed0b45dd08a76cc0ce7cd88dc880734fc7ae6803160ca64df668d1c4700826c8ac2be71213c9db2649863889ac9cff6e4dda6e8de617ee26cfe48f541a2f92608027793a94e1bf342d926269a79adb2134986dd9657ff70a4e7f2be7510437e4ca4e87724289a4e17ae7d47b199634ab44796395e10dad446cf783afd019e73fd4049d8f17e81851c56a4af0d4f3ec0fea4f356a799233c7b1a680ebe9af4087c6ff33282c2eb8c3d3fadef50214e9556210fd5b3b496aeb6dba2a9d8eebbc7dfc4f4106ee061b331333a6dc6a387c635c6d087b14d15fa58a372af0f1e5b4a8177bc8271147de72dbdee8aa14a5221becd04bfb1c70bcada39203b40088ddaad83d1fc6e7eea87ed4e623942dbc4f35e45f27f46a21f7351a44dd0c15aeb78c4f9ac7cb441c25073f563c6a77afab1a337a23b19fa77ffede164722cd654ddd74dd94b96a78b132351e41f42b7e7acb44b3d086e557004f28c2cd4a29e91a99363a7ea034d3fb08a0b5ac3c36b709527436f7b82551aa6d03000ea25aff398108ab78d58a8772a7873ff009bcf7dacfbf8060018deca7122a2e9e6e5a68a6d875f98754f11ff86e882bcb5a915145b1fecd9a8e6dbd1d342347ac225aa8798c88b25c683d12437f05d3af676eeda038f889d7bea760ed04c5de1a78f3a87454cbf460699cc392d3ca8d5e9e5f96014bda935e2ad60d0e51825fcf386f4c4ed9808aac36669be9d0c07dd3f7a80b099f8a7055bbf26ef795f7f1f88a7aeba91b7beb6c1b25aabe88685ecabb6108599fd534fb38c2787b7d8d5d4e49031b51e5bd24c7858c8161875f28b4b62f186e7c578d27b48d0236f834c21ebbd0cfa11a88b145fdae0a521d671e94e990effda1b4a8ccd67eed2de1c73afbde55672bb2a160ef0634d0910bfa2bc44a716eea72b53019d530ec2d1f9969b4b7a06130435dee328b7512136faec2f78a78bb9264937bda82d585a857832dbb5237364d18cc0ab86cf97736eef0dc6c112afedfd64aeedbd77d7f6f892456cae76bc288697fe625002f580029e372bcf6069ab82192c6d1f3d7e1024f3bcf47881d00e75032804dfed200af102990522b0f894d950c828a361d6095cd4041628cb4df1a5904b652946419cdc48d9d9d265ffc6188a1b33f60aa115db036860dc7b238f76a184a097f0a900090a8022bc247bd1042e411fb527d20fe03f0d8062f6ba9b32cc2f0747898d00c3fee654d18ea15587c3e88cd10d69de21e1586e47bf7cf18deb3767639151a4b2c010344677e6700b0aa4c93250e75a88124ae14408bc48d795f1cd861daa3d0cc3eb7325ee12bdada29b8d0ce743696f78801fb7795100357cc9a5dbeb0db6365544081449afbd74c635f61e4ea465c5ec264e80242ed12c0eeedd18ec4f46f01538a046700cd0bbeaf0ea19a0f7eedff306efba8b793fd019deafaa03323ff72691d1cbd8ac2a40e7e63a2df2159a9b38bc9834f7673bfe61b942425ee85a7647642f96360e8297bd184c74287bd9f13bfb08d50ec5239849f78dff070ac6194f802c0000"
+	tmp.Length = 11392
+	RESOURCES = append(RESOURCES, tmp)
+
 	tmp.Filename = "data/favicon.ico"
 	tmp.Contents = "1f8b08000000000004ff6ccab10d85300c45d1ebbf405cfd9a928e15d88181602446cb043c640c518a1ce9baf103c370ff114ee00fcc800313f90f6b9c57aed3b6c4325d3bad5a18d66f3e124f76d02a9561fd460209ee000000fffffdf4ae2dc6000000"
 	tmp.Length = 198
@@ -58,8 +63,8 @@ func init() {
 	RESOURCES = append(RESOURCES, tmp)
 
 	tmp.Filename = "data/index.html"
-	tmp.Contents = "1f8b08000000000004ffe458516fdcb8117ef7af982ac13d59929d5c8bc6e10a70e3b69736cd19f1a2489f0a8a9c5d8d97e2e8486ad70bc3ffbd20a55d6b6ddfc52ed016b8721f969438c399e1371f8712bfb9f8f1c3fc1f977f8426b4a63a12f10f8cb4cb598636ab8e00448352c70e80683148508d741ec32cebc322ff7d568eef0cd9153834b34cf6a1619741e37031cbcaa66fa5f545b80919ec26274556b638cb347ae5a80bc43603c536a00db3ecfcf2232cd8c1c5e72b30ccabbef34f4aaf70bb61a7fd44545b7f0cd79eed31c88eeea5020583d5ed6df103fb10d7bebb831cce2db0356471b21478746b5228ca41e608f6ce47b959b626dc74ecc264d50de9d0cc3446b93c0d8e812c059226f74a1a9c9d66d591f0616b10c2b6c35916f02694cafbac3a7a4556995e2328efcb9a39f8e06457b4640be57d5a3fed10dc0e7d805aaad5d2716f75aed8b03b7bb578177fef870977c35fe103ae315f300774f7c29dd49aecf20cbe3fe96ee06494011834c1ab77ef767a9e58080e5702a8d9697479e0ee0cde7437e0d9908657f82efe46dd4fda5358b9ce23bca6b68d36d446aad5280cb0601bf20dd2b2096750b3d1e39bbb2351a690c6d026184d637b2dd772783a0df1b52faf7fead16df3d3e2f44df17dd1922dae7dd49434bc4cd5e1661da8011065f42ee247d4acb7b10320ac5c8332d27b984166e5ba960e86bf9cec1a9dc7dd50e342f62664e0d8e0389b9672c895e4f72c1bf73237b80867bf3de96edeefb63777295ee9594ae5d884a671f5d9b8f6b803fb1931e54f2b2161ccdfec306744292bc841f8561a53fd52fa14a21c2689b2391d7c8f21d1b41e06a2b472d79d5815394092c57b8b44735a5d7cbe824f8908e06a979d13ad4fc9e70bd3939efa3599e5783379030771516c72dfe6a7118b79abf398b931764313f71e0c6daa76277a2f7b280c20ba6ade90df710c486378e361cb3d04860edd825d1bc92837c95d0f6b92e0a9ed0cc20ff3f92538fca9471ffc313854486bb2cb28eec0a1ef4df0891b407af8cbd58f9f73b48a356ae0fa1a55f08528bb21fcbb960c4268d907a8a52705bd974b04bc9169cd0df746271206432b84d0903f7ba0e5414c1e0effcdb8bf39dca197c5fde44038c55dc4481c3a1fdb6b50bd33d084d0f9b3b23c3821cad791abcbd70dfb30c140c47152f65f89c321845e188743e11487398326af788d0e360da906a27b1e1a69b541c0569249a76f681086a02de3b342712b06bf41732bc946dc814a006965c206c28223a2c92e7740fd3582654f8f4fa3e66f5fcb7dc4b2eadb7322a9fe6ae0f5f172fdbbc4177de761c36e15a94405b38d9c14796e6014bce95085e3f85033fa04b681f2801730ff3adff35960fe3fc4d0f9f9f979a9adcf654705bbe5cfe1e8e1bc67622931c190dc07c88b6d64c46f807cfe755ea6fab258d0cf19379df34cc3467e9b18757b4b0b28bea0267f371692a989e66df54506cc3f514b2132ce77b2eddec379dd7bcc2f1d0754b1561265f376eae42f9d472fa904e229b0af66766daafcdbd540da850f86d0060fd22198e80a6a080c6f4e4ec684f0b12e80867b770cd26a50a3c0a6e12424ebded31a811d7068d06dc8232c1da2dec2868c811aa136ac56a86143a101cb6039908a35da83722059f471911255d49543b315655d41dfb14d67ffbe72098d1c0e00dfa41340b1f5146bf947b8595268fa3a1e1fa55fddec409d2f39abc8fa208d899bb7e5de016f2ca46736debf6495f821961c403eda6339247324286e5b748aa4d95bb489be49bb85652f9db4013152895c4b32b22643611b6b2643fb013b904af54eaaede3c2284522d56ac9206352e11ded70bdb5d1e2217dc61ccd970cb7b7c5dfd179627b77379e948ff58e00df01e6118a6e6fd1eabbbbfb0c3810980ca6ddaefaced6be7b1f0b92670e920562bc1b929e6543371b2e27b32ce5f67877dc175387f0b60f2af5c7d9d3eafced5ef891ab87da52b13d9d0d207ab333c7ca354c34c5260c4ddeee2f325fd0c89843e345c18bd2d0e0edae0943f7379c662cfc74a3ba82b8ccaa3f491f52a27d49603108175b2b5b52f9c5e7ab2768ec79ea472a57dc9659f5670a792d3dea54eac74f12649fa2ee43d5a2eccdbd2793fd8fed3f13ccf3f435073e915d3d3790b5e1e5f0e5a1585099557f309c5c7be63e840d85802e05ea2ab041fbcf2bee8dcfaaf9f026eec0435dff83c87ce06e9baed80f6d81a7f195f2a95861dba503f32a7e9a81bf62dbbdd09fc9b68b72c8d9880951d6acb7d591289bd09aeae85f010000ffff48ab20efdc130000"
-	tmp.Length = 5084
+	tmp.Contents = "1f8b08000000000004ffe55c6b771bb991fd9e5f81e5cc89933d6c5292ed396b59d48c46d6c4def1431199c4b35f72c06e9084d56c741a68528c8ffffbde2aa01f7cc81635e3ac9df5ccb149341e85423d6e55a179f21fcfde9c8f7eb9bc1033374f4f7f7742ff885466d34147659dd3df0971325332a10ff838574e8a78260babdca053ba49f45f9d7e7896eaec5a142a1d7464e966a6e88859a126834e7f56ce65667beec67544bf3d5126e76ad049948d0b9d3b6db28e884de65486b9cf2e5f888929c4b3d743911a735de676e7e86bb55a9a22b1ada14966bbe29d355957c85c37a39c76a93a7dffbef7dc5847a33f7c109138cb84c940bb6a2d25ac2a163a56277d3f666bd58556cbdc14aeb5ea52276e3648148d8bf84b57e84c3b2dd3c8c632558343b0f3c4ba55aa845be598c5a91bd78fad45fb373a8bd33251025ffb63639c7585cc7b739df5d0c2ebf30989f7feb31063195f4f0b536649149bd414c7df4c9ed07f4f7d870ffe9f9e756aa1a20966544533389749a2b3e9b1787490df8883a755bb9f497cf3e4c993a7b72e24d657420f1c802a2267f2637184f9ac497522be514fe8bf8fd1d3cbe42222f16ad3161619a758b75e620236474ba5a7338747264dea694ffacc52622d8b519bb7efe442fad6368bdfd9febb7f94aa584587bdc3a3de23e6f23b4b3371dffda65a3facb5692033fd4a794ec626590541c2ae459c4a6bc54074f0652c0be1ff8974b65050aeea6ba226b24c21668581d4f8de7a2abdaef0be079d709651aa26eef831cef36975bc51c1fce2b6ce6960e549a2c3ea83b0763881ba07a9fce1e989acf4b7b3ae33277d790abd39b17399a6a71f539f1e58c19dc086c37afd3e08088ce88380f0b14515a994c48c0d45440f4dffd24f3facb4b335ebaef1d1242d75d2de57ab576196ad279b334067e7d121c962344fa2c3f59ecd0e6e1fda8c5d1f8cdef9e968a66dc524010699a5152b530a6744ae0a98bd3971330a764f2cb41456cf7388c0f3d1e8124616e26b1dac5ca162a517386a1a5ee0ab85b458565921adf8efe19bd791ca6293a84498f13b153b8b43c9b708ba80d4ad84828ae746674e803c6f96c718a871c498bc1688c4c43059e8a60a193bbd50022de51c56900593e4a32b96331dcf689ea9c249488779268599afcf647295c144f7c858774edfe01b99fd6a369a6827b5a3991273c823f669752c4a2ba74aa81bc91c5a9a324d581045aaaf616ac1ebe38d59364e70f3eb3da5e4e8d748c9c1b6949cd0b9adb7d29f6f455c1629bc81cbed71bfbfa69bfd6fc964f5bf9da1657dba3e4ff62fe1c36fae2d4624dac606321ac48ab667c54c66090e5ccda54e192b38c88567da94da7ab199877d43a6d092b192c52c2073c9b2a13090f48f5428a8d5bfa3b0d41ab75b6a5ebdedd71ceb9c7eba0f69e6bf8d78bdb85c7c57414c588fe29a4c49ecd215595012186f51d44d0ef3d9a5c6c428cbb213dc9d9988d1db516d7d9d31ff0f65e80c7ffa80de111974534c6f93a3cd7e7794a55f4f208ea8cfe8b337d1b711d7eeb39f90b75b660f4fff4cf852bce1a8c602a83cbced6036cf7b1fd9df71e6fbca3fb3f52c59c82c86872e81492c4ce38ac31a604e58da254b3ae36572e773404531866ee4f0d60432fda3081098bee4b20027810cb67cae0fc1e418163b50e7bff0df882cb2446556259dede33d71c5e9899b9d5e5673232c9b71cb330f8febefaf94cc4085ffdec7b05be64a822415c9e0a03e556aad9f1c6e369fa74a7a0f03cc551616e70a746e7501b68d35ec02b09b05470442443c0a9d1681733d3fcf1d488a93c1e16e92b6281d2ac704c533155f13dc87972466b609023693885abddb9cc07c5b4296c38b73b19008d218b0dd9db8c4ec4d5c58edcdcf9e286bd02a1de0ec3493ae84c914b2209ee27306ca613bef4e8e8219d983201c4e23ca4bed664266e202041e88379764be63c4b11572ddda303a27a155c5b66a2454bec7e93a3d57a6748323bb9beac75bedcfa180a98166e13897523b063a4a824232591443a8a22bc6ca2d95cac4e1c1c1dc32a58707760f46c6767074f0b077d03b3cc4dffda3479b64fc3e1bdbfce93a3303f7c479aa81d6c5b01c67aa75c4859a9292c8251d7008768836bb048e93ec4057c1b77af0863198803c704f88bf58d5707b10f31215d32b55e398c72c33817017928478c815651673b0813e526023e20f981b9f1e7fc7ab90b3ff23a6a710c232c164f412b26cb50c02273648f26d447b8cfc1e23bfc78a0e1f377799df34021835578406e84bd82826bb7da2684823c274773faea54e93581677b7149785198322518052c460568f5332d681521224bf89492aa72de26dd0057c5422c71cb02e2b615719ba58fdcf2aa48379090479adf24474aac6ceb1e8e05c5467ef7d7a3f71fe7cf72e5fbcde6c1fd5dacd23bbc2647c1c1bfdbba1a19e5840307cd3f36145a41791543aca53412829cbb1621122a817e0e2ddb74231bd7483d82e766f8622e05ddbf1e3d665ca1e8bad41d59e00ce7380d76a639400a81e65c9aefe354155cb4aced38da63c45ec544d09bf812889599b833594fae912037f7cf1fa59e8ff4f3c0cdda3894eab5d789e42af0960c81476624ccecb409739c5d128dd591cab7c4bcdd8f28528bfddef588444eae293128636f2903b301031fbd91b7252ac09dee044de44580e0de1ead9de7955d976baa18f6d0002dbc2c691fc1ea43f659f75b0ed53c8ef7387162609ae91d2362a01f77e6a18b0135ddd0a90ef8ce16bf0fb7d4d70e3b26e45f41f1db505a33f82f0b72298dbb135d92f298688c9f444c7e275ed0cbf74b0fdaa8445e5cc54900c3d153f54a4fb63a7a09374a47172deaab14f03c2763a2e53ce193708a090501ff6a219f50bd940937a78b082f956137d435c2335cbd1db1b6b0de9a67948856b378acf1852daaabba7749dc81ae97f1e51fc21b387bd20ec3de8607ff8e6acdffa7e9b307e6adc1ee2f85b6c6363f5ef3d0f071b44deb699bb8dde774b97855e002375c98be53e550c39332c3f8517a8a49205e5c3c1cc3832d624865a01236790106aa71a980d4359f41e3fecdd57ad1b1516e7089a358c4a16afbe74751e19ef0a3c7e559c476ff4921cb714e40f4595f10f2a6549e73836e39c245817d28fe81f37db4720493c66d78919d8bd1721fbd42c6361502ca15e68ad57ef821039afcb5aeec8cb6e74f25439abd249971ab270bc36ec2248006d616b5b55b615025ac74d2c449cf0902925cfb24aa2e8714479e9cf63295adc6a1c1157ee5ebdbd4db13e36665f751a714e804e89196c9929e09396a9986b0be0030181514df464a2384912601c143018de322b28a4e37c483851cf4f99ad28f72da785525412a903bdd6297b3402b07d6fcd437890cb6928de7ce1a9aa09e171b02a9bd6552f8a41bd94d95a9928f3b19ca96098941fa1c40c71671e76eb3541da6b0f25b2723ef60a9b97088fe2da7dda465f594119d821ac230798a62afd3c529d3767d20258b7c9f3eedefb4af205a515aa6d3fa862f5ae188d5eb294211a228d6907cbe0440221d43184bd0a1a09b5d285819cc3f0291f8217e786a53eb0ac44bb3252141abc3385762b9e84c2d129f92218a1d2019117f6de327ec155a2b3920c1d91fb3508fb8b89f0b52d8ab259d443198bcaaa46fba490cde59c59c775b0e02f28224f85adb2b7957678ae4bd8a1d88944a59aecfab178f53654d4fe509d832e5a00c09f9cbf97005dfb63570c2f7fea8a67afceaecebbe2d5e82c1a8e10158d5e0ea3abcb11777ff6f38b57e25aadc88c71f62aa4db5642158529fc9c4b5950b6d67e1e0562767c5271d67bedab30f536595c2929a07c32abcce84a8b64874026057e7b6ec82aa5e0bc21eb5e637c8e60564d2eab9542f9beee3fb0875d7bd484b7f7d3011c9bb8a0f8fdab907e20ab89264c51bada94af853f018b40197255ccb50b693f8e9aea9ab05c2bfdc23328cf00863e24c8c0b0b0f62b2eecc9d6850ad6a6b0046729434ac81f8dceb7e2206f1639079074eb908904613bda9fa9d4b483fc0a377d1e55b0f9a441393a1f1c3e39ea1df48e7a3e54f7340ec666fcc3a7b465ef89ee87a5a88c4adc5c4bdfe5909d8dbc14d533369aac99b81dcd992a1dbcf5666b93a7aa535d902436515ba941a7e679fbc99a6563d7a5086400f00533eb815fd2f558bd4617ad0b8d9c7a0e404f80be583560bbba61e68d6f02a18a9d8f319610547f6941dd1ff2fd2d2467bffcda642b8a91e128feb33a1cefd7bcb30bf976d2790e5402b328759d114b450c8ced54d2ba9aa4a4d5885400d2f188f5db04e0a81908d5818bb7ef55429b80a3ca94648a883a7f844b5a8f8b1a01e6c018755b515d353cb8e8cfa3ead5229f747c5b1df75755edaf4bd8168cf61637941938ade4799919bafe18aa3d3309de84d3601432e60b62e9caf311274053d5263f5bb91935b450e7bdc5fe99f7052f353020d589bf700f481508be67278a32b36b414a51a694855f84f886a05c0df338e9e0dd5e85bb7da286eb8654c849d55c502d11fed556ba65494b087ad72677dddc85da93076d5be6516793caa37d1ec926467c52aad73aed27d1bfea6ac2154ee3deb71062223e92b9bad95501aa203f276a4809a4387f7df6ea628f321acfcfd99888324e9bab9c794d9dc97a6eefda2c423bd14ee2ec51ef9adf44bcecd65a19451bc16ef35d53ebd3da7bee29b3b7ce5f67037ecdfcf00994fd8ea669a976f3ab82869aac13eb603829e26366048dac4ac9ad7cdc5e5ba4bc10b91292c38f5211d6ac63b63d6e24b83402d6a8f2616e9708b6ed4a26aeaeac72de883729add1e8e51e8b5a2323aa101576d772943ff34f3d362e1d4b6215c8cee7843313f232d37d769aa03f95bd6f119c20f96d99693930baf2973d7042dd804d5d0a386612004e72ba36356512c1ac2e74c257540973c06db1cbf3b74d61563459dcbb532bf12782dcc0207f445f5f5c2e1eb522f53142258801df68bc832c7cbc1a4a06a9b6facb65037bc36d07e8317d84baf9e4019d15552574c6c5ca5f42800b3a8211f1b0ac1ddf72cbc0ce4cde1da766bab6043c9eafb95b33af2f5578afd79e825b0695b9e96e49d6bda1c28fa989af39a1fb1504caed742754f4c5e55a2daf0506f25cc982222b4ec604ae86ec045d4f194bba0d33aef75ee7291a1c5b3ffcac4036c9ec38edd701e66d0e7fa3dbde194f8eb5384b03cf1aca5bede46e23ee212ce55c1ce328caaed9f088606944ebd8aabf2e1010320eb05b9122a0af3559c06d587a0af066a1322f99e3cd5d3db642f5352212eb702380d8ae082913990d72ae2e2053a75852f0e901b9f537c8fc9d2b1046f95c9f32b97f3ef52501cea1c356e6f62bc8a3428c1f24e1851013c2e7cac2832fc0c2be42d05410e8dd1442c5bcc52a68a414876d5fef62cc3464271c0dc9c743a2f0370b081f5833f33df5247a7dcbab1e9eb256ba7fdf440b9734fe72f592f57bac78c73edb2ec5dfd4780835a7fb7c103dbff918b6c1ef4cf126198bd20d2348baa5777020c217cd636f3fd8be9405e39f70e9d6a3890d7f42f5c9d66db3e0041338fa8d00a45073443d492b0059f26012ea807799dfc9fa4d2e3e696dab246d990b3995bc35c5e0ba0639ea26d70542fae04be78620c8c471cd8dd38764312624fd565168102a75cc97f03e007c3e61085e00eaed5fcd829e46a986ceb57c2383283f97d7a420750e069a5f9fbb6772c7971cbf74b5bc6a5d59c9a1915502a1a58905073d950ff239034aa5d00643d6453d48d326e3529d34cc9b360995a5b8149d88cb37c351ab12c8088f0f66a9c6331cdb7db5f3ad9f394ac483f71d5a9aae3bd6616a5774287f446d67f48583f9854ca9e1f0f19c9ac2fad452e97975b7037eb9cfcf3e3cb8c5062cfd49df23c59a03f12b115e95b4ad5b3eba512d8f0e5acc96755a1b4751dfcfabf3691eaecda88ac1f21bcc41661cddd1e2a243cb2aac17a57e63adf7f4d29d0b3d25a3565df77efeeaec3c1a3e3f3b7afc5db53f86e91b777e090a9d5dbe8886d535f5f5bb88bdddacfd85e5c91f496554c39d8b1664fdd3c5485407b78e78fd1edb7d9f5dbcbc185dd4ddfbdfea0db3566592d9e4dc66711a1053c99f9897966ff4c0d4a4402384eb00dc4b773fa3f3febd9e88de736cd514ab0f1f36cc5168ff1ab26d5509db99d6fb5de1a5177f45355ce321d75f54324c49656bd6ee1ecc1a7841715a10ce90af032eb8a7b9d96d05669ec1f78402de65672e00e135e5ac32dbfec6c9aaada5135d58f777ec35db2e6f81ef6b8f9ad47a1b3ab9f5721ec3a5d2aa96224ab75565ab9098e4abbf565cfd742e1e3e7cf88487d32d1b48bd8f7a827b8605802df215f2dcc4b3cfccf9efa51b1c1d1c3d8a0e0ea383a3d1e1e3e38303fcff3fbff20a6c5bd78041a1631b4a7645dafe92b49ddc28d7e3c4d918ec8c2e0be354fc35547cfdbb11a1984e5bf1055db09c37663f7ce8b62e6793e730dc57629f04a270e89c69586a4b375094a29203d58660e8386aad8c2b701d39a55d10ab1530889331fc4e0ac3353e0560e488adf5de3ee316ea068fc7391ecaa15102682bb29d62cd72cceedc5edf542f3f4653436fd15b273df4a96f00705b46bf6f402fd273d48665f13fe801d94c8ee4a05d1531dd32ab28aa2f594c4ba80b4c3de74de402a1a41ceb54bb15d5a3525d7fa1e0308678cb78d5fb48952710e91d389561ca8c02dbea0273bd1d3aa9bf2abefa4e3f1371dffccb5a87b59f8b683ee6f51b4950ddbb7df103c32f8fe864d0f11f3b9590b67f99a4f3d1df95b8e51725826c3fecdce945df1dbdd1a14c5bbfcd2136b52cd5ada7f5cf765ca9944b69e16731002a52bd35f0744b2a93599cf7b4e9774e7f620000c5ba62e1489578b68281d331e1a01dafc1de6dfaf08a2f497de7f44fda85dc12412bb2a05c21f9c4d427fd32fd1733f38c7fbb882a84d7776524a52e7b95fdc75e7fe454a63cbde370b7a4ab2b05336ae84caab2bf0f61506ce774e49fec9aebff8033e7265ff10fcadc715f9e23d76a9e73c16e485fc5cff8bae77ed62c80574fffd33afe1775e0d4f897abfe17419ef08bca4a0000"
+	tmp.Length = 19146
 	RESOURCES = append(RESOURCES, tmp)
 
 	tmp.Filename = "data/js/bootstrap.min.js"
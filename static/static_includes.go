@@ -1,19 +1,32 @@
 //
-// This file contains a helper method to allow parsing #include
-// lines from static-resources included in our binary.
+// Package static contains the resources, such as our templates and
+// stylesheets, which are embedded in our binary.
 //
-
-package main
+// The resources themselves live beneath data/, and are converted into
+// static.go by implant.  This file contains a helper method to allow
+// parsing #include lines from them.
+//
+package static
 
 import (
 	"fmt"
 	"strings"
+	"sync"
 )
 
 //
-// CACHE is a cache for our expanded templates.
+// cache holds our expanded templates, which never change once built.
 //
-var CACHE = make(map[string]string)
+var cache = struct {
+	sync.Mutex
+	expanded map[string]string
+}{expanded: make(map[string]string)}
+
+// Resource returns the contents of one of our embedded resources, by
+// its path beneath data/, such as "data/robots.txt".
+func Resource(path string) ([]byte, error) {
+	return getResource(path)
+}
 
 // ExpandResource reads a file from our static-resources, and
 // processes any lines that contain "includes".
@@ -26,7 +39,9 @@ func ExpandResource(file string) (string, error) {
 	//
 	// Return from the cache, if we can.
 	//
-	tmp := CACHE[file]
+	cache.Lock()
+	tmp := cache.expanded[file]
+	cache.Unlock()
 	if tmp != "" {
 		return tmp, nil
 	}
@@ -81,7 +96,9 @@ func ExpandResource(file string) (string, error) {
 	//
 	// Save the expanded text in our cache.
 	//
-	CACHE[file] = output
+	cache.Lock()
+	cache.expanded[file] = output
+	cache.Unlock()
 
 	//
 	// Before returning it.
@@ -2,7 +2,7 @@
 // Simple testing of our embedded resource.
 //
 
-package main
+package static
 
 import (
 	"io/ioutil"
@@ -15,8 +15,8 @@ import (
 //
 func TestResourceCount(t *testing.T) {
 	out := getResources()
-	if len(out) != 8 {
-		t.Errorf("We expected 7 resources but found %d.", len(out))
+	if len(out) != 9 {
+		t.Errorf("We expected 9 resources but found %d.", len(out))
 	}
 }
 